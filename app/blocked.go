@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"time"
+)
+
+// A client running a blocking command that can not be served yet, like
+// BLPOP on empty lists, is parked on the keys it waits for instead of
+// getting a reply. Its connection goroutine waits in waitUnblocked without
+// reading further commands.
+//
+// Commands creating a key a client waits for signal it as ready, see
+// DB.add. Once the command is done handleClientsBlockedOnKeys runs the
+// command of the clients waiting for the ready keys again, in the order
+// they blocked, as long as the key holds a value of the type they wait
+// for. The blocking command then finds something to serve.
+type blockState struct {
+	db      *DB
+	keys    []string
+	typ     FieldType // type of value the client waits for
+	msg     Message   // command run again when a key is ready
+	timer   *time.Timer
+	timeout func() // writes the reply sent when the timeout is over
+
+	// ready, when set, tells whether the value at a ready key can serve
+	// the client, like a stream with entries past the ID it reads after
+	ready func(key string, obj *Object) bool
+}
+
+var (
+	errTimeoutNotFloat = errors.New("ERR timeout is not a float or out of range")
+	errTimeoutNegative = errors.New("ERR timeout is negative")
+)
+
+// maxBlockedQueryBuffer bounds the commands a blocked client can send
+// before it is served, like the client-query-buffer-limit of Redis.
+const maxBlockedQueryBuffer = 1024 * 1024 * 1024
+
+// parseTimeout parses the timeout in seconds of the blocking commands,
+// fractions of a second are allowed. 0 blocks forever.
+func parseTimeout(s string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return 0, errTimeoutNotFloat
+	}
+	if secs < 0 {
+		return 0, errTimeoutNegative
+	}
+	if secs*float64(time.Second) > math.MaxInt64 {
+		return 0, errTimeoutNotFloat
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// parseTimeoutMs parses the timeout in milliseconds of the BLOCK option of
+// XREAD and XREADGROUP. 0 blocks forever.
+func parseTimeoutMs(s string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ms > math.MaxInt64/int64(time.Millisecond) {
+		return 0, errors.New("ERR timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return 0, errTimeoutNegative
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// canBlock reports whether c may block. Commands queued by MULTI and
+// commands run again for a ready key behave like their non-blocking
+// version instead.
+func (c *Client) canBlock() bool {
+	return !c.inExec && !c.reprocessing
+}
+
+// blockForKeys parks c until one of keys holds a value of type typ or the
+// timeout, 0 for none, is over and timeoutReply is sent. A client that
+// can not block gets timeoutReply right away.
+func (srv *Server) blockForKeys(c *Client, keys []string, typ FieldType, timeout time.Duration, msg Message, timeoutReply func()) error {
+	if !c.canBlock() {
+		timeoutReply()
+		return nil
+	}
+
+	bs := &blockState{db: c.db, typ: typ, msg: msg, timeout: timeoutReply}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		bs.keys = append(bs.keys, key)
+		c.db.blocking[key] = append(c.db.blocking[key], c)
+	}
+	c.blocked = bs
+
+	if timeout > 0 {
+		bs.timer = time.AfterFunc(timeout, func() {
+			srv.execute(func() {
+				if c.blocked == bs {
+					bs.timeout()
+					srv.unblockClient(c)
+					c.wakeUp()
+				}
+			})
+		})
+	}
+	return nil
+}
+
+// unblockClient removes c from the keys it waits for. Its connection
+// goroutine is woken up with wakeUp once the reply is written.
+func (srv *Server) unblockClient(c *Client) {
+	bs := c.blocked
+	for _, key := range bs.keys {
+		waiters := bs.db.blocking[key]
+		for i, w := range waiters {
+			if w == c {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(bs.db.blocking, key)
+		} else {
+			bs.db.blocking[key] = waiters
+		}
+	}
+	if bs.timer != nil {
+		bs.timer.Stop()
+	}
+	c.blocked = nil
+}
+
+// wakeUp tells the connection goroutine of c waiting in waitUnblocked that
+// the reply of the blocking command is ready.
+func (c *Client) wakeUp() {
+	select {
+	case c.unblocked <- struct{}{}:
+	default:
+	}
+}
+
+// handleClientsBlockedOnKeys serves the clients waiting for the keys made
+// ready by the last command. Serving a client can make other keys ready,
+// like BLMOVE pushing to another list, so it goes on until none is left.
+func (srv *Server) handleClientsBlockedOnKeys() {
+	for {
+		served := false
+		for _, db := range srv.dbs {
+			for len(db.ready) > 0 {
+				key := db.ready[0]
+				db.ready = db.ready[1:]
+				delete(db.readySet, key)
+
+				srv.serveClientsBlockedOnKey(db, key)
+				served = true
+			}
+		}
+		if !served {
+			return
+		}
+	}
+}
+
+func (srv *Server) serveClientsBlockedOnKey(db *DB, key string) {
+	// a copy, serving a client removes it from the waiters
+	waiters := append([]*Client(nil), db.blocking[key]...)
+	for _, c := range waiters {
+		obj := db.lookupNoTouch(key)
+		if obj == nil {
+			return
+		}
+		if c.blocked == nil || obj.Type != c.blocked.typ ||
+			c.blocked.ready != nil && !c.blocked.ready(key, obj) {
+			continue
+		}
+
+		msg := c.blocked.msg
+		srv.unblockClient(c)
+
+		c.reprocessing = true
+		if err := srv.runCommand(c, msg); err != nil {
+			c.writer.WriteError(err.Error())
+		}
+		c.reprocessing = false
+		c.wakeUp()
+	}
+}
+
+// waitUnblocked waits for c, blocked by its last command, to be served or
+// to time out. It returns false when the connection is closed meanwhile,
+// the client is then removed from the keys it waits for.
+//
+// Closing is noticed by reading ahead from the connection. If the client
+// sends more commands instead they are kept until c is unblocked, like
+// Redis does not process the commands of a blocked client, up to
+// maxBlockedQueryBuffer bytes past which the client is closed.
+func (srv *Server) waitUnblocked(c *Client) bool {
+	closed := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		err := c.reader.ReadAhead(maxBlockedQueryBuffer)
+		var nerr net.Error
+		if !(errors.As(err, &nerr) && nerr.Timeout()) {
+			close(closed)
+		}
+	}()
+
+	select {
+	case <-c.unblocked:
+		// stop reading so the reader is not used by two goroutines
+		c.conn.SetReadDeadline(time.Now())
+		<-stopped
+		c.conn.SetReadDeadline(time.Time{})
+		return true
+	case <-closed:
+		srv.execute(func() {
+			if c.blocked != nil {
+				srv.unblockClient(c)
+			}
+		})
+		return false
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+)
+
+// Client is the state of a single connection accepted by HandleCon.
+type Client struct {
+	id            int64
+	name          string
+	authenticated bool
+	db            *DB // selected with SELECT
+	conn          net.Conn
+	reader        *RESPReader
+	writer        *RESPWriter // writer.proto is the protocol set by HELLO
+
+	blocked      *blockState   // set while waiting in a blocking command
+	unblocked    chan struct{} // see waitUnblocked
+	reprocessing bool          // running a blocking command again, see blockState
+
+	inMulti    bool      // between MULTI and EXEC
+	multiQueue []Message // commands queued by MULTI
+	multiDirty bool      // a command failed to queue, EXEC aborts
+	inExec     bool      // running the commands queued by MULTI
+}
+
+func newClient(conn net.Conn) *Client {
+	return &Client{
+		conn:      conn,
+		reader:    NewRESPReader(conn),
+		writer:    NewRESPWriter(conn),
+		unblocked: make(chan struct{}, 1),
+	}
+}
+
+// Flush sends the queued replies once every pipelined command read so far
+// has been answered, so N commands in one packet get one write back.
+func (c *Client) Flush() error {
+	if c.reader.Buffered() > 0 {
+		return nil
+	}
+	return c.writer.Flush()
+}
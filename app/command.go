@@ -0,0 +1,1177 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+type CommandFlag uint32
+
+const (
+	CmdWrite CommandFlag = 1 << iota
+	CmdReadonly
+	CmdAdmin
+	CmdPubSub
+	CmdNoScript
+	CmdFast
+	CmdNoAuth
+	CmdBlocking
+)
+
+var commandFlagNames = []struct {
+	flag CommandFlag
+	name string
+}{
+	{CmdWrite, "write"},
+	{CmdReadonly, "readonly"},
+	{CmdAdmin, "admin"},
+	{CmdPubSub, "pubsub"},
+	{CmdNoScript, "noscript"},
+	{CmdFast, "fast"},
+	{CmdNoAuth, "no_auth"},
+	{CmdBlocking, "blocking"},
+}
+
+// Command describes a command of the table used for dispatch and by the
+// COMMAND introspection commands.
+//
+// Arity counts the command name, a negative arity means at least -Arity
+// arguments. FirstKey, LastKey and Step locate the keys in the arguments
+// with the command name at index 0; a negative LastKey counts from the end.
+// Commands where the keys depend on other arguments, like a number of keys,
+// locate them with GetKeys instead.
+type Command struct {
+	Name     string
+	Arity    int
+	Flags    CommandFlag
+	FirstKey int
+	LastKey  int
+	Step     int
+	Group    string
+	Since    string
+	Summary  string
+	GetKeys  func(argv []string) []int
+	Handler  func(srv *Server, c *Client, args []string) error
+}
+
+func newCommandTable() map[string]*Command {
+	commands := []*Command{
+		{
+			Name: "ping", Arity: -1, Flags: CmdFast,
+			Group: "connection", Since: "1.0.0", Summary: "Returns the server's liveliness response.",
+			Handler: (*Server).onPing,
+		},
+		{
+			Name: "echo", Arity: 2, Flags: CmdFast,
+			Group: "connection", Since: "1.0.0", Summary: "Returns the given string.",
+			Handler: (*Server).onEcho,
+		},
+		{
+			Name: "hello", Arity: -1, Flags: CmdNoScript | CmdFast | CmdNoAuth,
+			Group: "connection", Since: "6.0.0", Summary: "Handshakes with the Redis server.",
+			Handler: (*Server).onHello,
+		},
+		{
+			Name: "auth", Arity: -2, Flags: CmdNoScript | CmdFast | CmdNoAuth,
+			Group: "connection", Since: "1.0.0", Summary: "Authenticates the connection.",
+			Handler: (*Server).onAuth,
+		},
+		{
+			Name: "set", Arity: -3, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "string", Since: "1.0.0", Summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+			Handler: (*Server).onSet,
+		},
+		{
+			Name: "get", Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "string", Since: "1.0.0", Summary: "Returns the string value of a key.",
+			Handler: (*Server).onGet,
+		},
+		{
+			Name: "incr", Arity: 2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "string", Since: "1.0.0", Summary: "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.",
+			Handler: (*Server).onIncr,
+		},
+		{
+			Name: "decr", Arity: 2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "string", Since: "1.0.0", Summary: "Decrements the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.",
+			Handler: (*Server).onDecr,
+		},
+		{
+			Name: "incrby", Arity: 3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "string", Since: "1.0.0", Summary: "Increments the integer value of a key by a number. Uses 0 as initial value if the key doesn't exist.",
+			Handler: (*Server).onIncrBy,
+		},
+		{
+			Name: "decrby", Arity: 3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "string", Since: "1.0.0", Summary: "Decrements a number from the integer value of a key. Uses 0 as initial value if the key doesn't exist.",
+			Handler: (*Server).onDecrBy,
+		},
+		{
+			Name: "incrbyfloat", Arity: 3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "string", Since: "2.6.0", Summary: "Increment the floating point value of a key by a number. Uses 0 as initial value if the key doesn't exist.",
+			Handler: (*Server).onIncrByFloat,
+		},
+		{
+			Name: "keys", Arity: 2, Flags: CmdReadonly,
+			Group: "generic", Since: "1.0.0", Summary: "Returns all key names that match a pattern.",
+			Handler: (*Server).onKeys,
+		},
+		{
+			Name: "scan", Arity: -2, Flags: CmdReadonly,
+			Group: "generic", Since: "2.8.0", Summary: "Iterates over the key names in the database.",
+			Handler: (*Server).onScan,
+		},
+		{
+			Name: "del", Arity: -2, Flags: CmdWrite,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "generic", Since: "1.0.0", Summary: "Deletes one or more keys.",
+			Handler: (*Server).onDel,
+		},
+		{
+			Name: "unlink", Arity: -2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "generic", Since: "4.0.0", Summary: "Asynchronously deletes one or more keys.",
+			Handler: (*Server).onUnlink,
+		},
+		{
+			Name: "exists", Arity: -2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "generic", Since: "1.0.0", Summary: "Determines whether one or more keys exist.",
+			Handler: (*Server).onExists,
+		},
+		{
+			Name: "touch", Arity: -2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "generic", Since: "3.2.1", Summary: "Returns the number of existing keys out of those specified after updating the time they were last accessed.",
+			Handler: (*Server).onTouch,
+		},
+		{
+			Name: "type", Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "1.0.0", Summary: "Determines the type of value stored at a key.",
+			Handler: (*Server).onType,
+		},
+		{
+			Name: "object", Arity: -2, Flags: CmdReadonly,
+			FirstKey: 2, LastKey: 2, Step: 1,
+			Group: "generic", Since: "2.2.3", Summary: "Inspects the internals of a value.",
+			Handler: (*Server).onObject,
+		},
+		{
+			Name: "randomkey", Arity: 1, Flags: CmdReadonly,
+			Group: "generic", Since: "1.0.0", Summary: "Returns a random key name from the database.",
+			Handler: (*Server).onRandomKey,
+		},
+		{
+			Name: "rename", Arity: 3, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 2, Step: 1,
+			Group: "generic", Since: "1.0.0", Summary: "Renames a key and overwrites the destination.",
+			Handler: (*Server).onRename,
+		},
+		{
+			Name: "renamenx", Arity: 3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 2, Step: 1,
+			Group: "generic", Since: "1.0.0", Summary: "Renames a key only when the target key name doesn't exist.",
+			Handler: (*Server).onRenameNX,
+		},
+		{
+			Name: "copy", Arity: -3, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 2, Step: 1,
+			Group: "generic", Since: "6.2.0", Summary: "Copies the value of a key to a new key.",
+			Handler: (*Server).onCopy,
+		},
+		{
+			Name: "expire", Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "1.0.0", Summary: "Sets the expiration time of a key in seconds.",
+			Handler: (*Server).onExpire,
+		},
+		{
+			Name: "pexpire", Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "2.6.0", Summary: "Sets the expiration time of a key in milliseconds.",
+			Handler: (*Server).onPExpire,
+		},
+		{
+			Name: "expireat", Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "1.2.0", Summary: "Sets the expiration time of a key to a Unix timestamp.",
+			Handler: (*Server).onExpireAt,
+		},
+		{
+			Name: "pexpireat", Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "2.6.0", Summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+			Handler: (*Server).onPExpireAt,
+		},
+		{
+			Name: "ttl", Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "1.0.0", Summary: "Returns the expiration time in seconds of a key.",
+			Handler: (*Server).onTTL,
+		},
+		{
+			Name: "pttl", Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "2.6.0", Summary: "Returns the expiration time in milliseconds of a key.",
+			Handler: (*Server).onPTTL,
+		},
+		{
+			Name: "expiretime", Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "7.0.0", Summary: "Returns the expiration time of a key as a Unix timestamp.",
+			Handler: (*Server).onExpireTime,
+		},
+		{
+			Name: "pexpiretime", Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "7.0.0", Summary: "Returns the expiration time of a key as a Unix milliseconds timestamp.",
+			Handler: (*Server).onPExpireTime,
+		},
+		{
+			Name: "persist", Arity: 2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "2.2.0", Summary: "Removes the expiration time of a key.",
+			Handler: (*Server).onPersist,
+		},
+		{
+			Name: "select", Arity: 2, Flags: CmdFast,
+			Group: "connection", Since: "1.0.0", Summary: "Changes the selected database.",
+			Handler: (*Server).onSelect,
+		},
+		{
+			Name: "move", Arity: 3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "generic", Since: "1.0.0", Summary: "Moves a key to another database.",
+			Handler: (*Server).onMove,
+		},
+		{
+			Name: "swapdb", Arity: 3, Flags: CmdWrite | CmdFast,
+			Group: "server", Since: "4.0.0", Summary: "Swaps two Redis databases.",
+			Handler: (*Server).onSwapDB,
+		},
+		{
+			Name: "flushdb", Arity: -1, Flags: CmdWrite,
+			Group: "server", Since: "1.0.0", Summary: "Remove all keys from the current database.",
+			Handler: (*Server).onFlushDB,
+		},
+		{
+			Name: "flushall", Arity: -1, Flags: CmdWrite,
+			Group: "server", Since: "1.0.0", Summary: "Removes all keys from all databases.",
+			Handler: (*Server).onFlushAll,
+		},
+		{
+			Name: "dbsize", Arity: 1, Flags: CmdReadonly | CmdFast,
+			Group: "server", Since: "1.0.0", Summary: "Returns the number of keys in the database.",
+			Handler: (*Server).onDBSize,
+		},
+		{
+			Name: "lpush", Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0", Summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist.",
+			Handler: (*Server).onLPush,
+		},
+		{
+			Name: "rpush", Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0", Summary: "Appends one or more elements to a list. Creates the key if it doesn't exist.",
+			Handler: (*Server).onRPush,
+		},
+		{
+			Name: "lpop", Arity: -2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0", Summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.",
+			Handler: (*Server).onLPop,
+		},
+		{
+			Name: "rpop", Arity: -2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0", Summary: "Returns and removes the last elements of a list. Deletes the list if the last element was popped.",
+			Handler: (*Server).onRPop,
+		},
+		{
+			Name: "llen", Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0", Summary: "Returns the length of a list.",
+			Handler: (*Server).onLLen,
+		},
+		{
+			Name: "lrange", Arity: 4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0", Summary: "Returns a range of elements from a list.",
+			Handler: (*Server).onLRange,
+		},
+		{
+			Name: "lindex", Arity: 3, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0", Summary: "Returns an element from a list by its index.",
+			Handler: (*Server).onLIndex,
+		},
+		{
+			Name: "lset", Arity: 4, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0", Summary: "Sets the value of an element in a list by its index.",
+			Handler: (*Server).onLSet,
+		},
+		{
+			Name: "linsert", Arity: 5, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "2.2.0", Summary: "Inserts an element before or after another element in a list.",
+			Handler: (*Server).onLInsert,
+		},
+		{
+			Name: "lrem", Arity: 4, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0", Summary: "Removes elements from a list. Deletes the list if the last element was removed.",
+			Handler: (*Server).onLRem,
+		},
+		{
+			Name: "ltrim", Arity: 4, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "1.0.0", Summary: "Removes elements from both ends a list. Deletes the list if all elements were trimmed.",
+			Handler: (*Server).onLTrim,
+		},
+		{
+			Name: "lpos", Arity: -3, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "list", Since: "6.0.6", Summary: "Returns the index of matching elements in a list.",
+			Handler: (*Server).onLPos,
+		},
+		{
+			Name: "lmove", Arity: 5, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 2, Step: 1,
+			Group: "list", Since: "6.2.0", Summary: "Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved.",
+			Handler: (*Server).onLMove,
+		},
+		{
+			Name: "lmpop", Arity: -4, Flags: CmdWrite,
+			Group: "list", Since: "7.0.0", Summary: "Returns multiple elements from a list after removing them. Deletes the list if the last element was popped.",
+			GetKeys: numKeysPositions(1),
+			Handler: (*Server).onLMPop,
+		},
+		{
+			Name: "blpop", Arity: -3, Flags: CmdWrite | CmdBlocking,
+			FirstKey: 1, LastKey: -2, Step: 1,
+			Group: "list", Since: "2.0.0", Summary: "Removes and returns the first element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.",
+			Handler: (*Server).onBLPop,
+		},
+		{
+			Name: "brpop", Arity: -3, Flags: CmdWrite | CmdBlocking,
+			FirstKey: 1, LastKey: -2, Step: 1,
+			Group: "list", Since: "2.0.0", Summary: "Removes and returns the last element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.",
+			Handler: (*Server).onBRPop,
+		},
+		{
+			Name: "blmove", Arity: 6, Flags: CmdWrite | CmdBlocking,
+			FirstKey: 1, LastKey: 2, Step: 1,
+			Group: "list", Since: "6.2.0", Summary: "Pops an element from a list, pushes it to another list and returns it. Blocks until an element is available otherwise. Deletes the list if the last element was moved.",
+			Handler: (*Server).onBLMove,
+		},
+		{
+			Name: "blmpop", Arity: -5, Flags: CmdWrite | CmdBlocking,
+			Group: "list", Since: "7.0.0", Summary: "Pops the first element from one of multiple lists. Blocks until an element is available otherwise. Deletes the list if the last element was popped.",
+			GetKeys: numKeysPositions(2),
+			Handler: (*Server).onBLMPop,
+		},
+		{
+			Name: "multi", Arity: 1, Flags: CmdNoScript | CmdFast,
+			Group: "transactions", Since: "1.2.0", Summary: "Starts a transaction.",
+			Handler: (*Server).onMulti,
+		},
+		{
+			Name: "exec", Arity: 1, Flags: CmdNoScript,
+			Group: "transactions", Since: "1.2.0", Summary: "Executes all commands in a transaction.",
+			Handler: (*Server).onExec,
+		},
+		{
+			Name: "discard", Arity: 1, Flags: CmdNoScript | CmdFast,
+			Group: "transactions", Since: "2.0.0", Summary: "Discards a transaction.",
+			Handler: (*Server).onDiscard,
+		},
+		{
+			Name: "hset", Arity: -4, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0", Summary: "Creates or modifies the value of a field in a hash.",
+			Handler: (*Server).onHSet,
+		},
+		{
+			Name: "hsetnx", Arity: 4, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0", Summary: "Sets the value of a field in a hash only when the field doesn't exist.",
+			Handler: (*Server).onHSetNX,
+		},
+		{
+			Name: "hmset", Arity: -4, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0", Summary: "Sets the values of multiple fields.",
+			Handler: (*Server).onHMSet,
+		},
+		{
+			Name: "hget", Arity: 3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0", Summary: "Returns the value of a field in a hash.",
+			Handler: (*Server).onHGet,
+		},
+		{
+			Name: "hmget", Arity: -3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0", Summary: "Returns the values of all fields in a hash.",
+			Handler: (*Server).onHMGet,
+		},
+		{
+			Name: "hgetall", Arity: 2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0", Summary: "Returns all fields and values in a hash.",
+			Handler: (*Server).onHGetAll,
+		},
+		{
+			Name: "hkeys", Arity: 2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0", Summary: "Returns all fields in a hash.",
+			Handler: (*Server).onHKeys,
+		},
+		{
+			Name: "hvals", Arity: 2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0", Summary: "Returns all values in a hash.",
+			Handler: (*Server).onHVals,
+		},
+		{
+			Name: "hlen", Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0", Summary: "Returns the number of fields in a hash.",
+			Handler: (*Server).onHLen,
+		},
+		{
+			Name: "hexists", Arity: 3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0", Summary: "Determines whether a field exists in a hash.",
+			Handler: (*Server).onHExists,
+		},
+		{
+			Name: "hstrlen", Arity: 3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "3.2.0", Summary: "Returns the length of the value of a field.",
+			Handler: (*Server).onHStrLen,
+		},
+		{
+			Name: "hdel", Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0", Summary: "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.",
+			Handler: (*Server).onHDel,
+		},
+		{
+			Name: "hincrby", Arity: 4, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.0.0", Summary: "Increments the integer value of a field in a hash by a number. Uses 0 as initial value if the field doesn't exist.",
+			Handler: (*Server).onHIncrBy,
+		},
+		{
+			Name: "hincrbyfloat", Arity: 4, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.6.0", Summary: "Increments the floating point value of a field by a number. Uses 0 as initial value if the field doesn't exist.",
+			Handler: (*Server).onHIncrByFloat,
+		},
+		{
+			Name: "hscan", Arity: -3, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "2.8.0", Summary: "Iterates over fields and values of a hash.",
+			Handler: (*Server).onHScan,
+		},
+		{
+			Name: "hrandfield", Arity: -2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "6.2.0", Summary: "Returns one or more random fields from a hash.",
+			Handler: (*Server).onHRandField,
+		},
+		{
+			Name: "hexpire", Arity: -6, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "7.4.0", Summary: "Set expiry for hash field using relative time to expire (seconds)",
+			Handler: (*Server).onHExpire,
+		},
+		{
+			Name: "hpexpire", Arity: -6, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "7.4.0", Summary: "Set expiry for hash field using relative time to expire (milliseconds)",
+			Handler: (*Server).onHPExpire,
+		},
+		{
+			Name: "hexpireat", Arity: -6, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "7.4.0", Summary: "Set expiry for hash field using an absolute Unix timestamp (seconds)",
+			Handler: (*Server).onHExpireAt,
+		},
+		{
+			Name: "hpexpireat", Arity: -6, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "7.4.0", Summary: "Set expiry for hash field using an absolute Unix timestamp (milliseconds)",
+			Handler: (*Server).onHPExpireAt,
+		},
+		{
+			Name: "httl", Arity: -5, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "7.4.0", Summary: "Returns the TTL in seconds of a hash field.",
+			Handler: (*Server).onHTTL,
+		},
+		{
+			Name: "hpttl", Arity: -5, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "7.4.0", Summary: "Returns the TTL in milliseconds of a hash field.",
+			Handler: (*Server).onHPTTL,
+		},
+		{
+			Name: "hexpiretime", Arity: -5, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "7.4.0", Summary: "Returns the expiration time of a hash field as a Unix timestamp, in seconds.",
+			Handler: (*Server).onHExpireTime,
+		},
+		{
+			Name: "hpexpiretime", Arity: -5, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "7.4.0", Summary: "Returns the expiration time of a hash field as a Unix timestamp, in msec.",
+			Handler: (*Server).onHPExpireTime,
+		},
+		{
+			Name: "hpersist", Arity: -5, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "7.4.0", Summary: "Removes the expiration time for each specified field",
+			Handler: (*Server).onHPersist,
+		},
+		{
+			Name: "hgetex", Arity: -5, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "8.0.0", Summary: "Get the value of one or more fields of a given hash key, and optionally set their expiration.",
+			Handler: (*Server).onHGetEx,
+		},
+		{
+			Name: "hsetex", Arity: -6, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "hash", Since: "8.0.0", Summary: "Set the value of one or more fields of a given hash key, and optionally set their expiration.",
+			Handler: (*Server).onHSetEx,
+		},
+		{
+			Name: "sadd", Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Adds one or more members to a set. Creates the key if it doesn't exist.",
+			Handler: (*Server).onSAdd,
+		},
+		{
+			Name: "srem", Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Removes one or more members from a set. Deletes the set if the last member was removed.",
+			Handler: (*Server).onSRem,
+		},
+		{
+			Name: "smembers", Arity: 2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Returns all members of a set.",
+			Handler: (*Server).onSMembers,
+		},
+		{
+			Name: "sismember", Arity: 3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Determines whether a member belongs to a set.",
+			Handler: (*Server).onSIsMember,
+		},
+		{
+			Name: "smismember", Arity: -3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "6.2.0", Summary: "Determines whether multiple members belong to a set.",
+			Handler: (*Server).onSMIsMember,
+		},
+		{
+			Name: "scard", Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Returns the number of members in a set.",
+			Handler: (*Server).onSCard,
+		},
+		{
+			Name: "spop", Arity: -2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Returns one or more random members from a set after removing them. Deletes the set if the last member was popped.",
+			Handler: (*Server).onSPop,
+		},
+		{
+			Name: "srandmember", Arity: -2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Get one or multiple random members from a set",
+			Handler: (*Server).onSRandMember,
+		},
+		{
+			Name: "smove", Arity: 4, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 2, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Moves a member from one set to another.",
+			Handler: (*Server).onSMove,
+		},
+		{
+			Name: "sscan", Arity: -3, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "set", Since: "2.8.0", Summary: "Iterates over members of a set.",
+			Handler: (*Server).onSScan,
+		},
+		{
+			Name: "sinter", Arity: -2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Returns the intersect of multiple sets.",
+			Handler: (*Server).onSInter,
+		},
+		{
+			Name: "sinterstore", Arity: -3, Flags: CmdWrite,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Stores the intersect of multiple sets in a key.",
+			Handler: (*Server).onSInterStore,
+		},
+		{
+			Name: "sintercard", Arity: -3, Flags: CmdReadonly,
+			Group: "set", Since: "7.0.0", Summary: "Returns the number of members of the intersect of multiple sets.",
+			GetKeys: numKeysPositions(1),
+			Handler: (*Server).onSInterCard,
+		},
+		{
+			Name: "sunion", Arity: -2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Returns the union of multiple sets.",
+			Handler: (*Server).onSUnion,
+		},
+		{
+			Name: "sunionstore", Arity: -3, Flags: CmdWrite,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Stores the union of multiple sets in a key.",
+			Handler: (*Server).onSUnionStore,
+		},
+		{
+			Name: "sdiff", Arity: -2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Returns the difference of multiple sets.",
+			Handler: (*Server).onSDiff,
+		},
+		{
+			Name: "sdiffstore", Arity: -3, Flags: CmdWrite,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Group: "set", Since: "1.0.0", Summary: "Stores the difference of multiple sets in a key.",
+			Handler: (*Server).onSDiffStore,
+		},
+		{
+			Name: "zadd", Arity: -4, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0", Summary: "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.",
+			Handler: (*Server).onZAdd,
+		},
+		{
+			Name: "zincrby", Arity: 4, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0", Summary: "Increments the score of a member in a sorted set.",
+			Handler: (*Server).onZIncrBy,
+		},
+		{
+			Name: "zrem", Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0", Summary: "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed.",
+			Handler: (*Server).onZRem,
+		},
+		{
+			Name: "zcard", Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0", Summary: "Returns the number of members in a sorted set.",
+			Handler: (*Server).onZCard,
+		},
+		{
+			Name: "zscore", Arity: 3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0", Summary: "Returns the score of a member in a sorted set.",
+			Handler: (*Server).onZScore,
+		},
+		{
+			Name: "zmscore", Arity: -3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "6.2.0", Summary: "Returns the score of one or more members in a sorted set.",
+			Handler: (*Server).onZMScore,
+		},
+		{
+			Name: "zrank", Arity: -3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.0.0", Summary: "Returns the index of a member in a sorted set ordered by ascending scores.",
+			Handler: (*Server).onZRank,
+		},
+		{
+			Name: "zrevrank", Arity: -3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.0.0", Summary: "Returns the index of a member in a sorted set ordered by descending scores.",
+			Handler: (*Server).onZRevRank,
+		},
+		{
+			Name: "zcount", Arity: 4, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.0.0", Summary: "Returns the count of members in a sorted set that have scores within a range.",
+			Handler: (*Server).onZCount,
+		},
+		{
+			Name: "zlexcount", Arity: 4, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.8.9", Summary: "Returns the number of members in a sorted set within a lexicographical range.",
+			Handler: (*Server).onZLexCount,
+		},
+		{
+			Name: "zrange", Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0", Summary: "Returns members in a sorted set within a range of indexes.",
+			Handler: (*Server).onZRange,
+		},
+		{
+			Name: "zrangestore", Arity: -5, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 2, Step: 1,
+			Group: "sorted-set", Since: "6.2.0", Summary: "Stores a range of members from sorted set in a key.",
+			Handler: (*Server).onZRangeStore,
+		},
+		{
+			Name: "zrevrange", Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.2.0", Summary: "Returns members in a sorted set within a range of indexes in reverse order.",
+			Handler: (*Server).onZRevRange,
+		},
+		{
+			Name: "zrangebyscore", Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "1.0.5", Summary: "Returns members in a sorted set within a range of scores.",
+			Handler: (*Server).onZRangeByScore,
+		},
+		{
+			Name: "zrevrangebyscore", Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.2.0", Summary: "Returns members in a sorted set within a range of scores in reverse order.",
+			Handler: (*Server).onZRevRangeByScore,
+		},
+		{
+			Name: "zrangebylex", Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.8.9", Summary: "Returns members in a sorted set within a lexicographical range.",
+			Handler: (*Server).onZRangeByLex,
+		},
+		{
+			Name: "zrevrangebylex", Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "2.8.9", Summary: "Returns members in a sorted set within a lexicographical range in reverse order.",
+			Handler: (*Server).onZRevRangeByLex,
+		},
+		{
+			Name: "zunion", Arity: -3, Flags: CmdReadonly,
+			Group: "sorted-set", Since: "6.2.0", Summary: "Returns the union of multiple sorted sets.",
+			GetKeys: numKeysPositions(1),
+			Handler: (*Server).onZUnion,
+		},
+		{
+			Name: "zunionstore", Arity: -4, Flags: CmdWrite,
+			Group: "sorted-set", Since: "2.0.0", Summary: "Stores the union of multiple sorted sets in a key.",
+			GetKeys: destNumKeysPositions(2),
+			Handler: (*Server).onZUnionStore,
+		},
+		{
+			Name: "zinter", Arity: -3, Flags: CmdReadonly,
+			Group: "sorted-set", Since: "6.2.0", Summary: "Returns the intersect of multiple sorted sets.",
+			GetKeys: numKeysPositions(1),
+			Handler: (*Server).onZInter,
+		},
+		{
+			Name: "zinterstore", Arity: -4, Flags: CmdWrite,
+			Group: "sorted-set", Since: "2.0.0", Summary: "Stores the intersect of multiple sorted sets in a key.",
+			GetKeys: destNumKeysPositions(2),
+			Handler: (*Server).onZInterStore,
+		},
+		{
+			Name: "zintercard", Arity: -3, Flags: CmdReadonly,
+			Group: "sorted-set", Since: "7.0.0", Summary: "Returns the number of members of the intersect of multiple sorted sets.",
+			GetKeys: numKeysPositions(1),
+			Handler: (*Server).onZInterCard,
+		},
+		{
+			Name: "zdiff", Arity: -3, Flags: CmdReadonly,
+			Group: "sorted-set", Since: "6.2.0", Summary: "Returns the difference between multiple sorted sets.",
+			GetKeys: numKeysPositions(1),
+			Handler: (*Server).onZDiff,
+		},
+		{
+			Name: "zdiffstore", Arity: -4, Flags: CmdWrite,
+			Group: "sorted-set", Since: "6.2.0", Summary: "Stores the difference of multiple sorted sets in a key.",
+			GetKeys: destNumKeysPositions(2),
+			Handler: (*Server).onZDiffStore,
+		},
+		{
+			Name: "zpopmin", Arity: -2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "5.0.0", Summary: "Returns the lowest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.",
+			Handler: (*Server).onZPopMin,
+		},
+		{
+			Name: "zpopmax", Arity: -2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "5.0.0", Summary: "Returns the highest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.",
+			Handler: (*Server).onZPopMax,
+		},
+		{
+			Name: "bzpopmin", Arity: -3, Flags: CmdWrite | CmdBlocking,
+			FirstKey: 1, LastKey: -2, Step: 1,
+			Group: "sorted-set", Since: "5.0.0", Summary: "Removes and returns the member with the lowest score from one or more sorted sets. Blocks until a member is available otherwise. Deletes the sorted set if the last element was popped.",
+			Handler: (*Server).onBZPopMin,
+		},
+		{
+			Name: "bzpopmax", Arity: -3, Flags: CmdWrite | CmdBlocking,
+			FirstKey: 1, LastKey: -2, Step: 1,
+			Group: "sorted-set", Since: "5.0.0", Summary: "Removes and returns the member with the highest score from one or more sorted sets. Blocks until a member available otherwise. Deletes the sorted set if the last element was popped.",
+			Handler: (*Server).onBZPopMax,
+		},
+		{
+			Name: "zmpop", Arity: -4, Flags: CmdWrite,
+			Group: "sorted-set", Since: "7.0.0", Summary: "Returns the highest- or lowest-scoring members from one or more sorted sets after removing them. Deletes the sorted set if the last member was popped.",
+			GetKeys: numKeysPositions(1),
+			Handler: (*Server).onZMPop,
+		},
+		{
+			Name: "bzmpop", Arity: -5, Flags: CmdWrite | CmdBlocking,
+			Group: "sorted-set", Since: "7.0.0", Summary: "Removes and returns a member by score from one or more sorted sets. Blocks until a member is available otherwise. Deletes the sorted set if the last element was popped.",
+			GetKeys: numKeysPositions(2),
+			Handler: (*Server).onBZMPop,
+		},
+		{
+			Name: "zrandmember", Arity: -2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "sorted-set", Since: "6.2.0", Summary: "Returns one or more random members from a sorted set.",
+			Handler: (*Server).onZRandMember,
+		},
+		{
+			Name: "xadd", Arity: -5, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0", Summary: "Appends a new message to a stream. Creates the key if it doesn't exist.",
+			Handler: (*Server).onXAdd,
+		},
+		{
+			Name: "xrange", Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0", Summary: "Returns the messages from a stream within a range of IDs.",
+			Handler: (*Server).onXRange,
+		},
+		{
+			Name: "xrevrange", Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0", Summary: "Returns the messages from a stream within a range of IDs in reverse order.",
+			Handler: (*Server).onXRevRange,
+		},
+		{
+			Name: "xlen", Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0", Summary: "Return the number of messages in a stream.",
+			Handler: (*Server).onXLen,
+		},
+		{
+			Name: "xtrim", Arity: -4, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0", Summary: "Deletes messages from the beginning of a stream.",
+			Handler: (*Server).onXTrim,
+		},
+		{
+			Name: "xdel", Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0", Summary: "Returns the number of messages after removing them from a stream.",
+			Handler: (*Server).onXDel,
+		},
+		{
+			Name: "xread", Arity: -4, Flags: CmdReadonly | CmdBlocking,
+			Group: "stream", Since: "5.0.0", Summary: "Returns messages from multiple streams with IDs greater than the ones requested. Blocks until a message is available otherwise.",
+			GetKeys: streamsKeysPositions,
+			Handler: (*Server).onXRead,
+		},
+		{
+			Name: "xgroup", Arity: -2, Flags: CmdWrite,
+			FirstKey: 2, LastKey: 2, Step: 1,
+			Group: "stream", Since: "5.0.0", Summary: "A container for consumer groups commands.",
+			Handler: (*Server).onXGroup,
+		},
+		{
+			Name: "xreadgroup", Arity: -7, Flags: CmdWrite | CmdBlocking,
+			Group: "stream", Since: "5.0.0", Summary: "Returns new or historical messages from a stream for a consumer in a group. Blocks until a message is available otherwise.",
+			GetKeys: streamsKeysPositions,
+			Handler: (*Server).onXReadGroup,
+		},
+		{
+			Name: "xack", Arity: -4, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0", Summary: "Returns the number of messages that were successfully acknowledged by the consumer group member of a stream.",
+			Handler: (*Server).onXAck,
+		},
+		{
+			Name: "xpending", Arity: -3, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0", Summary: "Returns the information and entries from a stream consumer group's pending entries list.",
+			Handler: (*Server).onXPending,
+		},
+		{
+			Name: "xclaim", Arity: -6, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "5.0.0", Summary: "Changes, or acquires, ownership of a message in a consumer group, as if the message was delivered a consumer group member.",
+			Handler: (*Server).onXClaim,
+		},
+		{
+			Name: "xautoclaim", Arity: -6, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Group: "stream", Since: "6.2.0", Summary: "Changes, or acquires, ownership of messages in a consumer group, as if the messages were delivered to as consumer group member.",
+			Handler: (*Server).onXAutoClaim,
+		},
+		{
+			Name: "xinfo", Arity: -2, Flags: CmdReadonly,
+			FirstKey: 2, LastKey: 2, Step: 1,
+			Group: "stream", Since: "5.0.0", Summary: "A container for stream introspection commands.",
+			Handler: (*Server).onXInfo,
+		},
+		{
+			Name: "save", Arity: 1, Flags: CmdAdmin | CmdNoScript,
+			Group: "server", Since: "1.0.0", Summary: "Synchronously saves the database(s) to disk.",
+			Handler: (*Server).onSave,
+		},
+		{
+			Name: "config", Arity: -2, Flags: CmdAdmin | CmdNoScript,
+			Group: "server", Since: "2.0.0", Summary: "A container for server configuration commands.",
+			Handler: (*Server).onConfig,
+		},
+		{
+			Name: "info", Arity: -1,
+			Group: "server", Since: "1.0.0", Summary: "Returns information and statistics about the server.",
+			Handler: (*Server).onInfo,
+		},
+		{
+			Name: "command", Arity: -1,
+			Group: "server", Since: "2.8.13", Summary: "Returns detailed information about all commands.",
+			Handler: (*Server).onCommand,
+		},
+		{
+			Name: "replconf", Arity: -1, Flags: CmdAdmin | CmdNoScript,
+			Group: "server", Since: "3.0.0", Summary: "An internal command for configuring the replication stream.",
+			Handler: (*Server).onReplConf,
+		},
+		{
+			Name: "psync", Arity: -3, Flags: CmdAdmin | CmdNoScript,
+			Group: "server", Since: "2.8.0", Summary: "An internal command used in replication.",
+			Handler: (*Server).onPsync,
+		},
+	}
+
+	table := make(map[string]*Command, len(commands))
+	for _, cmd := range commands {
+		table[cmd.Name] = cmd
+	}
+	return table
+}
+
+// lookupCommand finds a command by name, names are case-insensitive.
+func (srv *Server) lookupCommand(name string) *Command {
+	return srv.commands[strings.ToLower(name)]
+}
+
+// checkArity reports whether argc arguments, the command name included,
+// are valid for the command.
+func (cmd *Command) checkArity(argc int) bool {
+	if cmd.Arity < 0 {
+		return argc >= -cmd.Arity
+	}
+	return argc == cmd.Arity
+}
+
+// keyPositions returns the indexes of the keys in argv, the full command
+// line with the name at index 0.
+func (cmd *Command) keyPositions(argv []string) []int {
+	if cmd.GetKeys != nil {
+		return cmd.GetKeys(argv)
+	}
+	if cmd.FirstKey == 0 {
+		return nil
+	}
+
+	last := cmd.LastKey
+	if last < 0 {
+		last = len(argv) + last
+	}
+
+	var res []int
+	for i := cmd.FirstKey; i <= last && i < len(argv); i += cmd.Step {
+		res = append(res, i)
+	}
+	return res
+}
+
+func (cmd *Command) flagNames() []string {
+	var res []string
+	for _, f := range commandFlagNames {
+		if cmd.Flags&f.flag != 0 {
+			res = append(res, f.name)
+		}
+	}
+	if cmd.GetKeys != nil {
+		res = append(res, "movablekeys")
+	}
+	return res
+}
+
+// aclCategories derives the ACL categories Redis would report from the
+// flags and group of the command.
+func (cmd *Command) aclCategories() []string {
+	var res []string
+	if cmd.Flags&CmdWrite != 0 {
+		res = append(res, "@write")
+	}
+	if cmd.Flags&CmdReadonly != 0 {
+		res = append(res, "@read")
+	}
+	if cmd.Flags&CmdAdmin != 0 {
+		res = append(res, "@admin", "@dangerous")
+	}
+	if cmd.Flags&CmdPubSub != 0 {
+		res = append(res, "@pubsub")
+	}
+	if cmd.Flags&CmdBlocking != 0 {
+		res = append(res, "@blocking")
+	}
+	switch cmd.Group {
+	case "generic":
+		res = append(res, "@keyspace")
+	case "server":
+	default:
+		res = append(res, "@"+cmd.Group)
+	}
+	if cmd.Flags&CmdFast != 0 {
+		res = append(res, "@fast")
+	} else {
+		res = append(res, "@slow")
+	}
+	return res
+}
+
+func (srv *Server) sortedCommands() []*Command {
+	res := make([]*Command, 0, len(srv.commands))
+	for _, cmd := range srv.commands {
+		res = append(res, cmd)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// onCommand implements the COMMAND introspection commands.
+// COMMAND [COUNT | INFO [name ...] | DOCS [name ...] | GETKEYS cmd [arg ...]]
+func (srv *Server) onCommand(c *Client, args []string) error {
+	if len(args) == 0 {
+		cmds := srv.sortedCommands()
+		c.writer.WriteArrayLen(len(cmds))
+		for _, cmd := range cmds {
+			writeCommandInfo(c.writer, cmd)
+		}
+		return nil
+	}
+
+	switch sub := strings.ToLower(args[0]); sub {
+	case "count":
+		if len(args) != 1 {
+			return errWrongNumberOfArgs("command|count")
+		}
+		c.writer.WriteInteger(int64(len(srv.commands)))
+	case "info":
+		names := args[1:]
+		if len(names) == 0 {
+			for _, cmd := range srv.sortedCommands() {
+				names = append(names, cmd.Name)
+			}
+		}
+
+		c.writer.WriteArrayLen(len(names))
+		for _, name := range names {
+			cmd := srv.lookupCommand(name)
+			if cmd == nil {
+				c.writer.WriteNullArray()
+				continue
+			}
+			writeCommandInfo(c.writer, cmd)
+		}
+	case "docs":
+		var cmds []*Command
+		if len(args) == 1 {
+			cmds = srv.sortedCommands()
+		}
+		for _, name := range args[1:] {
+			if cmd := srv.lookupCommand(name); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		}
+
+		c.writer.WriteMapLen(len(cmds))
+		for _, cmd := range cmds {
+			c.writer.WriteBulkString(cmd.Name)
+			c.writer.WriteMapLen(3)
+			c.writer.WriteBulkString("summary")
+			c.writer.WriteBulkString(cmd.Summary)
+			c.writer.WriteBulkString("since")
+			c.writer.WriteBulkString(cmd.Since)
+			c.writer.WriteBulkString("group")
+			c.writer.WriteBulkString(cmd.Group)
+		}
+	case "getkeys":
+		if len(args) < 2 {
+			return errWrongNumberOfArgs("command|getkeys")
+		}
+
+		argv := args[1:]
+		cmd := srv.lookupCommand(argv[0])
+		if cmd == nil {
+			return errors.New("ERR Invalid command specified")
+		}
+		if !cmd.checkArity(len(argv)) {
+			return errors.New("ERR Invalid number of arguments specified for command")
+		}
+
+		pos := cmd.keyPositions(argv)
+		if len(pos) == 0 {
+			return errors.New("ERR The command has no key arguments")
+		}
+
+		c.writer.WriteArrayLen(len(pos))
+		for _, i := range pos {
+			c.writer.WriteBulkString(argv[i])
+		}
+	default:
+		return errUnknownSubcommand("command", sub)
+	}
+	return nil
+}
+
+// writeCommandInfo writes the reply of COMMAND INFO for a single command:
+// name, arity, flags, first key, last key, step, ACL categories, tips, key
+// specs and subcommands.
+func writeCommandInfo(w *RESPWriter, cmd *Command) {
+	w.WriteArrayLen(10)
+	w.WriteBulkString(cmd.Name)
+	w.WriteInteger(int64(cmd.Arity))
+
+	flags := cmd.flagNames()
+	w.WriteSetLen(len(flags))
+	for _, f := range flags {
+		w.WriteSimpleString(f)
+	}
+
+	w.WriteInteger(int64(cmd.FirstKey))
+	w.WriteInteger(int64(cmd.LastKey))
+	w.WriteInteger(int64(cmd.Step))
+
+	categories := cmd.aclCategories()
+	w.WriteSetLen(len(categories))
+	for _, cat := range categories {
+		w.WriteSimpleString(cat)
+	}
+
+	w.WriteArrayLen(0) // tips
+	w.WriteArrayLen(0) // key specs
+	w.WriteArrayLen(0) // subcommands
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DB is the live keyspace. It is not safe for concurrent use: every access
+// happens on the event loop, see Server.execute.
+//
+// Keys with a TTL are also in expires, the timestamp they expire at is the
+// ExpireAt of their object. Expired keys are removed lazily when accessed
+// and by the active expire cycle run from the event loop.
+//
+// Hashes with fields that have a TTL are also in hexpires, with the unix ms
+// timestamp their first field expires at. It may be earlier than the real
+// one after a TTL is removed, the fields are then checked for nothing.
+//
+// blocking holds the clients blocked on each key, in the order they
+// blocked, and ready the keys they wait for that were created since, see
+// blockState.
+type DB struct {
+	id       int
+	data     *dict[*Object]
+	expires  *dict[*Object]
+	hexpires *dict[int64]
+	blocking map[string][]*Client
+	ready    []string
+	readySet map[string]bool
+}
+
+func newDB(id int) *DB {
+	return &DB{
+		id:       id,
+		data:     newDict[*Object](),
+		expires:  newDict[*Object](),
+		hexpires: newDict[int64](),
+		blocking: map[string][]*Client{},
+		readySet: map[string]bool{},
+	}
+}
+
+func mstime() int64 {
+	return time.Now().UnixMilli()
+}
+
+// expireIfNeeded deletes key if its TTL is over and reports whether it did.
+// The expired fields of a hash are removed too, with the key if none is
+// left.
+func (db *DB) expireIfNeeded(key string) bool {
+	now := mstime()
+	if obj, ok := db.expires.Get(key); ok && obj.ExpireAt <= now {
+		db.remove(key)
+		return true
+	}
+	if at, ok := db.hexpires.Get(key); ok && at <= now {
+		return db.expireHashFields(key, now)
+	}
+	return false
+}
+
+// expireHashFields removes the fields of the hash at key expired at now,
+// and key once no field is left. It reports whether key was deleted.
+func (db *DB) expireHashFields(key string, now int64) bool {
+	obj, _ := db.data.Get(key)
+	hashExpireFields(obj, now)
+	if hashLen(obj) == 0 {
+		db.remove(key)
+		return true
+	}
+	db.trackHashFields(key, obj)
+	return false
+}
+
+// trackHashFields updates hexpires after obj is stored at key or has
+// fields removed.
+func (db *DB) trackHashFields(key string, obj *Object) {
+	if obj.Type == FieldTypeHash {
+		if at := hashMinExpire(obj); at != 0 {
+			db.hexpires.Set(key, at)
+			return
+		}
+	}
+	db.hexpires.Delete(key)
+}
+
+// trackHashField records that a field of the hash at key expires at.
+func (db *DB) trackHashField(key string, at int64) {
+	if first, ok := db.hexpires.Get(key); !ok || at < first {
+		db.hexpires.Set(key, at)
+	}
+}
+
+// remove deletes key whatever its state.
+func (db *DB) remove(key string) {
+	db.data.Delete(key)
+	db.expires.Delete(key)
+	db.hexpires.Delete(key)
+}
+
+// lookup returns the object at key, nil if there is none, and records the
+// access for OBJECT IDLETIME and FREQ.
+func (db *DB) lookup(key string) *Object {
+	obj := db.lookupNoTouch(key)
+	if obj != nil {
+		obj.touch()
+	}
+	return obj
+}
+
+// lookupNoTouch is lookup for commands that inspect a key without
+// counting as an access, like TYPE or OBJECT.
+func (db *DB) lookupNoTouch(key string) *Object {
+	db.expireIfNeeded(key)
+	obj, _ := db.data.Get(key)
+	return obj
+}
+
+// lookupType is lookup for commands working on one type of value. It
+// fails with ErrWrongType when key holds another type.
+func (db *DB) lookupType(key string, t FieldType) (*Object, error) {
+	obj := db.lookup(key)
+	if obj != nil && obj.Type != t {
+		return nil, ErrWrongType
+	}
+	return obj, nil
+}
+
+// Exists reports whether key is in the keyspace and not expired.
+func (db *DB) Exists(key string) bool {
+	return db.lookupNoTouch(key) != nil
+}
+
+// Set stores obj and discards any TTL key had, like SET without KEEPTTL.
+func (db *DB) Set(key string, obj *Object) {
+	obj.ExpireAt = 0
+	db.add(key, obj)
+}
+
+// SetKeepTTL stores obj and keeps the TTL key had, like SET KEEPTTL.
+func (db *DB) SetKeepTTL(key string, obj *Object) {
+	obj.ExpireAt = 0
+	if old := db.lookupNoTouch(key); old != nil {
+		obj.ExpireAt = old.ExpireAt
+	}
+	db.add(key, obj)
+}
+
+// add stores obj with the TTL it carries.
+func (db *DB) add(key string, obj *Object) {
+	db.data.Set(key, obj)
+	if obj.ExpireAt != 0 {
+		db.expires.Set(key, obj)
+	} else {
+		db.expires.Delete(key)
+	}
+	db.trackHashFields(key, obj)
+	db.signalKeyAsReady(key)
+}
+
+// signalKeyAsReady records that key got a value if clients are blocked on
+// it.
+func (db *DB) signalKeyAsReady(key string) {
+	if _, ok := db.blocking[key]; !ok || db.readySet[key] {
+		return
+	}
+	db.ready = append(db.ready, key)
+	db.readySet[key] = true
+}
+
+// SetExpire sets the unix ms timestamp key expires at, key must exist.
+func (db *DB) SetExpire(key string, at int64) {
+	obj, _ := db.data.Get(key)
+	obj.ExpireAt = at
+	db.expires.Set(key, obj)
+}
+
+// Expire returns the unix ms timestamp key expires at, if it has a TTL.
+func (db *DB) Expire(key string) (int64, bool) {
+	obj, ok := db.expires.Get(key)
+	if !ok {
+		return 0, false
+	}
+	return obj.ExpireAt, true
+}
+
+// Persist removes the TTL of key and reports whether it had one.
+func (db *DB) Persist(key string) bool {
+	obj, ok := db.expires.Delete(key)
+	if ok {
+		obj.ExpireAt = 0
+	}
+	return ok
+}
+
+// Delete removes key and reports whether it existed.
+func (db *DB) Delete(key string) bool {
+	if db.expireIfNeeded(key) {
+		return false
+	}
+
+	_, ok := db.data.Get(key)
+	db.remove(key)
+	return ok
+}
+
+func (db *DB) Len() int {
+	return db.data.Len()
+}
+
+// Flush removes every key.
+func (db *DB) Flush() {
+	db.data = newDict[*Object]()
+	db.expires = newDict[*Object]()
+	db.hexpires = newDict[int64]()
+}
+
+// swap exchanges the keys of db and other. Clients select databases by
+// pointer so the contents move, not the DB values, and the clients blocked
+// on them stay where they are.
+func (db *DB) swap(other *DB) {
+	db.data, other.data = other.data, db.data
+	db.expires, other.expires = other.expires, db.expires
+	db.hexpires, other.hexpires = other.hexpires, db.hexpires
+
+	for _, d := range []*DB{db, other} {
+		for key := range d.blocking {
+			if d.Exists(key) {
+				d.signalKeyAsReady(key)
+			}
+		}
+	}
+}
+
+// Scan visits the keys of the bucket at cursor that are not expired, see
+// dict.Scan.
+func (db *DB) Scan(cursor uint64, fn func(key string)) uint64 {
+	var keys []string
+	next := db.data.Scan(cursor, func(key string, _ *Object) {
+		keys = append(keys, key)
+	})
+
+	for _, key := range keys {
+		if !db.expireIfNeeded(key) {
+			fn(key)
+		}
+	}
+	return next
+}
+
+// RandomKey returns a random key that is not expired.
+func (db *DB) RandomKey() (string, bool) {
+	// give up after a few expired keys in a row, the keyspace may be
+	// made only of them
+	for tries := 0; tries < 100; tries++ {
+		key, _, ok := db.data.Random()
+		if !ok {
+			return "", false
+		}
+		if !db.expireIfNeeded(key) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Type returns the name of the type of the value at key as reported by
+// TYPE, "none" when key does not exist.
+func (db *DB) Type(key string) string {
+	obj := db.lookupNoTouch(key)
+	if obj == nil {
+		return "none"
+	}
+	return obj.Type.String()
+}
+
+const (
+	activeExpireLookups  = 20 // keys sampled per round
+	activeExpireStale    = 25 // percent of expired samples to do another round
+	activeExpireTimeSpan = 25 * time.Millisecond
+)
+
+// activeExpireCycle removes expired keys and hash fields nobody accesses.
+// Like Redis it samples a few random keys with a TTL, and hashes with
+// field TTLs, and keeps going while a large part of the sample was
+// expired, within a time budget so the loop is not starved.
+func (db *DB) activeExpireCycle() int {
+	start := time.Now()
+	expired := 0
+	for {
+		now := mstime()
+		sampled, stale := 0, 0
+		for ; sampled < activeExpireLookups && db.expires.Len() > 0; sampled++ {
+			key, obj, _ := db.expires.Random()
+			if obj.ExpireAt <= now {
+				db.remove(key)
+				stale++
+			}
+		}
+		for i := 0; i < activeExpireLookups && db.hexpires.Len() > 0; i++ {
+			key, at, _ := db.hexpires.Random()
+			sampled++
+			if at <= now {
+				db.expireHashFields(key, now)
+				stale++
+			}
+		}
+		expired += stale
+
+		if sampled == 0 || stale*100/sampled <= activeExpireStale {
+			return expired
+		}
+		if time.Since(start) > activeExpireTimeSpan {
+			return expired
+		}
+	}
+}
+
+func (srv *Server) setupDatabases() {
+	n, err := strconv.Atoi(srv.config["databases"])
+	if err != nil || n < 1 {
+		log.Fatalf("setupDatabases: invalid databases %q\n", srv.config["databases"])
+	}
+
+	srv.dbs = make([]*DB, n)
+	for i := range srv.dbs {
+		srv.dbs[i] = newDB(i)
+	}
+}
+
+// parseDBIndex returns the database at index s or errMsg when s is out of
+// range.
+func (srv *Server) parseDBIndex(s string, errMsg string) (*DB, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, ErrNotInteger
+	}
+	if id < 0 || id >= len(srv.dbs) {
+		return nil, errors.New(errMsg)
+	}
+	return srv.dbs[id], nil
+}
+
+// SELECT index
+func (srv *Server) onSelect(c *Client, args []string) error {
+	db, err := srv.parseDBIndex(args[0], "ERR DB index is out of range")
+	if err != nil {
+		return err
+	}
+
+	c.db = db
+	c.writer.WriteSimpleString("OK")
+	return nil
+}
+
+// MOVE key db
+func (srv *Server) onMove(c *Client, args []string) error {
+	key := args[0]
+	dst, err := srv.parseDBIndex(args[1], "ERR DB index is out of range")
+	if err != nil {
+		return err
+	}
+
+	src := c.db
+	if src == dst {
+		return errors.New("ERR source and destination objects are the same")
+	}
+
+	obj := src.lookupNoTouch(key)
+	if obj == nil || dst.Exists(key) {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+
+	src.Delete(key)
+	dst.add(key, obj)
+
+	c.writer.WriteInteger(1)
+	return nil
+}
+
+// SWAPDB index1 index2
+func (srv *Server) onSwapDB(c *Client, args []string) error {
+	a, err := srv.parseDBIndex(args[0], "ERR invalid first DB index")
+	if err != nil {
+		return err
+	}
+	b, err := srv.parseDBIndex(args[1], "ERR invalid second DB index")
+	if err != nil {
+		return err
+	}
+
+	a.swap(b)
+	c.writer.WriteSimpleString("OK")
+	return nil
+}
+
+// parseFlushMode accepts the optional ASYNC or SYNC argument of FLUSHDB
+// and FLUSHALL. Flushing is always synchronous here, dropping the maps is
+// already cheap.
+func parseFlushMode(args []string) error {
+	if len(args) > 1 {
+		return ErrSyntax
+	}
+	if len(args) == 1 {
+		switch strings.ToLower(args[0]) {
+		case "async", "sync":
+		default:
+			return ErrSyntax
+		}
+	}
+	return nil
+}
+
+// FLUSHDB [ASYNC | SYNC]
+func (srv *Server) onFlushDB(c *Client, args []string) error {
+	if err := parseFlushMode(args); err != nil {
+		return err
+	}
+
+	c.db.Flush()
+	c.writer.WriteSimpleString("OK")
+	return nil
+}
+
+// FLUSHALL [ASYNC | SYNC]
+func (srv *Server) onFlushAll(c *Client, args []string) error {
+	if err := parseFlushMode(args); err != nil {
+		return err
+	}
+
+	for _, db := range srv.dbs {
+		db.Flush()
+	}
+	c.writer.WriteSimpleString("OK")
+	return nil
+}
+
+func (srv *Server) onDBSize(c *Client, args []string) error {
+	c.writer.WriteInteger(int64(c.db.Len()))
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDBExpire(t *testing.T) {
+	db := newDB(0)
+
+	db.Set("volatile", newStringObject("1"))
+	db.SetExpire("volatile", mstime()-1)
+	if db.lookup("volatile") != nil {
+		t.Fatal("expected expired key to be gone on access")
+	}
+
+	db.Set("overwritten", newStringObject("1"))
+	db.SetExpire("overwritten", mstime()-1)
+	db.Set("overwritten", newStringObject("2"))
+	if obj := db.lookup("overwritten"); obj == nil || obj.str() != "2" {
+		t.Fatalf("expected SET to clear the TTL got %v", obj)
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		db.Set(key, newStringObject("v"))
+		db.SetExpire(key, mstime()-1)
+	}
+	db.Set("persistent", newStringObject("v"))
+
+	for db.activeExpireCycle() > 0 {
+	}
+	if db.Len() != 2 || db.expires.Len() != 0 {
+		t.Fatalf("expected active expire to remove every expired key, left %d keys", db.Len())
+	}
+}
+
+func TestDBLookupType(t *testing.T) {
+	db := newDB(0)
+	db.Set("list", newObject(FieldTypeList, EncodingRaw, nil))
+
+	if _, err := db.lookupType("list", FieldTypeString); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType got %v", err)
+	}
+	if obj, err := db.lookupType("missing", FieldTypeString); obj != nil || err != nil {
+		t.Fatalf("expected no object and no error got %v %v", obj, err)
+	}
+	if db.Type("list") != "list" || db.Type("missing") != "none" {
+		t.Fatalf("unexpected types %q %q", db.Type("list"), db.Type("missing"))
+	}
+}
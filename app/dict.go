@@ -0,0 +1,189 @@
+package main
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand"
+)
+
+const dictMinSize = 4
+
+// dict is a chained hash table with a power of two number of buckets, the
+// layout Redis uses for its keyspace. Unlike a Go map it exposes its
+// buckets, which is what SCAN needs: a cursor walking the buckets in
+// reverse binary order returns every element present for the whole
+// iteration even if the table is resized between two calls.
+type dict[V any] struct {
+	seed      maphash.Seed
+	table     []*dictEntry[V]
+	used      int
+	iterating int // resizing is paused while Iterate runs
+}
+
+type dictEntry[V any] struct {
+	key  string
+	val  V
+	next *dictEntry[V]
+}
+
+func newDict[V any]() *dict[V] {
+	return &dict[V]{seed: maphash.MakeSeed()}
+}
+
+func (d *dict[V]) Len() int {
+	return d.used
+}
+
+func (d *dict[V]) bucket(key string) int {
+	return int(maphash.String(d.seed, key) & uint64(len(d.table)-1))
+}
+
+func (d *dict[V]) find(key string) *dictEntry[V] {
+	if d.used == 0 {
+		return nil
+	}
+	for e := d.table[d.bucket(key)]; e != nil; e = e.next {
+		if e.key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+func (d *dict[V]) Get(key string) (V, bool) {
+	if e := d.find(key); e != nil {
+		return e.val, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Set adds or replaces key and reports whether it was added.
+func (d *dict[V]) Set(key string, val V) bool {
+	if e := d.find(key); e != nil {
+		e.val = val
+		return false
+	}
+
+	if d.used >= len(d.table) {
+		d.resize(d.used * 2)
+	}
+	i := d.bucket(key)
+	d.table[i] = &dictEntry[V]{key: key, val: val, next: d.table[i]}
+	d.used++
+	return true
+}
+
+// Delete removes key and returns its value.
+func (d *dict[V]) Delete(key string) (V, bool) {
+	var zero V
+	if d.used == 0 {
+		return zero, false
+	}
+
+	i := d.bucket(key)
+	for prev, e := (*dictEntry[V])(nil), d.table[i]; e != nil; prev, e = e, e.next {
+		if e.key != key {
+			continue
+		}
+
+		if prev == nil {
+			d.table[i] = e.next
+		} else {
+			prev.next = e.next
+		}
+		d.used--
+		d.shrinkIfNeeded()
+		return e.val, true
+	}
+	return zero, false
+}
+
+func (d *dict[V]) shrinkIfNeeded() {
+	if d.iterating == 0 && len(d.table) > dictMinSize && d.used*8 < len(d.table) {
+		d.resize(d.used)
+	}
+}
+
+// resize rehashes every entry in a table of the smallest power of two
+// holding n entries. The whole table is rehashed at once, there is no
+// incremental rehashing since commands never run concurrently.
+func (d *dict[V]) resize(n int) {
+	size := dictMinSize
+	for size < n {
+		size *= 2
+	}
+
+	old := d.table
+	d.table = make([]*dictEntry[V], size)
+	for _, e := range old {
+		for e != nil {
+			next := e.next
+			i := d.bucket(e.key)
+			e.next = d.table[i]
+			d.table[i] = e
+			e = next
+		}
+	}
+}
+
+// Iterate calls fn for every entry until it returns false. fn must not
+// add entries, deleting the current one is allowed.
+func (d *dict[V]) Iterate(fn func(key string, val V) bool) {
+	d.iterating++
+	defer func() {
+		d.iterating--
+		d.shrinkIfNeeded()
+	}()
+
+	for _, e := range d.table {
+		for e != nil {
+			next := e.next
+			if !fn(e.key, e.val) {
+				return
+			}
+			e = next
+		}
+	}
+}
+
+// Random returns a random entry, ok is false when the dict is empty.
+func (d *dict[V]) Random() (key string, val V, ok bool) {
+	if d.used == 0 {
+		return "", val, false
+	}
+
+	var e *dictEntry[V]
+	for e == nil {
+		e = d.table[rand.Intn(len(d.table))]
+	}
+
+	n := 0
+	for it := e; it != nil; it = it.next {
+		n++
+	}
+	for i := rand.Intn(n); i > 0; i-- {
+		e = e.next
+	}
+	return e.key, e.val, true
+}
+
+// Scan calls fn for the entries of the bucket at cursor and returns the
+// cursor of the next bucket, 0 once the whole table was visited. The
+// cursor is incremented on its reversed bits so buckets that split or
+// merge on resize are never skipped.
+func (d *dict[V]) Scan(cursor uint64, fn func(key string, val V)) uint64 {
+	if d.used == 0 {
+		return 0
+	}
+
+	mask := uint64(len(d.table) - 1)
+	for e := d.table[cursor&mask]; e != nil; e = e.next {
+		fn(e.key, e.val)
+	}
+
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDict(t *testing.T) {
+	d := newDict[int]()
+	for i := 0; i < 1000; i++ {
+		if !d.Set(fmt.Sprint(i), i) {
+			t.Fatalf("expected %d to be added", i)
+		}
+	}
+	if d.Set("10", -10) {
+		t.Fatal("expected 10 to be replaced")
+	}
+	if v, ok := d.Get("10"); !ok || v != -10 {
+		t.Fatalf("expected -10 got %d %v", v, ok)
+	}
+
+	for i := 0; i < 990; i++ {
+		if _, ok := d.Delete(fmt.Sprint(i)); !ok {
+			t.Fatalf("expected %d to be deleted", i)
+		}
+	}
+	if d.Len() != 10 || len(d.table) > 16 {
+		t.Fatalf("expected 10 entries in a shrunk table got %d in %d buckets", d.Len(), len(d.table))
+	}
+}
+
+// TestDictScanResize checks that every element present for the whole scan
+// is returned while the table grows and shrinks between calls.
+func TestDictScanResize(t *testing.T) {
+	d := newDict[int]()
+	for i := 0; i < 100; i++ {
+		d.Set(fmt.Sprint(i), i)
+	}
+
+	seen := map[string]bool{}
+	cursor, step := uint64(0), 0
+	for {
+		cursor = d.Scan(cursor, func(key string, _ int) { seen[key] = true })
+		if cursor == 0 {
+			break
+		}
+
+		// grow then shrink the table while iterating
+		step++
+		switch {
+		case step < 20:
+			for i := 0; i < 50; i++ {
+				d.Set(fmt.Sprintf("tmp:%d:%d", step, i), i)
+			}
+		case step == 20:
+			d.Iterate(func(key string, _ int) bool {
+				if key[0] == 't' {
+					d.Delete(key)
+				}
+				return true
+			})
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if !seen[fmt.Sprint(i)] {
+			t.Fatalf("scan missed %d", i)
+		}
+	}
+}
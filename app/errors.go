@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by command handlers are sent to the client as error
+// replies, so their text starts with the Redis error code.
+var (
+	ErrSyntax     = errors.New("ERR syntax error")
+	ErrNotInteger = errors.New("ERR value is not an integer or out of range")
+	ErrNoAuth     = errors.New("NOAUTH Authentication required.")
+	ErrWrongPass  = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	ErrWrongType  = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrReadOnly   = errors.New("READONLY You can't write against a read only replica.")
+)
+
+func errWrongNumberOfArgs(cmd string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd))
+}
+
+func errUnknownCommand(cmd string, args []string) error {
+	var sb strings.Builder
+	for _, arg := range args {
+		if sb.Len() >= 128 {
+			break
+		}
+		sb.WriteString(fmt.Sprintf("'%s' ", truncate(arg, 128-sb.Len())))
+	}
+	return fmt.Errorf("ERR unknown command '%s', with args beginning with: %s", truncate(cmd, 128), sb.String())
+}
+
+func errUnknownSubcommand(cmd, sub string) error {
+	return fmt.Errorf("ERR unknown subcommand '%s'. Try %s HELP.", truncate(sub, 128), strings.ToUpper(cmd))
+}
+
+func errInvalidExpireTime(cmd string) error {
+	return fmt.Errorf("ERR invalid expire time in '%s' command", strings.ToLower(cmd))
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,43 @@
+package main
+
+import "time"
+
+// Commands run one at a time on a single goroutine, the event loop, so the
+// keyspace needs no locking. Connection goroutines only parse requests and
+// flush replies; background work runs from serverCron on the loop too.
+
+const (
+	jobQueueSize = 1024
+	hz           = 10 // serverCron runs per second
+)
+
+func (srv *Server) eventLoop() {
+	ticker := time.NewTicker(time.Second / hz)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case job := <-srv.jobs:
+			job()
+		case <-ticker.C:
+			srv.serverCron()
+		}
+	}
+}
+
+// serverCron does the background work of the server.
+func (srv *Server) serverCron() {
+	for _, db := range srv.dbs {
+		db.activeExpireCycle()
+	}
+}
+
+// execute runs fn on the event loop and waits for it to finish.
+func (srv *Server) execute(fn func()) {
+	done := make(chan struct{})
+	srv.jobs <- func() {
+		fn()
+		close(done)
+	}
+	<-done
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// expireGeneric implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT. when is
+// relative to basetime (0 for the AT variants) and given in unit ms.
+// EXPIRE key seconds [NX | XX | GT | LT]
+func (srv *Server) expireGeneric(c *Client, cmd string, args []string, basetime, unit int64) error {
+	key := args[0]
+	when, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return ErrNotInteger
+	}
+
+	var nx, xx, gt, lt bool
+	for _, opt := range args[2:] {
+		switch strings.ToLower(opt) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		default:
+			return fmt.Errorf("ERR Unsupported option %s", opt)
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return errors.New("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return errors.New("ERR GT and LT options at the same time are not compatible")
+	}
+
+	if when > math.MaxInt64/unit || when < math.MinInt64/unit {
+		return errInvalidExpireTime(cmd)
+	}
+	when *= unit
+	if (when > 0 && basetime > math.MaxInt64-when) || (when < 0 && basetime < math.MinInt64-when) {
+		return errInvalidExpireTime(cmd)
+	}
+	when += basetime
+
+	if !c.db.Exists(key) {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+
+	current, hasTTL := c.db.Expire(key)
+	switch {
+	case nx && hasTTL,
+		xx && !hasTTL,
+		// no TTL counts as an infinite one
+		gt && (!hasTTL || when <= current),
+		lt && hasTTL && when >= current:
+		c.writer.WriteInteger(0)
+		return nil
+	}
+
+	if when <= mstime() {
+		c.db.Delete(key)
+	} else {
+		c.db.SetExpire(key, when)
+	}
+	c.writer.WriteInteger(1)
+	return nil
+}
+
+func (srv *Server) onExpire(c *Client, args []string) error {
+	return srv.expireGeneric(c, "expire", args, mstime(), 1000)
+}
+
+func (srv *Server) onPExpire(c *Client, args []string) error {
+	return srv.expireGeneric(c, "pexpire", args, mstime(), 1)
+}
+
+func (srv *Server) onExpireAt(c *Client, args []string) error {
+	return srv.expireGeneric(c, "expireat", args, 0, 1000)
+}
+
+func (srv *Server) onPExpireAt(c *Client, args []string) error {
+	return srv.expireGeneric(c, "pexpireat", args, 0, 1)
+}
+
+// ttlGeneric implements TTL, PTTL, EXPIRETIME and PEXPIRETIME. It replies
+// -2 when key does not exist and -1 when it has no TTL.
+func (srv *Server) ttlGeneric(c *Client, key string, ms, absolute bool) {
+	if !c.db.Exists(key) {
+		c.writer.WriteInteger(-2)
+		return
+	}
+
+	at, ok := c.db.Expire(key)
+	if !ok {
+		c.writer.WriteInteger(-1)
+		return
+	}
+	c.writer.WriteInteger(ttlFromExpire(at, ms, absolute))
+}
+
+// ttlFromExpire converts the unix ms timestamp at to the reply of the TTL
+// commands: the time left or the timestamp itself when absolute, in ms or
+// seconds.
+func ttlFromExpire(at int64, ms, absolute bool) int64 {
+	res := at
+	if !absolute {
+		res = at - mstime()
+		if res < 0 {
+			res = 0
+		}
+	}
+	if !ms {
+		// rounded to the nearest second like Redis does
+		res = (res + 500) / 1000
+	}
+	return res
+}
+
+func (srv *Server) onTTL(c *Client, args []string) error {
+	srv.ttlGeneric(c, args[0], false, false)
+	return nil
+}
+
+func (srv *Server) onPTTL(c *Client, args []string) error {
+	srv.ttlGeneric(c, args[0], true, false)
+	return nil
+}
+
+func (srv *Server) onExpireTime(c *Client, args []string) error {
+	srv.ttlGeneric(c, args[0], false, true)
+	return nil
+}
+
+func (srv *Server) onPExpireTime(c *Client, args []string) error {
+	srv.ttlGeneric(c, args[0], true, true)
+	return nil
+}
+
+func (srv *Server) onPersist(c *Client, args []string) error {
+	if !c.db.Exists(args[0]) || !c.db.Persist(args[0]) {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+	c.writer.WriteInteger(1)
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+// stringMatch reports whether s matches the glob-style pattern, with the
+// rules of Redis' stringmatchlen:
+//
+//	?      any single character
+//	*      any sequence of characters, including none
+//	[abc]  one of the characters, [^abc] none of them, [a-z] a range
+//	\x     the character x literally
+func stringMatch(pattern, s string, nocase bool) bool {
+	skipLongerMatches := false
+	return stringMatchImpl(pattern, s, nocase, &skipLongerMatches, 0)
+}
+
+func stringMatchImpl(pattern, s string, nocase bool, skipLongerMatches *bool, nesting int) bool {
+	// protection against abusive patterns
+	if nesting > 1000 {
+		return false
+	}
+
+	p, i := 0, 0
+	for p < len(pattern) && i < len(s) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for ; i < len(s); i++ {
+				if stringMatchImpl(pattern[p+1:], s[i:], nocase, skipLongerMatches, nesting+1) {
+					return true
+				}
+				// a longer match of this star cannot succeed either, the
+				// rest of the pattern already failed on every suffix
+				if *skipLongerMatches {
+					return false
+				}
+			}
+			*skipLongerMatches = true
+			return false
+		case '?':
+			i++
+		case '[':
+			p++
+			not := p < len(pattern) && pattern[p] == '^'
+			if not {
+				p++
+			}
+
+			match := false
+			for {
+				if p < len(pattern) && pattern[p] == '\\' && p+1 < len(pattern) {
+					p++
+					if pattern[p] == s[i] {
+						match = true
+					}
+				} else if p < len(pattern) && pattern[p] == ']' {
+					break
+				} else if p >= len(pattern) {
+					p--
+					break
+				} else if p+2 < len(pattern) && pattern[p+1] == '-' {
+					start, end, c := pattern[p], pattern[p+2], s[i]
+					if start > end {
+						start, end = end, start
+					}
+					if nocase {
+						start, end, c = toLower(start), toLower(end), toLower(c)
+					}
+					p += 2
+					if c >= start && c <= end {
+						match = true
+					}
+				} else if equalByte(pattern[p], s[i], nocase) {
+					match = true
+				}
+				p++
+			}
+
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			i++
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+		default:
+			if !equalByte(pattern[p], s[i], nocase) {
+				return false
+			}
+			i++
+		}
+
+		p++
+		if i == len(s) {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			break
+		}
+	}
+
+	// trailing stars match the empty rest of s, Redis only gets there for
+	// patterns it special cases like a lone "*"
+	if i == len(s) {
+		for p < len(pattern) && pattern[p] == '*' {
+			p++
+		}
+	}
+	return p == len(pattern) && i == len(s)
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return toLower(a) == toLower(b)
+	}
+	return a == b
+}
+
+func toLower(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
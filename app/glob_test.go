@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		nocase  bool
+		expect  bool
+	}{
+		{"*", "", false, true},
+		{"*", "anything", false, true},
+		{"h?llo", "hello", false, true},
+		{"h?llo", "hllo", false, false},
+		{"h*llo", "heeeello", false, true},
+		{"h[ae]llo", "hallo", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-b]llo", "hbllo", false, true},
+		{"h[b-a]llo", "hallo", false, true},
+		{"h[a-b]llo", "hcllo", false, false},
+		{"h\\*llo", "h*llo", false, true},
+		{"h\\*llo", "hello", false, false},
+		{"user:*:name", "user:42:name", false, true},
+		{"user:*:name", "user:42:email", false, false},
+		{"HELLO", "hello", true, true},
+		{"HELLO", "hello", false, false},
+		{"a*", "", false, false},
+		{"a**", "a", false, true},
+		{"[abc", "a", false, true},
+		{"*a*a*a*a*a*a*a*a*a*a*b", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := stringMatch(tt.pattern, tt.s, tt.nocase); got != tt.expect {
+			t.Errorf("stringMatch(%q, %q, %v) expected %v got %v", tt.pattern, tt.s, tt.nocase, tt.expect, got)
+		}
+	}
+}
@@ -0,0 +1,717 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultHashMaxListpackEntries = 128
+	defaultHashMaxListpackValue   = 64
+)
+
+// A hash starts as a listpack of field value pairs, compact and fast
+// enough while small. It is converted to a hashTable once it holds more
+// than hash-max-listpack-entries fields or a field or value longer than
+// hash-max-listpack-value bytes, and never converted back.
+//
+// Fields can have a TTL of their own, see hash_expire.go. A listpack hash
+// with one becomes a listpackex, made of field value TTL triplets.
+
+// hashTable is the hashtable encoding of a hash. expires holds the unix ms
+// timestamp the fields with a TTL expire at.
+type hashTable struct {
+	fields  *dict[string]
+	expires map[string]int64
+}
+
+func newHashTable() *hashTable {
+	return &hashTable{fields: newDict[string](), expires: map[string]int64{}}
+}
+
+func (srv *Server) newHashObject() *Object {
+	return newObject(FieldTypeHash, EncodingListpack, newListpack())
+}
+
+// hashTryConversion converts o to a hashTable before strs, the fields and
+// values about to be added, make its listpack exceed the size limit of an
+// entry.
+func (srv *Server) hashTryConversion(o *Object, strs ...string) {
+	if o.Encoding == EncodingHashtable {
+		return
+	}
+	max := srv.configInt("hash-max-listpack-value", defaultHashMaxListpackValue)
+	for _, s := range strs {
+		if len(s) > max {
+			hashConvert(o)
+			return
+		}
+	}
+}
+
+// hashConvert switches o from a listpack to a hashTable.
+func hashConvert(o *Object) {
+	ht := newHashTable()
+	hashIterateWithTTL(o, func(field, value string, at int64) bool {
+		ht.fields.Set(field, value)
+		if at != 0 {
+			ht.expires[field] = at
+		}
+		return true
+	})
+	o.Encoding = EncodingHashtable
+	o.Value = ht
+}
+
+// hashStride is the number of listpack entries of a field.
+func hashStride(o *Object) int {
+	if o.Encoding == EncodingListpackEx {
+		return 3
+	}
+	return 2
+}
+
+func hashLen(o *Object) int {
+	if o.Encoding == EncodingHashtable {
+		return o.Value.(*hashTable).fields.Len()
+	}
+	return o.Value.(*listpack).Len() / hashStride(o)
+}
+
+// hashFind returns the offset of field in a listpack hash, -1 if missing.
+func hashFind(o *Object, field string) int {
+	lp := o.Value.(*listpack)
+	return lp.Find(lp.First(), field, hashStride(o)-1)
+}
+
+func hashGet(o *Object, field string) (string, bool) {
+	if o.Encoding == EncodingHashtable {
+		return o.Value.(*hashTable).fields.Get(field)
+	}
+
+	p := hashFind(o, field)
+	if p == -1 {
+		return "", false
+	}
+	lp := o.Value.(*listpack)
+	return lp.Get(lp.Next(p)), true
+}
+
+// hashSet sets field to value, discarding any TTL it had like HSET, and
+// reports whether field is new. Call hashTryConversion first so the
+// listpack never holds a too long entry.
+func (srv *Server) hashSet(o *Object, field, value string) bool {
+	return srv.hashSetGeneric(o, field, value, false)
+}
+
+// hashSetKeepTTL is hashSet keeping the TTL of field, like HINCRBY.
+func (srv *Server) hashSetKeepTTL(o *Object, field, value string) bool {
+	return srv.hashSetGeneric(o, field, value, true)
+}
+
+func (srv *Server) hashSetGeneric(o *Object, field, value string, keepTTL bool) bool {
+	if o.Encoding == EncodingHashtable {
+		ht := o.Value.(*hashTable)
+		if !keepTTL {
+			delete(ht.expires, field)
+		}
+		return ht.fields.Set(field, value)
+	}
+
+	lp := o.Value.(*listpack)
+	if p := hashFind(o, field); p != -1 {
+		p = lp.Next(p)
+		lp.Replace(p, value)
+		if o.Encoding == EncodingListpackEx && !keepTTL {
+			lp.Replace(lp.Next(p), "0")
+		}
+		return false
+	}
+	lp.Append(field)
+	lp.Append(value)
+	if o.Encoding == EncodingListpackEx {
+		lp.Append("0")
+	}
+	if hashLen(o) > srv.configInt("hash-max-listpack-entries", defaultHashMaxListpackEntries) {
+		hashConvert(o)
+	}
+	return true
+}
+
+func hashDelete(o *Object, field string) bool {
+	if o.Encoding == EncodingHashtable {
+		ht := o.Value.(*hashTable)
+		delete(ht.expires, field)
+		_, ok := ht.fields.Delete(field)
+		return ok
+	}
+
+	p := hashFind(o, field)
+	if p == -1 {
+		return false
+	}
+	lp := o.Value.(*listpack)
+	for i := 0; i < hashStride(o); i++ {
+		p = lp.Delete(p)
+	}
+	return true
+}
+
+// hashIterate calls fn for every field and its value until it returns
+// false. o must not be modified meanwhile.
+func hashIterate(o *Object, fn func(field, value string) bool) {
+	hashIterateWithTTL(o, func(field, value string, _ int64) bool {
+		return fn(field, value)
+	})
+}
+
+// hashIterateWithTTL is hashIterate also passing the unix ms timestamp
+// fields expire at, 0 for none.
+func hashIterateWithTTL(o *Object, fn func(field, value string, at int64) bool) {
+	if o.Encoding == EncodingHashtable {
+		ht := o.Value.(*hashTable)
+		ht.fields.Iterate(func(field, value string) bool {
+			return fn(field, value, ht.expires[field])
+		})
+		return
+	}
+
+	lp := o.Value.(*listpack)
+	for p := lp.First(); p != -1; {
+		v := lp.Next(p)
+		var at int64
+		next := lp.Next(v)
+		if o.Encoding == EncodingListpackEx {
+			at, _ = lp.GetInt(next)
+			next = lp.Next(next)
+		}
+		if !fn(lp.Get(p), lp.Get(v), at) {
+			return
+		}
+		p = next
+	}
+}
+
+// hashRandom returns a random field and its value of a non empty hash.
+func hashRandom(o *Object) (string, string) {
+	if o.Encoding == EncodingHashtable {
+		field, value, _ := o.Value.(*hashTable).fields.Random()
+		return field, value
+	}
+
+	lp := o.Value.(*listpack)
+	p := lp.Seek(hashStride(o) * rand.Intn(hashLen(o)))
+	return lp.Get(p), lp.Get(lp.Next(p))
+}
+
+// hashDup returns a deep copy of the value of a hash.
+func hashDup(o *Object) any {
+	if o.Encoding != EncodingHashtable {
+		b := o.Value.(*listpack).b
+		return &listpack{b: append([]byte(nil), b...)}
+	}
+
+	ht := o.Value.(*hashTable)
+	res := newHashTable()
+	ht.fields.Iterate(func(field, value string) bool {
+		res.fields.Set(field, value)
+		return true
+	})
+	for field, at := range ht.expires {
+		res.expires[field] = at
+	}
+	return res
+}
+
+// hashDeleteIfEmpty removes key once its hash has no field left.
+func hashDeleteIfEmpty(db *DB, key string, obj *Object) {
+	if hashLen(obj) == 0 {
+		db.Delete(key)
+	}
+}
+
+// hashLookupOrCreate returns the hash at key, creating an empty one when
+// the key does not exist.
+func (srv *Server) hashLookupOrCreate(db *DB, key string) (*Object, error) {
+	obj, err := db.lookupType(key, FieldTypeHash)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		obj = srv.newHashObject()
+		db.Set(key, obj)
+	}
+	return obj, nil
+}
+
+// HSET key field value [field value ...]
+func (srv *Server) onHSet(c *Client, args []string) error {
+	if len(args)%2 == 0 {
+		return errWrongNumberOfArgs("hset")
+	}
+
+	obj, err := srv.hashLookupOrCreate(c.db, args[0])
+	if err != nil {
+		return err
+	}
+
+	srv.hashTryConversion(obj, args[1:]...)
+	added := 0
+	for i := 1; i < len(args); i += 2 {
+		if srv.hashSet(obj, args[i], args[i+1]) {
+			added++
+		}
+	}
+	c.writer.WriteInteger(int64(added))
+	return nil
+}
+
+// HMSET key field value [field value ...]
+func (srv *Server) onHMSet(c *Client, args []string) error {
+	if len(args)%2 == 0 {
+		return errWrongNumberOfArgs("hmset")
+	}
+
+	obj, err := srv.hashLookupOrCreate(c.db, args[0])
+	if err != nil {
+		return err
+	}
+
+	srv.hashTryConversion(obj, args[1:]...)
+	for i := 1; i < len(args); i += 2 {
+		srv.hashSet(obj, args[i], args[i+1])
+	}
+	c.writer.WriteSimpleString("OK")
+	return nil
+}
+
+// HSETNX key field value
+func (srv *Server) onHSetNX(c *Client, args []string) error {
+	obj, err := srv.hashLookupOrCreate(c.db, args[0])
+	if err != nil {
+		return err
+	}
+
+	if _, ok := hashGet(obj, args[1]); ok {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+	srv.hashTryConversion(obj, args[1], args[2])
+	srv.hashSet(obj, args[1], args[2])
+	c.writer.WriteInteger(1)
+	return nil
+}
+
+// HGET key field
+func (srv *Server) onHGet(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeHash)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteNull()
+		return nil
+	}
+
+	value, ok := hashGet(obj, args[1])
+	if !ok {
+		c.writer.WriteNull()
+		return nil
+	}
+	c.writer.WriteBulkString(value)
+	return nil
+}
+
+// HMGET key field [field ...]
+func (srv *Server) onHMGet(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeHash)
+	if err != nil {
+		return err
+	}
+
+	c.writer.WriteArrayLen(len(args) - 1)
+	for _, field := range args[1:] {
+		var value string
+		ok := false
+		if obj != nil {
+			value, ok = hashGet(obj, field)
+		}
+		if ok {
+			c.writer.WriteBulkString(value)
+		} else {
+			c.writer.WriteNull()
+		}
+	}
+	return nil
+}
+
+// hashGetAll writes the fields, the values or both of the hash at key.
+func (srv *Server) hashGetAll(c *Client, key string, fields, values bool) error {
+	obj, err := c.db.lookupType(key, FieldTypeHash)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	if obj != nil {
+		n = hashLen(obj)
+	}
+	if fields && values {
+		c.writer.WriteMapLen(n)
+	} else {
+		c.writer.WriteArrayLen(n)
+	}
+	if obj == nil {
+		return nil
+	}
+
+	hashIterate(obj, func(field, value string) bool {
+		if fields {
+			c.writer.WriteBulkString(field)
+		}
+		if values {
+			c.writer.WriteBulkString(value)
+		}
+		return true
+	})
+	return nil
+}
+
+// HGETALL key
+func (srv *Server) onHGetAll(c *Client, args []string) error {
+	return srv.hashGetAll(c, args[0], true, true)
+}
+
+// HKEYS key
+func (srv *Server) onHKeys(c *Client, args []string) error {
+	return srv.hashGetAll(c, args[0], true, false)
+}
+
+// HVALS key
+func (srv *Server) onHVals(c *Client, args []string) error {
+	return srv.hashGetAll(c, args[0], false, true)
+}
+
+// HLEN key
+func (srv *Server) onHLen(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeHash)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+	c.writer.WriteInteger(int64(hashLen(obj)))
+	return nil
+}
+
+// HEXISTS key field
+func (srv *Server) onHExists(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeHash)
+	if err != nil {
+		return err
+	}
+	if obj != nil {
+		if _, ok := hashGet(obj, args[1]); ok {
+			c.writer.WriteInteger(1)
+			return nil
+		}
+	}
+	c.writer.WriteInteger(0)
+	return nil
+}
+
+// HSTRLEN key field
+func (srv *Server) onHStrLen(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeHash)
+	if err != nil {
+		return err
+	}
+	var value string
+	if obj != nil {
+		value, _ = hashGet(obj, args[1])
+	}
+	c.writer.WriteInteger(int64(len(value)))
+	return nil
+}
+
+// HDEL key field [field ...]
+func (srv *Server) onHDel(c *Client, args []string) error {
+	key := args[0]
+	obj, err := c.db.lookupType(key, FieldTypeHash)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+
+	deleted := 0
+	for _, field := range args[1:] {
+		if hashDelete(obj, field) {
+			deleted++
+		}
+	}
+	hashDeleteIfEmpty(c.db, key, obj)
+	c.writer.WriteInteger(int64(deleted))
+	return nil
+}
+
+var (
+	errHashValueNotInteger = errors.New("ERR hash value is not an integer")
+	errHashValueNotFloat   = errors.New("ERR hash value is not a float")
+	errIncrOverflow        = errors.New("ERR increment or decrement would overflow")
+	errDecrOverflow        = errors.New("ERR decrement would overflow")
+	errIncrNaNOrInfinity   = errors.New("ERR increment would produce NaN or Infinity")
+	errNotFloat            = errors.New("ERR value is not a valid float")
+)
+
+// parseFloat parses a float argument or value, NaN is never valid.
+func parseFloat(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// The INCRBYFLOAT commands compute in long double like Redis does, whose
+// x87 mantissa has 64 bits, so 0.1 plus 0.2 is 0.3 and not
+// 0.30000000000000004 like in float64. The binary exponent of a long
+// double is at most 16384 and at least -16444 for subnormals.
+const (
+	longDoublePrec   = 64
+	longDoubleMaxExp = 16384
+	longDoubleMinExp = -16444
+)
+
+// parseLongDouble parses an operand of the INCRBYFLOAT commands. Infinities
+// are valid but values out of the long double range are not.
+func parseLongDouble(s string) (*big.Float, bool) {
+	f, _, err := big.ParseFloat(s, 10, longDoublePrec, big.ToNearestEven)
+	if err != nil {
+		return nil, false
+	}
+	return f, f.IsInf() || inLongDoubleRange(f)
+}
+
+func inLongDoubleRange(f *big.Float) bool {
+	exp := f.MantExp(nil)
+	return f.Sign() == 0 || exp <= longDoubleMaxExp && exp >= longDoubleMinExp
+}
+
+// addLongDouble returns f plus incr formatted like Redis does for humans,
+// with 17 decimals without the trailing zeros and no exponent. It fails
+// when the sum is not finite.
+func addLongDouble(f, incr *big.Float) (string, bool) {
+	if f.IsInf() || incr.IsInf() {
+		return "", false
+	}
+	sum := new(big.Float).SetPrec(longDoublePrec).Add(f, incr)
+	if !inLongDoubleRange(sum) {
+		return "", false
+	}
+	res := strings.TrimRight(sum.Text('f', 17), "0")
+	return strings.TrimSuffix(res, "."), true
+}
+
+// HINCRBY key field increment
+func (srv *Server) onHIncrBy(c *Client, args []string) error {
+	incr, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return ErrNotInteger
+	}
+
+	obj, err := srv.hashLookupOrCreate(c.db, args[0])
+	if err != nil {
+		return err
+	}
+
+	var n int64
+	if value, ok := hashGet(obj, args[1]); ok {
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			hashDeleteIfEmpty(c.db, args[0], obj)
+			return errHashValueNotInteger
+		}
+	}
+	if (incr < 0 && n < math.MinInt64-incr) || (incr > 0 && n > math.MaxInt64-incr) {
+		hashDeleteIfEmpty(c.db, args[0], obj)
+		return errIncrOverflow
+	}
+
+	n += incr
+	srv.hashTryConversion(obj, args[1])
+	srv.hashSetKeepTTL(obj, args[1], strconv.FormatInt(n, 10))
+	c.writer.WriteInteger(n)
+	return nil
+}
+
+// HINCRBYFLOAT key field increment
+func (srv *Server) onHIncrByFloat(c *Client, args []string) error {
+	incr, ok := parseLongDouble(args[2])
+	if !ok {
+		return errNotFloat
+	}
+
+	obj, err := srv.hashLookupOrCreate(c.db, args[0])
+	if err != nil {
+		return err
+	}
+
+	f := new(big.Float)
+	if value, ok := hashGet(obj, args[1]); ok {
+		if f, ok = parseLongDouble(value); !ok {
+			hashDeleteIfEmpty(c.db, args[0], obj)
+			return errHashValueNotFloat
+		}
+	}
+	value, ok := addLongDouble(f, incr)
+	if !ok {
+		hashDeleteIfEmpty(c.db, args[0], obj)
+		return errIncrNaNOrInfinity
+	}
+
+	srv.hashTryConversion(obj, args[1], value)
+	srv.hashSetKeepTTL(obj, args[1], value)
+	c.writer.WriteBulkString(value)
+	return nil
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+//
+// A listpack hash is small enough to be returned in one call, with cursor
+// 0, like Redis does.
+func (srv *Server) onHScan(c *Client, args []string) error {
+	cursor, opt, err := parseScanArgs(args[1:], "hscan")
+	if err != nil {
+		return err
+	}
+
+	obj, err := c.db.lookupType(args[0], FieldTypeHash)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		writeScanReply(c.writer, 0, nil)
+		return nil
+	}
+
+	var res []string
+	visit := func(field, value string) {
+		if opt.match != "" && !stringMatch(opt.match, field, false) {
+			return
+		}
+		res = append(res, field)
+		if !opt.noValues {
+			res = append(res, value)
+		}
+	}
+
+	if obj.Encoding == EncodingHashtable {
+		cursor = scanDict(obj.Value.(*hashTable).fields, cursor, opt.count, visit)
+	} else {
+		hashIterate(obj, func(field, value string) bool {
+			visit(field, value)
+			return true
+		})
+		cursor = 0
+	}
+
+	writeScanReply(c.writer, cursor, res)
+	return nil
+}
+
+// HRANDFIELD key [count [WITHVALUES]]
+//
+// A positive count returns distinct fields, at most the whole hash, a
+// negative one returns -count fields that may repeat.
+func (srv *Server) onHRandField(c *Client, args []string) error {
+	if len(args) > 3 || (len(args) == 3 && strings.ToLower(args[2]) != "withvalues") {
+		return ErrSyntax
+	}
+
+	count, withCount := 1, len(args) > 1
+	if withCount {
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return ErrNotInteger
+		}
+		if n < -math.MaxInt64/2 || n > math.MaxInt64/2 {
+			return errors.New("ERR value is out of range")
+		}
+		count = int(n)
+	}
+	withValues := len(args) == 3
+
+	obj, err := c.db.lookupType(args[0], FieldTypeHash)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		if withCount {
+			c.writer.WriteArrayLen(0)
+		} else {
+			c.writer.WriteNull()
+		}
+		return nil
+	}
+
+	if !withCount {
+		field, _ := hashRandom(obj)
+		c.writer.WriteBulkString(field)
+		return nil
+	}
+
+	var pairs [][2]string
+	if count < 0 {
+		for i := 0; i < -count; i++ {
+			field, value := hashRandom(obj)
+			pairs = append(pairs, [2]string{field, value})
+		}
+	} else {
+		hashIterate(obj, func(field, value string) bool {
+			pairs = append(pairs, [2]string{field, value})
+			return true
+		})
+		rand.Shuffle(len(pairs), func(i, j int) {
+			pairs[i], pairs[j] = pairs[j], pairs[i]
+		})
+		if count < len(pairs) {
+			pairs = pairs[:count]
+		}
+	}
+
+	writeFieldValuePairs(c.writer, pairs, withValues)
+	return nil
+}
+
+// writeFieldValuePairs writes the fields alone or, withValues, each field
+// followed by its value. RESP3 nests every pair in an array of its own.
+func writeFieldValuePairs(w *RESPWriter, pairs [][2]string, withValues bool) {
+	switch {
+	case !withValues:
+		w.WriteArrayLen(len(pairs))
+		for _, p := range pairs {
+			w.WriteBulkString(p[0])
+		}
+	case w.proto == 3:
+		w.WriteArrayLen(len(pairs))
+		for _, p := range pairs {
+			w.WriteArrayLen(2)
+			w.WriteBulkString(p[0])
+			w.WriteBulkString(p[1])
+		}
+	default:
+		w.WriteArrayLen(2 * len(pairs))
+		for _, p := range pairs {
+			w.WriteBulkString(p[0])
+			w.WriteBulkString(p[1])
+		}
+	}
+}
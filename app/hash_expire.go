@@ -0,0 +1,504 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Hash fields can expire on their own, like keys. A listpack hash keeps
+// the unix ms timestamp after the value of every field, 0 for none, once a
+// field gets a TTL and becomes a listpackex. A hashTable keeps them in its
+// expires map.
+//
+// Expired fields are removed when the key is looked up or by the active
+// expire cycle, the DB tracks when the first field of every hash expires,
+// see DB.expireHashFields.
+
+// hashMaxExpire is the latest unix ms timestamp a field can expire at,
+// like Redis.
+const hashMaxExpire = (1<<48 - 1) >> 2
+
+var (
+	errMissingFields  = errors.New("ERR Mandatory argument FIELDS is missing or not at the right position")
+	errNumFields      = errors.New("ERR Number of fields must be a positive integer")
+	errNumFieldsMatch = errors.New("ERR The `numfields` parameter must match the number of arguments")
+)
+
+// hashToListpackEx adds a TTL of 0 after every field of a listpack hash.
+func hashToListpackEx(o *Object) {
+	lp := newListpack()
+	hashIterate(o, func(field, value string) bool {
+		lp.Append(field)
+		lp.Append(value)
+		lp.Append("0")
+		return true
+	})
+	o.Encoding = EncodingListpackEx
+	o.Value = lp
+}
+
+// hashGetExpire returns the unix ms timestamp field expires at, 0 when it
+// has no TTL. ok is false when field does not exist.
+func hashGetExpire(o *Object, field string) (at int64, ok bool) {
+	switch o.Encoding {
+	case EncodingHashtable:
+		ht := o.Value.(*hashTable)
+		if _, ok := ht.fields.Get(field); !ok {
+			return 0, false
+		}
+		return ht.expires[field], true
+	case EncodingListpackEx:
+		p := hashFind(o, field)
+		if p == -1 {
+			return 0, false
+		}
+		lp := o.Value.(*listpack)
+		at, _ = lp.GetInt(lp.Next(lp.Next(p)))
+		return at, true
+	}
+	return 0, hashFind(o, field) != -1
+}
+
+// hashSetExpire sets the TTL of field, which must exist, to the unix ms
+// timestamp at, 0 removing it.
+func hashSetExpire(o *Object, field string, at int64) {
+	if o.Encoding == EncodingHashtable {
+		ht := o.Value.(*hashTable)
+		if at == 0 {
+			delete(ht.expires, field)
+		} else {
+			ht.expires[field] = at
+		}
+		return
+	}
+
+	if o.Encoding == EncodingListpack {
+		if at == 0 {
+			return
+		}
+		hashToListpackEx(o)
+	}
+	lp := o.Value.(*listpack)
+	p := lp.Next(lp.Next(hashFind(o, field)))
+	lp.Replace(p, strconv.FormatInt(at, 10))
+}
+
+// hashMinExpire returns the earliest unix ms timestamp a field of o
+// expires at, 0 when no field has a TTL.
+func hashMinExpire(o *Object) int64 {
+	var first int64
+	update := func(at int64) {
+		if at != 0 && (first == 0 || at < first) {
+			first = at
+		}
+	}
+
+	switch o.Encoding {
+	case EncodingHashtable:
+		for _, at := range o.Value.(*hashTable).expires {
+			update(at)
+		}
+	case EncodingListpackEx:
+		hashIterateWithTTL(o, func(_, _ string, at int64) bool {
+			update(at)
+			return true
+		})
+	}
+	return first
+}
+
+// hashExpireFields removes the fields of o expired at now.
+func hashExpireFields(o *Object, now int64) {
+	switch o.Encoding {
+	case EncodingHashtable:
+		ht := o.Value.(*hashTable)
+		for field, at := range ht.expires {
+			if at <= now {
+				ht.fields.Delete(field)
+				delete(ht.expires, field)
+			}
+		}
+	case EncodingListpackEx:
+		lp := o.Value.(*listpack)
+		for p := lp.First(); p != -1; {
+			ttl := lp.Next(lp.Next(p))
+			if at, _ := lp.GetInt(ttl); at == 0 || at > now {
+				p = lp.Next(ttl)
+				continue
+			}
+			for i := 0; i < 3; i++ {
+				p = lp.Delete(p)
+			}
+		}
+	}
+}
+
+// parseFieldsArg parses FIELDS numfields followed by the fields, each one
+// taking perField arguments, and returns these arguments.
+func parseFieldsArg(args []string, perField int) ([]string, error) {
+	if len(args) < 2 || strings.ToLower(args[0]) != "fields" {
+		return nil, errMissingFields
+	}
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || n < 1 {
+		return nil, errNumFields
+	}
+	if rest := args[2:]; int64(len(rest)) != n*int64(perField) {
+		return nil, errNumFieldsMatch
+	}
+	return args[2:], nil
+}
+
+// Status codes of the fields in the replies of HEXPIRE and HPERSIST.
+const (
+	fieldNotFound     = -2
+	fieldNoTTL        = -1
+	fieldNotUpdated   = 0
+	fieldUpdated      = 1
+	fieldDeletedByTTL = 2
+)
+
+// hexpireGeneric implements HEXPIRE, HPEXPIRE, HEXPIREAT and HPEXPIREAT.
+// when is relative to basetime (0 for the AT variants) and given in unit
+// ms. Each field gets a status code in the reply.
+// HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func (srv *Server) hexpireGeneric(c *Client, cmd string, args []string, basetime, unit int64) error {
+	key := args[0]
+	obj, err := c.db.lookupType(key, FieldTypeHash)
+	if err != nil {
+		return err
+	}
+
+	when, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return ErrNotInteger
+	}
+	if when < 0 {
+		return errors.New("ERR invalid expire time, must be >= 0")
+	}
+	if when > hashMaxExpire/unit {
+		return errInvalidExpireTime(cmd)
+	}
+	when *= unit
+	if when > hashMaxExpire-basetime {
+		return errInvalidExpireTime(cmd)
+	}
+	when += basetime
+
+	cond, rest := "", args[2:]
+	switch strings.ToLower(rest[0]) {
+	case "nx", "xx", "gt", "lt":
+		cond, rest = strings.ToLower(rest[0]), rest[1:]
+	}
+	fields, err := parseFieldsArg(rest, 1)
+	if err != nil {
+		return err
+	}
+
+	c.writer.WriteArrayLen(len(fields))
+	now := mstime()
+	for _, field := range fields {
+		if obj == nil {
+			c.writer.WriteInteger(fieldNotFound)
+			continue
+		}
+		at, ok := hashGetExpire(obj, field)
+		if !ok {
+			c.writer.WriteInteger(fieldNotFound)
+			continue
+		}
+
+		switch {
+		case cond == "nx" && at != 0,
+			cond == "xx" && at == 0,
+			// no TTL counts as an infinite one
+			cond == "gt" && (at == 0 || when <= at),
+			cond == "lt" && at != 0 && when >= at:
+			c.writer.WriteInteger(fieldNotUpdated)
+		case when <= now:
+			hashDelete(obj, field)
+			c.writer.WriteInteger(fieldDeletedByTTL)
+		default:
+			hashSetExpire(obj, field, when)
+			c.db.trackHashField(key, when)
+			c.writer.WriteInteger(fieldUpdated)
+		}
+	}
+
+	if obj != nil {
+		hashDeleteIfEmpty(c.db, key, obj)
+	}
+	return nil
+}
+
+func (srv *Server) onHExpire(c *Client, args []string) error {
+	return srv.hexpireGeneric(c, "hexpire", args, mstime(), 1000)
+}
+
+func (srv *Server) onHPExpire(c *Client, args []string) error {
+	return srv.hexpireGeneric(c, "hpexpire", args, mstime(), 1)
+}
+
+func (srv *Server) onHExpireAt(c *Client, args []string) error {
+	return srv.hexpireGeneric(c, "hexpireat", args, 0, 1000)
+}
+
+func (srv *Server) onHPExpireAt(c *Client, args []string) error {
+	return srv.hexpireGeneric(c, "hpexpireat", args, 0, 1)
+}
+
+// httlGeneric implements HTTL, HPTTL, HEXPIRETIME and HPEXPIRETIME. Each
+// field gets -2 when it does not exist, -1 when it has no TTL.
+// HTTL key FIELDS numfields field [field ...]
+func (srv *Server) httlGeneric(c *Client, args []string, ms, absolute bool) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeHash)
+	if err != nil {
+		return err
+	}
+	fields, err := parseFieldsArg(args[1:], 1)
+	if err != nil {
+		return err
+	}
+
+	c.writer.WriteArrayLen(len(fields))
+	for _, field := range fields {
+		var at int64
+		ok := false
+		if obj != nil {
+			at, ok = hashGetExpire(obj, field)
+		}
+		switch {
+		case !ok:
+			c.writer.WriteInteger(fieldNotFound)
+		case at == 0:
+			c.writer.WriteInteger(fieldNoTTL)
+		default:
+			c.writer.WriteInteger(ttlFromExpire(at, ms, absolute))
+		}
+	}
+	return nil
+}
+
+func (srv *Server) onHTTL(c *Client, args []string) error {
+	return srv.httlGeneric(c, args, false, false)
+}
+
+func (srv *Server) onHPTTL(c *Client, args []string) error {
+	return srv.httlGeneric(c, args, true, false)
+}
+
+func (srv *Server) onHExpireTime(c *Client, args []string) error {
+	return srv.httlGeneric(c, args, false, true)
+}
+
+func (srv *Server) onHPExpireTime(c *Client, args []string) error {
+	return srv.httlGeneric(c, args, true, true)
+}
+
+// HPERSIST key FIELDS numfields field [field ...]
+func (srv *Server) onHPersist(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeHash)
+	if err != nil {
+		return err
+	}
+	fields, err := parseFieldsArg(args[1:], 1)
+	if err != nil {
+		return err
+	}
+
+	c.writer.WriteArrayLen(len(fields))
+	for _, field := range fields {
+		var at int64
+		ok := false
+		if obj != nil {
+			at, ok = hashGetExpire(obj, field)
+		}
+		switch {
+		case !ok:
+			c.writer.WriteInteger(fieldNotFound)
+		case at == 0:
+			c.writer.WriteInteger(fieldNoTTL)
+		default:
+			hashSetExpire(obj, field, 0)
+			c.writer.WriteInteger(fieldUpdated)
+		}
+	}
+	return nil
+}
+
+// hashExOptions are the options of HGETEX and HSETEX.
+type hashExOptions struct {
+	expire  bool
+	when    int64 // unix ms timestamp
+	persist bool
+	keepTTL bool
+	fnx     bool
+	fxx     bool
+	fields  []string // the arguments after numfields
+}
+
+// parseHashExOptions parses the options of cmd, HGETEX or HSETEX, up to
+// FIELDS and the fields, each one taking perField arguments.
+func parseHashExOptions(cmd string, args []string, perField int) (hashExOptions, error) {
+	var opt hashExOptions
+	ttlFlag := ""
+	i := 0
+	for ; i < len(args); i++ {
+		flag := strings.ToLower(args[i])
+		if flag == "fields" {
+			break
+		}
+
+		switch {
+		case flag == "fnx" && cmd == "hsetex" && !opt.fxx:
+			opt.fnx = true
+		case flag == "fxx" && cmd == "hsetex" && !opt.fnx:
+			opt.fxx = true
+		case flag == "persist" && cmd == "hgetex" && ttlFlag == "",
+			flag == "keepttl" && cmd == "hsetex" && ttlFlag == "":
+			ttlFlag = flag
+			opt.persist = flag == "persist"
+			opt.keepTTL = flag == "keepttl"
+		case flag == "ex" || flag == "px" || flag == "exat" || flag == "pxat":
+			if ttlFlag != "" || i+1 >= len(args) {
+				return opt, ErrSyntax
+			}
+			ttlFlag = flag
+			i++
+
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return opt, ErrNotInteger
+			}
+			if n <= 0 {
+				return opt, errInvalidExpireTime(cmd)
+			}
+
+			if flag == "ex" || flag == "exat" {
+				if n > hashMaxExpire/1000 {
+					return opt, errInvalidExpireTime(cmd)
+				}
+				n *= 1000
+			}
+			if flag == "ex" || flag == "px" {
+				n += mstime()
+			}
+			if n > hashMaxExpire {
+				return opt, errInvalidExpireTime(cmd)
+			}
+
+			opt.expire = true
+			opt.when = n
+		default:
+			return opt, ErrSyntax
+		}
+	}
+
+	var err error
+	opt.fields, err = parseFieldsArg(args[i:], perField)
+	return opt, err
+}
+
+// HGETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds |
+// PXAT unix-time-milliseconds | PERSIST] FIELDS numfields field [field ...]
+func (srv *Server) onHGetEx(c *Client, args []string) error {
+	key := args[0]
+	opt, err := parseHashExOptions("hgetex", args[1:], 1)
+	if err != nil {
+		return err
+	}
+
+	obj, err := c.db.lookupType(key, FieldTypeHash)
+	if err != nil {
+		return err
+	}
+
+	c.writer.WriteArrayLen(len(opt.fields))
+	now := mstime()
+	for _, field := range opt.fields {
+		var value string
+		ok := false
+		if obj != nil {
+			value, ok = hashGet(obj, field)
+		}
+		if !ok {
+			c.writer.WriteNull()
+			continue
+		}
+		c.writer.WriteBulkString(value)
+
+		switch {
+		case opt.persist:
+			hashSetExpire(obj, field, 0)
+		case opt.expire && opt.when <= now:
+			hashDelete(obj, field)
+		case opt.expire:
+			hashSetExpire(obj, field, opt.when)
+			c.db.trackHashField(key, opt.when)
+		}
+	}
+
+	if obj != nil {
+		hashDeleteIfEmpty(c.db, key, obj)
+	}
+	return nil
+}
+
+// HSETEX key [FNX | FXX] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+// FIELDS numfields field value [field value ...]
+//
+// It replies 1 when the fields are set, 0 when FNX or FXX prevented it.
+func (srv *Server) onHSetEx(c *Client, args []string) error {
+	key := args[0]
+	opt, err := parseHashExOptions("hsetex", args[1:], 2)
+	if err != nil {
+		return err
+	}
+
+	obj, err := c.db.lookupType(key, FieldTypeHash)
+	if err != nil {
+		return err
+	}
+
+	if opt.fnx || opt.fxx {
+		for i := 0; i < len(opt.fields); i += 2 {
+			exists := false
+			if obj != nil {
+				_, exists = hashGet(obj, opt.fields[i])
+			}
+			if exists == opt.fnx {
+				c.writer.WriteInteger(0)
+				return nil
+			}
+		}
+	}
+
+	if obj == nil {
+		obj = srv.newHashObject()
+		c.db.Set(key, obj)
+	}
+
+	srv.hashTryConversion(obj, opt.fields...)
+	now := mstime()
+	for i := 0; i < len(opt.fields); i += 2 {
+		field, value := opt.fields[i], opt.fields[i+1]
+		switch {
+		case opt.keepTTL:
+			srv.hashSetKeepTTL(obj, field, value)
+		case opt.expire && opt.when <= now:
+			hashDelete(obj, field)
+		default:
+			srv.hashSet(obj, field, value)
+		}
+		if opt.expire && opt.when > now {
+			hashSetExpire(obj, field, opt.when)
+			c.db.trackHashField(key, opt.when)
+		}
+	}
+
+	hashDeleteIfEmpty(c.db, key, obj)
+	c.writer.WriteInteger(1)
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const redisVersion = "8.0.0"
+
+// onHello switches the connection to the requested protocol, optionally
+// authenticating and naming it, and replies with the server properties.
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func (srv *Server) onHello(c *Client, args []string) error {
+	proto := c.writer.proto
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(args[0])
+		if err != nil {
+			return errors.New("ERR Protocol version is not an integer or out of range")
+		}
+		if ver < 2 || ver > 3 {
+			return errors.New("NOPROTO unsupported protocol version")
+		}
+		proto = ver
+	}
+
+	var user, pass, name string
+	var auth, setName bool
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "auth":
+			if i+2 >= len(args) {
+				return fmt.Errorf("ERR Syntax error in HELLO option '%s'", args[i])
+			}
+			auth = true
+			user, pass = args[i+1], args[i+2]
+			i += 2
+		case "setname":
+			if i+1 >= len(args) {
+				return fmt.Errorf("ERR Syntax error in HELLO option '%s'", args[i])
+			}
+			setName = true
+			name = args[i+1]
+			i += 1
+		default:
+			return fmt.Errorf("ERR Syntax error in HELLO option '%s'", args[i])
+		}
+	}
+
+	if auth {
+		if !srv.checkPassword(user, pass) {
+			return ErrWrongPass
+		}
+		c.authenticated = true
+	}
+
+	if !c.authenticated {
+		return errors.New("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+
+	if setName {
+		if !validClientName(name) {
+			return errors.New("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		c.name = name
+	}
+
+	c.writer.proto = proto
+
+	role := "master"
+	if srv.replication.role == REPLICATION_ROLE_SLAVE {
+		role = "replica"
+	}
+
+	c.writer.WriteMapLen(7)
+	c.writer.WriteBulkString("server")
+	c.writer.WriteBulkString("redis")
+	c.writer.WriteBulkString("version")
+	c.writer.WriteBulkString(redisVersion)
+	c.writer.WriteBulkString("proto")
+	c.writer.WriteInteger(int64(proto))
+	c.writer.WriteBulkString("id")
+	c.writer.WriteInteger(c.id)
+	c.writer.WriteBulkString("mode")
+	c.writer.WriteBulkString("standalone")
+	c.writer.WriteBulkString("role")
+	c.writer.WriteBulkString(role)
+	c.writer.WriteBulkString("modules")
+	c.writer.WriteArrayLen(0)
+	return nil
+}
+
+// onAuth authenticates the connection.
+// AUTH [username] password
+func (srv *Server) onAuth(c *Client, args []string) error {
+	var user, pass string
+	switch len(args) {
+	case 1:
+		if srv.config["requirepass"] == "" {
+			return errors.New("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		}
+		user, pass = "default", args[0]
+	case 2:
+		user, pass = args[0], args[1]
+	default:
+		return ErrSyntax
+	}
+
+	if !srv.checkPassword(user, pass) {
+		return ErrWrongPass
+	}
+
+	c.authenticated = true
+	c.writer.WriteSimpleString("OK")
+	return nil
+}
+
+// checkPassword validates the credentials of the default user, the only
+// user there is. Without requirepass it accepts any password like the
+// nopass default user of Redis.
+func (srv *Server) checkPassword(user, pass string) bool {
+	if user != "default" {
+		return false
+	}
+
+	requirePass := srv.config["requirepass"]
+	if requirePass == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(pass), []byte(requirePass)) == 1
+}
+
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// intset is the Redis encoding of small sets made only of integers: a
+// sorted array where every member takes the width of the largest one, 2,
+// 4 or 8 bytes, so membership is a binary search.
+//
+//	<encoding uint32> <length uint32> <contents>
+//
+// The width grows when a larger member is added, it never shrinks.
+type intset struct {
+	b []byte
+}
+
+const (
+	intsetEnc16 = 2
+	intsetEnc32 = 4
+	intsetEnc64 = 8
+
+	intsetHeaderSize = 8
+)
+
+var errIntsetCorrupt = errors.New("corrupt intset")
+
+func newIntset() *intset {
+	b := make([]byte, intsetHeaderSize)
+	binary.LittleEndian.PutUint32(b, intsetEnc16)
+	return &intset{b: b}
+}
+
+// intsetFromBytes checks that b is a valid intset, as loaded from an RDB
+// file.
+func intsetFromBytes(b []byte) (*intset, error) {
+	if len(b) < intsetHeaderSize {
+		return nil, errIntsetCorrupt
+	}
+	is := &intset{b: b}
+	switch is.enc() {
+	case intsetEnc16, intsetEnc32, intsetEnc64:
+	default:
+		return nil, errIntsetCorrupt
+	}
+	if len(b) != intsetHeaderSize+is.Len()*is.enc() {
+		return nil, errIntsetCorrupt
+	}
+	for i := 1; i < is.Len(); i++ {
+		if is.Get(i-1) >= is.Get(i) {
+			return nil, errIntsetCorrupt
+		}
+	}
+	return is, nil
+}
+
+// canonicalInt parses s as an integer only when s is the way the integer
+// is written, no sign or leading zero, so that s can be restored from it.
+func canonicalInt(s string) (int64, bool) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != s {
+		return 0, false
+	}
+	return v, true
+}
+
+func (is *intset) enc() int {
+	return int(binary.LittleEndian.Uint32(is.b))
+}
+
+// Len returns the number of members.
+func (is *intset) Len() int {
+	return int(binary.LittleEndian.Uint32(is.b[4:]))
+}
+
+// Get returns the member at index i, members are sorted.
+func (is *intset) Get(i int) int64 {
+	p := intsetHeaderSize + i*is.enc()
+	switch is.enc() {
+	case intsetEnc16:
+		return int64(int16(binary.LittleEndian.Uint16(is.b[p:])))
+	case intsetEnc32:
+		return int64(int32(binary.LittleEndian.Uint32(is.b[p:])))
+	}
+	return int64(binary.LittleEndian.Uint64(is.b[p:]))
+}
+
+func (is *intset) set(i int, v int64) {
+	p := intsetHeaderSize + i*is.enc()
+	switch is.enc() {
+	case intsetEnc16:
+		binary.LittleEndian.PutUint16(is.b[p:], uint16(v))
+	case intsetEnc32:
+		binary.LittleEndian.PutUint32(is.b[p:], uint32(v))
+	default:
+		binary.LittleEndian.PutUint64(is.b[p:], uint64(v))
+	}
+}
+
+func (is *intset) setLen(n int) {
+	binary.LittleEndian.PutUint32(is.b[4:], uint32(n))
+}
+
+// intsetValueEnc returns the narrowest width v fits in.
+func intsetValueEnc(v int64) int {
+	switch {
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return intsetEnc16
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return intsetEnc32
+	}
+	return intsetEnc64
+}
+
+// search returns the index of v, or the index it would be inserted at.
+func (is *intset) search(v int64) (int, bool) {
+	n := is.Len()
+	i := sort.Search(n, func(i int) bool { return is.Get(i) >= v })
+	return i, i < n && is.Get(i) == v
+}
+
+// Find reports whether v is a member.
+func (is *intset) Find(v int64) bool {
+	if intsetValueEnc(v) > is.enc() {
+		return false
+	}
+	_, ok := is.search(v)
+	return ok
+}
+
+// Add inserts v and reports whether it was not a member yet.
+func (is *intset) Add(v int64) bool {
+	if intsetValueEnc(v) > is.enc() {
+		is.upgradeAndAdd(v)
+		return true
+	}
+
+	i, ok := is.search(v)
+	if ok {
+		return false
+	}
+	n, enc := is.Len(), is.enc()
+	is.b = append(is.b, make([]byte, enc)...)
+	p := intsetHeaderSize + i*enc
+	copy(is.b[p+enc:], is.b[p:intsetHeaderSize+n*enc])
+	is.set(i, v)
+	is.setLen(n + 1)
+	return true
+}
+
+// upgradeAndAdd widens every member to fit v, which is larger or smaller
+// than all of them since it does not fit the current width.
+func (is *intset) upgradeAndAdd(v int64) {
+	n := is.Len()
+	old := &intset{b: is.b}
+	enc := intsetValueEnc(v)
+
+	is.b = make([]byte, intsetHeaderSize+(n+1)*enc)
+	binary.LittleEndian.PutUint32(is.b, uint32(enc))
+	is.setLen(n + 1)
+	shift := 0
+	if v < 0 {
+		shift = 1
+		is.set(0, v)
+	} else {
+		is.set(n, v)
+	}
+	for i := 0; i < n; i++ {
+		is.set(i+shift, old.Get(i))
+	}
+}
+
+// Remove deletes v and reports whether it was a member.
+func (is *intset) Remove(v int64) bool {
+	if intsetValueEnc(v) > is.enc() {
+		return false
+	}
+	i, ok := is.search(v)
+	if !ok {
+		return false
+	}
+	n, enc := is.Len(), is.enc()
+	p := intsetHeaderSize + i*enc
+	copy(is.b[p:], is.b[p+enc:])
+	is.b = is.b[:len(is.b)-enc]
+	is.setLen(n - 1)
+	return true
+}
+
+// Random returns a random member of a non empty intset.
+func (is *intset) Random() int64 {
+	return is.Get(rand.Intn(is.Len()))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestIntset(t *testing.T) {
+	is := newIntset()
+	for _, v := range []int64{5, 1, 3, 1} {
+		is.Add(v)
+	}
+	if is.Len() != 3 || is.enc() != intsetEnc16 {
+		t.Fatalf("expected 3 members of width 2 got %d of width %d", is.Len(), is.enc())
+	}
+
+	// members stay sorted when the width grows at both ends
+	is.Add(math.MaxInt32 + 1)
+	is.Add(-70000)
+	want := []int64{-70000, 1, 3, 5, math.MaxInt32 + 1}
+	if is.Len() != len(want) || is.enc() != intsetEnc64 {
+		t.Fatalf("expected %d members of width 8 got %d of width %d", len(want), is.Len(), is.enc())
+	}
+	for i, v := range want {
+		if is.Get(i) != v {
+			t.Fatalf("member %d: expected %d got %d", i, v, is.Get(i))
+		}
+	}
+
+	if !is.Find(3) || is.Find(4) || is.Find(math.MinInt64) {
+		t.Fatal("unexpected Find result")
+	}
+	if !is.Remove(3) || is.Remove(3) || is.Len() != 4 {
+		t.Fatal("unexpected Remove result")
+	}
+
+	loaded, err := intsetFromBytes(append([]byte(nil), is.b...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 4 || loaded.Get(3) != math.MaxInt32+1 {
+		t.Fatalf("unexpected loaded intset %v", loaded.b)
+	}
+
+	for _, b := range [][]byte{
+		{2, 0, 0, 0},
+		{3, 0, 0, 0, 0, 0, 0, 0},
+		{2, 0, 0, 0, 2, 0, 0, 0, 1, 0},
+		{2, 0, 0, 0, 2, 0, 0, 0, 2, 0, 1, 0},
+	} {
+		if _, err := intsetFromBytes(b); err == nil {
+			t.Fatalf("expected an error for %v", b)
+		}
+	}
+}
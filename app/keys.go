@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// KEYS pattern
+func (srv *Server) onKeys(c *Client, args []string) error {
+	pattern := args[0]
+	allKeys := pattern == "*"
+
+	var keys []string
+	c.db.data.Iterate(func(key string, _ *Object) bool {
+		if allKeys || stringMatch(pattern, key, false) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	res := keys[:0]
+	for _, key := range keys {
+		if !c.db.expireIfNeeded(key) {
+			res = append(res, key)
+		}
+	}
+
+	c.writer.WriteBulkStrings(res)
+	return nil
+}
+
+type scanOptions struct {
+	match    string // empty matches everything
+	count    int
+	typ      string // empty matches every type
+	noValues bool
+}
+
+// parseScanArgs parses the cursor and the options of the command cmd of
+// the SCAN family. TYPE is only valid for SCAN itself and NOVALUES for
+// HSCAN.
+// cursor [MATCH pattern] [COUNT count] [TYPE type] [NOVALUES]
+func parseScanArgs(args []string, cmd string) (uint64, scanOptions, error) {
+	opt := scanOptions{count: 10}
+
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, opt, errors.New("ERR invalid cursor")
+	}
+
+	for i := 1; i < len(args); i += 2 {
+		if cmd == "hscan" && strings.ToLower(args[i]) == "novalues" {
+			opt.noValues = true
+			i-- // no argument
+			continue
+		}
+		if i+1 >= len(args) {
+			return 0, opt, ErrSyntax
+		}
+
+		switch strings.ToLower(args[i]) {
+		case "match":
+			opt.match = args[i+1]
+			if opt.match == "*" {
+				opt.match = ""
+			}
+		case "count":
+			opt.count, err = strconv.Atoi(args[i+1])
+			if err != nil {
+				return 0, opt, ErrNotInteger
+			}
+			if opt.count < 1 {
+				return 0, opt, ErrSyntax
+			}
+		case "type":
+			if cmd != "scan" {
+				return 0, opt, ErrSyntax
+			}
+			opt.typ = strings.ToLower(args[i+1])
+		default:
+			return 0, opt, ErrSyntax
+		}
+	}
+	return cursor, opt, nil
+}
+
+// scanDict calls fn for the entries of d from cursor on, until count of
+// them or 10 times count buckets are visited, and returns the next cursor.
+func scanDict[V any](d *dict[V], cursor uint64, count int, fn func(key string, val V)) uint64 {
+	visited := 0
+	for iterations := count * 10; ; iterations-- {
+		cursor = d.Scan(cursor, func(key string, val V) {
+			fn(key, val)
+			visited++
+		})
+		if cursor == 0 || iterations <= 1 || visited >= count {
+			return cursor
+		}
+	}
+}
+
+// writeScanReply writes the reply of the SCAN family: the next cursor and
+// the elements of the batch.
+func writeScanReply(w *RESPWriter, cursor uint64, elements []string) {
+	w.WriteArrayLen(2)
+	w.WriteBulkString(strconv.FormatUint(cursor, 10))
+	w.WriteBulkStrings(elements)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+//
+// Keys present for the whole iteration are returned at least once, keys
+// added or removed meanwhile may or may not be. A key can be returned more
+// than once if the keyspace shrinks during the iteration.
+func (srv *Server) onScan(c *Client, args []string) error {
+	cursor, opt, err := parseScanArgs(args, "scan")
+	if err != nil {
+		return err
+	}
+
+	// bound the work of a call when most buckets are empty
+	var keys []string
+	for iterations := opt.count * 10; ; iterations-- {
+		cursor = c.db.Scan(cursor, func(key string) {
+			keys = append(keys, key)
+		})
+		if cursor == 0 || iterations <= 1 || len(keys) >= opt.count {
+			break
+		}
+	}
+
+	res := keys[:0]
+	for _, key := range keys {
+		if opt.match != "" && !stringMatch(opt.match, key, false) {
+			continue
+		}
+		if opt.typ != "" && c.db.Type(key) != opt.typ {
+			continue
+		}
+		res = append(res, key)
+	}
+
+	writeScanReply(c.writer, cursor, res)
+	return nil
+}
+
+// DEL key [key ...]
+func (srv *Server) onDel(c *Client, args []string) error {
+	deleted := 0
+	for _, key := range args {
+		if c.db.Delete(key) {
+			deleted++
+		}
+	}
+	c.writer.WriteInteger(int64(deleted))
+	return nil
+}
+
+// UNLINK key [key ...]
+//
+// Values are reclaimed by the Go garbage collector, which already happens
+// off the event loop, so UNLINK is DEL.
+func (srv *Server) onUnlink(c *Client, args []string) error {
+	return srv.onDel(c, args)
+}
+
+// EXISTS key [key ...]
+//
+// A key given several times is counted several times.
+func (srv *Server) onExists(c *Client, args []string) error {
+	count := 0
+	for _, key := range args {
+		if c.db.Exists(key) {
+			count++
+		}
+	}
+	c.writer.WriteInteger(int64(count))
+	return nil
+}
+
+// TOUCH key [key ...]
+func (srv *Server) onTouch(c *Client, args []string) error {
+	count := 0
+	for _, key := range args {
+		if c.db.lookup(key) != nil {
+			count++
+		}
+	}
+	c.writer.WriteInteger(int64(count))
+	return nil
+}
+
+// TYPE key
+func (srv *Server) onType(c *Client, args []string) error {
+	c.writer.WriteSimpleString(c.db.Type(args[0]))
+	return nil
+}
+
+// RANDOMKEY
+func (srv *Server) onRandomKey(c *Client, args []string) error {
+	key, ok := c.db.RandomKey()
+	if !ok {
+		c.writer.WriteNull()
+		return nil
+	}
+	c.writer.WriteBulkString(key)
+	return nil
+}
+
+// renameGeneric implements RENAME and RENAMENX, the TTL of src moves with
+// it and the one of dst is discarded.
+func (srv *Server) renameGeneric(c *Client, src, dst string, nx bool) error {
+	obj := c.db.lookup(src)
+	if obj == nil {
+		return errors.New("ERR no such key")
+	}
+
+	if src == dst {
+		if nx {
+			c.writer.WriteInteger(0)
+		} else {
+			c.writer.WriteSimpleString("OK")
+		}
+		return nil
+	}
+
+	if nx && c.db.Exists(dst) {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+
+	c.db.Delete(src)
+	c.db.add(dst, obj)
+
+	if nx {
+		c.writer.WriteInteger(1)
+	} else {
+		c.writer.WriteSimpleString("OK")
+	}
+	return nil
+}
+
+// RENAME key newkey
+func (srv *Server) onRename(c *Client, args []string) error {
+	return srv.renameGeneric(c, args[0], args[1], false)
+}
+
+// RENAMENX key newkey
+func (srv *Server) onRenameNX(c *Client, args []string) error {
+	return srv.renameGeneric(c, args[0], args[1], true)
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func (srv *Server) onCopy(c *Client, args []string) error {
+	src, dst := args[0], args[1]
+	dstDB := c.db
+	replace := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "replace":
+			replace = true
+		case "db":
+			if i+1 >= len(args) {
+				return ErrSyntax
+			}
+			i++
+
+			db, err := srv.parseDBIndex(args[i], "ERR DB index is out of range")
+			if err != nil {
+				return err
+			}
+			dstDB = db
+		default:
+			return ErrSyntax
+		}
+	}
+
+	if src == dst && dstDB == c.db {
+		return errors.New("ERR source and destination objects are the same")
+	}
+
+	obj := c.db.lookup(src)
+	if obj == nil || (!replace && dstDB.Exists(dst)) {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+
+	dstDB.add(dst, obj.dup())
+
+	c.writer.WriteInteger(1)
+	return nil
+}
+
+// parseNumKeys parses a number of keys followed by the keys, like the
+// arguments of LMPOP, and returns the keys and the arguments after them.
+func parseNumKeys(args []string) ([]string, []string, error) {
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, ErrNotInteger
+	}
+	if n <= 0 {
+		return nil, nil, errors.New("ERR numkeys should be greater than 0")
+	}
+	if n > len(args)-1 {
+		return nil, nil, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	return args[1 : 1+n], args[1+n:], nil
+}
+
+// numKeysPositions returns a Command.GetKeys for commands with a number of
+// keys at index pos of argv followed by the keys.
+func numKeysPositions(pos int) func(argv []string) []int {
+	return func(argv []string) []int {
+		if pos >= len(argv) {
+			return nil
+		}
+		n, err := strconv.Atoi(argv[pos])
+		if err != nil || n <= 0 || pos+n >= len(argv) {
+			return nil
+		}
+
+		res := make([]int, n)
+		for i := range res {
+			res[i] = pos + 1 + i
+		}
+		return res
+	}
+}
+
+// streamsKeysPositions is the Command.GetKeys of XREAD and XREADGROUP,
+// whose keys are the first half of the arguments after STREAMS.
+func streamsKeysPositions(argv []string) []int {
+	for i := 1; i < len(argv); i++ {
+		switch strings.ToLower(argv[i]) {
+		case "block", "count":
+			i++
+		case "group":
+			i += 2
+		case "streams":
+			n := len(argv) - i - 1
+			if n == 0 || n%2 != 0 {
+				return nil
+			}
+			res := make([]int, n/2)
+			for j := range res {
+				res[j] = i + 1 + j
+			}
+			return res
+		}
+	}
+	return nil
+}
+
+// destNumKeysPositions is numKeysPositions for commands also storing their
+// result at the key at index 1 of argv, like ZUNIONSTORE.
+func destNumKeysPositions(pos int) func(argv []string) []int {
+	keys := numKeysPositions(pos)
+	return func(argv []string) []int {
+		res := keys(argv)
+		if res == nil {
+			return nil
+		}
+		return append([]int{1}, res...)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"errors"
+	"io"
 )
 
 func DecodeLength(r *bufio.Reader) (int, error) {
@@ -26,9 +27,13 @@ func DecodeLength(r *bufio.Reader) (int, error) {
 		res <<= 8
 		res |= int(b)
 		return res, nil
-	case b&0b10000000 == 0b10000000:
+	case b == 0x80 || b == 0x81:
+		// 32 or 64 bit big endian length
 		bs := make([]byte, 4)
-		_, err := r.Read(bs)
+		if b == 0x81 {
+			bs = make([]byte, 8)
+		}
+		_, err := io.ReadFull(r, bs)
 		if err != nil {
 			return 0, err
 		}
@@ -0,0 +1,722 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const defaultListMaxListpackSize = -2
+
+// newListObject returns an empty list with the node size configured by
+// list-max-listpack-size.
+func (srv *Server) newListObject() *Object {
+	fill := srv.configInt("list-max-listpack-size", defaultListMaxListpackSize)
+	if fill == 0 {
+		fill = defaultListMaxListpackSize
+	}
+	return newObject(FieldTypeList, EncodingListpack, newQuicklist(fill))
+}
+
+// listUpdateEncoding switches the encoding reported for o after a change.
+// A list is a single listpack until it outgrows a node, and becomes one
+// again when it shrinks to a single node half full.
+func listUpdateEncoding(o *Object) {
+	ql := o.Value.(*quicklist)
+	switch {
+	case o.Encoding == EncodingListpack && ql.nodes > 1:
+		o.Encoding = EncodingQuicklist
+	case o.Encoding == EncodingQuicklist && ql.fitsHalfLimit():
+		o.Encoding = EncodingListpack
+	}
+}
+
+const (
+	listHead = iota
+	listTail
+)
+
+// parseListWhere parses the LEFT | RIGHT argument of LMOVE.
+func parseListWhere(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "left":
+		return listHead, nil
+	case "right":
+		return listTail, nil
+	}
+	return 0, ErrSyntax
+}
+
+func listPush(o *Object, s string, where int) {
+	ql := o.Value.(*quicklist)
+	if where == listHead {
+		ql.PushHead(s)
+	} else {
+		ql.PushTail(s)
+	}
+}
+
+func listPop(o *Object, where int) (string, bool) {
+	ql := o.Value.(*quicklist)
+	if where == listHead {
+		return ql.PopHead()
+	}
+	return ql.PopTail()
+}
+
+// listRange normalizes the start and stop indexes of LRANGE and LTRIM to
+// positions in a list of n elements. ok is false when the range is empty.
+func listRange(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	return start, stop, true
+}
+
+func (srv *Server) pushGeneric(c *Client, args []string, where int) error {
+	key := args[0]
+	obj, err := c.db.lookupType(key, FieldTypeList)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		obj = srv.newListObject()
+		c.db.Set(key, obj)
+	}
+
+	for _, elem := range args[1:] {
+		listPush(obj, elem, where)
+	}
+	listUpdateEncoding(obj)
+
+	c.writer.WriteInteger(int64(obj.Value.(*quicklist).Len()))
+	return nil
+}
+
+// LPUSH key element [element ...]
+func (srv *Server) onLPush(c *Client, args []string) error {
+	return srv.pushGeneric(c, args, listHead)
+}
+
+// RPUSH key element [element ...]
+func (srv *Server) onRPush(c *Client, args []string) error {
+	return srv.pushGeneric(c, args, listTail)
+}
+
+var errNotPositive = errors.New("ERR value is out of range, must be positive")
+
+func (srv *Server) popGeneric(c *Client, cmd string, args []string, where int) error {
+	if len(args) > 2 {
+		return errWrongNumberOfArgs(cmd)
+	}
+
+	count := -1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return errNotPositive
+		}
+		count = n
+	}
+
+	key := args[0]
+	obj, err := c.db.lookupType(key, FieldTypeList)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		if count == -1 {
+			c.writer.WriteNull()
+		} else {
+			c.writer.WriteNullArray()
+		}
+		return nil
+	}
+
+	if count == -1 {
+		elem, _ := listPop(obj, where)
+		c.writer.WriteBulkString(elem)
+	} else {
+		var elems []string
+		for ; count > 0; count-- {
+			elem, ok := listPop(obj, where)
+			if !ok {
+				break
+			}
+			elems = append(elems, elem)
+		}
+		c.writer.WriteBulkStrings(elems)
+	}
+
+	listDeleteIfEmpty(c.db, key, obj)
+	return nil
+}
+
+// listDeleteIfEmpty removes key once its list has no element left, empty
+// lists are never kept in the keyspace.
+func listDeleteIfEmpty(db *DB, key string, obj *Object) {
+	if obj.Value.(*quicklist).Len() == 0 {
+		db.Delete(key)
+	} else {
+		listUpdateEncoding(obj)
+	}
+}
+
+// LPOP key [count]
+func (srv *Server) onLPop(c *Client, args []string) error {
+	return srv.popGeneric(c, "lpop", args, listHead)
+}
+
+// RPOP key [count]
+func (srv *Server) onRPop(c *Client, args []string) error {
+	return srv.popGeneric(c, "rpop", args, listTail)
+}
+
+// LLEN key
+func (srv *Server) onLLen(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeList)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+	c.writer.WriteInteger(int64(obj.Value.(*quicklist).Len()))
+	return nil
+}
+
+// LRANGE key start stop
+func (srv *Server) onLRange(c *Client, args []string) error {
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		return ErrNotInteger
+	}
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		return ErrNotInteger
+	}
+
+	obj, err := c.db.lookupType(args[0], FieldTypeList)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteArrayLen(0)
+		return nil
+	}
+
+	ql := obj.Value.(*quicklist)
+	start, stop, ok := listRange(start, stop, ql.Len())
+	if !ok {
+		c.writer.WriteArrayLen(0)
+		return nil
+	}
+
+	c.writer.WriteArrayLen(stop - start + 1)
+	it := ql.iterator(start, false)
+	for i := start; i <= stop; i++ {
+		elem, _ := it.Next()
+		c.writer.WriteBulkString(elem)
+	}
+	return nil
+}
+
+// LINDEX key index
+func (srv *Server) onLIndex(c *Client, args []string) error {
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return ErrNotInteger
+	}
+
+	obj, err := c.db.lookupType(args[0], FieldTypeList)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteNull()
+		return nil
+	}
+
+	elem, ok := obj.Value.(*quicklist).Index(index)
+	if !ok {
+		c.writer.WriteNull()
+		return nil
+	}
+	c.writer.WriteBulkString(elem)
+	return nil
+}
+
+// LSET key index element
+func (srv *Server) onLSet(c *Client, args []string) error {
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return ErrNotInteger
+	}
+
+	obj, err := c.db.lookupType(args[0], FieldTypeList)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return errors.New("ERR no such key")
+	}
+
+	if !obj.Value.(*quicklist).Replace(index, args[2]) {
+		return errors.New("ERR index out of range")
+	}
+	listUpdateEncoding(obj)
+
+	c.writer.WriteSimpleString("OK")
+	return nil
+}
+
+// LINSERT key BEFORE | AFTER pivot element
+func (srv *Server) onLInsert(c *Client, args []string) error {
+	var after bool
+	switch strings.ToLower(args[1]) {
+	case "before":
+	case "after":
+		after = true
+	default:
+		return ErrSyntax
+	}
+
+	obj, err := c.db.lookupType(args[0], FieldTypeList)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+
+	ql := obj.Value.(*quicklist)
+	it := ql.iterator(0, false)
+	for {
+		elem, ok := it.Next()
+		if !ok {
+			c.writer.WriteInteger(-1)
+			return nil
+		}
+		if elem == args[2] {
+			break
+		}
+	}
+
+	if after {
+		it.InsertAfter(args[3])
+	} else {
+		it.InsertBefore(args[3])
+	}
+	listUpdateEncoding(obj)
+
+	c.writer.WriteInteger(int64(ql.Len()))
+	return nil
+}
+
+// LREM key count element
+func (srv *Server) onLRem(c *Client, args []string) error {
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return ErrNotInteger
+	}
+
+	key := args[0]
+	obj, err := c.db.lookupType(key, FieldTypeList)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+
+	// a negative count removes from the tail
+	ql := obj.Value.(*quicklist)
+	var it *quicklistIter
+	if count < 0 {
+		count = -count
+		it = ql.iterator(-1, true)
+	} else {
+		it = ql.iterator(0, false)
+	}
+
+	removed := 0
+	for count == 0 || removed < count {
+		elem, ok := it.Next()
+		if !ok {
+			break
+		}
+		if elem == args[2] {
+			it.Delete()
+			removed++
+		}
+	}
+	listDeleteIfEmpty(c.db, key, obj)
+
+	c.writer.WriteInteger(int64(removed))
+	return nil
+}
+
+// LTRIM key start stop
+func (srv *Server) onLTrim(c *Client, args []string) error {
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		return ErrNotInteger
+	}
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		return ErrNotInteger
+	}
+
+	key := args[0]
+	obj, err := c.db.lookupType(key, FieldTypeList)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteSimpleString("OK")
+		return nil
+	}
+
+	ql := obj.Value.(*quicklist)
+	n := ql.Len()
+	start, stop, ok := listRange(start, stop, n)
+	if !ok {
+		ql.DeleteRange(0, n)
+	} else {
+		ql.DeleteRange(stop+1, n-stop-1)
+		ql.DeleteRange(0, start)
+	}
+	listDeleteIfEmpty(c.db, key, obj)
+
+	c.writer.WriteSimpleString("OK")
+	return nil
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func (srv *Server) onLPos(c *Client, args []string) error {
+	rank, count, maxlen := 1, -1, 0
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return ErrSyntax
+		}
+
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return ErrNotInteger
+		}
+
+		switch strings.ToLower(args[i]) {
+		case "rank":
+			if n == 0 {
+				return errors.New("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = n
+		case "count":
+			if n < 0 {
+				return errors.New("ERR COUNT can't be negative")
+			}
+			count = n
+		case "maxlen":
+			if n < 0 {
+				return errors.New("ERR MAXLEN can't be negative")
+			}
+			maxlen = n
+		default:
+			return ErrSyntax
+		}
+	}
+
+	obj, err := c.db.lookupType(args[0], FieldTypeList)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		if count == -1 {
+			c.writer.WriteNull()
+		} else {
+			c.writer.WriteArrayLen(0)
+		}
+		return nil
+	}
+
+	ql := obj.Value.(*quicklist)
+	var it *quicklistIter
+	if rank < 0 {
+		rank = -rank
+		it = ql.iterator(-1, true)
+	} else {
+		it = ql.iterator(0, false)
+	}
+
+	var matches []int64
+	for i := 0; maxlen == 0 || i < maxlen; i++ {
+		elem, ok := it.Next()
+		if !ok {
+			break
+		}
+		if elem != args[1] {
+			continue
+		}
+		if rank > 1 {
+			rank--
+			continue
+		}
+
+		index := i
+		if it.reverse {
+			index = ql.Len() - 1 - i
+		}
+		matches = append(matches, int64(index))
+		if count != 0 && len(matches) >= count {
+			break
+		}
+	}
+
+	if count == -1 {
+		if len(matches) == 0 {
+			c.writer.WriteNull()
+		} else {
+			c.writer.WriteInteger(matches[0])
+		}
+		return nil
+	}
+
+	c.writer.WriteArrayLen(len(matches))
+	for _, m := range matches {
+		c.writer.WriteInteger(m)
+	}
+	return nil
+}
+
+// LMOVE source destination LEFT | RIGHT LEFT | RIGHT
+func (srv *Server) onLMove(c *Client, args []string) error {
+	from, err := parseListWhere(args[2])
+	if err != nil {
+		return err
+	}
+	to, err := parseListWhere(args[3])
+	if err != nil {
+		return err
+	}
+
+	src, err := c.db.lookupType(args[0], FieldTypeList)
+	if err != nil {
+		return err
+	}
+	if src == nil {
+		c.writer.WriteNull()
+		return nil
+	}
+
+	elem, err := srv.listMove(c.db, args[0], src, args[1], from, to)
+	if err != nil {
+		return err
+	}
+	c.writer.WriteBulkString(elem)
+	return nil
+}
+
+// listMove pops an element of the list src at srcKey and pushes it to the
+// list at dstKey, created if needed.
+func (srv *Server) listMove(db *DB, srcKey string, src *Object, dstKey string, from, to int) (string, error) {
+	dst, err := db.lookupType(dstKey, FieldTypeList)
+	if err != nil {
+		return "", err
+	}
+
+	elem, _ := listPop(src, from)
+	if dst == nil {
+		dst = srv.newListObject()
+		db.Set(dstKey, dst)
+	}
+	listPush(dst, elem, to)
+	listUpdateEncoding(dst)
+
+	listDeleteIfEmpty(db, srcKey, src)
+	return elem, nil
+}
+
+// blockingPopGeneric implements BLPOP and BRPOP, the reply is the key and
+// the element popped from the first non empty list.
+func (srv *Server) blockingPopGeneric(c *Client, cmd string, args []string, where int) error {
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return err
+	}
+
+	keys := args[:len(args)-1]
+	for _, key := range keys {
+		obj, err := c.db.lookupType(key, FieldTypeList)
+		if err != nil {
+			return err
+		}
+		if obj == nil {
+			continue
+		}
+
+		elem, _ := listPop(obj, where)
+		c.writer.WriteBulkStrings([]string{key, elem})
+		listDeleteIfEmpty(c.db, key, obj)
+		return nil
+	}
+
+	return srv.blockForKeys(c, keys, FieldTypeList, timeout, Message{cmd: cmd, args: args}, c.writer.WriteNullArray)
+}
+
+// BLPOP key [key ...] timeout
+func (srv *Server) onBLPop(c *Client, args []string) error {
+	return srv.blockingPopGeneric(c, "blpop", args, listHead)
+}
+
+// BRPOP key [key ...] timeout
+func (srv *Server) onBRPop(c *Client, args []string) error {
+	return srv.blockingPopGeneric(c, "brpop", args, listTail)
+}
+
+// BLMOVE source destination LEFT | RIGHT LEFT | RIGHT timeout
+func (srv *Server) onBLMove(c *Client, args []string) error {
+	from, err := parseListWhere(args[2])
+	if err != nil {
+		return err
+	}
+	to, err := parseListWhere(args[3])
+	if err != nil {
+		return err
+	}
+	timeout, err := parseTimeout(args[4])
+	if err != nil {
+		return err
+	}
+
+	src, err := c.db.lookupType(args[0], FieldTypeList)
+	if err != nil {
+		return err
+	}
+	if src == nil {
+		return srv.blockForKeys(c, args[:1], FieldTypeList, timeout, Message{cmd: "blmove", args: args}, c.writer.WriteNull)
+	}
+
+	elem, err := srv.listMove(c.db, args[0], src, args[1], from, to)
+	if err != nil {
+		return err
+	}
+	c.writer.WriteBulkString(elem)
+	return nil
+}
+
+// parseMPopArgs parses the arguments of LMPOP and BLMPOP that follow the
+// keys: LEFT | RIGHT [COUNT count].
+func parseMPopArgs(args []string) (where, count int, err error) {
+	if len(args) == 0 {
+		return 0, 0, ErrSyntax
+	}
+	if where, err = parseListWhere(args[0]); err != nil {
+		return 0, 0, err
+	}
+
+	if count, err = parseMPopCount(args[1:]); err != nil {
+		return 0, 0, err
+	}
+	return where, count, nil
+}
+
+// parseMPopCount parses the optional COUNT count ending the arguments of
+// the MPOP commands, 1 when missing.
+func parseMPopCount(args []string) (int, error) {
+	switch {
+	case len(args) == 0:
+		return 1, nil
+	case len(args) == 2 && strings.ToLower(args[0]) == "count":
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return 0, errors.New("ERR count should be greater than 0")
+		}
+		return n, nil
+	}
+	return 0, ErrSyntax
+}
+
+// mpopGeneric pops up to count elements from the first non empty list of
+// keys, replying with the key and the elements. It reports whether it
+// found one.
+func (srv *Server) mpopGeneric(c *Client, keys []string, where, count int) (bool, error) {
+	for _, key := range keys {
+		obj, err := c.db.lookupType(key, FieldTypeList)
+		if err != nil {
+			return false, err
+		}
+		if obj == nil {
+			continue
+		}
+
+		var elems []string
+		for ; count > 0; count-- {
+			elem, ok := listPop(obj, where)
+			if !ok {
+				break
+			}
+			elems = append(elems, elem)
+		}
+		c.writer.WriteArrayLen(2)
+		c.writer.WriteBulkString(key)
+		c.writer.WriteBulkStrings(elems)
+		listDeleteIfEmpty(c.db, key, obj)
+		return true, nil
+	}
+	return false, nil
+}
+
+// LMPOP numkeys key [key ...] LEFT | RIGHT [COUNT count]
+func (srv *Server) onLMPop(c *Client, args []string) error {
+	keys, rest, err := parseNumKeys(args)
+	if err != nil {
+		return err
+	}
+	where, count, err := parseMPopArgs(rest)
+	if err != nil {
+		return err
+	}
+
+	ok, err := srv.mpopGeneric(c, keys, where, count)
+	if err == nil && !ok {
+		c.writer.WriteNullArray()
+	}
+	return err
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT | RIGHT [COUNT count]
+func (srv *Server) onBLMPop(c *Client, args []string) error {
+	timeout, err := parseTimeout(args[0])
+	if err != nil {
+		return err
+	}
+	keys, rest, err := parseNumKeys(args[1:])
+	if err != nil {
+		return err
+	}
+	where, count, err := parseMPopArgs(rest)
+	if err != nil {
+		return err
+	}
+
+	ok, err := srv.mpopGeneric(c, keys, where, count)
+	if err != nil || ok {
+		return err
+	}
+	return srv.blockForKeys(c, keys, FieldTypeList, timeout, Message{cmd: "blmpop", args: args}, c.writer.WriteNullArray)
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// listpack is a sequence of strings serialized in one byte slice, the
+// compact encoding Redis uses for small lists, hashes, sets and sorted
+// sets. The layout is the one of Redis so a listpack can be saved to and
+// loaded from RDB files as is:
+//
+//	<total bytes uint32> <number of elements uint16> <entry> ... <0xFF>
+//
+// Each entry is an encoding byte, the integer or the string data and the
+// length of both, written backwards so the entries can be walked from the
+// tail. Strings that are integers are stored as integers.
+//
+// Entries are addressed by their byte offset, -1 standing for no entry.
+type listpack struct {
+	b []byte
+}
+
+const (
+	lpHeaderSize   = 6
+	lpEOF          = 0xFF
+	lpUnknownCount = math.MaxUint16
+)
+
+var errListpackCorrupt = errors.New("corrupt listpack")
+
+func newListpack() *listpack {
+	lp := &listpack{b: make([]byte, lpHeaderSize, 64)}
+	lp.b = append(lp.b, lpEOF)
+	lp.setHeader(0)
+	return lp
+}
+
+// listpackFromBytes wraps b, read from an RDB file, after checking every
+// entry is in bounds.
+func listpackFromBytes(b []byte) (*listpack, error) {
+	if len(b) < lpHeaderSize+1 || int(binary.LittleEndian.Uint32(b)) != len(b) || b[len(b)-1] != lpEOF {
+		return nil, errListpackCorrupt
+	}
+
+	lp := &listpack{b: b}
+	n := 0
+	for p := lpHeaderSize; b[p] != lpEOF; n++ {
+		size, ok := lp.entrySizeChecked(p)
+		if !ok {
+			return nil, errListpackCorrupt
+		}
+		p += size
+	}
+	if count := binary.LittleEndian.Uint16(b[4:]); count != lpUnknownCount && int(count) != n {
+		return nil, errListpackCorrupt
+	}
+	lp.setHeader(n)
+	return lp, nil
+}
+
+func (lp *listpack) setHeader(n int) {
+	binary.LittleEndian.PutUint32(lp.b, uint32(len(lp.b)))
+	if n >= lpUnknownCount {
+		n = lpUnknownCount
+	}
+	binary.LittleEndian.PutUint16(lp.b[4:], uint16(n))
+}
+
+// Len returns the number of elements.
+func (lp *listpack) Len() int {
+	n := int(binary.LittleEndian.Uint16(lp.b[4:]))
+	if n != lpUnknownCount {
+		return n
+	}
+
+	n = 0
+	for p := lp.First(); p != -1; p = lp.Next(p) {
+		n++
+	}
+	return n
+}
+
+// Bytes returns the size of the serialized listpack.
+func (lp *listpack) Bytes() int {
+	return len(lp.b)
+}
+
+func (lp *listpack) First() int {
+	if lp.b[lpHeaderSize] == lpEOF {
+		return -1
+	}
+	return lpHeaderSize
+}
+
+func (lp *listpack) Last() int {
+	return lp.Prev(len(lp.b) - 1)
+}
+
+func (lp *listpack) Next(p int) int {
+	p += lp.entrySize(p)
+	if lp.b[p] == lpEOF {
+		return -1
+	}
+	return p
+}
+
+func (lp *listpack) Prev(p int) int {
+	if p <= lpHeaderSize {
+		return -1
+	}
+
+	// the backlen is read right to left, 7 bits per byte, the high bit
+	// telling whether there is another byte on the left
+	size, shift := 0, 0
+	i := p - 1
+	for {
+		size |= int(lp.b[i]&127) << shift
+		if lp.b[i]&128 == 0 {
+			break
+		}
+		shift += 7
+		i--
+	}
+	return i - size
+}
+
+// Seek returns the offset of the element at index, negative indexes
+// counting from the tail.
+func (lp *listpack) Seek(index int) int {
+	n := lp.Len()
+	if index < 0 {
+		index += n
+	}
+	if index < 0 || index >= n {
+		return -1
+	}
+
+	if index < n/2 {
+		p := lp.First()
+		for ; index > 0; index-- {
+			p = lp.Next(p)
+		}
+		return p
+	}
+
+	p := lp.Last()
+	for i := n - 1; i > index; i-- {
+		p = lp.Prev(p)
+	}
+	return p
+}
+
+// Get returns the element at p as a string.
+func (lp *listpack) Get(p int) string {
+	s, v, isInt := lp.get(p)
+	if isInt {
+		return strconv.FormatInt(v, 10)
+	}
+	return s
+}
+
+// GetInt returns the element at p if it is stored as an integer.
+func (lp *listpack) GetInt(p int) (int64, bool) {
+	_, v, isInt := lp.get(p)
+	return v, isInt
+}
+
+func (lp *listpack) get(p int) (string, int64, bool) {
+	b := lp.b[p:]
+	switch enc := b[0]; {
+	case enc&0x80 == 0: // 7 bit unsigned integer
+		return "", int64(enc), true
+	case enc&0xC0 == 0x80: // 6 bit length string
+		n := int(enc & 0x3F)
+		return string(b[1 : 1+n]), 0, false
+	case enc&0xE0 == 0xC0: // 13 bit signed integer
+		v := int64(enc&0x1F)<<8 | int64(b[1])
+		if v >= 1<<12 {
+			v -= 1 << 13
+		}
+		return "", v, true
+	case enc&0xF0 == 0xE0: // 12 bit length string
+		n := int(enc&0x0F)<<8 | int(b[1])
+		return string(b[2 : 2+n]), 0, false
+	case enc == 0xF0: // 32 bit length string
+		n := int(binary.LittleEndian.Uint32(b[1:]))
+		return string(b[5 : 5+n]), 0, false
+	case enc == 0xF1:
+		return "", int64(int16(binary.LittleEndian.Uint16(b[1:]))), true
+	case enc == 0xF2:
+		v := int64(b[1]) | int64(b[2])<<8 | int64(int8(b[3]))<<16
+		return "", v, true
+	case enc == 0xF3:
+		return "", int64(int32(binary.LittleEndian.Uint32(b[1:]))), true
+	case enc == 0xF4:
+		return "", int64(binary.LittleEndian.Uint64(b[1:])), true
+	}
+	panic("listpack: bad entry encoding")
+}
+
+// encodedSize returns the size of the encoding byte and the data of the
+// entry at p, without its backlen.
+func (lp *listpack) encodedSize(p int) int {
+	size, _ := lp.encodedSizeChecked(p)
+	return size
+}
+
+func (lp *listpack) encodedSizeChecked(p int) (int, bool) {
+	b := lp.b[p:]
+	need := func(n int) (int, bool) {
+		return n, n <= len(b)
+	}
+	switch enc := b[0]; {
+	case enc&0x80 == 0:
+		return 1, true
+	case enc&0xC0 == 0x80:
+		return need(1 + int(enc&0x3F))
+	case enc&0xE0 == 0xC0:
+		return need(2)
+	case enc&0xF0 == 0xE0:
+		if len(b) < 2 {
+			return 0, false
+		}
+		return need(2 + (int(enc&0x0F)<<8 | int(b[1])))
+	case enc == 0xF0:
+		if len(b) < 5 {
+			return 0, false
+		}
+		return need(5 + int(binary.LittleEndian.Uint32(b[1:])))
+	case enc == 0xF1:
+		return need(3)
+	case enc == 0xF2:
+		return need(4)
+	case enc == 0xF3:
+		return need(5)
+	case enc == 0xF4:
+		return need(9)
+	}
+	return 0, false
+}
+
+// entrySize returns the size of the entry at p, backlen included.
+func (lp *listpack) entrySize(p int) int {
+	n := lp.encodedSize(p)
+	return n + backlenSize(n)
+}
+
+func (lp *listpack) entrySizeChecked(p int) (int, bool) {
+	n, ok := lp.encodedSizeChecked(p)
+	if !ok {
+		return 0, false
+	}
+	size := n + backlenSize(n)
+	// leave room for the terminator
+	if p+size >= len(lp.b) {
+		return 0, false
+	}
+	return size, string(lp.b[p+n:p+size]) == string(appendBacklen(nil, n))
+}
+
+func backlenSize(n int) int {
+	switch {
+	case n < 1<<7:
+		return 1
+	case n < 1<<14:
+		return 2
+	case n < 1<<21:
+		return 3
+	case n < 1<<28:
+		return 4
+	}
+	return 5
+}
+
+// encodeEntry serializes s as a listpack entry.
+func encodeEntry(s string) []byte {
+	var b []byte
+	if v, ok := canonicalInt(s); ok {
+		switch {
+		case v >= 0 && v <= 127:
+			b = []byte{byte(v)}
+		case v >= -4096 && v <= 4095:
+			u := uint64(v) & 0x1FFF
+			b = []byte{0xC0 | byte(u>>8), byte(u)}
+		case v >= math.MinInt16 && v <= math.MaxInt16:
+			b = []byte{0xF1, 0, 0}
+			binary.LittleEndian.PutUint16(b[1:], uint16(v))
+		case v >= -1<<23 && v < 1<<23:
+			u := uint32(v)
+			b = []byte{0xF2, byte(u), byte(u >> 8), byte(u >> 16)}
+		case v >= math.MinInt32 && v <= math.MaxInt32:
+			b = []byte{0xF3, 0, 0, 0, 0}
+			binary.LittleEndian.PutUint32(b[1:], uint32(v))
+		default:
+			b = make([]byte, 9)
+			b[0] = 0xF4
+			binary.LittleEndian.PutUint64(b[1:], uint64(v))
+		}
+	} else {
+		switch n := len(s); {
+		case n < 64:
+			b = append([]byte{0x80 | byte(n)}, s...)
+		case n < 4096:
+			b = append([]byte{0xE0 | byte(n>>8), byte(n)}, s...)
+		default:
+			b = make([]byte, 5, 5+n)
+			b[0] = 0xF0
+			binary.LittleEndian.PutUint32(b[1:], uint32(n))
+			b = append(b, s...)
+		}
+	}
+
+	return appendBacklen(b, len(b))
+}
+
+// appendBacklen appends n to b so it can be read from right to left: the
+// last byte holds the 7 least significant bits, the high bit of a byte
+// telling whether more follow on its left.
+func appendBacklen(b []byte, n int) []byte {
+	size := backlenSize(n)
+	for i := size - 1; i >= 0; i-- {
+		c := byte(n>>(7*i)) & 127
+		if i < size-1 {
+			c |= 128
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// Insert adds s before the entry at p, at the tail when p is -1, and
+// returns the offset of the new entry.
+func (lp *listpack) Insert(p int, s string) int {
+	if p == -1 {
+		p = len(lp.b) - 1
+	}
+	n := lp.Len()
+
+	entry := encodeEntry(s)
+	lp.b = append(lp.b, entry...)
+	copy(lp.b[p+len(entry):], lp.b[p:len(lp.b)-len(entry)])
+	copy(lp.b[p:], entry)
+	lp.setHeader(n + 1)
+	return p
+}
+
+func (lp *listpack) Append(s string) {
+	lp.Insert(-1, s)
+}
+
+func (lp *listpack) Prepend(s string) {
+	lp.Insert(lpHeaderSize, s)
+}
+
+// Delete removes the entry at p and returns the offset of the entry that
+// followed it.
+func (lp *listpack) Delete(p int) int {
+	n := lp.Len()
+	size := lp.entrySize(p)
+	lp.b = append(lp.b[:p], lp.b[p+size:]...)
+	lp.setHeader(n - 1)
+
+	if lp.b[p] == lpEOF {
+		return -1
+	}
+	return p
+}
+
+// Replace overwrites the entry at p with s.
+func (lp *listpack) Replace(p int, s string) {
+	lp.Delete(p)
+	lp.Insert(p, s)
+}
+
+// Find returns the offset of the first entry equal to s from p on, skip
+// entries being skipped after every comparison, like for the fields of a
+// hash stored as field value pairs.
+func (lp *listpack) Find(p int, s string, skip int) int {
+	for p != -1 {
+		if lp.Get(p) == s {
+			return p
+		}
+		p = lp.Next(p)
+		for i := 0; i < skip && p != -1; i++ {
+			p = lp.Next(p)
+		}
+	}
+	return -1
+}
+
+// Strings returns every element.
+func (lp *listpack) Strings() []string {
+	res := make([]string, 0, lp.Len())
+	for p := lp.First(); p != -1; p = lp.Next(p) {
+		res = append(res, lp.Get(p))
+	}
+	return res
+}
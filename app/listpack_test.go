@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListpack(t *testing.T) {
+	elems := []string{
+		"0", "127", "128", "-1", "4095", "-4096", "4096", "32767", "-32768",
+		"8388607", "-8388608", "2147483647", "-2147483648", "9223372036854775807",
+		"-9223372036854775808", "01", "+1", "", "foo", strings.Repeat("x", 63),
+		strings.Repeat("y", 64), strings.Repeat("z", 4096),
+	}
+
+	lp := newListpack()
+	for _, e := range elems {
+		lp.Append(e)
+	}
+	if lp.Len() != len(elems) {
+		t.Fatalf("expected %d elements got %d", len(elems), lp.Len())
+	}
+
+	got := lp.Strings()
+	for i := range elems {
+		if got[i] != elems[i] {
+			t.Fatalf("element %d: expected %q got %q", i, elems[i], got[i])
+		}
+	}
+
+	// walk backward
+	i := len(elems) - 1
+	for p := lp.Last(); p != -1; p = lp.Prev(p) {
+		if lp.Get(p) != elems[i] {
+			t.Fatalf("backward element %d: expected %q got %q", i, elems[i], lp.Get(p))
+		}
+		i--
+	}
+	if i != -1 {
+		t.Fatalf("backward walk stopped at %d", i)
+	}
+
+	if _, err := listpackFromBytes(append([]byte(nil), lp.b...)); err != nil {
+		t.Fatalf("expected valid listpack got %v", err)
+	}
+	corrupt := append([]byte(nil), lp.b...)
+	corrupt[lpHeaderSize+1] = 0x05 // backlen of the first entry
+	if _, err := listpackFromBytes(corrupt); err == nil {
+		t.Fatal("expected corrupt listpack to be rejected")
+	}
+}
+
+func TestListpackEdit(t *testing.T) {
+	lp := newListpack()
+	for i := 0; i < 10; i++ {
+		lp.Append(strconv.Itoa(i))
+	}
+
+	lp.Prepend("head")
+	lp.Insert(lp.Seek(5), "mid")
+	lp.Replace(lp.Seek(-1), "tail")
+	for p := lp.First(); p != -1; {
+		if lp.Get(p) == "2" {
+			p = lp.Delete(p)
+		} else {
+			p = lp.Next(p)
+		}
+	}
+
+	want := "head 0 1 3 mid 4 5 6 7 8 tail"
+	if got := strings.Join(lp.Strings(), " "); got != want {
+		t.Fatalf("expected %q got %q", want, got)
+	}
+	if p := lp.Find(lp.First(), "mid", 0); lp.Get(p) != "mid" {
+		t.Fatal("expected Find to return mid")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import "errors"
+
+var errLZFCorrupt = errors.New("corrupt LZF data")
+
+// lzfDecompress expands data compressed by liblzf, as Redis does for
+// strings longer than 20 bytes in RDB files when rdbcompression is on.
+//
+// Each chunk starts with a control byte: below 32 it is followed by a run
+// of ctrl+1 literal bytes, otherwise it is a back reference into the output
+// made of a length (the 3 high bits, extended by the next byte when all
+// set) and a 13 bit offset.
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 1<<5 {
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errLZFCorrupt
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errLZFCorrupt
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errLZFCorrupt
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errLZFCorrupt
+		}
+
+		// the reference may overlap the bytes being copied
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != outLen {
+		return nil, errLZFCorrupt
+	}
+	return out, nil
+}
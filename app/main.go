@@ -8,18 +8,22 @@ import (
 func main() {
 	flags := parseFlags()
 	startServer(ServerOpt{
-		dir:        flags.dir,
-		dbfilename: flags.dbfilename,
-		port:       flags.port,
-		replicaOf:  flags.replicaof,
+		dir:         flags.dir,
+		dbfilename:  flags.dbfilename,
+		port:        flags.port,
+		replicaOf:   flags.replicaof,
+		requirePass: flags.requirepass,
+		databases:   flags.databases,
 	})
 }
 
 type flags struct {
-	port       string
-	dir        string
-	dbfilename string
-	replicaof  string
+	port        string
+	dir         string
+	dbfilename  string
+	replicaof   string
+	requirepass string
+	databases   string
 }
 
 func parseFlags() flags {
@@ -41,6 +45,12 @@ func parseFlags() flags {
 		case "--replicaof":
 			i += 2
 			result.replicaof = fmt.Sprintf("%s %s", args[i-1], args[i])
+		case "--requirepass":
+			i += 1
+			result.requirepass = args[i]
+		case "--databases":
+			i += 1
+			result.databases = args[i]
 		}
 	}
 	return result
@@ -0,0 +1,77 @@
+package main
+
+import "errors"
+
+// Transactions: MULTI queues the following commands of the client, EXEC
+// runs them in a row, nothing else runs on the event loop meanwhile.
+// Blocking commands do not block inside a transaction, see canBlock.
+
+// isTransactionCommand reports whether cmd runs right away between MULTI
+// and EXEC instead of being queued.
+func isTransactionCommand(cmd *Command) bool {
+	switch cmd.Name {
+	case "multi", "exec", "discard":
+		return true
+	}
+	return false
+}
+
+// flagTransaction makes the EXEC of the current transaction fail when a
+// command could not be queued, and returns err.
+func (c *Client) flagTransaction(err error) error {
+	if c.inMulti {
+		c.multiDirty = true
+	}
+	return err
+}
+
+func (c *Client) discardTransaction() {
+	c.inMulti = false
+	c.multiQueue = nil
+	c.multiDirty = false
+}
+
+// MULTI
+func (srv *Server) onMulti(c *Client, args []string) error {
+	if c.inMulti {
+		return errors.New("ERR MULTI calls can not be nested")
+	}
+
+	c.inMulti = true
+	c.writer.WriteSimpleString("OK")
+	return nil
+}
+
+// EXEC
+func (srv *Server) onExec(c *Client, args []string) error {
+	if !c.inMulti {
+		return errors.New("ERR EXEC without MULTI")
+	}
+
+	queue, dirty := c.multiQueue, c.multiDirty
+	c.discardTransaction()
+	if dirty {
+		return errors.New("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	c.writer.WriteArrayLen(len(queue))
+	c.inExec = true
+	for _, m := range queue {
+		if err := srv.runCommand(c, m); err != nil {
+			c.writer.WriteError(err.Error())
+		}
+	}
+	c.inExec = false
+	return nil
+}
+
+// DISCARD
+func (srv *Server) onDiscard(c *Client, args []string) error {
+	if !c.inMulti {
+		return errors.New("ERR DISCARD without MULTI")
+	}
+
+	c.discardTransaction()
+	c.writer.WriteSimpleString("OK")
+	return nil
+}
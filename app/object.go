@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// ObjEncoding is the in-memory representation of a value, as reported by
+// OBJECT ENCODING.
+type ObjEncoding byte
+
+const (
+	EncodingRaw ObjEncoding = iota
+	EncodingEmbStr
+	EncodingListpack
+	EncodingQuicklist
+	EncodingHashtable
+	EncodingListpackEx
+	EncodingIntset
+	EncodingSkiplist
+	EncodingStream
+	EncodingInt
+)
+
+var encodingNames = map[ObjEncoding]string{
+	EncodingRaw:        "raw",
+	EncodingEmbStr:     "embstr",
+	EncodingListpack:   "listpack",
+	EncodingQuicklist:  "quicklist",
+	EncodingHashtable:  "hashtable",
+	EncodingListpackEx: "listpackex",
+	EncodingIntset:     "intset",
+	EncodingSkiplist:   "skiplist",
+	EncodingStream:     "stream",
+	EncodingInt:        "int",
+}
+
+func (e ObjEncoding) String() string {
+	return encodingNames[e]
+}
+
+// Object is a value of the keyspace. Type is one of the base FieldTypes
+// (string, list, set, zset, hash, stream) whatever the RDB encoding it was
+// loaded from, Encoding tells how Value is laid out in memory.
+type Object struct {
+	Type     FieldType
+	Encoding ObjEncoding
+	Value    any
+	ExpireAt int64 // unix ms timestamp, 0 when the key has no TTL
+	LRU      int64 // unix ms timestamp of the last access
+	LFU      uint8 // logarithmic access counter, see touch
+}
+
+// embstrSizeLimit is the longest string Redis allocates together with its
+// object header, kept to report the same encodings.
+const embstrSizeLimit = 44
+
+// newStringObject returns a string, held as an int64 when s is the
+// canonical form of one, so counters take less memory.
+func newStringObject(s string) *Object {
+	if n, ok := canonicalInt(s); ok {
+		return newObject(FieldTypeString, EncodingInt, n)
+	}
+	enc := EncodingRaw
+	if len(s) <= embstrSizeLimit {
+		enc = EncodingEmbStr
+	}
+	return newObject(FieldTypeString, enc, s)
+}
+
+func newObject(t FieldType, enc ObjEncoding, val any) *Object {
+	return &Object{
+		Type:     t,
+		Encoding: enc,
+		Value:    val,
+		LRU:      mstime(),
+		LFU:      lfuInitVal,
+	}
+}
+
+// newObjectFromField converts a value loaded from an RDB file. It returns
+// nil for empty collections, Redis skips them on load.
+func (srv *Server) newObjectFromField(f Field) (*Object, error) {
+	switch f.Type {
+	case FieldTypeString:
+		return newStringObject(f.Value.(string)), nil
+	case FieldTypeList, FieldTypeListZiplist, FieldTypeListQuicklist, FieldTypeListQuicklist2:
+		if len(f.Value.([]string)) == 0 {
+			return nil, nil
+		}
+		obj := srv.newListObject()
+		for _, elem := range f.Value.([]string) {
+			listPush(obj, elem, listTail)
+		}
+		listUpdateEncoding(obj)
+		return obj, nil
+	case FieldTypeHash, FieldTypeHashZiplist, FieldTypeHashListpack, FieldTypeHashMetadata, FieldTypeHashListpackEx:
+		hv := f.Value.(HashValue)
+		obj := srv.newHashObject()
+		srv.hashTryConversion(obj, hv.Pairs...)
+		now := mstime()
+		for i := 0; i < len(hv.Pairs); i += 2 {
+			field := hv.Pairs[i]
+			at := hv.Expires[field]
+			if at != 0 && at <= now {
+				continue
+			}
+			srv.hashSet(obj, field, hv.Pairs[i+1])
+			hashSetExpire(obj, field, at)
+		}
+		if hashLen(obj) == 0 {
+			return nil, nil
+		}
+		return obj, nil
+	case FieldTypeSet, FieldTypeSetIntset, FieldTypeSetListpack:
+		members := f.Value.([]string)
+		if len(members) == 0 {
+			return nil, nil
+		}
+		obj := srv.newSetObject(members[0])
+		for _, member := range members {
+			srv.setAdd(obj, member)
+		}
+		return obj, nil
+	case FieldTypeZSet, FieldTypeZSet2, FieldTypeZSetZiplist, FieldTypeZSetListpack:
+		entries := f.Value.([]zsetEntry)
+		if len(entries) == 0 {
+			return nil, nil
+		}
+		return srv.zsetFromEntries(entries), nil
+	case FieldTypeStream, FieldTypeStreamListpacks2, FieldTypeStreamListpacks3:
+		// unlike the other types an empty stream is a value of its own
+		return newObject(FieldTypeStream, EncodingStream, f.Value.(*stream)), nil
+	}
+	return nil, fmt.Errorf("unsupported value type %d", f.Type)
+}
+
+func (o *Object) str() string {
+	if o.Encoding == EncodingInt {
+		return strconv.FormatInt(o.Value.(int64), 10)
+	}
+	return o.Value.(string)
+}
+
+// dup returns a deep copy of o, TTL included, for COPY.
+func (o *Object) dup() *Object {
+	res := *o
+	switch o.Type {
+	case FieldTypeList:
+		res.Value = o.Value.(*quicklist).dup()
+	case FieldTypeHash:
+		res.Value = hashDup(o)
+	case FieldTypeSet:
+		res.Value = setDup(o)
+	case FieldTypeZSet:
+		res.Value = zsetDup(o)
+	case FieldTypeStream:
+		res.Value = o.Value.(*stream).dup()
+	}
+	res.LRU = mstime()
+	res.LFU = lfuInitVal
+	return &res
+}
+
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = 60 * 1000 // ms per counter decrement
+)
+
+// touch records an access for the idle time and the access frequency.
+// Like Redis the frequency counter grows logarithmically, the higher it is
+// the less likely an access increments it, and decays with idle time.
+func (o *Object) touch() {
+	now := mstime()
+
+	if periods := (now - o.LRU) / lfuDecayTime; periods > 0 {
+		if periods > int64(o.LFU) {
+			o.LFU = 0
+		} else {
+			o.LFU -= uint8(periods)
+		}
+	}
+
+	if o.LFU < 255 {
+		base := float64(o.LFU) - lfuInitVal
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			o.LFU++
+		}
+	}
+
+	o.LRU = now
+}
+
+// OBJECT ENCODING | FREQ | IDLETIME | REFCOUNT key
+func (srv *Server) onObject(c *Client, args []string) error {
+	sub := strings.ToLower(args[0])
+	switch sub {
+	case "encoding", "freq", "idletime", "refcount":
+	default:
+		return errUnknownSubcommand("object", sub)
+	}
+	if len(args) != 2 {
+		return errWrongNumberOfArgs("object|" + sub)
+	}
+
+	obj := c.db.lookupNoTouch(args[1])
+	if obj == nil {
+		c.writer.WriteNull()
+		return nil
+	}
+
+	switch sub {
+	case "encoding":
+		c.writer.WriteBulkString(obj.Encoding.String())
+	case "freq":
+		c.writer.WriteInteger(int64(obj.LFU))
+	case "idletime":
+		c.writer.WriteInteger((mstime() - obj.LRU) / 1000)
+	case "refcount":
+		c.writer.WriteInteger(1)
+	}
+	return nil
+}
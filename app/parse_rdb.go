@@ -3,9 +3,12 @@ package main
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"io"
 	"log"
+	"math"
 	"os"
+	"strconv"
 )
 
 const (
@@ -44,12 +47,60 @@ type Database struct {
 	Fields map[string]Field
 }
 
+// FieldType is the RDB value type of a key. The keyspace only uses the base
+// types, whatever the encoding a value was saved with.
 type FieldType byte
 
 const (
 	FieldTypeString FieldType = 0
+	FieldTypeList   FieldType = 1
+	FieldTypeSet    FieldType = 2
+	FieldTypeZSet   FieldType = 3
+	FieldTypeHash   FieldType = 4
+	FieldTypeStream FieldType = 15
+
+	// encodings of the base types
+	FieldTypeListZiplist      FieldType = 10
+	FieldTypeListQuicklist    FieldType = 14
+	FieldTypeListQuicklist2   FieldType = 18
+	FieldTypeSetIntset        FieldType = 11
+	FieldTypeSetListpack      FieldType = 20
+	FieldTypeZSet2            FieldType = 5 // binary scores
+	FieldTypeZSetZiplist      FieldType = 12
+	FieldTypeZSetListpack     FieldType = 17
+	FieldTypeHashZiplist      FieldType = 13
+	FieldTypeHashListpack     FieldType = 16
+	FieldTypeHashMetadata     FieldType = 24 // with field TTLs
+	FieldTypeHashListpackEx   FieldType = 25 // with field TTLs
+	FieldTypeStreamListpacks2 FieldType = 19 // with first and max deleted IDs
+	FieldTypeStreamListpacks3 FieldType = 21 // with consumer active times
 )
 
+// quicklist node containers of FieldTypeListQuicklist2
+const (
+	quicklistNodePlain  = 1 // a single element too large for a listpack
+	quicklistNodePacked = 2 // a listpack
+)
+
+// String returns the type name reported by TYPE.
+func (t FieldType) String() string {
+	switch t {
+	case FieldTypeString:
+		return "string"
+	case FieldTypeList:
+		return "list"
+	case FieldTypeSet:
+		return "set"
+	case FieldTypeZSet:
+		return "zset"
+	case FieldTypeHash:
+		return "hash"
+	case FieldTypeStream:
+		return "stream"
+	}
+	return "unknown"
+}
+
 type Field struct {
 	Key         string
 	ExpiredTime uint64 // unix ms timestamp
@@ -59,6 +110,13 @@ type Field struct {
 
 type StringValue string
 
+// HashValue is a hash loaded from an RDB file: its fields and values in
+// pairs, and the unix ms timestamp the fields with a TTL expire at.
+type HashValue struct {
+	Pairs   []string
+	Expires map[string]int64
+}
+
 func ParseRDB(path string) RDB {
 	file, err := os.Open(path)
 	if err != nil {
@@ -80,7 +138,7 @@ func ParseRDB(path string) RDB {
 		log.Fatalln(err)
 	}
 
-	var curDBID int
+	var curDB int // index in rdb.Databases of the last SELECTDB
 	for {
 		b, err := r.ReadByte()
 		if err == io.EOF {
@@ -116,22 +174,22 @@ func ParseRDB(path string) RDB {
 
 			db.ID = dbID
 			db.Fields = map[string]Field{}
-			curDBID = dbID
 
 			rdb.Databases = append(rdb.Databases, db)
+			curDB = len(rdb.Databases) - 1
 			continue
 		case OPCodeRESIZEDB:
 			hashTableSize, err := DecodeLength(r)
 			if err != nil {
 				log.Fatalln(err)
 			}
-			rdb.Databases[curDBID].ResizeDB.HashTableSize = hashTableSize
+			rdb.Databases[curDB].ResizeDB.HashTableSize = hashTableSize
 
 			expireHashTableSize, err := DecodeLength(r)
 			if err != nil {
 				log.Fatalln(err)
 			}
-			rdb.Databases[curDBID].ResizeDB.ExpireHashTable = expireHashTableSize
+			rdb.Databases[curDB].ResizeDB.ExpireHashTable = expireHashTableSize
 			continue
 		default:
 			var f Field
@@ -139,7 +197,7 @@ func ParseRDB(path string) RDB {
 			case OPCodeEXPIRETIME:
 				var data uint32
 				binary.Read(r, binary.LittleEndian, &data)
-				f.ExpiredTime = uint64(data)
+				f.ExpiredTime = uint64(data) * 1000 // seconds
 				b, err := r.ReadByte()
 				if err != nil {
 					log.Fatalln(err)
@@ -174,16 +232,501 @@ func ParseRDB(path string) RDB {
 					log.Fatalln("asdf3", err)
 				}
 				f.Value = val
+			case FieldTypeList, FieldTypeListZiplist, FieldTypeListQuicklist, FieldTypeListQuicklist2:
+				val, err := parseList(r, f.Type)
+				if err != nil {
+					log.Fatalf("ParseRDB: key %q: %v\n", key, err)
+				}
+				f.Value = val
+			case FieldTypeSet, FieldTypeSetIntset, FieldTypeSetListpack:
+				val, err := parseSet(r, f.Type)
+				if err != nil {
+					log.Fatalf("ParseRDB: key %q: %v\n", key, err)
+				}
+				f.Value = val
+			case FieldTypeZSet, FieldTypeZSet2, FieldTypeZSetZiplist, FieldTypeZSetListpack:
+				val, err := parseZSet(r, f.Type)
+				if err != nil {
+					log.Fatalf("ParseRDB: key %q: %v\n", key, err)
+				}
+				f.Value = val
+			case FieldTypeHash, FieldTypeHashZiplist, FieldTypeHashListpack, FieldTypeHashMetadata, FieldTypeHashListpackEx:
+				val, err := parseHash(r, f.Type)
+				if err != nil {
+					log.Fatalf("ParseRDB: key %q: %v\n", key, err)
+				}
+				f.Value = val
+			case FieldTypeStream, FieldTypeStreamListpacks2, FieldTypeStreamListpacks3:
+				val, err := parseStream(r, f.Type)
+				if err != nil {
+					log.Fatalf("ParseRDB: key %q: %v\n", key, err)
+				}
+				f.Value = val
+			default:
+				// the length of an unknown value is unknown too, there is
+				// no way to skip it and read the following keys
+				log.Fatalf("ParseRDB: key %q has unsupported value type %d\n", key, f.Type)
 			}
 
-			rdb.Databases[curDBID].Fields[key] = f
-			rdb.Databases[curDBID].Keys = append(rdb.Databases[curDBID].Keys, key)
+			rdb.Databases[curDB].Fields[key] = f
+			rdb.Databases[curDB].Keys = append(rdb.Databases[curDB].Keys, key)
 		}
 	}
 
 	return rdb
 }
 
+// parseList reads the elements of a list saved with any of the list
+// encodings.
+func parseList(r *bufio.Reader, t FieldType) ([]string, error) {
+	if t == FieldTypeListZiplist {
+		blob, err := DecodeString(r)
+		if err != nil {
+			return nil, err
+		}
+		return ziplistEntries([]byte(blob))
+	}
+
+	n, err := DecodeLength(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for i := 0; i < n; i++ {
+		container := quicklistNodePacked
+		if t == FieldTypeListQuicklist2 {
+			if container, err = DecodeLength(r); err != nil {
+				return nil, err
+			}
+		}
+
+		s, err := DecodeString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case t == FieldTypeList || container == quicklistNodePlain:
+			res = append(res, s)
+		case t == FieldTypeListQuicklist:
+			elems, err := ziplistEntries([]byte(s))
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, elems...)
+		default:
+			lp, err := listpackFromBytes([]byte(s))
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, lp.Strings()...)
+		}
+	}
+	return res, nil
+}
+
+// parseSet reads the members of a set saved with any of the set
+// encodings.
+func parseSet(r *bufio.Reader, t FieldType) ([]string, error) {
+	if t == FieldTypeSet {
+		n, err := DecodeLength(r)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			s, err := DecodeString(r)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, s)
+		}
+		return res, nil
+	}
+
+	blob, err := DecodeString(r)
+	if err != nil {
+		return nil, err
+	}
+	if t == FieldTypeSetListpack {
+		lp, err := listpackFromBytes([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		return lp.Strings(), nil
+	}
+	is, err := intsetFromBytes([]byte(blob))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, is.Len())
+	for i := range res {
+		res[i] = strconv.FormatInt(is.Get(i), 10)
+	}
+	return res, nil
+}
+
+// parseZSet reads the members and scores of a sorted set saved with any of
+// the sorted set encodings.
+func parseZSet(r *bufio.Reader, t FieldType) ([]zsetEntry, error) {
+	if t == FieldTypeZSetZiplist || t == FieldTypeZSetListpack {
+		blob, err := DecodeString(r)
+		if err != nil {
+			return nil, err
+		}
+		var elems []string
+		if t == FieldTypeZSetZiplist {
+			elems, err = ziplistEntries([]byte(blob))
+		} else {
+			var lp *listpack
+			if lp, err = listpackFromBytes([]byte(blob)); err == nil {
+				elems = lp.Strings()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(elems)%2 != 0 {
+			return nil, errors.New("sorted set listpack with a missing score")
+		}
+
+		res := make([]zsetEntry, 0, len(elems)/2)
+		for i := 0; i < len(elems); i += 2 {
+			score, err := strconv.ParseFloat(elems[i+1], 64)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, zsetEntry{member: elems[i], score: score})
+		}
+		return res, nil
+	}
+
+	n, err := DecodeLength(r)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]zsetEntry, 0, n)
+	for i := 0; i < n; i++ {
+		member, err := DecodeString(r)
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if t == FieldTypeZSet2 {
+			var b [8]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return nil, err
+			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(b[:]))
+		} else if score, err = decodeDoubleString(r); err != nil {
+			return nil, err
+		}
+		res = append(res, zsetEntry{member: member, score: score})
+	}
+	return res, nil
+}
+
+// decodeDoubleString reads a score of the first sorted set encoding: its
+// length on a byte, 253 to 255 standing for NaN, +inf and -inf, then its
+// text.
+func decodeDoubleString(r *bufio.Reader) (float64, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// parseStream reads a stream: its listpack nodes each after its master ID,
+// then its metadata. The first encoding lacks the first and max deleted
+// IDs and the number of entries ever added.
+func parseStream(r *bufio.Reader, t FieldType) (*stream, error) {
+	s := newStream()
+	n, err := DecodeLength(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		key, err := DecodeString(r)
+		if err != nil {
+			return nil, err
+		}
+		master, ok := decodeStreamID(key)
+		if !ok {
+			return nil, errors.New("stream node key is not a 128 bit ID")
+		}
+		blob, err := DecodeString(r)
+		if err != nil {
+			return nil, err
+		}
+		lp, err := listpackFromBytes([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		if lp.First() == -1 {
+			return nil, errors.New("empty stream node")
+		}
+		s.nodes = append(s.nodes, &streamNode{master: master, lp: lp})
+	}
+
+	// the IDs are 64 bit unsigned, read as ints they keep their bits
+	readID := func() (streamID, error) {
+		ms, err := DecodeLength(r)
+		if err != nil {
+			return streamID{}, err
+		}
+		seq, err := DecodeLength(r)
+		return streamID{uint64(ms), uint64(seq)}, err
+	}
+	if s.length, err = DecodeLength(r); err != nil {
+		return nil, err
+	}
+	if s.lastID, err = readID(); err != nil {
+		return nil, err
+	}
+	if t == FieldTypeStream {
+		s.updateFirstID()
+		s.entriesAdded = uint64(s.length)
+	} else {
+		if s.firstID, err = readID(); err != nil {
+			return nil, err
+		}
+		if s.maxDeletedID, err = readID(); err != nil {
+			return nil, err
+		}
+		added, err := DecodeLength(r)
+		if err != nil {
+			return nil, err
+		}
+		s.entriesAdded = uint64(added)
+	}
+
+	groups, err := DecodeLength(r)
+	if err != nil {
+		return nil, err
+	}
+	for ; groups > 0; groups-- {
+		if err := parseStreamGroup(r, t, s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseStreamGroup reads a consumer group of s: its name and last
+// delivered ID, its PEL then its consumers with the IDs of their pending
+// entries. The first encoding lacks the entries read, older ones the
+// active time of the consumers.
+func parseStreamGroup(r *bufio.Reader, t FieldType, s *stream) error {
+	name, err := DecodeString(r)
+	if err != nil {
+		return err
+	}
+	var lastID streamID
+	for _, v := range []*uint64{&lastID.ms, &lastID.seq} {
+		n, err := DecodeLength(r)
+		if err != nil {
+			return err
+		}
+		*v = uint64(n)
+	}
+	entriesRead := int64(streamInvalidEntriesRead)
+	if t == FieldTypeStream {
+		entriesRead = s.estimateEntriesRead(lastID)
+	} else {
+		n, err := DecodeLength(r)
+		if err != nil {
+			return err
+		}
+		entriesRead = int64(n)
+	}
+	g := s.createGroup(name, lastID, entriesRead)
+	if g == nil {
+		return errors.New("duplicated stream consumer group")
+	}
+
+	readID := func() (streamID, error) {
+		var b [16]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return streamID{}, err
+		}
+		id, _ := decodeStreamID(string(b[:]))
+		return id, nil
+	}
+	readMillis := func() (int64, error) {
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		return int64(binary.LittleEndian.Uint64(b[:])), nil
+	}
+
+	n, err := DecodeLength(r)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		id, err := readID()
+		if err != nil {
+			return err
+		}
+		nack := &streamNACK{}
+		if nack.deliveryTime, err = readMillis(); err != nil {
+			return err
+		}
+		count, err := DecodeLength(r)
+		if err != nil {
+			return err
+		}
+		nack.deliveryCount = int64(count)
+		g.pel.insert(id, nack)
+	}
+
+	if n, err = DecodeLength(r); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		consumerName, err := DecodeString(r)
+		if err != nil {
+			return err
+		}
+		consumer := g.createConsumer(consumerName, 0)
+		if consumer == nil {
+			return errors.New("duplicated stream consumer")
+		}
+		if consumer.seenTime, err = readMillis(); err != nil {
+			return err
+		}
+		consumer.activeTime = consumer.seenTime
+		if t == FieldTypeStreamListpacks3 {
+			if consumer.activeTime, err = readMillis(); err != nil {
+				return err
+			}
+		}
+
+		pending, err := DecodeLength(r)
+		if err != nil {
+			return err
+		}
+		for j := 0; j < pending; j++ {
+			id, err := readID()
+			if err != nil {
+				return err
+			}
+			nack := g.pel.get(id)
+			if nack == nil || nack.consumer != nil {
+				return errors.New("stream consumer pending entry not in the group PEL")
+			}
+			nack.consumer = consumer
+			consumer.pel.insert(id, nack)
+		}
+	}
+
+	// every pending entry is owned by a consumer
+	orphan := false
+	g.pel.iterate(streamID{}, func(_ streamID, nack *streamNACK) bool {
+		orphan = nack.consumer == nil
+		return !orphan
+	})
+	if orphan {
+		return errors.New("stream group pending entry without consumer")
+	}
+	return nil
+}
+
+// parseHash reads a hash saved with any of the hash encodings.
+func parseHash(r *bufio.Reader, t FieldType) (HashValue, error) {
+	res := HashValue{Expires: map[string]int64{}}
+
+	// the encodings with field TTLs start with the earliest one
+	var minExpire int64
+	if t == FieldTypeHashMetadata || t == FieldTypeHashListpackEx {
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return res, err
+		}
+		minExpire = int64(binary.LittleEndian.Uint64(b[:]))
+	}
+
+	switch t {
+	case FieldTypeHash, FieldTypeHashMetadata:
+		n, err := DecodeLength(r)
+		if err != nil {
+			return res, err
+		}
+		for i := 0; i < n; i++ {
+			// a TTL relative to minExpire, plus 1 so 0 means none
+			var ttl int
+			if t == FieldTypeHashMetadata {
+				if ttl, err = DecodeLength(r); err != nil {
+					return res, err
+				}
+			}
+			var kv [2]string
+			for j := range kv {
+				if kv[j], err = DecodeString(r); err != nil {
+					return res, err
+				}
+			}
+			res.Pairs = append(res.Pairs, kv[0], kv[1])
+			if ttl != 0 {
+				res.Expires[kv[0]] = minExpire + int64(ttl) - 1
+			}
+		}
+		return res, nil
+	}
+
+	blob, err := DecodeString(r)
+	if err != nil {
+		return res, err
+	}
+	var elems []string
+	if t == FieldTypeHashZiplist {
+		elems, err = ziplistEntries([]byte(blob))
+	} else {
+		var lp *listpack
+		if lp, err = listpackFromBytes([]byte(blob)); err == nil {
+			elems = lp.Strings()
+		}
+	}
+	if err != nil {
+		return res, err
+	}
+
+	// a listpackex holds field value TTL triplets, 0 for no TTL
+	stride := 2
+	if t == FieldTypeHashListpackEx {
+		stride = 3
+	}
+	if len(elems)%stride != 0 {
+		return res, errors.New("hash listpack with a missing value")
+	}
+	for i := 0; i < len(elems); i += stride {
+		res.Pairs = append(res.Pairs, elems[i], elems[i+1])
+		if stride == 3 && elems[i+2] != "0" {
+			at, err := strconv.ParseInt(elems[i+2], 10, 64)
+			if err != nil {
+				return res, err
+			}
+			res.Expires[elems[i]] = at
+		}
+	}
+	return res, nil
+}
+
 func parseAux(r *bufio.Reader) (string, string, error) {
 	var kv [2]string
 
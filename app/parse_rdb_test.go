@@ -8,7 +8,7 @@ import (
 )
 
 func TestParse(t *testing.T) {
-	rdb := ParseRDB("/home/highbits/code/sandbox/codecrafters-redis-go/dump/dump.rdb")
+	rdb := ParseRDB("../dump/dump.rdb")
 	// fmt.Println(string(rdb.MagicString[:]), string(rdb.RDBVerNum[:]), rdb.AuxField, rdb.Databases)
 	fmt.Printf("%+v\n", rdb)
 }
@@ -19,3 +19,155 @@ func TestParseAux(t *testing.T) {
 	key, val, err := parseAux(r)
 	fmt.Println(key, val, err)
 }
+
+func TestParseList(t *testing.T) {
+	// a ziplist of "ab", 5 and 1000
+	zl := "\x15\x00\x00\x00\x10\x00\x00\x00\x03\x00" +
+		"\x00\x02ab" + "\x04\xf6" + "\x02\xc0\xe8\x03" + "\xff"
+	lp := newListpack()
+	lp.Append("ab")
+	lp.Append("5")
+
+	tests := []struct {
+		name string
+		typ  FieldType
+		in   string
+		want string
+	}{
+		{"list", FieldTypeList, "\x02\x02ab\xc0\x05", "ab 5"},
+		{"ziplist", FieldTypeListZiplist, "\x15" + zl, "ab 5 1000"},
+		{"quicklist", FieldTypeListQuicklist, "\x01\x15" + zl, "ab 5 1000"},
+		{"quicklist2", FieldTypeListQuicklist2, "\x02\x02" + string(rune(len(lp.b))) + string(lp.b) + "\x01\x03xyz", "ab 5 xyz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseList(bufio.NewReader(strings.NewReader(tt.in)), tt.typ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHash(t *testing.T) {
+	// a ziplist of "a", "1", "b" and "xy"
+	zl := "\x17\x00\x00\x00\x13\x00\x00\x00\x04\x00" +
+		"\x00\x01a" + "\x03\xf2" + "\x02\x01b" + "\x03\x02xy" + "\xff"
+	lp, lpex := newListpack(), newListpack()
+	for _, s := range []string{"a", "1", "b", "xy"} {
+		lp.Append(s)
+	}
+	for _, s := range []string{"a", "1", "0", "b", "xy", "1700000000000"} {
+		lpex.Append(s)
+	}
+	// 1700000000000 in ms
+	minExpire := "\x00\x68\xe5\xcf\x8b\x01\x00\x00"
+
+	tests := []struct {
+		name    string
+		typ     FieldType
+		in      string
+		want    string
+		expires map[string]int64
+	}{
+		{"hash", FieldTypeHash, "\x02\x01a\x011\x01b\x02xy", "a 1 b xy", nil},
+		{"ziplist", FieldTypeHashZiplist, "\x17" + zl, "a 1 b xy", nil},
+		{"listpack", FieldTypeHashListpack, string(rune(len(lp.b))) + string(lp.b), "a 1 b xy", nil},
+		{"metadata", FieldTypeHashMetadata, minExpire + "\x02\x00\x01a\x011\x03\x01b\x02xy", "a 1 b xy", map[string]int64{"b": 1700000000002}},
+		{"listpackex", FieldTypeHashListpackEx, minExpire + string(rune(len(lpex.b))) + string(lpex.b), "a 1 b xy", map[string]int64{"b": 1700000000000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHash(bufio.NewReader(strings.NewReader(tt.in)), tt.typ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got.Pairs, " ") != tt.want {
+				t.Errorf("got %q want %q", got.Pairs, tt.want)
+			}
+			if len(got.Expires) != len(tt.expires) {
+				t.Fatalf("got expires %v want %v", got.Expires, tt.expires)
+			}
+			for field, at := range tt.expires {
+				if got.Expires[field] != at {
+					t.Errorf("got expires %v want %v", got.Expires, tt.expires)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSet(t *testing.T) {
+	is := newIntset()
+	for _, v := range []int64{5, -70000, 1} {
+		is.Add(v)
+	}
+	lp := newListpack()
+	for _, s := range []string{"a", "1"} {
+		lp.Append(s)
+	}
+
+	tests := []struct {
+		name string
+		typ  FieldType
+		in   string
+		want string
+	}{
+		{"set", FieldTypeSet, "\x02\x01a\x02bc", "a bc"},
+		{"intset", FieldTypeSetIntset, string(rune(len(is.b))) + string(is.b), "-70000 1 5"},
+		{"listpack", FieldTypeSetListpack, string(rune(len(lp.b))) + string(lp.b), "a 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSet(bufio.NewReader(strings.NewReader(tt.in)), tt.typ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseZSet(t *testing.T) {
+	lp := newListpack()
+	for _, s := range []string{"a", "1.5", "b", "2"} {
+		lp.Append(s)
+	}
+	// 1.5 and -2 as little endian doubles
+	binScores := "\x00\x00\x00\x00\x00\x00\xf8\x3f" + "\x00\x00\x00\x00\x00\x00\x00\xc0"
+
+	tests := []struct {
+		name string
+		typ  FieldType
+		in   string
+		want string
+	}{
+		{"zset", FieldTypeZSet, "\x02\x01a\x031.5\x01b\xfe", "a 1.5 b inf"},
+		{"zset2", FieldTypeZSet2, "\x02\x01a" + binScores[:8] + "\x01b" + binScores[8:], "a 1.5 b -2"},
+		{"listpack", FieldTypeZSetListpack, string(rune(len(lp.b))) + string(lp.b), "a 1.5 b 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseZSet(bufio.NewReader(strings.NewReader(tt.in)), tt.typ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.member, formatDouble(e.score))
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
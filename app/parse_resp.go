@@ -2,130 +2,517 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
-	"net"
+	"io"
 	"strconv"
-	"strings"
 )
 
 type Message struct {
-	cmd      string
-	args     []string
-	dataType string
-	raw      string
+	cmd  string
+	args []string
 }
 
-func ParseRESP(conn net.Conn) (Message, error) {
-	r := bufio.NewReader(conn)
-	b, err := r.ReadBytes('\n')
-	if err != nil {
-		return Message{}, err
+type RESPType byte
+
+const (
+	RESPSimpleString RESPType = '+'
+	RESPError        RESPType = '-'
+	RESPInteger      RESPType = ':'
+	RESPBulkString   RESPType = '$'
+	RESPArray        RESPType = '*'
+
+	// RESP3
+	RESPNull      RESPType = '_'
+	RESPDouble    RESPType = ','
+	RESPBoolean   RESPType = '#'
+	RESPBigNumber RESPType = '('
+	RESPBlobError RESPType = '!'
+	RESPVerbatim  RESPType = '='
+	RESPMap       RESPType = '%'
+	RESPSet       RESPType = '~'
+	RESPPush      RESPType = '>'
+)
+
+const (
+	maxBulkLength  = 512 * 1024 * 1024
+	maxArrayLength = 1024 * 1024 * 1024
+	maxInlineSize  = 64 * 1024 // also the limit for any header line
+	maxNesting     = 128       // nested aggregates in a frame
+)
+
+// ProtocolError is a malformed request. The client gets it as an error
+// reply and the connection is closed since the stream cannot be resynced.
+type ProtocolError struct {
+	msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+var errQueryBufferLimit = errors.New("query buffer limit reached")
+
+func protocolErrorf(format string, a ...any) error {
+	return &ProtocolError{msg: fmt.Sprintf(format, a...)}
+}
+
+// Value is a decoded RESP frame. Str holds the payload of simple strings,
+// errors, bulk strings, big numbers and verbatim strings, Int the payload
+// of integers and Array the elements of arrays, sets and pushes. Maps are
+// kept in Array as a flat list of key, value pairs. Null is set for the
+// $-1 and *-1 replies and for the RESP3 null.
+type Value struct {
+	Type   RESPType
+	Str    string
+	Int    int64
+	Double float64
+	Bool   bool
+	Array  []Value
+	Null   bool
+}
+
+// Strings returns the elements of an array made only of bulk strings.
+func (v Value) Strings() ([]string, error) {
+	if v.Type != RESPArray || v.Null {
+		return nil, fmt.Errorf("expected array got %q", v.Type)
 	}
 
-	if len(b) < 1 {
-		return Message{}, errors.New("empty line")
+	res := make([]string, len(v.Array))
+	for i, e := range v.Array {
+		if e.Type != RESPBulkString || e.Null {
+			return nil, fmt.Errorf("expected bulk string got %q", e.Type)
+		}
+		res[i] = e.Str
 	}
+	return res, nil
+}
+
+// RESPReader decodes RESP frames from a connection. It owns the buffer for
+// the whole life of the connection, so bytes that arrive after the current
+// frame (pipelined commands) are kept for the next call instead of being
+// dropped.
+type RESPReader struct {
+	r   *bufio.Reader
+	src *spillReader
+}
+
+func NewRESPReader(rd io.Reader) *RESPReader {
+	src := &spillReader{rd: rd}
+	return &RESPReader{r: bufio.NewReader(src), src: src}
+}
+
+// spillReader reads the bytes kept by ReadAhead before reading rd again.
+type spillReader struct {
+	spill []byte
+	rd    io.Reader
+}
 
-	// TODO(ga): refactor this
-	if b[0] == '+' {
-		return Message{
-			dataType: "SIMPLE_STRING",
-			raw:      string(b),
-		}, nil
+func (s *spillReader) Read(p []byte) (int, error) {
+	if len(s.spill) > 0 {
+		n := copy(p, s.spill)
+		s.spill = s.spill[n:]
+		return n, nil
 	}
+	return s.rd.Read(p)
+}
+
+// Buffered returns the number of bytes already read from the connection
+// but not consumed yet.
+func (rr *RESPReader) Buffered() int {
+	return rr.r.Buffered() + len(rr.src.spill)
+}
 
-	if b[0] != '*' {
-		fmt.Printf("%s", b)
-		return Message{}, errors.New("not impl first command not array")
+// ReadAhead reads from the connection until it fails, keeping the bytes
+// for the next frames, whatever is buffered already. It fails too past
+// limit bytes kept.
+func (rr *RESPReader) ReadAhead(limit int) error {
+	var buf [4096]byte
+	for {
+		n, err := rr.src.rd.Read(buf[:])
+		rr.src.spill = append(rr.src.spill, buf[:n]...)
+		if err != nil {
+			return err
+		}
+		if len(rr.src.spill) > limit {
+			return errQueryBufferLimit
+		}
 	}
+}
 
-	readUntilCRLF := func(r *bufio.Reader) ([]byte, error) {
-		b, err := r.ReadBytes('\n')
+// ReadMessage reads a command sent by a client. It is either an array of
+// bulk strings where the first element is the command name, or an inline
+// command typed in telnet/nc: a single line of space separated arguments.
+// Empty arrays and empty inline lines are skipped.
+func (rr *RESPReader) ReadMessage() (Message, error) {
+	for {
+		b, err := rr.r.Peek(1)
 		if err != nil {
-			return b, err
+			return Message{}, err
 		}
 
-		if len(b) < 1 {
-			return b, errors.New("empty line")
+		var parts []string
+		if b[0] == byte(RESPArray) {
+			parts, err = rr.readMultiBulk()
+		} else {
+			parts, err = rr.readInline()
+		}
+		if err != nil {
+			return Message{}, err
 		}
 
-		if !strings.HasSuffix(string(b), "\r\n") {
-			return b, errors.New("not ended with CRLF")
+		if len(parts) > 0 {
+			return Message{cmd: parts[0], args: parts[1:]}, nil
 		}
+	}
+}
 
-		length := len(b)
-		return b[:length-2], nil
+// readMultiBulk reads a command sent as an array, whose elements can only be
+// bulk strings like Redis expects. A null or empty array is no command.
+func (rr *RESPReader) readMultiBulk() ([]string, error) {
+	line, err := readUntilCRLF(rr.r)
+	if err != nil {
+		return nil, err
+	}
+	length, err := parseLength(string(line[1:]), maxArrayLength)
+	if err != nil {
+		return nil, protocolErrorf("invalid multibulk length")
+	}
+	if length <= 0 {
+		return nil, nil
 	}
 
-	lengthBytes := b[1:]
-	lengthStr := string(lengthBytes[:len(lengthBytes)-2]) // remove the CRLF
-	length, err := strconv.Atoi(lengthStr)
+	// the header is untrusted, grow as elements actually arrive
+	capacity := length
+	if capacity > 1024 {
+		capacity = 1024
+	}
+	parts := make([]string, 0, capacity)
+	for i := 0; i < length; i++ {
+		line, err := readUntilCRLF(rr.r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != byte(RESPBulkString) {
+			return nil, protocolErrorf("expected '$', got %q", line)
+		}
+		n, err := parseLength(string(line[1:]), maxBulkLength)
+		if err != nil || n < 0 {
+			return nil, protocolErrorf("invalid bulk length")
+		}
+		part, err := readBulkString(rr.r, n)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+func (rr *RESPReader) readInline() ([]string, error) {
+	line, err := readLine(rr.r)
 	if err != nil {
-		return Message{}, fmt.Errorf("invalid ararys length: %w", err)
+		var perr *ProtocolError
+		if errors.As(err, &perr) {
+			return nil, protocolErrorf("too big inline request")
+		}
+		return nil, err
 	}
 
-	if length < 1 {
-		return Message{}, errors.New("empty command")
+	// telnet sends CRLF but nc may send a bare LF
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+
+	parts, err := splitArgs(string(line))
+	if err != nil {
+		return nil, protocolErrorf("unbalanced quotes in request")
 	}
+	return parts, nil
+}
 
-	msg := Message{
-		args: make([]string, length-1),
+// ReadValue reads the next frame of any type, arrays are decoded
+// recursively up to maxNesting levels.
+func (rr *RESPReader) ReadValue() (Value, error) {
+	return rr.readValue(0)
+}
+
+func (rr *RESPReader) readValue(depth int) (Value, error) {
+	line, err := readUntilCRLF(rr.r)
+	if err != nil {
+		return Value{}, err
 	}
 
-	for i := 0; i < length; i++ {
-		var data string
-		b, err := r.ReadByte()
+	if len(line) < 1 {
+		return Value{}, protocolErrorf("empty line")
+	}
+
+	v := Value{Type: RESPType(line[0])}
+	payload := string(line[1:])
+
+	switch v.Type {
+	case RESPSimpleString, RESPError, RESPBigNumber:
+		v.Str = payload
+	case RESPInteger:
+		v.Int, err = strconv.ParseInt(payload, 10, 64)
 		if err != nil {
-			return Message{}, err
+			return Value{}, protocolErrorf("invalid integer %q", payload)
+		}
+	case RESPNull:
+		v.Null = true
+	case RESPDouble:
+		v.Double, err = strconv.ParseFloat(payload, 64)
+		if err != nil {
+			return Value{}, protocolErrorf("invalid double %q", payload)
+		}
+	case RESPBoolean:
+		switch payload {
+		case "t":
+			v.Bool = true
+		case "f":
+		default:
+			return Value{}, protocolErrorf("invalid boolean %q", payload)
+		}
+	case RESPBulkString, RESPBlobError, RESPVerbatim:
+		length, err := parseLength(payload, maxBulkLength)
+		if err != nil {
+			return Value{}, protocolErrorf("invalid bulk length")
+		}
+		if length < 0 {
+			v.Null = true
+			break
 		}
 
-		switch b {
-		case '$': // bulk string
-			lengthByte, err := readUntilCRLF(r)
-			if err != nil {
-				return Message{}, fmt.Errorf("failed reading bulk string length: %w", err)
-			}
-			length, err := strconv.Atoi(string(lengthByte))
-			if err != nil {
-				return Message{}, fmt.Errorf("invalid bulk string length: %w", err)
+		v.Str, err = readBulkString(rr.r, length)
+		if err != nil {
+			return Value{}, err
+		}
+		if v.Type == RESPVerbatim {
+			// drop the "txt:" format prefix
+			if len(v.Str) < 4 || v.Str[3] != ':' {
+				return Value{}, protocolErrorf("invalid verbatim string %q", v.Str)
 			}
+			v.Str = v.Str[4:]
+		}
+	case RESPArray, RESPMap, RESPSet, RESPPush:
+		length, err := parseLength(payload, maxArrayLength)
+		if err != nil {
+			return Value{}, protocolErrorf("invalid multibulk length")
+		}
+		if length < 0 {
+			v.Null = true
+			break
+		}
+		if v.Type == RESPMap {
+			length *= 2
+		}
+		if depth == maxNesting {
+			return Value{}, protocolErrorf("too deeply nested multibulk")
+		}
 
-			data, err = readBulkString(r, length)
+		// the header is untrusted, grow as elements actually arrive
+		capacity := length
+		if capacity > 1024 {
+			capacity = 1024
+		}
+		v.Array = make([]Value, 0, capacity)
+		for i := 0; i < length; i++ {
+			e, err := rr.readValue(depth + 1)
 			if err != nil {
-				return Message{}, fmt.Errorf("failed to read bulkstring: %w", err)
+				return Value{}, err
 			}
+			v.Array = append(v.Array, e)
 		}
+	default:
+		return Value{}, protocolErrorf("unknown type %q", line[0])
+	}
+
+	return v, nil
+}
+
+// ReadRDBPayload reads the snapshot a master sends after +FULLRESYNC. It is
+// framed like a bulk string but without the trailing CRLF.
+func (rr *RESPReader) ReadRDBPayload() ([]byte, error) {
+	line, err := readUntilCRLF(rr.r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 1 || line[0] != '$' {
+		return nil, fmt.Errorf("expected rdb payload got %q", line)
+	}
+
+	length, err := parseLength(string(line[1:]), maxBulkLength)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid rdb payload length %q", line[1:])
+	}
 
-		if i == 0 {
-			msg.cmd = data
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(rr.r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// parseLength parses the length header of bulk strings and arrays, -1 is
+// the only negative value allowed and stands for null.
+func parseLength(s string, max int) (int, error) {
+	length, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if length < -1 || length > max {
+		return 0, fmt.Errorf("length %d out of range", length)
+	}
+	return length, nil
+}
+
+// readLine reads up to and including the next LF. Lines are capped to
+// maxInlineSize so a peer that never sends a newline cannot grow the
+// buffer forever.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxInlineSize {
+			return nil, protocolErrorf("line too long")
+		}
+		line = append(line, chunk...)
+
+		if err == bufio.ErrBufferFull {
 			continue
 		}
+		if err != nil {
+			return nil, err
+		}
+		return line, nil
+	}
+}
+
+func readUntilCRLF(r *bufio.Reader) ([]byte, error) {
+	b, err := readLine(r)
+	if err != nil {
+		return b, err
+	}
 
-		msg.args[i-1] = data
+	if !bytes.HasSuffix(b, []byte("\r\n")) {
+		return b, protocolErrorf("not ended with CRLF")
 	}
 
-	return msg, nil
+	length := len(b)
+	return b[:length-2], nil
 }
 
 func readBulkString(r *bufio.Reader, length int) (string, error) {
-	buf := make([]byte, length)
-	if _, err := r.Read(buf); err != nil {
+	// io.ReadFull keeps reading when the frame is split across several
+	// TCP segments, a single Read may return only part of it.
+	buf := make([]byte, length+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return "", err
 	}
 
-	if _, err := r.Read(make([]byte, 2)); err != nil {
-		return "", err
+	if buf[length] != '\r' || buf[length+1] != '\n' {
+		return "", protocolErrorf("bulk string not ended with CRLF")
 	}
 
-	return string(buf), nil
+	return string(buf[:length]), nil
 }
 
-func makeArrayBulkString(s []string) string {
-	var result strings.Builder
-	result.WriteString(fmt.Sprintf("*%d\r\n", len(s)))
-	for _, v := range s {
-		result.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(v), v))
+// splitArgs splits an inline command the way redis-cli does. Arguments are
+// separated by spaces and may be quoted: "double quotes" understand the
+// \n \r \t \b \a \\ \" and \xHH escapes, 'single quotes' only \'. A closing
+// quote must be followed by a space or the end of the line.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var cur []byte
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			if i >= len(line) {
+				if inDouble || inSingle {
+					return nil, errors.New("unbalanced quotes")
+				}
+				break
+			}
+
+			ch := line[i]
+			switch {
+			case inDouble:
+				if ch == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					n, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					cur = append(cur, byte(n))
+					i += 3
+				} else if ch == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						cur = append(cur, '\n')
+					case 'r':
+						cur = append(cur, '\r')
+					case 't':
+						cur = append(cur, '\t')
+					case 'b':
+						cur = append(cur, '\b')
+					case 'a':
+						cur = append(cur, '\a')
+					default:
+						cur = append(cur, line[i])
+					}
+				} else if ch == '"' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errors.New("closing quote must be followed by a space")
+					}
+					done = true
+				} else {
+					cur = append(cur, ch)
+				}
+			case inSingle:
+				if ch == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					cur = append(cur, '\'')
+					i++
+				} else if ch == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errors.New("closing quote must be followed by a space")
+					}
+					done = true
+				} else {
+					cur = append(cur, ch)
+				}
+			default:
+				switch {
+				case isSpace(ch):
+					done = true
+				case ch == '"':
+					inDouble = true
+				case ch == '\'':
+					inSingle = true
+				default:
+					cur = append(cur, ch)
+				}
+			}
+			i++
+		}
+
+		args = append(args, string(cur))
 	}
-	return result.String()
+}
+
+func isSpace(b byte) bool {
+	switch b {
+	case ' ', '\n', '\r', '\t', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+func isHexDigit(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
 }
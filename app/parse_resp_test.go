@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadMessagePipelined(t *testing.T) {
+	input := makeArrayBulkString([]string{"set", "foo", "bar"}) +
+		makeArrayBulkString([]string{"get", "foo"})
+
+	// OneByteReader forces every frame to be assembled from partial reads
+	rr := NewRESPReader(iotest.OneByteReader(strings.NewReader(input)))
+
+	tests := []Message{
+		{cmd: "set", args: []string{"foo", "bar"}},
+		{cmd: "get", args: []string{"foo"}},
+	}
+	for _, tt := range tests {
+		m, err := rr.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.cmd != tt.cmd || strings.Join(m.args, " ") != strings.Join(tt.args, " ") {
+			t.Fatalf("expected %+v got %+v", tt, m)
+		}
+	}
+}
+
+func TestReadValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		expect Value
+	}{
+		{
+			name:   "simple_string",
+			input:  "+OK\r\n",
+			expect: Value{Type: RESPSimpleString, Str: "OK"},
+		},
+		{
+			name:   "error",
+			input:  "-ERR unknown command\r\n",
+			expect: Value{Type: RESPError, Str: "ERR unknown command"},
+		},
+		{
+			name:   "integer",
+			input:  ":-42\r\n",
+			expect: Value{Type: RESPInteger, Int: -42},
+		},
+		{
+			name:   "bulk_string",
+			input:  "$8\r\nfoo\r\nbar\r\n",
+			expect: Value{Type: RESPBulkString, Str: "foo\r\nbar"},
+		},
+		{
+			name:   "null_bulk_string",
+			input:  "$-1\r\n",
+			expect: Value{Type: RESPBulkString, Null: true},
+		},
+		{
+			name:   "null_array",
+			input:  "*-1\r\n",
+			expect: Value{Type: RESPArray, Null: true},
+		},
+		{
+			name:  "nested_array",
+			input: "*3\r\n:1\r\n*2\r\n+a\r\n$-1\r\n*0\r\n",
+			expect: Value{Type: RESPArray, Array: []Value{
+				{Type: RESPInteger, Int: 1},
+				{Type: RESPArray, Array: []Value{
+					{Type: RESPSimpleString, Str: "a"},
+					{Type: RESPBulkString, Null: true},
+				}},
+				{Type: RESPArray, Array: []Value{}},
+			}},
+		},
+		{
+			name:  "resp3_map",
+			input: "%2\r\n+proto\r\n:3\r\n$4\r\nnull\r\n_\r\n",
+			expect: Value{Type: RESPMap, Array: []Value{
+				{Type: RESPSimpleString, Str: "proto"},
+				{Type: RESPInteger, Int: 3},
+				{Type: RESPBulkString, Str: "null"},
+				{Type: RESPNull, Null: true},
+			}},
+		},
+		{
+			name:   "resp3_double",
+			input:  ",-1.5\r\n",
+			expect: Value{Type: RESPDouble, Double: -1.5},
+		},
+		{
+			name:   "resp3_boolean",
+			input:  "#t\r\n",
+			expect: Value{Type: RESPBoolean, Bool: true},
+		},
+		{
+			name:   "resp3_verbatim",
+			input:  "=8\r\ntxt:info\r\n",
+			expect: Value{Type: RESPVerbatim, Str: "info"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := NewRESPReader(strings.NewReader(tt.input))
+			got, err := rr.ReadValue()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.expect) {
+				t.Fatalf("expected %+v got %+v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestReadValueNesting(t *testing.T) {
+	rr := NewRESPReader(strings.NewReader(strings.Repeat("*1\r\n", maxNesting) + ":1\r\n"))
+	if _, err := rr.ReadValue(); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = NewRESPReader(strings.NewReader(strings.Repeat("*1\r\n", 1000000)))
+	var perr *ProtocolError
+	if _, err := rr.ReadValue(); !errors.As(err, &perr) {
+		t.Fatalf("expected a protocol error got %v", err)
+	}
+}
+
+func TestReadRDBPayload(t *testing.T) {
+	rr := NewRESPReader(strings.NewReader("+FULLRESYNC abc 0\r\n$5\r\nREDIS+OK\r\n"))
+	if _, err := rr.ReadValue(); err != nil {
+		t.Fatal(err)
+	}
+
+	rdb, err := rr.ReadRDBPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rdb) != "REDIS" {
+		t.Fatalf("expected %q got %q", "REDIS", rdb)
+	}
+
+	// the frame following the payload is still readable
+	v, err := rr.ReadValue()
+	if err != nil || v.Str != "OK" {
+		t.Fatalf("expected OK got %+v %v", v, err)
+	}
+}
+
+func TestReadMessageInline(t *testing.T) {
+	input := "PING\r\n\r\nset \"hello world\" 'it\\'s'\nget \"\\x41\\tb\"\r\n"
+	rr := NewRESPReader(strings.NewReader(input))
+
+	tests := []Message{
+		{cmd: "PING", args: []string{}},
+		{cmd: "set", args: []string{"hello world", "it's"}},
+		{cmd: "get", args: []string{"A\tb"}},
+	}
+	for _, tt := range tests {
+		m, err := rr.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, tt) {
+			t.Fatalf("expected %+v got %+v", tt, m)
+		}
+	}
+}
+
+func TestReadMessageProtocolError(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		expect string
+	}{
+		{
+			name:   "unbalanced_quotes",
+			input:  "set \"foo bar\r\n",
+			expect: "Protocol error: unbalanced quotes in request",
+		},
+		{
+			name:   "too_big_inline",
+			input:  strings.Repeat("a", maxInlineSize+1),
+			expect: "Protocol error: too big inline request",
+		},
+		{
+			name:   "invalid_multibulk_length",
+			input:  "*x\r\n",
+			expect: "Protocol error: invalid multibulk length",
+		},
+		{
+			name:   "nested_array",
+			input:  "*1\r\n*1\r\n$4\r\nPING\r\n",
+			expect: "Protocol error: expected '$', got \"*1\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := NewRESPReader(strings.NewReader(tt.input))
+			_, err := rr.ReadMessage()
+
+			var perr *ProtocolError
+			if !errors.As(err, &perr) || err.Error() != tt.expect {
+				t.Fatalf("expected %q got %v", tt.expect, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+// quicklist is a doubly linked list of listpacks, the representation of
+// lists. Packing the elements in nodes of a few kilobytes keeps the memory
+// overhead of the links low while pushes and pops at both ends stay cheap.
+//
+// fill is list-max-listpack-size: a positive fill is the maximum number of
+// elements of a node, a negative one a maximum size, -1 for 4KB up to -5
+// for 64KB.
+type quicklist struct {
+	head, tail *quicklistNode
+	count      int // elements in all the nodes
+	nodes      int
+	fill       int
+}
+
+type quicklistNode struct {
+	prev, next *quicklistNode
+	lp         *listpack
+}
+
+func newQuicklist(fill int) *quicklist {
+	return &quicklist{fill: fill}
+}
+
+func (ql *quicklist) Len() int {
+	return ql.count
+}
+
+// sizeLimit returns the maximum size of a node for a negative fill.
+func (ql *quicklist) sizeLimit() int {
+	level := -ql.fill
+	if level > 5 {
+		level = 5
+	}
+	return 4096 << (level - 1)
+}
+
+// allowInsert reports whether an entry of size bytes fits in node.
+func (ql *quicklist) allowInsert(node *quicklistNode, size int) bool {
+	if node == nil {
+		return false
+	}
+	if ql.fill >= 0 {
+		return node.lp.Len() < ql.fill
+	}
+	return node.lp.Bytes()+size <= ql.sizeLimit()
+}
+
+// exceedsLimit reports whether node holds more than the fill allows.
+func (ql *quicklist) exceedsLimit(node *quicklistNode) bool {
+	if ql.fill >= 0 {
+		return node.lp.Len() > ql.fill
+	}
+	return node.lp.Bytes() > ql.sizeLimit()
+}
+
+// fitsHalfLimit reports whether the list is a single node using at most
+// half of what the fill allows. Lists are reported as listpack encoded
+// then, the margin avoids flapping between encodings.
+func (ql *quicklist) fitsHalfLimit() bool {
+	if ql.nodes > 1 {
+		return false
+	}
+	if ql.nodes == 0 {
+		return true
+	}
+	if ql.fill >= 0 {
+		return ql.head.lp.Len() <= ql.fill/2
+	}
+	return ql.head.lp.Bytes() <= ql.sizeLimit()/2
+}
+
+// linkAfter inserts node after prev, at the head when prev is nil.
+func (ql *quicklist) linkAfter(prev, node *quicklistNode) {
+	node.prev = prev
+	if prev == nil {
+		node.next = ql.head
+		ql.head = node
+	} else {
+		node.next = prev.next
+		prev.next = node
+	}
+	if node.next != nil {
+		node.next.prev = node
+	} else {
+		ql.tail = node
+	}
+	ql.nodes++
+}
+
+func (ql *quicklist) unlink(node *quicklistNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		ql.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		ql.tail = node.prev
+	}
+	ql.nodes--
+}
+
+func (ql *quicklist) PushHead(s string) {
+	if !ql.allowInsert(ql.head, len(s)+11) {
+		ql.linkAfter(nil, &quicklistNode{lp: newListpack()})
+	}
+	ql.head.lp.Prepend(s)
+	ql.count++
+}
+
+func (ql *quicklist) PushTail(s string) {
+	if !ql.allowInsert(ql.tail, len(s)+11) {
+		ql.linkAfter(ql.tail, &quicklistNode{lp: newListpack()})
+	}
+	ql.tail.lp.Append(s)
+	ql.count++
+}
+
+// appendListpack links lp as the new tail node, as loaded from an RDB.
+func (ql *quicklist) appendListpack(lp *listpack) {
+	if lp.Len() == 0 {
+		return
+	}
+	ql.linkAfter(ql.tail, &quicklistNode{lp: lp})
+	ql.count += lp.Len()
+}
+
+func (ql *quicklist) PopHead() (string, bool) {
+	if ql.count == 0 {
+		return "", false
+	}
+	p := ql.head.lp.First()
+	s := ql.head.lp.Get(p)
+	ql.deleteEntry(ql.head, p)
+	return s, true
+}
+
+func (ql *quicklist) PopTail() (string, bool) {
+	if ql.count == 0 {
+		return "", false
+	}
+	p := ql.tail.lp.Last()
+	s := ql.tail.lp.Get(p)
+	ql.deleteEntry(ql.tail, p)
+	return s, true
+}
+
+// deleteEntry removes the entry at p of node, and node with it if it gets
+// empty. It returns the offset of the entry that followed in node.
+func (ql *quicklist) deleteEntry(node *quicklistNode, p int) int {
+	next := node.lp.Delete(p)
+	ql.count--
+	if node.lp.Len() == 0 {
+		ql.unlink(node)
+	}
+	return next
+}
+
+// lookup returns the node and the offset of the element at index,
+// negative indexes counting from the tail.
+func (ql *quicklist) lookup(index int) (*quicklistNode, int, bool) {
+	if index < 0 {
+		index += ql.count
+	}
+	if index < 0 || index >= ql.count {
+		return nil, -1, false
+	}
+
+	if index < ql.count/2 {
+		for node := ql.head; node != nil; node = node.next {
+			n := node.lp.Len()
+			if index < n {
+				return node, node.lp.Seek(index), true
+			}
+			index -= n
+		}
+	} else {
+		index = ql.count - 1 - index
+		for node := ql.tail; node != nil; node = node.prev {
+			n := node.lp.Len()
+			if index < n {
+				return node, node.lp.Seek(n - 1 - index), true
+			}
+			index -= n
+		}
+	}
+	return nil, -1, false
+}
+
+func (ql *quicklist) Index(index int) (string, bool) {
+	node, p, ok := ql.lookup(index)
+	if !ok {
+		return "", false
+	}
+	return node.lp.Get(p), true
+}
+
+// Replace sets the element at index and reports whether it exists.
+func (ql *quicklist) Replace(index int, s string) bool {
+	node, p, ok := ql.lookup(index)
+	if !ok {
+		return false
+	}
+	node.lp.Replace(p, s)
+	ql.splitIfNeeded(node)
+	return true
+}
+
+// insert adds s before or after the entry at p of node.
+func (ql *quicklist) insert(node *quicklistNode, p int, s string, after bool) {
+	if after {
+		p = node.lp.Next(p)
+	}
+	node.lp.Insert(p, s)
+	ql.count++
+	ql.splitIfNeeded(node)
+}
+
+// splitIfNeeded moves the second half of node to a new node as long as
+// node is over the fill.
+func (ql *quicklist) splitIfNeeded(node *quicklistNode) {
+	for ql.exceedsLimit(node) && node.lp.Len() > 1 {
+		half := node.lp.Len() / 2
+		next := &quicklistNode{lp: newListpack()}
+		p := node.lp.Seek(half)
+		for p != -1 {
+			next.lp.Append(node.lp.Get(p))
+			p = node.lp.Delete(p)
+		}
+		ql.linkAfter(node, next)
+
+		if ql.exceedsLimit(next) {
+			ql.splitIfNeeded(next)
+		}
+	}
+}
+
+// DeleteRange removes n elements from start on.
+func (ql *quicklist) DeleteRange(start, n int) {
+	it := ql.iterator(start, false)
+	for ; n > 0; n-- {
+		if _, ok := it.Next(); !ok {
+			return
+		}
+		it.Delete()
+	}
+}
+
+// quicklistIter walks the elements from head to tail or from tail to head.
+// Delete removes the element returned last without breaking the walk.
+type quicklistIter struct {
+	ql      *quicklist
+	reverse bool
+
+	// position of the element Next returns
+	node *quicklistNode
+	p    int
+
+	// position of the element Next returned
+	lastNode *quicklistNode
+	lastP    int
+}
+
+// iterator returns an iterator starting at index, negative indexes
+// counting from the tail.
+func (ql *quicklist) iterator(index int, reverse bool) *quicklistIter {
+	node, p, _ := ql.lookup(index)
+	return &quicklistIter{ql: ql, reverse: reverse, node: node, p: p}
+}
+
+func (it *quicklistIter) Next() (string, bool) {
+	if it.node == nil {
+		return "", false
+	}
+
+	s := it.node.lp.Get(it.p)
+	it.lastNode, it.lastP = it.node, it.p
+
+	if it.reverse {
+		it.p = it.node.lp.Prev(it.p)
+		if it.p == -1 {
+			it.node = it.node.prev
+			if it.node != nil {
+				it.p = it.node.lp.Last()
+			}
+		}
+	} else {
+		it.p = it.node.lp.Next(it.p)
+		if it.p == -1 {
+			it.node = it.node.next
+			if it.node != nil {
+				it.p = it.node.lp.First()
+			}
+		}
+	}
+	return s, true
+}
+
+func (it *quicklistIter) Delete() {
+	it.ql.deleteEntry(it.lastNode, it.lastP)
+
+	// walking forward in the same node, the next entry moved to where the
+	// deleted one was. Walking backward it did not move.
+	if !it.reverse && it.node == it.lastNode {
+		it.p = it.lastP
+	}
+}
+
+// InsertBefore and InsertAfter add s next to the element Next returned
+// last. The iterator can not be used afterwards.
+func (it *quicklistIter) InsertBefore(s string) {
+	it.ql.insert(it.lastNode, it.lastP, s, false)
+}
+
+func (it *quicklistIter) InsertAfter(s string) {
+	it.ql.insert(it.lastNode, it.lastP, s, true)
+}
+
+// Nodes returns the listpack of every node, head first.
+func (ql *quicklist) Nodes() []*listpack {
+	res := make([]*listpack, 0, ql.nodes)
+	for node := ql.head; node != nil; node = node.next {
+		res = append(res, node.lp)
+	}
+	return res
+}
+
+// dup returns a deep copy of ql.
+func (ql *quicklist) dup() *quicklist {
+	res := newQuicklist(ql.fill)
+	for node := ql.head; node != nil; node = node.next {
+		b := make([]byte, len(node.lp.b))
+		copy(b, node.lp.b)
+		res.appendListpack(&listpack{b: b})
+	}
+	return res
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestQuicklist(t *testing.T) {
+	ql := newQuicklist(4)
+	for i := 0; i < 10; i++ {
+		ql.PushTail(strconv.Itoa(i))
+	}
+	ql.PushHead("-1")
+
+	if ql.Len() != 11 || ql.nodes != 4 {
+		t.Fatalf("expected 11 elements in 4 nodes got %d in %d", ql.Len(), ql.nodes)
+	}
+	for i := -1; i < 10; i++ {
+		if s, _ := ql.Index(i + 1); s != strconv.Itoa(i) {
+			t.Fatalf("index %d: expected %d got %s", i+1, i, s)
+		}
+	}
+	if s, _ := ql.Index(-2); s != "8" {
+		t.Fatalf("index -2: expected 8 got %s", s)
+	}
+
+	// remove the even elements while walking
+	it := ql.iterator(0, false)
+	for {
+		s, ok := it.Next()
+		if !ok {
+			break
+		}
+		if n, _ := strconv.Atoi(s); n%2 == 0 {
+			it.Delete()
+		}
+	}
+	if got := collect(ql); got != "-1 1 3 5 7 9" {
+		t.Fatalf("unexpected elements %q", got)
+	}
+
+	it = ql.iterator(2, false)
+	it.Next()
+	it.InsertAfter("x")
+	ql.DeleteRange(0, 2)
+	if got := collect(ql); got != "3 x 5 7 9" {
+		t.Fatalf("unexpected elements %q", got)
+	}
+
+	for ql.Len() > 0 {
+		ql.PopTail()
+	}
+	if ql.nodes != 0 || ql.head != nil || ql.tail != nil {
+		t.Fatal("expected empty nodes to be unlinked")
+	}
+}
+
+func collect(ql *quicklist) string {
+	var s string
+	it := ql.iterator(0, false)
+	for {
+		e, ok := it.Next()
+		if !ok {
+			return s
+		}
+		if s != "" {
+			s += " "
+		}
+		s += e
+	}
+}
@@ -2,19 +2,24 @@ package main
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
+	"sync/atomic"
 )
 
 type Server struct {
+	clientID    int64 // last id given to a client, use atomic
+	commands    map[string]*Command
 	config      map[string]string
-	data        map[string]string
+	dbs         []*DB
+	jobs        chan func() // work for the event loop
 	opt         ServerOpt
 	rdb         RDB
 	replication replicationInfo
@@ -22,10 +27,12 @@ type Server struct {
 }
 
 type ServerOpt struct {
-	dbfilename string
-	dir        string
-	port       string
-	replicaOf  string
+	dbfilename  string
+	dir         string
+	port        string
+	replicaOf   string
+	requirePass string
+	databases   string
 }
 
 type replicationInfo struct {
@@ -41,14 +48,17 @@ const (
 
 func startServer(opt ServerOpt) {
 	srv := &Server{
-		data:   make(map[string]string),
-		config: make(map[string]string),
-		opt:    opt,
+		commands: newCommandTable(),
+		jobs:     make(chan func(), jobQueueSize),
+		config:   make(map[string]string),
+		opt:      opt,
 	}
 
 	srv.setupConfig()
+	srv.setupDatabases()
 	srv.loadRDB()
 	srv.setReplicationInfo()
+	go srv.eventLoop()
 	if srv.replication.role == REPLICATION_ROLE_SLAVE {
 		go srv.setupSlave()
 	}
@@ -74,11 +84,36 @@ func startServer(opt ServerOpt) {
 	}
 }
 
+// configInt returns the integer value of the config parameter name, def
+// when it is not set or not an integer.
+func (srv *Server) configInt(name string, def int) int {
+	n, err := strconv.Atoi(srv.config[name])
+	if err != nil {
+		return def
+	}
+	return n
+}
+
 func (srv *Server) setupConfig() {
 	srv.config["dir"] = srv.opt.dir
 	srv.config["dbfilename"] = srv.opt.dbfilename
 	srv.config["port"] = srv.opt.port
 	srv.config["replicaOf"] = srv.opt.replicaOf
+	srv.config["requirepass"] = srv.opt.requirePass
+	srv.config["databases"] = srv.opt.databases
+	if srv.config["databases"] == "" {
+		srv.config["databases"] = "16"
+	}
+	srv.config["list-max-listpack-size"] = strconv.Itoa(defaultListMaxListpackSize)
+	srv.config["hash-max-listpack-entries"] = strconv.Itoa(defaultHashMaxListpackEntries)
+	srv.config["hash-max-listpack-value"] = strconv.Itoa(defaultHashMaxListpackValue)
+	srv.config["set-max-intset-entries"] = strconv.Itoa(defaultSetMaxIntsetEntries)
+	srv.config["set-max-listpack-entries"] = strconv.Itoa(defaultSetMaxListpackEntries)
+	srv.config["set-max-listpack-value"] = strconv.Itoa(defaultSetMaxListpackValue)
+	srv.config["zset-max-listpack-entries"] = strconv.Itoa(defaultZsetMaxListpackEntries)
+	srv.config["zset-max-listpack-value"] = strconv.Itoa(defaultZsetMaxListpackValue)
+	srv.config["stream-node-max-bytes"] = strconv.Itoa(defaultStreamNodeMaxBytes)
+	srv.config["stream-node-max-entries"] = strconv.Itoa(defaultStreamNodeMaxEntries)
 
 	log.Printf("setupConfig: %+v\n", srv.config)
 }
@@ -86,7 +121,9 @@ func (srv *Server) setupConfig() {
 func (srv *Server) loadRDB() {
 	// log.Printf("loadRDB: %+v\n", srv.rdb.Databases)
 
-	if srv.config["dir"] == "" || srv.config["dbfilename"] == "" {
+	path := filepath.Join(srv.config["dir"], srv.config["dbfilename"])
+	_, err := os.Stat(path)
+	if srv.config["dir"] == "" || srv.config["dbfilename"] == "" || err != nil {
 		var db Database
 		db.ID = 0
 		db.Fields = map[string]Field{}
@@ -94,29 +131,29 @@ func (srv *Server) loadRDB() {
 		srv.rdb.Databases = append(srv.rdb.Databases, db)
 		return
 	}
-
-	path := filepath.Join(srv.config["dir"], srv.config["dbfilename"])
-	_, err := os.Stat(path)
-	if err != nil {
-		return
-	}
 	srv.rdb = ParseRDB(path)
-	for _, f := range srv.rdb.Databases[0].Fields {
-		if f.ExpiredTime != 0 {
-			expTime := time.UnixMilli(int64(f.ExpiredTime))
-			if time.Now().After(expTime) {
+	now := mstime()
+	for _, rdbDB := range srv.rdb.Databases {
+		if rdbDB.ID >= len(srv.dbs) {
+			log.Fatalf("loadRDB: %s has a database %d, the server is configured for %d databases\n", path, rdbDB.ID, len(srv.dbs))
+		}
+
+		db := srv.dbs[rdbDB.ID]
+		for _, f := range rdbDB.Fields {
+			if f.ExpiredTime != 0 && int64(f.ExpiredTime) <= now {
 				continue
 			}
 
-			duration := time.Until(expTime)
-			go func() {
-				time.AfterFunc(duration, func() {
-					delete(srv.data, f.Key)
-				})
-			}()
+			obj, err := srv.newObjectFromField(f)
+			if err != nil {
+				log.Fatalf("loadRDB: %s: key %q: %v\n", path, f.Key, err)
+			}
+			if obj == nil {
+				continue
+			}
+			obj.ExpireAt = int64(f.ExpiredTime)
+			db.add(f.Key, obj)
 		}
-
-		srv.data[f.Key] = f.Value.(string)
 	}
 }
 
@@ -131,118 +168,208 @@ func (srv *Server) setReplicationInfo() {
 }
 
 func (srv *Server) HandleCon(conn net.Conn) {
+	defer conn.Close()
+
+	c := newClient(conn)
+	c.db = srv.dbs[0]
+	c.id = atomic.AddInt64(&srv.clientID, 1)
+	c.authenticated = srv.config["requirepass"] == ""
 	for {
-		m, err := ParseRESP(conn)
+		m, err := c.reader.ReadMessage()
 		if err != nil {
+			var perr *ProtocolError
+			if errors.As(err, &perr) {
+				c.writer.WriteError("ERR " + perr.Error())
+				c.writer.Flush()
+			}
 			break
 		}
 
-		log.Printf("incoming message: %+v\n", m)
+		var blocked bool
+		srv.execute(func() {
+			srv.RunMessage(c, m)
+			blocked = c.blocked != nil
+		})
+		if blocked && !srv.waitUnblocked(c) {
+			break
+		}
 
-		err = srv.RunMessage(conn, m)
-		if err != nil {
-			log.Fatalln(err)
+		if err := c.Flush(); err != nil {
 			break
 		}
 	}
 }
 
-func (srv *Server) RunMessage(conn net.Conn, m Message) error {
-	var resp string
-	switch m.cmd {
-	case "ping", "PING":
-		resp = "+PONG\r\n"
-	case "echo":
-		resp = fmt.Sprintf("+%v\r\n", m.args[0])
-	case "set":
-		resp = srv.onSet(m.args)
-	case "get":
-		resp = srv.onGet(m.args)
-	case "config":
-		resp = srv.onConfig(m.args)
-	case "keys":
-		resp = srv.onKeys(m.args)
-	case "info":
-		resp = srv.onInfo(m.args)
-	case "replconf", "REPLCONF":
-		resp = srv.onReplConf(m.args)
-		// fmt.Printf("LocalAddr: %v\n", conn.LocalAddr().String())
-		// fmt.Printf("RemoteAddr: %v\n", conn.RemoteAddr().String())
-		// resp = srv.onReplConf(m.args, conn.LocalAddr().String())
-	case "PSYNC":
-		resp = srv.onPsync(m.args)
-	default:
-		return fmt.Errorf("unknown command")
+// RunMessage executes a command and queues its reply. Errors returned by
+// handlers are replies for the client, the connection stays open. Clients
+// blocked on keys the command created are served afterwards.
+func (srv *Server) RunMessage(c *Client, m Message) {
+	if err := srv.runCommand(c, m); err != nil {
+		c.writer.WriteError(err.Error())
 	}
-
-	_, err := conn.Write([]byte(resp))
-	return err
+	srv.handleClientsBlockedOnKeys()
 }
 
-func (srv *Server) onSet(args []string) string {
-	if len(args) == 2 {
-		srv.data[args[0]] = args[1]
+func (srv *Server) runCommand(c *Client, m Message) error {
+	cmd := srv.lookupCommand(m.cmd)
+	if cmd == nil {
+		return c.flagTransaction(errUnknownCommand(m.cmd, m.args))
 	}
-	if len(args) == 4 {
-		srv.data[args[0]] = args[1]
 
-		ttl, err := strconv.ParseInt(args[3], 10, 64)
-		if err != nil {
-			log.Fatal(err)
-		}
+	if !cmd.checkArity(len(m.args) + 1) {
+		return c.flagTransaction(errWrongNumberOfArgs(cmd.Name))
+	}
 
-		go func() {
-			<-time.After(time.Duration(ttl) * time.Millisecond)
-			delete(srv.data, args[0])
-		}()
+	if !c.authenticated && cmd.Flags&CmdNoAuth == 0 {
+		return c.flagTransaction(ErrNoAuth)
 	}
-	return "+OK\r\n"
-}
 
-func (srv *Server) onGet(args []string) string {
-	val := srv.data[args[0]]
+	if srv.replication.role == REPLICATION_ROLE_SLAVE && cmd.Flags&CmdWrite != 0 {
+		return c.flagTransaction(ErrReadOnly)
+	}
 
-	if len(val) == 0 {
-		return "$-1\r\n"
+	if c.inMulti && !isTransactionCommand(cmd) {
+		c.multiQueue = append(c.multiQueue, m)
+		c.writer.WriteSimpleString("QUEUED")
+		return nil
 	}
 
-	return fmt.Sprintf("+%v\r\n", val)
+	return cmd.Handler(srv, c, m.args)
 }
 
-func (srv *Server) onConfig(args []string) string {
+func (srv *Server) onPing(c *Client, args []string) error {
+	switch len(args) {
+	case 0:
+		c.writer.WriteSimpleString("PONG")
+	case 1:
+		c.writer.WriteBulkString(args[0])
+	default:
+		return errWrongNumberOfArgs("ping")
+	}
+	return nil
+}
+
+func (srv *Server) onEcho(c *Client, args []string) error {
+	c.writer.WriteBulkString(args[0])
+	return nil
+}
+
+// tunableConfigs are the parameters CONFIG SET changes, by their minimum.
+// They are read when values are converted, so new values apply from the
+// next conversion on.
+var tunableConfigs = map[string]int{
+	"list-max-listpack-size":    math.MinInt32,
+	"hash-max-listpack-entries": 0,
+	"hash-max-listpack-value":   0,
+	"set-max-intset-entries":    0,
+	"set-max-listpack-entries":  0,
+	"set-max-listpack-value":    0,
+	"zset-max-listpack-entries": 0,
+	"zset-max-listpack-value":   0,
+	"stream-node-max-bytes":     0,
+	"stream-node-max-entries":   0,
+}
+
+// CONFIG GET parameter
+// CONFIG SET parameter value [parameter value ...]
+func (srv *Server) onConfig(c *Client, args []string) error {
+	switch sub := strings.ToLower(args[0]); sub {
+	case "get":
+	case "set":
+		return srv.configSet(c, args[1:])
+	default:
+		return errUnknownSubcommand("config", args[0])
+	}
+	if len(args) != 2 {
+		return errWrongNumberOfArgs("config|get")
+	}
+
 	key := args[1]
 	val := srv.config[args[1]]
 
 	if len(val) == 0 {
-		return "$-1\r\n"
+		c.writer.WriteMapLen(0)
+		return nil
 	}
-	return makeArrayBulkString([]string{key, val})
+	c.writer.WriteMapLen(1)
+	c.writer.WriteBulkString(key)
+	c.writer.WriteBulkString(val)
+	return nil
 }
 
-func (srv *Server) onKeys(args []string) string {
-	switch args[0] {
-	case "*":
-		return makeArrayBulkString(srv.rdb.Databases[0].Keys)
+// configSet validates every parameter before setting any, like Redis
+// applies them all or none.
+func (srv *Server) configSet(c *Client, args []string) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errWrongNumberOfArgs("config|set")
 	}
-	return "*0"
+	values := map[string]string{}
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(args[i])
+		min, ok := tunableConfigs[name]
+		if !ok {
+			return fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i])
+		}
+		if _, ok := values[name]; ok {
+			return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", args[i])
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 32)
+		if err != nil || int(n) < min {
+			return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - argument couldn't be parsed into an integer", args[i])
+		}
+		values[name] = strconv.FormatInt(n, 10)
+	}
+
+	for name, value := range values {
+		srv.config[name] = value
+	}
+	c.writer.WriteSimpleString("OK")
+	return nil
 }
 
-func (srv *Server) onInfo(args []string) string {
-	switch args[0] {
-	case "replication":
-		var sb strings.Builder
-		sb.WriteString("# Replication\n")
-		sb.WriteString(fmt.Sprintf("role:%s\n", srv.replication.role))
-		sb.WriteString(fmt.Sprintf("master_replid:%s\n", srv.replication.masterReplid))
-		sb.WriteString(fmt.Sprintf("master_repl_offset:%v", srv.replication.masterReplOffset))
+func (srv *Server) onInfo(c *Client, args []string) error {
+	section := "default"
+	if len(args) > 0 {
+		section = strings.ToLower(args[0])
+	}
 
-		return fmt.Sprintf("$%d\r\n%s\r\n", len(sb.String()), sb.String())
+	sections := []string{section}
+	switch section {
+	case "default", "all", "everything":
+		sections = []string{"replication", "keyspace"}
+	}
+
+	var parts []string
+	for _, section := range sections {
+		var sb strings.Builder
+		switch section {
+		case "replication":
+			sb.WriteString("# Replication\n")
+			sb.WriteString(fmt.Sprintf("role:%s\n", srv.replication.role))
+			sb.WriteString(fmt.Sprintf("master_replid:%s\n", srv.replication.masterReplid))
+			sb.WriteString(fmt.Sprintf("master_repl_offset:%v", srv.replication.masterReplOffset))
+		case "keyspace":
+			sb.WriteString("# Keyspace")
+			for _, db := range srv.dbs {
+				if db.Len() == 0 {
+					continue
+				}
+				sb.WriteString(fmt.Sprintf("\ndb%d:keys=%d,expires=%d,avg_ttl=0", db.id, db.Len(), db.expires.Len()))
+			}
+		default:
+			continue
+		}
+		parts = append(parts, sb.String())
 	}
-	return "*0"
+
+	c.writer.WriteVerbatim("txt", strings.Join(parts, "\n\n"))
+	return nil
 }
 
-func (srv *Server) onReplConf(args []string) string {
-	resp := "+OK\r\n"
+func (srv *Server) onReplConf(c *Client, args []string) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return ErrSyntax
+	}
 
 	slave := SlaveServer{}
 
@@ -254,10 +381,12 @@ func (srv *Server) onReplConf(args []string) string {
 
 	srv.slave = slave
 
-	return resp
+	c.writer.WriteSimpleString("OK")
+	return nil
 }
 
-func (srv *Server) onPsync(args []string) string {
+func (srv *Server) onPsync(c *Client, args []string) error {
+
 	// go fullResync(srv.slave)
 
 	// NOTE: setup empty rdb
@@ -266,10 +395,10 @@ func (srv *Server) onPsync(args []string) string {
 	if err != nil {
 		log.Fatalln("base64.StdEncoding.DecodeString:", err)
 	}
-	empty := fmt.Sprintf("$%d\r\n%s", len(data), data)
 
-	resp := fmt.Sprintf("+FULLRESYNC %s 0\r\n", srv.replication.masterReplid) + empty
-	return resp
+	c.writer.WriteSimpleString(fmt.Sprintf("FULLRESYNC %s 0", srv.replication.masterReplid))
+	c.writer.WriteRDBPayload(data)
+	return nil
 }
 
 func (srv *Server) setupSlave() {
@@ -281,39 +410,47 @@ func (srv *Server) setupSlave() {
 		log.Fatalln("setupSlave:", err)
 	}
 
-	res, err := master.Send("*1\r\n$4\r\nping\r\n")
+	res, err := master.Send(makeArrayBulkString([]string{"ping"}))
 	if err != nil {
 		log.Fatalln("setupSlave:", err)
 	}
-	if res.raw != "+PONG\r\n" {
-		fmt.Printf("%q\n", res.raw)
-		log.Fatalln("setupSlave: unexpected ping res:", res.raw)
+	if res.Type != RESPSimpleString || res.Str != "PONG" {
+		log.Fatalf("setupSlave: unexpected ping res: %+v\n", res)
 	}
 	fmt.Printf("res ping:%+v\n", res)
 
-	res, err = master.Send("*3\r\n$8\r\nREPLCONF\r\n$14\r\nlistening-port\r\n$4\r\n6380\r\n")
+	res, err = master.Send(makeArrayBulkString([]string{"REPLCONF", "listening-port", srv.config["port"]}))
 	if err != nil {
 		log.Fatalln("setupSlave:", err)
 	}
-	if res.raw != "+OK\r\n" {
-		log.Fatalln("setupSlave: unexpected ping res:", res.raw)
+	if res.Type != RESPSimpleString || res.Str != "OK" {
+		log.Fatalf("setupSlave: unexpected REPLCONF res: %+v\n", res)
 	}
 	fmt.Printf("res REPLCONF:%+v\n", res)
 
-	res, err = master.Send("*3\r\n$8\r\nREPLCONF\r\n$4\r\ncapa\r\n$6\r\npsync2\r\n")
+	res, err = master.Send(makeArrayBulkString([]string{"REPLCONF", "capa", "psync2"}))
 	if err != nil {
 		log.Fatalln("setupSlave:", err)
 	}
-	if res.raw != "+OK\r\n" {
-		log.Fatalln("setupSlave: unexpected ping res:", res.raw)
+	if res.Type != RESPSimpleString || res.Str != "OK" {
+		log.Fatalf("setupSlave: unexpected REPLCONF res: %+v\n", res)
 	}
 	fmt.Printf("res REPLCONF:%+v\n", res)
 
-	res, err = master.Send("*3\r\n$5\r\nPSYNC\r\n$1\r\n?\r\n$2\r\n-1\r\n")
+	res, err = master.Send(makeArrayBulkString([]string{"PSYNC", "?", "-1"}))
 	if err != nil {
 		log.Fatalln("setupSlave:", err)
 	}
+	if res.Type != RESPSimpleString || !strings.HasPrefix(res.Str, "FULLRESYNC") {
+		log.Fatalf("setupSlave: unexpected PSYNC res: %+v\n", res)
+	}
 	fmt.Printf("res PSYNC:%+v\n", res)
+
+	rdb, err := master.reader.ReadRDBPayload()
+	if err != nil {
+		log.Fatalln("setupSlave:", err)
+	}
+	fmt.Printf("res RDB: %d bytes\n", len(rdb))
 }
 
 // func fullResync(ss SlaveServer) {
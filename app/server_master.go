@@ -6,8 +6,9 @@ import (
 )
 
 type MasterServer struct {
-	host string
-	conn net.Conn
+	host   string
+	conn   net.Conn
+	reader *RESPReader
 }
 
 func (ms *MasterServer) Connect() error {
@@ -16,20 +17,21 @@ func (ms *MasterServer) Connect() error {
 		return fmt.Errorf("Connect: %w", err)
 	}
 	ms.conn = conn
+	ms.reader = NewRESPReader(conn)
 	// fmt.Println("Connect")
 	return nil
 }
 
-func (ms *MasterServer) Send(b string) (Message, error) {
+func (ms *MasterServer) Send(b string) (Value, error) {
 	_, err := ms.conn.Write([]byte(b))
 	if err != nil {
-		return Message{}, fmt.Errorf("Send: %w", err)
+		return Value{}, fmt.Errorf("Send: %w", err)
 	}
 
-	m, err := ParseRESP(ms.conn)
+	v, err := ms.reader.ReadValue()
 	if err != nil {
-		return Message{}, fmt.Errorf("Send: %w", err)
+		return Value{}, fmt.Errorf("Send: %w", err)
 	}
 	// fmt.Println("Send")
-	return m, nil
+	return v, nil
 }
@@ -6,8 +6,9 @@ import (
 )
 
 type SlaveServer struct {
-	host string
-	conn net.Conn
+	host   string
+	conn   net.Conn
+	reader *RESPReader
 }
 
 func (ss *SlaveServer) Connect() error {
@@ -16,20 +17,21 @@ func (ss *SlaveServer) Connect() error {
 		return fmt.Errorf("Connect: %w", err)
 	}
 	ss.conn = conn
+	ss.reader = NewRESPReader(conn)
 	// fmt.Println("Connect")
 	return nil
 }
 
-func (ss *SlaveServer) Send(b string) (Message, error) {
+func (ss *SlaveServer) Send(b string) (Value, error) {
 	_, err := ss.conn.Write([]byte(b))
 	if err != nil {
-		return Message{}, fmt.Errorf("Send: %w", err)
+		return Value{}, fmt.Errorf("Send: %w", err)
 	}
 
-	m, err := ParseRESP(ss.conn)
+	v, err := ss.reader.ReadValue()
 	if err != nil {
-		return Message{}, fmt.Errorf("Send: %w", err)
+		return Value{}, fmt.Errorf("Send: %w", err)
 	}
 	// fmt.Println("Send")
-	return m, nil
+	return v, nil
 }
@@ -2,7 +2,12 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -10,7 +15,7 @@ import (
 func TestStartServer(t *testing.T) {
 	go startServer(ServerOpt{
 		port:       "6379",
-		dir:        "/home/highbits/code/sandbox/codecrafters-redis-go/dump",
+		dir:        "../dump",
 		dbfilename: "dump.rdb",
 	})
 	time.Sleep(time.Millisecond)
@@ -34,7 +39,7 @@ func TestStartServer(t *testing.T) {
 		{
 			name:   "echo",
 			input:  makeArrayBulkString([]string{"echo", "foobarbaz"}),
-			expect: "+foobarbaz\r\n",
+			expect: makeBulkString("foobarbaz"),
 		},
 		{
 			name:   "set",
@@ -44,7 +49,7 @@ func TestStartServer(t *testing.T) {
 		{
 			name:   "get",
 			input:  makeArrayBulkString([]string{"get", "hello"}),
-			expect: "+world\r\n",
+			expect: makeBulkString("world"),
 		},
 		{
 			name:   "get_not_found",
@@ -59,7 +64,7 @@ func TestStartServer(t *testing.T) {
 		{
 			name:   "get_with_expiry",
 			input:  makeArrayBulkString([]string{"get", "expiry"}),
-			expect: "+123\r\n",
+			expect: makeBulkString("123"),
 			wait:   11 * time.Millisecond,
 		},
 		{
@@ -67,15 +72,41 @@ func TestStartServer(t *testing.T) {
 			input:  makeArrayBulkString([]string{"get", "expiry"}),
 			expect: "$-1\r\n",
 		},
+		{
+			name:   "set_with_expiry_overwritten",
+			input:  makeArrayBulkString([]string{"set", "expiry", "456", "px", "10"}),
+			expect: "+OK\r\n",
+		},
+		{
+			name:   "set_without_expiry",
+			input:  makeArrayBulkString([]string{"set", "expiry", "789"}),
+			expect: "+OK\r\n",
+			wait:   11 * time.Millisecond,
+		},
+		{
+			name:   "get_ttl_cleared",
+			input:  makeArrayBulkString([]string{"get", "expiry"}),
+			expect: makeBulkString("789"),
+		},
 		{
 			name:   "get_config",
 			input:  makeArrayBulkString([]string{"config", "get", "dir"}),
-			expect: makeArrayBulkString([]string{"dir", "/home/highbits/code/sandbox/codecrafters-redis-go/dump"}),
+			expect: makeArrayBulkString([]string{"dir", "../dump"}),
 		},
 		{
 			name:   "get_keys",
-			input:  makeArrayBulkString([]string{"keys", "*"}),
-			expect: makeArrayBulkString([]string{"foo", "bar"}),
+			input:  makeArrayBulkString([]string{"keys", "f*"}),
+			expect: makeArrayBulkString([]string{"foo"}),
+		},
+		{
+			name:   "get_keys_expired",
+			input:  makeArrayBulkString([]string{"keys", "ba?"}),
+			expect: makeArrayBulkString([]string{}),
+		},
+		{
+			name:   "get_keys_created_by_set",
+			input:  makeArrayBulkString([]string{"keys", "h[a-z]llo"}),
+			expect: makeArrayBulkString([]string{"hello"}),
 		},
 		{
 			name:   "info_replication",
@@ -107,20 +138,1342 @@ func TestStartServer(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			res := make([]byte, 1024)
-			n, err := conn.Read(res)
+			got := readN(t, conn, len(tt.expect))
+			if got != tt.expect {
+				t.Fatalf("expected %q got %q", tt.expect, got)
+			}
+		})
+		<-time.After(tt.wait)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6390"})
+
+	input := makeArrayBulkString([]string{"set", "a", "1"}) +
+		makeArrayBulkString([]string{"ping"}) +
+		makeArrayBulkString([]string{"get", "a"})
+	if _, err := conn.Write([]byte(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "+OK\r\n+PONG\r\n" + makeBulkString("1")
+	if got := readN(t, conn, len(expect)); got != expect {
+		t.Fatalf("expected %q got %q", expect, got)
+	}
+
+	// a command split across several writes is answered once complete
+	input = makeArrayBulkString([]string{"echo", "split"})
+	for _, part := range []string{input[:3], input[3:10], input[10:]} {
+		if _, err := conn.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	expect = makeBulkString("split")
+	if got := readN(t, conn, len(expect)); got != expect {
+		t.Fatalf("expected %q got %q", expect, got)
+	}
+
+	// null and empty arrays are no command
+	runCases(t, conn, []testCase{
+		{name: "null_array", input: "*-1\r\n*0\r\n" + makeArrayBulkString([]string{"ping"}), expect: "+PONG\r\n"},
+	})
+}
+
+func TestHello(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6391", requirePass: "secret"})
+
+	runCases(t, conn, []testCase{
+		{
+			name:   "noauth",
+			input:  makeArrayBulkString([]string{"get", "foo"}),
+			expect: "-NOAUTH Authentication required.\r\n",
+		},
+		{
+			name:   "wrongpass",
+			input:  makeArrayBulkString([]string{"HELLO", "3", "AUTH", "default", "nope"}),
+			expect: "-WRONGPASS invalid username-password pair or user is disabled.\r\n",
+		},
+		{
+			name:   "noproto",
+			input:  makeArrayBulkString([]string{"HELLO", "4"}),
+			expect: "-NOPROTO unsupported protocol version\r\n",
+		},
+		{
+			name:  "hello_3",
+			input: makeArrayBulkString([]string{"HELLO", "3", "AUTH", "default", "secret", "SETNAME", "worker"}),
+			expect: "%7\r\n" +
+				"$6\r\nserver\r\n$5\r\nredis\r\n" +
+				"$7\r\nversion\r\n" + makeBulkString(redisVersion) +
+				"$5\r\nproto\r\n:3\r\n" +
+				"$2\r\nid\r\n:1\r\n" +
+				"$4\r\nmode\r\n$10\r\nstandalone\r\n" +
+				"$4\r\nrole\r\n$6\r\nmaster\r\n" +
+				"$7\r\nmodules\r\n*0\r\n",
+		},
+		{
+			name:   "resp3_null",
+			input:  makeArrayBulkString([]string{"get", "missing"}),
+			expect: "_\r\n",
+		},
+		{
+			name:   "resp3_config_map",
+			input:  makeArrayBulkString([]string{"config", "get", "port"}),
+			expect: "%1\r\n$4\r\nport\r\n$4\r\n6391\r\n",
+		},
+		{
+			name:  "hello_2",
+			input: makeArrayBulkString([]string{"HELLO", "2"}),
+			expect: "*14\r\n" +
+				"$6\r\nserver\r\n$5\r\nredis\r\n" +
+				"$7\r\nversion\r\n" + makeBulkString(redisVersion) +
+				"$5\r\nproto\r\n:2\r\n" +
+				"$2\r\nid\r\n:1\r\n" +
+				"$4\r\nmode\r\n$10\r\nstandalone\r\n" +
+				"$4\r\nrole\r\n$6\r\nmaster\r\n" +
+				"$7\r\nmodules\r\n*0\r\n",
+		},
+	})
+}
+
+func TestInline(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6392"})
+
+	runCases(t, conn, []testCase{
+		{
+			name:   "ping",
+			input:  "PING\r\n",
+			expect: "+PONG\r\n",
+		},
+		{
+			name:   "set_quoted",
+			input:  "set greeting \"hello world\"\n",
+			expect: "+OK\r\n",
+		},
+		{
+			name:   "get",
+			input:  "get greeting\r\n",
+			expect: makeBulkString("hello world"),
+		},
+		{
+			name:   "unbalanced_quotes",
+			input:  "get \"greeting\r\n",
+			expect: "-ERR Protocol error: unbalanced quotes in request\r\n",
+		},
+	})
+}
+
+func TestErrorReplies(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6393"})
+
+	runCases(t, conn, []testCase{
+		{
+			name:   "unknown_command",
+			input:  makeArrayBulkString([]string{"sett", "foo", "bar"}),
+			expect: "-ERR unknown command 'sett', with args beginning with: 'foo' 'bar' \r\n",
+		},
+		{
+			name:   "wrong_number_of_args",
+			input:  makeArrayBulkString([]string{"get"}),
+			expect: "-ERR wrong number of arguments for 'get' command\r\n",
+		},
+		{
+			name:   "px_not_integer",
+			input:  makeArrayBulkString([]string{"set", "foo", "bar", "px", "soon"}),
+			expect: "-ERR value is not an integer or out of range\r\n",
+		},
+		{
+			name:   "config_missing_key",
+			input:  makeArrayBulkString([]string{"config", "get"}),
+			expect: "-ERR wrong number of arguments for 'config|get' command\r\n",
+		},
+		{
+			name:   "keys_without_rdb",
+			input:  makeArrayBulkString([]string{"keys", "*"}),
+			expect: "*0\r\n",
+		},
+		{
+			name:   "still_alive",
+			input:  makeArrayBulkString([]string{"ping"}),
+			expect: "+PONG\r\n",
+		},
+	})
+}
+
+func TestCommandTable(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6394"})
+
+	runCases(t, conn, []testCase{
+		{
+			name:   "uppercase_set",
+			input:  makeArrayBulkString([]string{"SET", "foo", "bar"}),
+			expect: "+OK\r\n",
+		},
+		{
+			name:   "mixed_case_get",
+			input:  makeArrayBulkString([]string{"Get", "foo"}),
+			expect: makeBulkString("bar"),
+		},
+		{
+			name:   "arity",
+			input:  makeArrayBulkString([]string{"echo", "a", "b"}),
+			expect: "-ERR wrong number of arguments for 'echo' command\r\n",
+		},
+		{
+			name:   "command_count",
+			input:  makeArrayBulkString([]string{"COMMAND", "COUNT"}),
+			expect: fmt.Sprintf(":%d\r\n", len(newCommandTable())),
+		},
+		{
+			name:   "command_getkeys",
+			input:  makeArrayBulkString([]string{"command", "getkeys", "set", "a", "b"}),
+			expect: makeArrayBulkString([]string{"a"}),
+		},
+		{
+			name:   "command_getkeys_no_keys",
+			input:  makeArrayBulkString([]string{"command", "getkeys", "ping"}),
+			expect: "-ERR The command has no key arguments\r\n",
+		},
+		{
+			name:  "command_info",
+			input: makeArrayBulkString([]string{"command", "info", "get", "nope"}),
+			expect: "*2\r\n*10\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n" +
+				"*3\r\n+@read\r\n+@string\r\n+@fast\r\n*0\r\n*0\r\n*0\r\n*-1\r\n",
+		},
+		{
+			name:  "command_docs",
+			input: makeArrayBulkString([]string{"command", "docs", "echo"}),
+			expect: "*2\r\n$4\r\necho\r\n*6\r\n$7\r\nsummary\r\n" + makeBulkString("Returns the given string.") +
+				"$5\r\nsince\r\n$5\r\n1.0.0\r\n$5\r\ngroup\r\n$10\r\nconnection\r\n",
+		},
+	})
+}
+
+// TestConcurrentClients hammers the keyspace from many connections, run it
+// with -race.
+func TestConcurrentClients(t *testing.T) {
+	startTestServer(t, ServerOpt{port: "6395"})
+
+	const clients, rounds = 20, 200
+
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			conn, err := net.Dial("tcp", "0.0.0.0:6395")
 			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			rr := NewRESPReader(conn)
+
+			for j := 0; j < rounds; j++ {
+				key := fmt.Sprintf("key:%d", j%10)
+				val := fmt.Sprintf("%d:%d", i, j)
+				input := makeArrayBulkString([]string{"set", key, val, "px", "1000"}) +
+					makeArrayBulkString([]string{"get", key})
+				if _, err := conn.Write([]byte(input)); err != nil {
+					errs <- err
+					return
+				}
+
+				for _, expect := range []RESPType{RESPSimpleString, RESPBulkString} {
+					v, err := rr.ReadValue()
+					if err != nil {
+						errs <- err
+						return
+					}
+					if v.Type != expect {
+						errs <- fmt.Errorf("expected %q got %+v", expect, v)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestExpireCommands(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6396"})
+
+	runCases(t, conn, []testCase{
+		{name: "set", input: makeArrayBulkString([]string{"set", "k", "v"}), expect: "+OK\r\n"},
+		{name: "ttl_no_expire", input: makeArrayBulkString([]string{"ttl", "k"}), expect: ":-1\r\n"},
+		{name: "ttl_missing", input: makeArrayBulkString([]string{"pttl", "missing"}), expect: ":-2\r\n"},
+		{name: "expire_missing", input: makeArrayBulkString([]string{"expire", "missing", "10"}), expect: ":0\r\n"},
+		{name: "expire_xx_without_ttl", input: makeArrayBulkString([]string{"expire", "k", "10", "XX"}), expect: ":0\r\n"},
+		{name: "expire_gt_without_ttl", input: makeArrayBulkString([]string{"expire", "k", "10", "GT"}), expect: ":0\r\n"},
+		{name: "expire", input: makeArrayBulkString([]string{"expire", "k", "100"}), expect: ":1\r\n"},
+		{name: "ttl", input: makeArrayBulkString([]string{"ttl", "k"}), expect: ":100\r\n"},
+		{name: "expire_nx_with_ttl", input: makeArrayBulkString([]string{"expire", "k", "10", "nx"}), expect: ":0\r\n"},
+		{name: "expire_lt", input: makeArrayBulkString([]string{"expire", "k", "50", "lt"}), expect: ":1\r\n"},
+		{name: "expire_gt_smaller", input: makeArrayBulkString([]string{"expire", "k", "20", "gt"}), expect: ":0\r\n"},
+		{name: "pexpireat", input: makeArrayBulkString([]string{"pexpireat", "k", "32503680000123"}), expect: ":1\r\n"},
+		{name: "pexpiretime", input: makeArrayBulkString([]string{"pexpiretime", "k"}), expect: ":32503680000123\r\n"},
+		{name: "expiretime", input: makeArrayBulkString([]string{"expiretime", "k"}), expect: ":32503680000\r\n"},
+		{name: "pexpireat_half", input: makeArrayBulkString([]string{"pexpireat", "k", "32503680000500"}), expect: ":1\r\n"},
+		{name: "expiretime_rounded", input: makeArrayBulkString([]string{"expiretime", "k"}), expect: ":32503680001\r\n"},
+		{name: "persist", input: makeArrayBulkString([]string{"persist", "k"}), expect: ":1\r\n"},
+		{name: "persist_again", input: makeArrayBulkString([]string{"persist", "k"}), expect: ":0\r\n"},
+		{
+			name:   "nx_xx",
+			input:  makeArrayBulkString([]string{"expire", "k", "10", "nx", "xx"}),
+			expect: "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n",
+		},
+		{
+			name:   "overflow",
+			input:  makeArrayBulkString([]string{"expire", "k", "9223372036854775807"}),
+			expect: "-ERR invalid expire time in 'expire' command\r\n",
+		},
+		{name: "expire_in_the_past", input: makeArrayBulkString([]string{"expireat", "k", "1"}), expect: ":1\r\n"},
+		{name: "deleted", input: makeArrayBulkString([]string{"get", "k"}), expect: "$-1\r\n"},
+	})
+}
+
+func TestSetOptions(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6397"})
+
+	runCases(t, conn, []testCase{
+		{name: "nx", input: makeArrayBulkString([]string{"set", "lock", "a", "NX", "PX", "100000"}), expect: "+OK\r\n"},
+		{name: "nx_taken", input: makeArrayBulkString([]string{"set", "lock", "b", "nx", "px", "100000"}), expect: "$-1\r\n"},
+		{name: "nx_kept", input: makeArrayBulkString([]string{"get", "lock"}), expect: makeBulkString("a")},
+		{name: "xx_missing", input: makeArrayBulkString([]string{"set", "missing", "a", "xx"}), expect: "$-1\r\n"},
+		{name: "get_old", input: makeArrayBulkString([]string{"set", "lock", "c", "get", "keepttl"}), expect: makeBulkString("a")},
+		{name: "keepttl", input: makeArrayBulkString([]string{"ttl", "lock"}), expect: ":100\r\n"},
+		{name: "get_missing", input: makeArrayBulkString([]string{"set", "fresh", "a", "get"}), expect: "$-1\r\n"},
+		{name: "nx_get", input: makeArrayBulkString([]string{"set", "fresh", "b", "nx", "get"}), expect: makeBulkString("a")},
+		{name: "ex_is_seconds", input: makeArrayBulkString([]string{"set", "ex", "a", "EX", "10"}), expect: "+OK\r\n"},
+		{name: "ex_ttl", input: makeArrayBulkString([]string{"ttl", "ex"}), expect: ":10\r\n"},
+		{name: "exat", input: makeArrayBulkString([]string{"set", "exat", "a", "exat", "32503680000"}), expect: "+OK\r\n"},
+		{name: "exat_time", input: makeArrayBulkString([]string{"expiretime", "exat"}), expect: ":32503680000\r\n"},
+		{name: "pxat_past", input: makeArrayBulkString([]string{"set", "exat", "a", "pxat", "1"}), expect: "+OK\r\n"},
+		{name: "pxat_past_gone", input: makeArrayBulkString([]string{"get", "exat"}), expect: "$-1\r\n"},
+		{name: "nx_xx", input: makeArrayBulkString([]string{"set", "k", "v", "nx", "xx"}), expect: "-ERR syntax error\r\n"},
+		{name: "ex_px", input: makeArrayBulkString([]string{"set", "k", "v", "ex", "1", "px", "1"}), expect: "-ERR syntax error\r\n"},
+		{name: "ex_keepttl", input: makeArrayBulkString([]string{"set", "k", "v", "ex", "1", "keepttl"}), expect: "-ERR syntax error\r\n"},
+		{name: "ex_missing_value", input: makeArrayBulkString([]string{"set", "k", "v", "ex"}), expect: "-ERR syntax error\r\n"},
+		{name: "ex_zero", input: makeArrayBulkString([]string{"set", "k", "v", "ex", "0"}), expect: "-ERR invalid expire time in 'set' command\r\n"},
+	})
+}
+
+func TestCounters(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6412"})
+
+	cmd := func(args ...string) string { return makeArrayBulkString(args) }
+	runCases(t, conn, []testCase{
+		{name: "incr_missing", input: cmd("incr", "n"), expect: ":1\r\n"},
+		{name: "incrby", input: cmd("incrby", "n", "41"), expect: ":42\r\n"},
+		{name: "decr", input: cmd("decr", "n"), expect: ":41\r\n"},
+		{name: "decrby", input: cmd("decrby", "n", "50"), expect: ":-9\r\n"},
+		{name: "get", input: cmd("get", "n"), expect: makeBulkString("-9")},
+		{name: "encoding_int", input: cmd("object", "encoding", "n"), expect: makeBulkString("int")},
+		{name: "set_int", input: cmd("set", "s", "123"), expect: "+OK\r\n"},
+		{name: "set_int_encoding", input: cmd("object", "encoding", "s"), expect: makeBulkString("int")},
+		{name: "set_zero_padded", input: cmd("set", "z", "0123"), expect: "+OK\r\n"},
+		{name: "zero_padded_encoding", input: cmd("object", "encoding", "z"), expect: makeBulkString("embstr")},
+		{name: "incr_zero_padded", input: cmd("incr", "z"), expect: "-ERR value is not an integer or out of range\r\n"},
+		{name: "set_ttl", input: cmd("set", "t", "1", "ex", "100"), expect: "+OK\r\n"},
+		{name: "incr_keeps_ttl", input: cmd("incr", "t"), expect: ":2\r\n"},
+		{name: "ttl", input: cmd("ttl", "t"), expect: ":100\r\n"},
+
+		{name: "set_max", input: cmd("set", "max", "9223372036854775807"), expect: "+OK\r\n"},
+		{name: "incr_overflow", input: cmd("incr", "max"), expect: "-ERR increment or decrement would overflow\r\n"},
+		{name: "decrby_min", input: cmd("decrby", "n", "-9223372036854775808"), expect: "-ERR decrement would overflow\r\n"},
+		{name: "incrby_not_integer", input: cmd("incrby", "n", "1.5"), expect: "-ERR value is not an integer or out of range\r\n"},
+		{name: "set_str", input: cmd("set", "str", "abc"), expect: "+OK\r\n"},
+		{name: "incr_str", input: cmd("incr", "str"), expect: "-ERR value is not an integer or out of range\r\n"},
+		{name: "rpush", input: cmd("rpush", "list", "a"), expect: ":1\r\n"},
+		{name: "incr_wrongtype", input: cmd("incr", "list"), expect: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+
+		{name: "incrbyfloat", input: cmd("incrbyfloat", "f", "10.5"), expect: makeBulkString("10.5")},
+		{name: "incrbyfloat_again", input: cmd("incrbyfloat", "f", "0.1"), expect: makeBulkString("10.6")},
+		// 17 decimals show the rounding of long doubles past a few digits
+		{name: "incrbyfloat_exponent", input: cmd("incrbyfloat", "f", "5.0e3"), expect: makeBulkString("5010.60000000000000009")},
+		{name: "incrbyfloat_tenth", input: cmd("incrbyfloat", "tenths", "0.1"), expect: makeBulkString("0.1")},
+		{name: "incrbyfloat_tenths", input: cmd("incrbyfloat", "tenths", "0.2"), expect: makeBulkString("0.3")},
+		{name: "incrbyfloat_int", input: cmd("incrbyfloat", "s", "-23"), expect: makeBulkString("100")},
+		{name: "incrbyfloat_int_encoding", input: cmd("object", "encoding", "s"), expect: makeBulkString("int")},
+		{name: "incr_after_float", input: cmd("incr", "s"), expect: ":101\r\n"},
+		{name: "incr_float", input: cmd("incr", "f"), expect: "-ERR value is not an integer or out of range\r\n"},
+		{name: "incrbyfloat_not_float", input: cmd("incrbyfloat", "f", "abc"), expect: "-ERR value is not a valid float\r\n"},
+		{name: "incrbyfloat_str", input: cmd("incrbyfloat", "str", "1"), expect: "-ERR value is not a valid float\r\n"},
+		{name: "incrbyfloat_inf", input: cmd("incrbyfloat", "f", "inf"), expect: "-ERR increment would produce NaN or Infinity\r\n"},
+		{name: "incrbyfloat_ttl", input: cmd("incrbyfloat", "t", "1.5"), expect: makeBulkString("3.5")},
+		{name: "incrbyfloat_keeps_ttl", input: cmd("ttl", "t"), expect: ":100\r\n"},
+	})
+}
+
+func TestSaveError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	conn := startTestServer(t, ServerOpt{port: "6413", dir: dir, dbfilename: "dump.rdb"})
+
+	tmp := filepath.Join(dir, fmt.Sprintf("temp-%d.rdb", os.Getpid()))
+	runCases(t, conn, []testCase{
+		{name: "save", input: makeArrayBulkString([]string{"save"}), expect: "-ERR open " + tmp + ": no such file or directory\r\n"},
+	})
+}
+
+func TestConfigSet(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6414"})
+
+	cmd := func(args ...string) string { return makeArrayBulkString(args) }
+	runCases(t, conn, []testCase{
+		{name: "hset_small", input: cmd("hset", "small", "a", "1", "b", "2", "c", "3"), expect: ":3\r\n"},
+		{name: "small_listpack", input: cmd("object", "encoding", "small"), expect: makeBulkString("listpack")},
+		{name: "set_entries", input: cmd("config", "set", "hash-max-listpack-entries", "2", "hash-max-listpack-value", "8"), expect: "+OK\r\n"},
+		{name: "get_entries", input: cmd("config", "get", "hash-max-listpack-entries"), expect: "*2\r\n" + makeBulkString("hash-max-listpack-entries") + makeBulkString("2")},
+		{name: "hset_entries", input: cmd("hset", "h", "a", "1", "b", "2", "c", "3"), expect: ":3\r\n"},
+		{name: "entries_hashtable", input: cmd("object", "encoding", "h"), expect: makeBulkString("hashtable")},
+		{name: "hset_value", input: cmd("hset", "v", "a", "123456789"), expect: ":1\r\n"},
+		{name: "value_hashtable", input: cmd("object", "encoding", "v"), expect: makeBulkString("hashtable")},
+		{name: "hset_small_again", input: cmd("hset", "small", "d", "4"), expect: ":1\r\n"},
+		{name: "small_converted", input: cmd("object", "encoding", "small"), expect: makeBulkString("hashtable")},
+
+		{name: "set_unknown", input: cmd("config", "set", "foo", "1"), expect: "-ERR Unknown option or number of arguments for CONFIG SET - 'foo'\r\n"},
+		{name: "set_not_integer", input: cmd("config", "set", "hash-max-listpack-value", "x"), expect: "-ERR CONFIG SET failed (possibly related to argument 'hash-max-listpack-value') - argument couldn't be parsed into an integer\r\n"},
+		{name: "set_negative", input: cmd("config", "set", "hash-max-listpack-entries", "3", "hash-max-listpack-value", "-1"), expect: "-ERR CONFIG SET failed (possibly related to argument 'hash-max-listpack-value') - argument couldn't be parsed into an integer\r\n"},
+		{name: "none_set", input: cmd("config", "get", "hash-max-listpack-entries"), expect: "*2\r\n" + makeBulkString("hash-max-listpack-entries") + makeBulkString("2")},
+		{name: "set_odd", input: cmd("config", "set", "hash-max-listpack-value"), expect: "-ERR wrong number of arguments for 'config|set' command\r\n"},
+	})
+}
+
+func TestDatabases(t *testing.T) {
+	// two databases, foo=bar in db 0 and baz=qux in db 1
+	dir := t.TempDir()
+	rdb := "REDIS0011" +
+		"\xfe\x00" + "\x00\x03foo\x03bar" +
+		"\xfe\x01" + "\x00\x03baz\x03qux" +
+		"\xff" + strings.Repeat("\x00", 8)
+	if err := os.WriteFile(filepath.Join(dir, "multi.rdb"), []byte(rdb), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := startTestServer(t, ServerOpt{port: "6398", dir: dir, dbfilename: "multi.rdb"})
+
+	runCases(t, conn, []testCase{
+		{name: "db0", input: makeArrayBulkString([]string{"get", "foo"}), expect: makeBulkString("bar")},
+		{name: "db0_no_baz", input: makeArrayBulkString([]string{"get", "baz"}), expect: "$-1\r\n"},
+		{name: "select_1", input: makeArrayBulkString([]string{"select", "1"}), expect: "+OK\r\n"},
+		{name: "db1", input: makeArrayBulkString([]string{"get", "baz"}), expect: makeBulkString("qux")},
+		{name: "select_out_of_range", input: makeArrayBulkString([]string{"select", "16"}), expect: "-ERR DB index is out of range\r\n"},
+		{name: "set_ttl", input: makeArrayBulkString([]string{"set", "ttl", "v", "ex", "100"}), expect: "+OK\r\n"},
+		{name: "move", input: makeArrayBulkString([]string{"move", "ttl", "2"}), expect: ":1\r\n"},
+		{name: "move_missing", input: makeArrayBulkString([]string{"move", "ttl", "2"}), expect: ":0\r\n"},
+		{name: "move_same", input: makeArrayBulkString([]string{"move", "baz", "1"}), expect: "-ERR source and destination objects are the same\r\n"},
+		{name: "dbsize", input: makeArrayBulkString([]string{"dbsize"}), expect: ":1\r\n"},
+		{name: "select_2", input: makeArrayBulkString([]string{"select", "2"}), expect: "+OK\r\n"},
+		{name: "moved_ttl", input: makeArrayBulkString([]string{"ttl", "ttl"}), expect: ":100\r\n"},
+		{name: "swapdb", input: makeArrayBulkString([]string{"swapdb", "0", "2"}), expect: "+OK\r\n"},
+		{name: "swapped", input: makeArrayBulkString([]string{"get", "foo"}), expect: makeBulkString("bar")},
+		{name: "swapdb_invalid", input: makeArrayBulkString([]string{"swapdb", "0", "99"}), expect: "-ERR invalid second DB index\r\n"},
+		{name: "flushdb", input: makeArrayBulkString([]string{"flushdb", "async"}), expect: "+OK\r\n"},
+		{name: "flushed", input: makeArrayBulkString([]string{"dbsize"}), expect: ":0\r\n"},
+		{name: "select_1_again", input: makeArrayBulkString([]string{"select", "1"}), expect: "+OK\r\n"},
+		{name: "flushall", input: makeArrayBulkString([]string{"flushall"}), expect: "+OK\r\n"},
+		{name: "flushed_all", input: makeArrayBulkString([]string{"dbsize"}), expect: ":0\r\n"},
+	})
+}
+
+func TestScan(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6399"})
+	rr := NewRESPReader(conn)
+
+	expect := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		expect[key] = true
+		conn.Write([]byte(makeArrayBulkString([]string{"set", key, "v"})))
+		conn.Write([]byte(makeArrayBulkString([]string{"set", fmt.Sprintf("other:%d", i), "v"})))
+	}
+	for i := 0; i < 200; i++ {
+		if _, err := rr.ReadValue(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := map[string]bool{}
+	cursor := "0"
+	for {
+		conn.Write([]byte(makeArrayBulkString([]string{"scan", cursor, "match", "user:*", "count", "20", "type", "string"})))
+		v, err := rr.ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := v.Array[1].Strings()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			if !expect[key] {
+				t.Fatalf("unexpected key %q", key)
+			}
+			seen[key] = true
+		}
+
+		// keys added during the iteration must not break it
+		conn.Write([]byte(makeArrayBulkString([]string{"set", "new:" + cursor, "v"})))
+		rr.ReadValue()
+
+		cursor = v.Array[0].Str
+		if cursor == "0" {
+			break
+		}
+	}
+
+	if len(seen) != len(expect) {
+		t.Fatalf("expected %d keys got %d", len(expect), len(seen))
+	}
+
+	runCases(t, conn, []testCase{
+		{
+			name:   "invalid_cursor",
+			input:  makeArrayBulkString([]string{"scan", "abc"}),
+			expect: "-ERR invalid cursor\r\n",
+		},
+		{
+			name:   "count_zero",
+			input:  makeArrayBulkString([]string{"scan", "0", "count", "0"}),
+			expect: "-ERR syntax error\r\n",
+		},
+	})
+}
+
+func TestKeyCommands(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6400"})
+
+	runCases(t, conn, []testCase{
+		{name: "randomkey_empty", input: makeArrayBulkString([]string{"randomkey"}), expect: "$-1\r\n"},
+		{name: "set_a", input: makeArrayBulkString([]string{"set", "a", "1", "ex", "100"}), expect: "+OK\r\n"},
+		{name: "set_b", input: makeArrayBulkString([]string{"set", "b", "2"}), expect: "+OK\r\n"},
+		{name: "exists", input: makeArrayBulkString([]string{"exists", "a", "a", "b", "c"}), expect: ":3\r\n"},
+		{name: "touch", input: makeArrayBulkString([]string{"touch", "a", "c"}), expect: ":1\r\n"},
+		{name: "type", input: makeArrayBulkString([]string{"type", "a"}), expect: "+string\r\n"},
+		{name: "type_none", input: makeArrayBulkString([]string{"type", "c"}), expect: "+none\r\n"},
+		{name: "rename", input: makeArrayBulkString([]string{"rename", "a", "c"}), expect: "+OK\r\n"},
+		{name: "rename_keeps_ttl", input: makeArrayBulkString([]string{"ttl", "c"}), expect: ":100\r\n"},
+		{name: "rename_missing", input: makeArrayBulkString([]string{"rename", "a", "d"}), expect: "-ERR no such key\r\n"},
+		{name: "renamenx_taken", input: makeArrayBulkString([]string{"renamenx", "b", "c"}), expect: ":0\r\n"},
+		{name: "renamenx", input: makeArrayBulkString([]string{"renamenx", "b", "d"}), expect: ":1\r\n"},
+		{name: "copy", input: makeArrayBulkString([]string{"copy", "c", "e"}), expect: ":1\r\n"},
+		{name: "copy_ttl", input: makeArrayBulkString([]string{"ttl", "e"}), expect: ":100\r\n"},
+		{name: "copy_taken", input: makeArrayBulkString([]string{"copy", "d", "e"}), expect: ":0\r\n"},
+		{name: "copy_replace", input: makeArrayBulkString([]string{"copy", "d", "e", "replace"}), expect: ":1\r\n"},
+		{name: "copy_replaced", input: makeArrayBulkString([]string{"get", "e"}), expect: makeBulkString("2")},
+		{name: "copy_db", input: makeArrayBulkString([]string{"copy", "d", "d", "db", "3"}), expect: ":1\r\n"},
+		{name: "copy_same", input: makeArrayBulkString([]string{"copy", "d", "d"}), expect: "-ERR source and destination objects are the same\r\n"},
+		{name: "del", input: makeArrayBulkString([]string{"del", "c", "d", "e", "missing"}), expect: ":3\r\n"},
+		{name: "unlink_missing", input: makeArrayBulkString([]string{"unlink", "c"}), expect: ":0\r\n"},
+		{name: "select_3", input: makeArrayBulkString([]string{"select", "3"}), expect: "+OK\r\n"},
+		{name: "randomkey", input: makeArrayBulkString([]string{"randomkey"}), expect: makeBulkString("d")},
+	})
+}
+
+func TestObject(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6401"})
+	long := strings.Repeat("x", 45)
+
+	runCases(t, conn, []testCase{
+		{name: "set_short", input: makeArrayBulkString([]string{"set", "short", "abc"}), expect: "+OK\r\n"},
+		{name: "set_long", input: makeArrayBulkString([]string{"set", "long", long}), expect: "+OK\r\n"},
+		{name: "encoding_embstr", input: makeArrayBulkString([]string{"object", "encoding", "short"}), expect: makeBulkString("embstr")},
+		{name: "encoding_raw", input: makeArrayBulkString([]string{"object", "ENCODING", "long"}), expect: makeBulkString("raw")},
+		{name: "encoding_missing", input: makeArrayBulkString([]string{"object", "encoding", "missing"}), expect: "$-1\r\n"},
+		{name: "refcount", input: makeArrayBulkString([]string{"object", "refcount", "short"}), expect: ":1\r\n"},
+		{name: "idletime", input: makeArrayBulkString([]string{"object", "idletime", "short"}), expect: ":0\r\n"},
+		{name: "wrong_args", input: makeArrayBulkString([]string{"object", "encoding"}), expect: "-ERR wrong number of arguments for 'object|encoding' command\r\n"},
+		{name: "unknown_subcommand", input: makeArrayBulkString([]string{"object", "foo", "short"}), expect: "-ERR unknown subcommand 'foo'. Try OBJECT HELP.\r\n"},
+	})
+
+	// TOUCH counts as an access, OBJECT does not
+	time.Sleep(1100 * time.Millisecond)
+	runCases(t, conn, []testCase{
+		{name: "idletime_idle", input: makeArrayBulkString([]string{"object", "idletime", "short"}), expect: ":1\r\n"},
+		{name: "touch", input: makeArrayBulkString([]string{"touch", "short", "missing"}), expect: ":1\r\n"},
+		{name: "idletime_touched", input: makeArrayBulkString([]string{"object", "idletime", "short"}), expect: ":0\r\n"},
+	})
+}
+
+func TestLists(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6402"})
+
+	runCases(t, conn, []testCase{
+		{name: "rpush", input: makeArrayBulkString([]string{"rpush", "l", "a", "b", "c"}), expect: ":3\r\n"},
+		{name: "lpush", input: makeArrayBulkString([]string{"lpush", "l", "1", "0"}), expect: ":5\r\n"},
+		{name: "lrange", input: makeArrayBulkString([]string{"lrange", "l", "0", "-1"}), expect: "*5\r\n$1\r\n0\r\n$1\r\n1\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{name: "lrange_negative", input: makeArrayBulkString([]string{"lrange", "l", "-2", "100"}), expect: "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{name: "lrange_empty", input: makeArrayBulkString([]string{"lrange", "l", "3", "1"}), expect: "*0\r\n"},
+		{name: "lindex", input: makeArrayBulkString([]string{"lindex", "l", "-1"}), expect: makeBulkString("c")},
+		{name: "lindex_out", input: makeArrayBulkString([]string{"lindex", "l", "5"}), expect: "$-1\r\n"},
+		{name: "lset", input: makeArrayBulkString([]string{"lset", "l", "1", "one"}), expect: "+OK\r\n"},
+		{name: "lset_out", input: makeArrayBulkString([]string{"lset", "l", "9", "x"}), expect: "-ERR index out of range\r\n"},
+		{name: "lset_missing", input: makeArrayBulkString([]string{"lset", "missing", "0", "x"}), expect: "-ERR no such key\r\n"},
+		{name: "linsert", input: makeArrayBulkString([]string{"linsert", "l", "before", "a", "b"}), expect: ":6\r\n"},
+		{name: "linsert_missing_pivot", input: makeArrayBulkString([]string{"linsert", "l", "after", "z", "x"}), expect: ":-1\r\n"},
+		{name: "linsert_syntax", input: makeArrayBulkString([]string{"linsert", "l", "middle", "a", "x"}), expect: "-ERR syntax error\r\n"},
+		{name: "lpos", input: makeArrayBulkString([]string{"lpos", "l", "b"}), expect: ":2\r\n"},
+		{name: "lpos_rank", input: makeArrayBulkString([]string{"lpos", "l", "b", "rank", "-1"}), expect: ":4\r\n"},
+		{name: "lpos_count", input: makeArrayBulkString([]string{"lpos", "l", "b", "count", "0"}), expect: "*2\r\n:2\r\n:4\r\n"},
+		{name: "lpos_maxlen", input: makeArrayBulkString([]string{"lpos", "l", "b", "count", "0", "maxlen", "3"}), expect: "*1\r\n:2\r\n"},
+		{name: "lpos_rank_zero", input: makeArrayBulkString([]string{"lpos", "l", "b", "rank", "0"}), expect: "-ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list\r\n"},
+		{name: "lrem", input: makeArrayBulkString([]string{"lrem", "l", "-1", "b"}), expect: ":1\r\n"},
+		{name: "lrange_after_edit", input: makeArrayBulkString([]string{"lrange", "l", "0", "-1"}), expect: "*5\r\n$1\r\n0\r\n$3\r\none\r\n$1\r\nb\r\n$1\r\na\r\n$1\r\nc\r\n"},
+		{name: "ltrim", input: makeArrayBulkString([]string{"ltrim", "l", "1", "-2"}), expect: "+OK\r\n"},
+		{name: "llen", input: makeArrayBulkString([]string{"llen", "l"}), expect: ":3\r\n"},
+		{name: "lmove", input: makeArrayBulkString([]string{"lmove", "l", "m", "left", "right"}), expect: makeBulkString("one")},
+		{name: "lmove_rotate", input: makeArrayBulkString([]string{"lmove", "l", "l", "right", "left"}), expect: makeBulkString("a")},
+		{name: "lpop_count", input: makeArrayBulkString([]string{"lpop", "l", "5"}), expect: "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{name: "emptied_list_deleted", input: makeArrayBulkString([]string{"exists", "l"}), expect: ":0\r\n"},
+		{name: "lpop_missing", input: makeArrayBulkString([]string{"lpop", "l"}), expect: "$-1\r\n"},
+		{name: "lpop_missing_count", input: makeArrayBulkString([]string{"lpop", "l", "1"}), expect: "*-1\r\n"},
+		{name: "lpop_negative", input: makeArrayBulkString([]string{"lpop", "m", "-1"}), expect: "-ERR value is out of range, must be positive\r\n"},
+		{name: "rpop", input: makeArrayBulkString([]string{"rpop", "m"}), expect: makeBulkString("one")},
+		{name: "type", input: makeArrayBulkString([]string{"rpush", "m", "x"}), expect: ":1\r\n"},
+		{name: "type_list", input: makeArrayBulkString([]string{"type", "m"}), expect: "+list\r\n"},
+		{name: "encoding", input: makeArrayBulkString([]string{"object", "encoding", "m"}), expect: makeBulkString("listpack")},
+		{name: "get_wrongtype", input: makeArrayBulkString([]string{"get", "m"}), expect: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{name: "set_get_wrongtype", input: makeArrayBulkString([]string{"set", "m", "x", "get"}), expect: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{name: "set_string", input: makeArrayBulkString([]string{"set", "s", "x"}), expect: "+OK\r\n"},
+		{name: "lpush_wrongtype", input: makeArrayBulkString([]string{"lpush", "s", "x"}), expect: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+
+	// a list larger than a node switches to quicklist
+	elems := []string{"rpush", "big"}
+	for i := 0; i < 1000; i++ {
+		elems = append(elems, strings.Repeat("x", 20))
+	}
+	runCases(t, conn, []testCase{
+		{name: "rpush_big", input: makeArrayBulkString(elems), expect: ":1000\r\n"},
+		{name: "encoding_quicklist", input: makeArrayBulkString([]string{"object", "encoding", "big"}), expect: makeBulkString("quicklist")},
+		{name: "ltrim_big", input: makeArrayBulkString([]string{"ltrim", "big", "0", "9"}), expect: "+OK\r\n"},
+		{name: "encoding_back_to_listpack", input: makeArrayBulkString([]string{"object", "encoding", "big"}), expect: makeBulkString("listpack")},
+	})
+}
+
+func TestBlockingLists(t *testing.T) {
+	a := startTestServer(t, ServerOpt{port: "6403"})
+	b := dialTestServer(t, "6403")
+	c := dialTestServer(t, "6403")
+
+	send := func(conn net.Conn, args ...string) {
+		t.Helper()
+		if _, err := conn.Write([]byte(makeArrayBulkString(args))); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectReply := func(conn net.Conn, expect string) {
+		t.Helper()
+		if got := readN(t, conn, len(expect)); got != expect {
+			t.Fatalf("expected %q got %q", expect, got)
+		}
+	}
+
+	// waiters are served in the order they blocked
+	send(a, "blpop", "q", "0")
+	send(b, "blpop", "other", "q", "0")
+	runCases(t, c, []testCase{
+		{name: "rpush", input: makeArrayBulkString([]string{"rpush", "q", "x", "y"}), expect: ":2\r\n"},
+	})
+	expectReply(a, "*2\r\n$1\r\nq\r\n$1\r\nx\r\n")
+	expectReply(b, "*2\r\n$1\r\nq\r\n$1\r\ny\r\n")
+
+	start := time.Now()
+	runCases(t, a, []testCase{
+		{name: "brpop_timeout", input: makeArrayBulkString([]string{"brpop", "q", "0.1"}), expect: "*-1\r\n"},
+	})
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("expected brpop to block for the timeout, returned after %v", elapsed)
+	}
+
+	send(a, "blmove", "src", "dst", "right", "left", "0")
+	send(b, "blmpop", "0", "2", "k1", "k2", "left", "count", "2")
+	runCases(t, c, []testCase{
+		{name: "rpush_src", input: makeArrayBulkString([]string{"rpush", "src", "v"}), expect: ":1\r\n"},
+		{name: "rpush_k2", input: makeArrayBulkString([]string{"rpush", "k2", "1", "2", "3"}), expect: ":3\r\n"},
+		{name: "dst", input: makeArrayBulkString([]string{"lrange", "dst", "0", "-1"}), expect: "*1\r\n$1\r\nv\r\n"},
+	})
+	expectReply(a, makeBulkString("v"))
+	expectReply(b, "*2\r\n$2\r\nk2\r\n*2\r\n$1\r\n1\r\n$1\r\n2\r\n")
+
+	// a closed connection stops waiting
+	d := dialTestServer(t, "6403")
+	send(d, "blpop", "gone", "0")
+	d.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	// also when it pipelined more commands after the blocking one
+	e := dialTestServer(t, "6403")
+	if _, err := e.Write([]byte(makeArrayBulkString([]string{"blpop", "piped", "0"}) + makeArrayBulkString([]string{"ping"}))); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	e.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	runCases(t, c, []testCase{
+		{name: "rpush_gone", input: makeArrayBulkString([]string{"rpush", "gone", "v"}), expect: ":1\r\n"},
+		{name: "not_consumed", input: makeArrayBulkString([]string{"llen", "gone"}), expect: ":1\r\n"},
+		{name: "lpush_piped", input: makeArrayBulkString([]string{"lpush", "piped", "v"}), expect: ":1\r\n"},
+		{name: "piped_not_consumed", input: makeArrayBulkString([]string{"llen", "piped"}), expect: ":1\r\n"},
+		{name: "timeout_negative", input: makeArrayBulkString([]string{"blpop", "q", "-1"}), expect: "-ERR timeout is negative\r\n"},
+		{name: "timeout_not_float", input: makeArrayBulkString([]string{"blpop", "q", "abc"}), expect: "-ERR timeout is not a float or out of range\r\n"},
+		{name: "blmpop_numkeys", input: makeArrayBulkString([]string{"blmpop", "0", "0", "k", "left"}), expect: "-ERR numkeys should be greater than 0\r\n"},
+		{name: "lmpop", input: makeArrayBulkString([]string{"lmpop", "2", "empty", "gone", "right"}), expect: "*2\r\n$4\r\ngone\r\n*1\r\n$1\r\nv\r\n"},
+		{name: "getkeys", input: makeArrayBulkString([]string{"command", "getkeys", "blmpop", "0", "2", "k1", "k2", "left"}), expect: "*2\r\n$2\r\nk1\r\n$2\r\nk2\r\n"},
+	})
+}
+
+func TestMulti(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6404"})
+
+	runCases(t, conn, []testCase{
+		{name: "exec_without_multi", input: makeArrayBulkString([]string{"exec"}), expect: "-ERR EXEC without MULTI\r\n"},
+		{name: "multi", input: makeArrayBulkString([]string{"multi"}), expect: "+OK\r\n"},
+		{name: "nested", input: makeArrayBulkString([]string{"multi"}), expect: "-ERR MULTI calls can not be nested\r\n"},
+		{name: "queue_set", input: makeArrayBulkString([]string{"set", "a", "1"}), expect: "+QUEUED\r\n"},
+		{name: "queue_blpop", input: makeArrayBulkString([]string{"blpop", "empty", "0"}), expect: "+QUEUED\r\n"},
+		{name: "queue_get", input: makeArrayBulkString([]string{"lpush", "a", "1"}), expect: "+QUEUED\r\n"},
+		{name: "exec", input: makeArrayBulkString([]string{"exec"}), expect: "*3\r\n+OK\r\n*-1\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{name: "multi_again", input: makeArrayBulkString([]string{"multi"}), expect: "+OK\r\n"},
+		{name: "queue_unknown", input: makeArrayBulkString([]string{"nosuchcommand"}), expect: "-ERR unknown command 'nosuchcommand', with args beginning with: \r\n"},
+		{name: "exec_abort", input: makeArrayBulkString([]string{"exec"}), expect: "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{name: "discard_without_multi", input: makeArrayBulkString([]string{"discard"}), expect: "-ERR DISCARD without MULTI\r\n"},
+		{name: "multi_discard", input: makeArrayBulkString([]string{"multi"}), expect: "+OK\r\n"},
+		{name: "queue_del", input: makeArrayBulkString([]string{"del", "a"}), expect: "+QUEUED\r\n"},
+		{name: "discard", input: makeArrayBulkString([]string{"discard"}), expect: "+OK\r\n"},
+		{name: "not_deleted", input: makeArrayBulkString([]string{"get", "a"}), expect: makeBulkString("1")},
+	})
+}
+
+type testCase struct {
+	name   string
+	input  string
+	expect string
+	wait   time.Duration
+}
+
+// runCases sends each input on conn in order and checks the reply.
+func runCases(t *testing.T, conn net.Conn, tests []testCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := conn.Write([]byte(tt.input)); err != nil {
 				t.Fatal(err)
 			}
 
-			if string(res[:n]) != tt.expect {
-				t.Fatalf("expected %q got %q", tt.expect, string(res[:n]))
+			got := readN(t, conn, len(tt.expect))
+			if got != tt.expect {
+				t.Fatalf("expected %q got %q", tt.expect, got)
 			}
 		})
 		<-time.After(tt.wait)
 	}
 }
 
+func startTestServer(t *testing.T, opt ServerOpt) net.Conn {
+	t.Helper()
+
+	go startServer(opt)
+	time.Sleep(10 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "0.0.0.0:"+opt.port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func dialTestServer(t *testing.T, port string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", "0.0.0.0:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readN(t *testing.T, conn net.Conn, n int) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	res := make([]byte, n)
+	if _, err := io.ReadFull(conn, res); err != nil {
+		t.Fatal(err)
+	}
+	return string(res)
+}
+
 func makeBulkString(s string) string {
 	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
 }
+
+func TestHashes(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6405"})
+
+	runCases(t, conn, []testCase{
+		{name: "hset", input: makeArrayBulkString([]string{"hset", "h", "a", "1", "b", "2"}), expect: ":2\r\n"},
+		{name: "hset_update", input: makeArrayBulkString([]string{"hset", "h", "a", "10", "c", "3"}), expect: ":1\r\n"},
+		{name: "hset_odd", input: makeArrayBulkString([]string{"hset", "h", "a", "1", "b"}), expect: "-ERR wrong number of arguments for 'hset' command\r\n"},
+		{name: "hget", input: makeArrayBulkString([]string{"hget", "h", "a"}), expect: makeBulkString("10")},
+		{name: "hget_missing", input: makeArrayBulkString([]string{"hget", "h", "z"}), expect: "$-1\r\n"},
+		{name: "hmget", input: makeArrayBulkString([]string{"hmget", "h", "b", "z"}), expect: "*2\r\n$1\r\n2\r\n$-1\r\n"},
+		{name: "hgetall", input: makeArrayBulkString([]string{"hgetall", "h"}), expect: "*6\r\n$1\r\na\r\n$2\r\n10\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\nc\r\n$1\r\n3\r\n"},
+		{name: "hlen", input: makeArrayBulkString([]string{"hlen", "h"}), expect: ":3\r\n"},
+		{name: "hsetnx", input: makeArrayBulkString([]string{"hsetnx", "h", "a", "x"}), expect: ":0\r\n"},
+		{name: "hincrby", input: makeArrayBulkString([]string{"hincrby", "h", "a", "-15"}), expect: ":-5\r\n"},
+		{name: "hincrby_new", input: makeArrayBulkString([]string{"hincrby", "h", "n", "7"}), expect: ":7\r\n"},
+		{name: "hincrby_overflow", input: makeArrayBulkString([]string{"hincrby", "h", "n", "9223372036854775807"}), expect: "-ERR increment or decrement would overflow\r\n"},
+		{name: "hincrbyfloat", input: makeArrayBulkString([]string{"hincrbyfloat", "h", "b", "0.5"}), expect: makeBulkString("2.5")},
+		{name: "hincrbyfloat_exp", input: makeArrayBulkString([]string{"hincrbyfloat", "h", "f", "5.0e3"}), expect: makeBulkString("5000")},
+		{name: "hincrbyfloat_tenth", input: makeArrayBulkString([]string{"hincrbyfloat", "h", "tenths", "0.1"}), expect: makeBulkString("0.1")},
+		{name: "hincrbyfloat_tenths", input: makeArrayBulkString([]string{"hincrbyfloat", "h", "tenths", "0.2"}), expect: makeBulkString("0.3")},
+		{name: "hdel_tenths", input: makeArrayBulkString([]string{"hdel", "h", "tenths"}), expect: ":1\r\n"},
+		{name: "hincrbyfloat_out_of_range", input: makeArrayBulkString([]string{"hincrbyfloat", "h", "b", "1e5000"}), expect: "-ERR value is not a valid float\r\n"},
+		{name: "hset_text", input: makeArrayBulkString([]string{"hset", "h", "t", "abc"}), expect: ":1\r\n"},
+		{name: "hincrby_not_integer", input: makeArrayBulkString([]string{"hincrby", "h", "t", "1"}), expect: "-ERR hash value is not an integer\r\n"},
+		{name: "hincrbyfloat_not_float", input: makeArrayBulkString([]string{"hincrbyfloat", "h", "t", "1"}), expect: "-ERR hash value is not a float\r\n"},
+		{name: "hincrbyfloat_inf", input: makeArrayBulkString([]string{"hincrbyfloat", "h", "b", "inf"}), expect: "-ERR increment would produce NaN or Infinity\r\n"},
+		{name: "hdel", input: makeArrayBulkString([]string{"hdel", "h", "a", "b", "z"}), expect: ":2\r\n"},
+		{name: "hkeys", input: makeArrayBulkString([]string{"hkeys", "h"}), expect: "*4\r\n$1\r\nc\r\n$1\r\nn\r\n$1\r\nf\r\n$1\r\nt\r\n"},
+		{name: "hscan", input: makeArrayBulkString([]string{"hscan", "h", "0", "match", "[cn]"}), expect: "*2\r\n$1\r\n0\r\n*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nn\r\n$1\r\n7\r\n"},
+		{name: "hscan_novalues", input: makeArrayBulkString([]string{"hscan", "h", "0", "novalues", "count", "1"}), expect: "*2\r\n$1\r\n0\r\n*4\r\n$1\r\nc\r\n$1\r\nn\r\n$1\r\nf\r\n$1\r\nt\r\n"},
+		{name: "hscan_type", input: makeArrayBulkString([]string{"hscan", "h", "0", "type", "hash"}), expect: "-ERR syntax error\r\n"},
+		{name: "encoding", input: makeArrayBulkString([]string{"object", "encoding", "h"}), expect: makeBulkString("listpack")},
+		{name: "type", input: makeArrayBulkString([]string{"type", "h"}), expect: "+hash\r\n"},
+		{name: "hrandfield_missing", input: makeArrayBulkString([]string{"hrandfield", "nohash"}), expect: "$-1\r\n"},
+		{name: "hrandfield_missing_count", input: makeArrayBulkString([]string{"hrandfield", "nohash", "3"}), expect: "*0\r\n"},
+		{name: "hrandfield_repeat", input: makeArrayBulkString([]string{"hset", "one", "k", "v"}), expect: ":1\r\n"},
+		{name: "hrandfield_negative", input: makeArrayBulkString([]string{"hrandfield", "one", "-3", "withvalues"}), expect: "*6\r\n$1\r\nk\r\n$1\r\nv\r\n$1\r\nk\r\n$1\r\nv\r\n$1\r\nk\r\n$1\r\nv\r\n"},
+		{name: "hrandfield_distinct", input: makeArrayBulkString([]string{"hrandfield", "one", "5"}), expect: "*1\r\n$1\r\nk\r\n"},
+		{name: "hrandfield_syntax", input: makeArrayBulkString([]string{"hrandfield", "one", "1", "values"}), expect: "-ERR syntax error\r\n"},
+		{name: "hdel_last", input: makeArrayBulkString([]string{"hdel", "one", "k"}), expect: ":1\r\n"},
+		{name: "emptied_hash_deleted", input: makeArrayBulkString([]string{"exists", "one"}), expect: ":0\r\n"},
+		{name: "hget_wrongtype", input: makeArrayBulkString([]string{"lpush", "l", "x"}), expect: ":1\r\n"},
+		{name: "hset_wrongtype", input: makeArrayBulkString([]string{"hset", "l", "a", "1"}), expect: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+
+	// too many fields or a too long value switch to a hash table
+	fields := []string{"hset", "big"}
+	for i := 0; i < 129; i++ {
+		fields = append(fields, fmt.Sprint(i), "v")
+	}
+	runCases(t, conn, []testCase{
+		{name: "hset_big", input: makeArrayBulkString(fields[:len(fields)-2]), expect: ":128\r\n"},
+		{name: "encoding_128", input: makeArrayBulkString([]string{"object", "encoding", "big"}), expect: makeBulkString("listpack")},
+		{name: "hset_129", input: makeArrayBulkString(fields), expect: ":1\r\n"},
+		{name: "encoding_hashtable", input: makeArrayBulkString([]string{"object", "encoding", "big"}), expect: makeBulkString("hashtable")},
+		{name: "hget_hashtable", input: makeArrayBulkString([]string{"hget", "big", "128"}), expect: makeBulkString("v")},
+		{name: "hset_long", input: makeArrayBulkString([]string{"hset", "long", "f", strings.Repeat("x", 65)}), expect: ":1\r\n"},
+		{name: "encoding_long", input: makeArrayBulkString([]string{"object", "encoding", "long"}), expect: makeBulkString("hashtable")},
+		{name: "hstrlen", input: makeArrayBulkString([]string{"hstrlen", "long", "f"}), expect: ":65\r\n"},
+		{name: "hexists", input: makeArrayBulkString([]string{"hexists", "long", "g"}), expect: ":0\r\n"},
+	})
+
+	// HSCAN walks a hash table with cursors, every field is returned
+	rr := NewRESPReader(conn)
+	seen := map[string]bool{}
+	cursor := "0"
+	for {
+		conn.Write([]byte(makeArrayBulkString([]string{"hscan", "big", cursor, "novalues"})))
+		v, err := rr.ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fields, err := v.Array[1].Strings()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range fields {
+			seen[field] = true
+		}
+
+		cursor = v.Array[0].Str
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != 129 {
+		t.Fatalf("hscan returned %d fields, want 129", len(seen))
+	}
+}
+
+func TestHashFieldExpire(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6406"})
+
+	hcmd := func(args ...string) string { return makeArrayBulkString(args) }
+	runCases(t, conn, []testCase{
+		{name: "hset", input: hcmd("hset", "h", "a", "1", "b", "2", "c", "3"), expect: ":3\r\n"},
+		{name: "hexpire", input: hcmd("hexpire", "h", "100", "fields", "2", "a", "z"), expect: "*2\r\n:1\r\n:-2\r\n"},
+		{name: "encoding", input: hcmd("object", "encoding", "h"), expect: makeBulkString("listpackex")},
+		{name: "httl", input: hcmd("httl", "h", "fields", "3", "a", "b", "z"), expect: "*3\r\n:100\r\n:-1\r\n:-2\r\n"},
+		{name: "hexpire_gt", input: hcmd("hexpire", "h", "50", "gt", "fields", "1", "a"), expect: "*1\r\n:0\r\n"},
+		{name: "hexpire_lt", input: hcmd("hexpire", "h", "50", "lt", "fields", "1", "a"), expect: "*1\r\n:1\r\n"},
+		{name: "hexpire_nx", input: hcmd("hexpire", "h", "70", "nx", "fields", "1", "a"), expect: "*1\r\n:0\r\n"},
+		{name: "hexpire_xx", input: hcmd("hexpire", "h", "70", "xx", "fields", "1", "b"), expect: "*1\r\n:0\r\n"},
+		{name: "hexpiretime", input: hcmd("hpexpireat", "h", "9999999999999", "fields", "1", "b"), expect: "*1\r\n:1\r\n"},
+		{name: "hpexpiretime", input: hcmd("hpexpiretime", "h", "fields", "1", "b"), expect: "*1\r\n:9999999999999\r\n"},
+		{name: "hexpiretime_rounded", input: hcmd("hexpiretime", "h", "fields", "1", "b"), expect: "*1\r\n:10000000000\r\n"},
+		{name: "hpersist", input: hcmd("hpersist", "h", "fields", "3", "a", "b", "c"), expect: "*3\r\n:1\r\n:1\r\n:-1\r\n"},
+		{name: "hexpire_past", input: hcmd("hexpire", "h", "0", "fields", "1", "c"), expect: "*1\r\n:2\r\n"},
+		{name: "hexists_deleted", input: hcmd("hexists", "h", "c"), expect: ":0\r\n"},
+		{name: "hexpire_missing_key", input: hcmd("hexpire", "nohash", "10", "fields", "1", "a"), expect: "*1\r\n:-2\r\n"},
+		{name: "hexpire_numfields", input: hcmd("hexpire", "h", "10", "fields", "2", "a"), expect: "-ERR The `numfields` parameter must match the number of arguments\r\n"},
+		{name: "hexpire_no_fields", input: hcmd("hexpire", "h", "10", "nx", "a", "1", "b"), expect: "-ERR Mandatory argument FIELDS is missing or not at the right position\r\n"},
+		{name: "hexpire_negative", input: hcmd("hexpire", "h", "-1", "fields", "1", "a"), expect: "-ERR invalid expire time, must be >= 0\r\n"},
+		{name: "hexpire_too_big", input: hcmd("hexpire", "h", "9999999999999", "fields", "1", "a"), expect: "-ERR invalid expire time in 'hexpire' command\r\n"},
+		{name: "hpexpire", input: hcmd("hpexpire", "h", "50", "fields", "1", "a"), expect: "*1\r\n:1\r\n", wait: 100 * time.Millisecond},
+		{name: "hget_expired", input: hcmd("hget", "h", "a"), expect: "$-1\r\n"},
+		{name: "hlen_after_expire", input: hcmd("hlen", "h"), expect: ":1\r\n"},
+		{name: "hgetex_px", input: hcmd("hgetex", "h", "px", "50", "fields", "2", "b", "z"), expect: "*2\r\n$1\r\n2\r\n$-1\r\n", wait: 100 * time.Millisecond},
+		{name: "last_field_expired", input: hcmd("exists", "h"), expect: ":0\r\n"},
+		{name: "hsetex_fnx", input: hcmd("hsetex", "h2", "fnx", "ex", "100", "fields", "2", "x", "1", "y", "2"), expect: ":1\r\n"},
+		{name: "hsetex_fnx_exists", input: hcmd("hsetex", "h2", "fnx", "fields", "2", "x", "3", "w", "4"), expect: ":0\r\n"},
+		{name: "hsetex_fxx_missing", input: hcmd("hsetex", "h2", "fxx", "fields", "2", "x", "3", "w", "4"), expect: ":0\r\n"},
+		{name: "hsetex_keepttl", input: hcmd("hsetex", "h2", "fxx", "keepttl", "fields", "1", "x", "5"), expect: ":1\r\n"},
+		{name: "httl_kept", input: hcmd("httl", "h2", "fields", "1", "x"), expect: "*1\r\n:100\r\n"},
+		{name: "hset_clears_ttl", input: hcmd("hset", "h2", "x", "6"), expect: ":0\r\n"},
+		{name: "httl_cleared", input: hcmd("httl", "h2", "fields", "1", "x"), expect: "*1\r\n:-1\r\n"},
+		{name: "hincrby_keeps_ttl", input: hcmd("hincrby", "h2", "y", "1"), expect: ":3\r\n"},
+		{name: "httl_after_hincrby", input: hcmd("httl", "h2", "fields", "1", "y"), expect: "*1\r\n:100\r\n"},
+		{name: "hgetex_persist", input: hcmd("hgetex", "h2", "persist", "fields", "1", "y"), expect: "*1\r\n$1\r\n3\r\n"},
+		{name: "httl_persisted", input: hcmd("httl", "h2", "fields", "1", "y"), expect: "*1\r\n:-1\r\n"},
+		{name: "hgetex_keepttl", input: hcmd("hgetex", "h2", "keepttl", "fields", "1", "y"), expect: "-ERR syntax error\r\n"},
+		{name: "hsetex_invalid_expire", input: hcmd("hsetex", "h2", "ex", "0", "fields", "1", "x", "1"), expect: "-ERR invalid expire time in 'hsetex' command\r\n"},
+		{name: "hsetex_numfields", input: hcmd("hsetex", "h2", "fields", "2", "x", "1"), expect: "-ERR The `numfields` parameter must match the number of arguments\r\n"},
+		{name: "httl_wrongtype", input: hcmd("set", "s", "v"), expect: "+OK\r\n"},
+		{name: "hexpire_wrongtype", input: hcmd("hexpire", "s", "10", "fields", "1", "a"), expect: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+
+	// a hash table keeps the TTLs, and fields nobody accesses are
+	// reclaimed by the active expire cycle
+	fields := []string{"hset", "big"}
+	for i := 0; i < 200; i++ {
+		fields = append(fields, fmt.Sprint(i), "v")
+	}
+	runCases(t, conn, []testCase{
+		{name: "flushdb", input: hcmd("flushdb"), expect: "+OK\r\n"},
+		{name: "hset_big", input: makeArrayBulkString(fields), expect: ":200\r\n"},
+		{name: "encoding_hashtable", input: hcmd("object", "encoding", "big"), expect: makeBulkString("hashtable")},
+		{name: "hpexpire_big", input: hcmd("hpexpire", "big", "50", "fields", "2", "1", "2"), expect: "*2\r\n:1\r\n:1\r\n"},
+		{name: "hset_short", input: hcmd("hset", "short", "f", "v"), expect: ":1\r\n"},
+		{name: "hpexpire_short", input: hcmd("hpexpire", "short", "50", "fields", "1", "f"), expect: "*1\r\n:1\r\n", wait: 300 * time.Millisecond},
+		{name: "short_reclaimed", input: hcmd("dbsize"), expect: ":1\r\n"},
+		{name: "hlen_big", input: hcmd("hlen", "big"), expect: ":198\r\n"},
+	})
+}
+
+func TestSets(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6407"})
+
+	cmd := func(args ...string) string { return makeArrayBulkString(args) }
+	runCases(t, conn, []testCase{
+		{name: "sadd", input: cmd("sadd", "s", "3", "1", "2", "1"), expect: ":3\r\n"},
+		{name: "encoding_intset", input: cmd("object", "encoding", "s"), expect: makeBulkString("intset")},
+		{name: "smembers_sorted", input: cmd("smembers", "s"), expect: "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n"},
+		{name: "sismember", input: cmd("sismember", "s", "2"), expect: ":1\r\n"},
+		{name: "sismember_not_int", input: cmd("sismember", "s", "02"), expect: ":0\r\n"},
+		{name: "smismember", input: cmd("smismember", "s", "1", "9"), expect: "*2\r\n:1\r\n:0\r\n"},
+		{name: "sadd_string", input: cmd("sadd", "s", "a"), expect: ":1\r\n"},
+		{name: "encoding_listpack", input: cmd("object", "encoding", "s"), expect: makeBulkString("listpack")},
+		{name: "scard", input: cmd("scard", "s"), expect: ":4\r\n"},
+		{name: "srem", input: cmd("srem", "s", "a", "z"), expect: ":1\r\n"},
+		{name: "sadd_long", input: cmd("sadd", "s", strings.Repeat("x", 65)), expect: ":1\r\n"},
+		{name: "encoding_hashtable", input: cmd("object", "encoding", "s"), expect: makeBulkString("hashtable")},
+		{name: "srem_long", input: cmd("srem", "s", strings.Repeat("x", 65)), expect: ":1\r\n"},
+		{name: "sadd_t", input: cmd("sadd", "t", "2", "3", "4"), expect: ":3\r\n"},
+		{name: "sinterstore", input: cmd("sinterstore", "i", "s", "t"), expect: ":2\r\n"},
+		{name: "sinterstore_result", input: cmd("smembers", "i"), expect: "*2\r\n$1\r\n2\r\n$1\r\n3\r\n"},
+		{name: "sintercard", input: cmd("sintercard", "2", "s", "t"), expect: ":2\r\n"},
+		{name: "sintercard_limit", input: cmd("sintercard", "2", "s", "t", "limit", "1"), expect: ":1\r\n"},
+		{name: "sintercard_negative", input: cmd("sintercard", "1", "s", "limit", "-1"), expect: "-ERR LIMIT can't be negative\r\n"},
+		{name: "sintercard_numkeys", input: cmd("sintercard", "0", "s"), expect: "-ERR numkeys should be greater than 0\r\n"},
+		{name: "sinter_missing", input: cmd("sinter", "s", "missing"), expect: "*0\r\n"},
+		{name: "sunionstore", input: cmd("sunionstore", "u", "s", "t", "missing"), expect: ":4\r\n"},
+		{name: "sdiffstore", input: cmd("sdiffstore", "d", "u", "t"), expect: ":1\r\n"},
+		{name: "sdiff", input: cmd("sdiff", "d"), expect: "*1\r\n$1\r\n1\r\n"},
+		{name: "sinterstore_empty", input: cmd("sinterstore", "d", "s", "missing"), expect: ":0\r\n"},
+		{name: "empty_store_deleted", input: cmd("exists", "d"), expect: ":0\r\n"},
+		{name: "smove", input: cmd("smove", "t", "d", "4"), expect: ":1\r\n"},
+		{name: "smove_missing", input: cmd("smove", "t", "d", "4"), expect: ":0\r\n"},
+		{name: "smove_result", input: cmd("smembers", "d"), expect: "*1\r\n$1\r\n4\r\n"},
+		{name: "spop_all", input: cmd("spop", "d", "5"), expect: "*1\r\n$1\r\n4\r\n"},
+		{name: "spop_deleted", input: cmd("exists", "d"), expect: ":0\r\n"},
+		{name: "spop_missing", input: cmd("spop", "d"), expect: "$-1\r\n"},
+		{name: "spop_negative", input: cmd("spop", "s", "-1"), expect: "-ERR value is out of range, must be positive\r\n"},
+		{name: "srandmember_repeat", input: cmd("sadd", "one", "m"), expect: ":1\r\n"},
+		{name: "srandmember_negative", input: cmd("srandmember", "one", "-3"), expect: "*3\r\n$1\r\nm\r\n$1\r\nm\r\n$1\r\nm\r\n"},
+		{name: "srandmember_distinct", input: cmd("srandmember", "one", "3"), expect: "*1\r\n$1\r\nm\r\n"},
+		{name: "srandmember_missing", input: cmd("srandmember", "missing"), expect: "$-1\r\n"},
+		{name: "spop_one", input: cmd("spop", "one"), expect: makeBulkString("m")},
+		{name: "sscan", input: cmd("sscan", "u", "0", "match", "[12]"), expect: "*2\r\n$1\r\n0\r\n*2\r\n$1\r\n1\r\n$1\r\n2\r\n"},
+		{name: "type", input: cmd("type", "u"), expect: "+set\r\n"},
+		{name: "sadd_wrongtype", input: cmd("set", "str", "v"), expect: "+OK\r\n"},
+		{name: "sinter_wrongtype", input: cmd("sinter", "missing", "str"), expect: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+
+	// an intset past set-max-intset-entries becomes a hash table
+	members := []string{"sadd", "big"}
+	for i := 0; i < 513; i++ {
+		members = append(members, fmt.Sprint(i))
+	}
+	runCases(t, conn, []testCase{
+		{name: "sadd_512", input: makeArrayBulkString(members[:len(members)-1]), expect: ":512\r\n"},
+		{name: "encoding_512", input: cmd("object", "encoding", "big"), expect: makeBulkString("intset")},
+		{name: "sadd_513", input: makeArrayBulkString(members), expect: ":1\r\n"},
+		{name: "encoding_513", input: cmd("object", "encoding", "big"), expect: makeBulkString("hashtable")},
+		{name: "scard_big", input: cmd("scard", "big"), expect: ":513\r\n"},
+	})
+}
+
+func TestSortedSets(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6408"})
+
+	cmd := func(args ...string) string { return makeArrayBulkString(args) }
+	arr := func(elems ...string) string { return makeArrayBulkString(elems) }
+	runCases(t, conn, []testCase{
+		{name: "zadd", input: cmd("zadd", "z", "1", "a", "2", "b", "3", "c"), expect: ":3\r\n"},
+		{name: "encoding_listpack", input: cmd("object", "encoding", "z"), expect: makeBulkString("listpack")},
+		{name: "type", input: cmd("type", "z"), expect: "+zset\r\n"},
+		{name: "zadd_update", input: cmd("zadd", "z", "0", "c", "4", "d"), expect: ":1\r\n"},
+		{name: "zadd_ch", input: cmd("zadd", "z", "ch", "5", "c", "4", "d"), expect: ":1\r\n"},
+		{name: "zadd_nx", input: cmd("zadd", "z", "nx", "9", "c", "9", "e"), expect: ":1\r\n"},
+		{name: "zadd_xx", input: cmd("zadd", "z", "xx", "8", "e", "9", "f"), expect: ":0\r\n"},
+		{name: "zadd_gt", input: cmd("zadd", "z", "gt", "ch", "7", "e", "6", "c"), expect: ":1\r\n"},
+		{name: "zadd_lt", input: cmd("zadd", "z", "lt", "ch", "9", "e"), expect: ":0\r\n"},
+		{name: "zadd_incr", input: cmd("zadd", "z", "incr", "0.5", "a"), expect: makeBulkString("1.5")},
+		{name: "zadd_incr_nop", input: cmd("zadd", "z", "nx", "incr", "1", "a"), expect: "$-1\r\n"},
+		{name: "zadd_nx_xx", input: cmd("zadd", "z", "nx", "xx", "1", "a"), expect: "-ERR XX and NX options at the same time are not compatible\r\n"},
+		{name: "zadd_gt_lt", input: cmd("zadd", "z", "gt", "lt", "1", "a"), expect: "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n"},
+		{name: "zadd_incr_pairs", input: cmd("zadd", "z", "incr", "1", "a", "2", "b"), expect: "-ERR INCR option supports a single increment-element pair\r\n"},
+		{name: "zadd_not_float", input: cmd("zadd", "z", "x", "a"), expect: "-ERR value is not a valid float\r\n"},
+		{name: "zadd_odd", input: cmd("zadd", "z", "1", "a", "2"), expect: "-ERR syntax error\r\n"},
+		{name: "zrange_withscores", input: cmd("zrange", "z", "0", "-1", "withscores"), expect: arr("a", "1.5", "b", "2", "d", "4", "c", "6", "e", "8")},
+		{name: "zrange_rev", input: cmd("zrange", "z", "0", "1", "rev"), expect: arr("e", "c")},
+		{name: "zrevrange", input: cmd("zrevrange", "z", "-2", "-1"), expect: arr("b", "a")},
+		{name: "zrange_byscore", input: cmd("zrange", "z", "(1.5", "6", "byscore"), expect: arr("b", "d", "c")},
+		{name: "zrange_byscore_rev_limit", input: cmd("zrange", "z", "+inf", "-inf", "byscore", "rev", "limit", "1", "2"), expect: arr("c", "d")},
+		{name: "zrange_limit_rank", input: cmd("zrange", "z", "0", "1", "limit", "0", "1"), expect: "-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n"},
+		{name: "zrange_not_float", input: cmd("zrange", "z", "a", "1", "byscore"), expect: "-ERR min or max is not a float\r\n"},
+		{name: "zrangebyscore", input: cmd("zrangebyscore", "z", "-inf", "(4", "withscores", "limit", "1", "-1"), expect: arr("b", "2")},
+		{name: "zrevrangebyscore", input: cmd("zrevrangebyscore", "z", "6", "4"), expect: arr("c", "d")},
+		{name: "zrank", input: cmd("zrank", "z", "d"), expect: ":2\r\n"},
+		{name: "zrevrank_withscore", input: cmd("zrevrank", "z", "d", "withscore"), expect: "*2\r\n:2\r\n$1\r\n4\r\n"},
+		{name: "zrank_missing", input: cmd("zrank", "z", "x"), expect: "$-1\r\n"},
+		{name: "zrank_missing_withscore", input: cmd("zrank", "z", "x", "withscore"), expect: "*-1\r\n"},
+		{name: "zscore", input: cmd("zscore", "z", "a"), expect: makeBulkString("1.5")},
+		{name: "zmscore", input: cmd("zmscore", "z", "b", "x"), expect: "*2\r\n$1\r\n2\r\n$-1\r\n"},
+		{name: "zincrby", input: cmd("zincrby", "z", "-1", "e"), expect: makeBulkString("7")},
+		{name: "zcount", input: cmd("zcount", "z", "2", "(6"), expect: ":2\r\n"},
+		{name: "zcard", input: cmd("zcard", "z"), expect: ":5\r\n"},
+		{name: "zrem", input: cmd("zrem", "z", "a", "x"), expect: ":1\r\n"},
+		{name: "zrangestore", input: cmd("zrangestore", "dst", "z", "1", "2"), expect: ":2\r\n"},
+		{name: "zrangestore_result", input: cmd("zrange", "dst", "0", "-1"), expect: arr("d", "c")},
+		{name: "zrangestore_empty", input: cmd("zrangestore", "dst", "z", "10", "20"), expect: ":0\r\n"},
+		{name: "zrangestore_deleted", input: cmd("exists", "dst"), expect: ":0\r\n"},
+		{name: "zrem_all", input: cmd("zrem", "z", "b", "c", "d", "e"), expect: ":4\r\n"},
+		{name: "zrem_deleted", input: cmd("exists", "z"), expect: ":0\r\n"},
+		{name: "zadd_xx_missing", input: cmd("zadd", "z", "xx", "1", "a"), expect: ":0\r\n"},
+		{name: "zadd_lex", input: cmd("zadd", "lex", "0", "a", "0", "b", "0", "c", "0", "d"), expect: ":4\r\n"},
+		{name: "zrangebylex", input: cmd("zrangebylex", "lex", "(a", "[c"), expect: arr("b", "c")},
+		{name: "zrevrangebylex", input: cmd("zrevrangebylex", "lex", "+", "(b", "limit", "0", "1"), expect: arr("d")},
+		{name: "zrange_bylex", input: cmd("zrange", "lex", "-", "+", "bylex", "limit", "2", "5"), expect: arr("c", "d")},
+		{name: "zrange_bylex_withscores", input: cmd("zrange", "lex", "-", "+", "bylex", "withscores"), expect: "-ERR syntax error, WITHSCORES not supported in combination with BYLEX\r\n"},
+		{name: "zlexcount", input: cmd("zlexcount", "lex", "[b", "+"), expect: ":3\r\n"},
+		{name: "zlexcount_invalid", input: cmd("zlexcount", "lex", "b", "+"), expect: "-ERR min or max not valid string range item\r\n"},
+		{name: "zadd_long_member", input: cmd("zadd", "lex", "0", strings.Repeat("x", 65)), expect: ":1\r\n"},
+		{name: "encoding_skiplist", input: cmd("object", "encoding", "lex"), expect: makeBulkString("skiplist")},
+		{name: "zrangebylex_skiplist", input: cmd("zrangebylex", "lex", "[c", "[d"), expect: arr("c", "d")},
+		{name: "zlexcount_skiplist", input: cmd("zlexcount", "lex", "(a", "+"), expect: ":4\r\n"},
+		{name: "set_string", input: cmd("set", "str", "v"), expect: "+OK\r\n"},
+		{name: "zscore_wrongtype", input: cmd("zscore", "str", "a"), expect: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+
+	// past zset-max-listpack-entries members the set becomes a skiplist,
+	// with ranks in O(log n)
+	args := []string{"zadd", "big"}
+	for i := 0; i < 200; i++ {
+		args = append(args, fmt.Sprint(i), fmt.Sprintf("m%03d", i))
+	}
+	runCases(t, conn, []testCase{
+		{name: "zadd_big", input: makeArrayBulkString(args), expect: ":200\r\n"},
+		{name: "encoding_big", input: cmd("object", "encoding", "big"), expect: makeBulkString("skiplist")},
+		{name: "zrank_big", input: cmd("zrank", "big", "m150"), expect: ":150\r\n"},
+		{name: "zrevrank_big", input: cmd("zrevrank", "big", "m150"), expect: ":49\r\n"},
+		{name: "zcount_big", input: cmd("zcount", "big", "(10", "100"), expect: ":90\r\n"},
+		{name: "zrange_big", input: cmd("zrange", "big", "-2", "-1", "withscores"), expect: arr("m198", "198", "m199", "199")},
+		{name: "zrange_big_rev", input: cmd("zrange", "big", "(5", "0", "byscore", "rev", "limit", "1", "2"), expect: arr("m003", "m002")},
+		{name: "zincrby_big", input: cmd("zincrby", "big", "1000", "m000"), expect: makeBulkString("1000")},
+		{name: "zrank_big_moved", input: cmd("zrank", "big", "m000"), expect: ":199\r\n"},
+		{name: "zrem_big", input: cmd("zrem", "big", "m001"), expect: ":1\r\n"},
+		{name: "zrange_big_first", input: cmd("zrange", "big", "0", "0"), expect: arr("m002")},
+	})
+}
+
+func TestSortedSetOps(t *testing.T) {
+	a := startTestServer(t, ServerOpt{port: "6409"})
+	b := dialTestServer(t, "6409")
+
+	cmd := func(args ...string) string { return makeArrayBulkString(args) }
+	arr := func(elems ...string) string { return makeArrayBulkString(elems) }
+	runCases(t, a, []testCase{
+		{name: "zadd_z1", input: cmd("zadd", "z1", "1", "a", "2", "b", "3", "c"), expect: ":3\r\n"},
+		{name: "zadd_z2", input: cmd("zadd", "z2", "10", "b", "20", "c", "30", "d"), expect: ":3\r\n"},
+		{name: "sadd_s", input: cmd("sadd", "s", "c", "d"), expect: ":2\r\n"},
+		{name: "zunion", input: cmd("zunion", "2", "z1", "z2", "withscores"), expect: arr("a", "1", "b", "12", "c", "23", "d", "30")},
+		{name: "zunion_weights_max", input: cmd("zunion", "2", "z1", "z2", "weights", "10", "1", "aggregate", "max", "withscores"), expect: arr("a", "10", "b", "20", "c", "30", "d", "30")},
+		{name: "zinter_set", input: cmd("zinter", "3", "z1", "z2", "s", "withscores"), expect: arr("c", "24")},
+		{name: "zinterstore_min", input: cmd("zinterstore", "dst", "2", "z1", "z2", "aggregate", "min"), expect: ":2\r\n"},
+		{name: "zinterstore_result", input: cmd("zrange", "dst", "0", "-1", "withscores"), expect: arr("b", "2", "c", "3")},
+		{name: "zunionstore", input: cmd("zunionstore", "dst", "2", "z1", "missing"), expect: ":3\r\n"},
+		{name: "zinterstore_empty", input: cmd("zinterstore", "dst", "2", "z1", "missing"), expect: ":0\r\n"},
+		{name: "zinterstore_deleted", input: cmd("exists", "dst"), expect: ":0\r\n"},
+		{name: "zdiff", input: cmd("zdiff", "2", "z1", "z2", "withscores"), expect: arr("a", "1")},
+		{name: "zdiffstore", input: cmd("zdiffstore", "dst", "2", "z2", "s"), expect: ":1\r\n"},
+		{name: "zdiff_weights", input: cmd("zdiff", "1", "z1", "weights", "1"), expect: "-ERR syntax error\r\n"},
+		{name: "zintercard", input: cmd("zintercard", "2", "z1", "z2"), expect: ":2\r\n"},
+		{name: "zintercard_limit", input: cmd("zintercard", "2", "z1", "z2", "limit", "1"), expect: ":1\r\n"},
+		{name: "zunion_numkeys", input: cmd("zunion", "0", "z1"), expect: "-ERR at least 1 input key is needed for 'zunion' command\r\n"},
+		{name: "zunion_weight", input: cmd("zunion", "1", "z1", "weights", "x"), expect: "-ERR weight value is not a float\r\n"},
+		{name: "zunion_aggregate", input: cmd("zunion", "1", "z1", "aggregate", "avg"), expect: "-ERR syntax error\r\n"},
+		{name: "zunionstore_withscores", input: cmd("zunionstore", "dst", "1", "z1", "withscores"), expect: "-ERR syntax error\r\n"},
+		{name: "set_str", input: cmd("set", "str", "v"), expect: "+OK\r\n"},
+		{name: "zunion_wrongtype", input: cmd("zunion", "2", "z1", "str"), expect: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{name: "getkeys", input: cmd("command", "getkeys", "zunionstore", "dst", "2", "k1", "k2"), expect: arr("dst", "k1", "k2")},
+
+		{name: "zpopmin", input: cmd("zpopmin", "z1"), expect: arr("a", "1")},
+		{name: "zpopmax_count", input: cmd("zpopmax", "z2", "2"), expect: arr("d", "30", "c", "20")},
+		{name: "zpopmin_negative", input: cmd("zpopmin", "z1", "-1"), expect: "-ERR value is out of range, must be positive\r\n"},
+		{name: "zpopmin_missing", input: cmd("zpopmin", "missing"), expect: "*0\r\n"},
+		{name: "zmpop", input: cmd("zmpop", "2", "missing", "z1", "max", "count", "5"), expect: "*2\r\n$2\r\nz1\r\n*2\r\n" + arr("c", "3") + arr("b", "2")},
+		{name: "zmpop_deleted", input: cmd("exists", "z1"), expect: ":0\r\n"},
+		{name: "zmpop_none", input: cmd("zmpop", "1", "z1", "min"), expect: "*-1\r\n"},
+		{name: "zmpop_direction", input: cmd("zmpop", "1", "z1", "left"), expect: "-ERR syntax error\r\n"},
+		{name: "zmpop_count", input: cmd("zmpop", "1", "z1", "min", "count", "0"), expect: "-ERR count should be greater than 0\r\n"},
+		{name: "zrandmember", input: cmd("zrandmember", "z2"), expect: makeBulkString("b")},
+		{name: "zrandmember_negative", input: cmd("zrandmember", "z2", "-2", "withscores"), expect: arr("b", "10", "b", "10")},
+		{name: "zrandmember_distinct", input: cmd("zrandmember", "z2", "5"), expect: arr("b")},
+		{name: "zrandmember_missing", input: cmd("zrandmember", "missing", "1"), expect: "*0\r\n"},
+	})
+
+	send := func(conn net.Conn, args ...string) {
+		t.Helper()
+		if _, err := conn.Write([]byte(makeArrayBulkString(args))); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectReply := func(conn net.Conn, expect string) {
+		t.Helper()
+		if got := readN(t, conn, len(expect)); got != expect {
+			t.Fatalf("expected %q got %q", expect, got)
+		}
+	}
+
+	// blocked clients are served by any command creating the sorted set,
+	// and are not served by a value of another type
+	send(b, "bzpopmin", "q", "0")
+	runCases(t, a, []testCase{
+		{name: "rpush_q", input: cmd("rpush", "q", "x"), expect: ":1\r\n"},
+		{name: "del_q", input: cmd("del", "q"), expect: ":1\r\n"},
+		{name: "zadd_q", input: cmd("zadd", "q", "2", "x", "1", "y"), expect: ":2\r\n"},
+	})
+	expectReply(b, "*3\r\n$1\r\nq\r\n$1\r\ny\r\n"+makeBulkString("1"))
+
+	send(b, "bzmpop", "0", "1", "r", "max")
+	runCases(t, a, []testCase{
+		{name: "zunionstore_r", input: cmd("zunionstore", "r", "1", "q"), expect: ":1\r\n"},
+	})
+	expectReply(b, "*2\r\n$1\r\nr\r\n*1\r\n*2\r\n$1\r\nx\r\n"+makeBulkString("2"))
+
+	start := time.Now()
+	runCases(t, b, []testCase{
+		{name: "bzpopmax_timeout", input: cmd("bzpopmax", "r", "0.1"), expect: "*-1\r\n"},
+	})
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("expected bzpopmax to block for the timeout, returned after %v", elapsed)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6410"})
+
+	cmd := func(args ...string) string { return makeArrayBulkString(args) }
+	arr := func(elems ...string) string { return makeArrayBulkString(elems) }
+	entry := func(id string, fields ...string) string { return "*2\r\n" + makeBulkString(id) + arr(fields...) }
+	runCases(t, conn, []testCase{
+		{name: "xadd", input: cmd("xadd", "s", "1-1", "a", "1"), expect: makeBulkString("1-1")},
+		{name: "xadd_partial", input: cmd("xadd", "s", "1-*", "b", "2"), expect: makeBulkString("1-2")},
+		{name: "xadd_ms", input: cmd("xadd", "s", "2", "a", "3", "b", "4"), expect: makeBulkString("2-0")},
+		{name: "xadd_smaller", input: cmd("xadd", "s", "1-5", "a", "1"), expect: "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{name: "xadd_partial_smaller", input: cmd("xadd", "s", "1-*", "a", "1"), expect: "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{name: "xadd_zero", input: cmd("xadd", "other", "0-0", "a", "1"), expect: "-ERR The ID specified in XADD must be greater than 0-0\r\n"},
+		{name: "xadd_zero_partial", input: cmd("xadd", "other", "0-*", "a", "1"), expect: makeBulkString("0-1")},
+		{name: "xadd_invalid", input: cmd("xadd", "s", "1-x", "a", "1"), expect: "-ERR Invalid stream ID specified as stream command argument\r\n"},
+		{name: "xadd_odd", input: cmd("xadd", "s", "3-0", "a", "1", "b"), expect: "-ERR wrong number of arguments for 'xadd' command\r\n"},
+		{name: "xadd_nomkstream", input: cmd("xadd", "missing", "nomkstream", "*", "a", "1"), expect: "$-1\r\n"},
+		{name: "xadd_limit", input: cmd("xadd", "s", "maxlen", "5", "limit", "10", "*", "a", "1"), expect: "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n"},
+		{name: "xadd_maxlen_minid", input: cmd("xadd", "s", "maxlen", "5", "minid", "1", "*", "a", "1"), expect: "-ERR syntax error, MAXLEN and MINID options at the same time are not compatible\r\n"},
+		{name: "xadd_maxlen_negative", input: cmd("xadd", "s", "maxlen", "-1", "*", "a", "1"), expect: "-ERR The MAXLEN argument must be >= 0.\r\n"},
+		{name: "xlen", input: cmd("xlen", "s"), expect: ":3\r\n"},
+		{name: "xlen_missing", input: cmd("xlen", "missing"), expect: ":0\r\n"},
+
+		{name: "xrange", input: cmd("xrange", "s", "-", "+"), expect: "*3\r\n" + entry("1-1", "a", "1") + entry("1-2", "b", "2") + entry("2-0", "a", "3", "b", "4")},
+		{name: "xrange_ms", input: cmd("xrange", "s", "1", "1"), expect: "*2\r\n" + entry("1-1", "a", "1") + entry("1-2", "b", "2")},
+		{name: "xrange_exclusive", input: cmd("xrange", "s", "(1-1", "+", "count", "1"), expect: "*1\r\n" + entry("1-2", "b", "2")},
+		{name: "xrange_count_zero", input: cmd("xrange", "s", "-", "+", "count", "0"), expect: "*-1\r\n"},
+		{name: "xrange_empty", input: cmd("xrange", "s", "3", "+"), expect: "*0\r\n"},
+		{name: "xrange_missing", input: cmd("xrange", "missing", "-", "+"), expect: "*0\r\n"},
+		{name: "xrange_invalid_end", input: cmd("xrange", "s", "-", "(0-0"), expect: "-ERR invalid end ID for the interval\r\n"},
+		{name: "xrange_syntax", input: cmd("xrange", "s", "-", "+", "limit", "1"), expect: "-ERR syntax error\r\n"},
+		{name: "xrevrange", input: cmd("xrevrange", "s", "+", "-", "count", "2"), expect: "*2\r\n" + entry("2-0", "a", "3", "b", "4") + entry("1-2", "b", "2")},
+
+		{name: "xdel", input: cmd("xdel", "s", "1-2", "9-9"), expect: ":1\r\n"},
+		{name: "xdel_invalid", input: cmd("xdel", "s", "+"), expect: "-ERR Invalid stream ID specified as stream command argument\r\n"},
+		{name: "xrange_deleted", input: cmd("xrange", "s", "-", "+"), expect: "*2\r\n" + entry("1-1", "a", "1") + entry("2-0", "a", "3", "b", "4")},
+		{name: "xadd_maxlen", input: cmd("xadd", "s", "maxlen", "=", "1", "3-0", "c", "5"), expect: makeBulkString("3-0")},
+		{name: "xlen_trimmed", input: cmd("xlen", "s"), expect: ":1\r\n"},
+		{name: "xadd_4", input: cmd("xadd", "s", "4-0", "d", "6"), expect: makeBulkString("4-0")},
+		{name: "xtrim_minid", input: cmd("xtrim", "s", "minid", "4"), expect: ":1\r\n"},
+		{name: "xtrim_strategy", input: cmd("xtrim", "s", "count", "1"), expect: "-ERR syntax error\r\n"},
+		{name: "xtrim_approx", input: cmd("xtrim", "s", "maxlen", "~", "0"), expect: ":1\r\n"},
+		{name: "xlen_empty", input: cmd("xlen", "s"), expect: ":0\r\n"},
+		{name: "type_empty", input: cmd("type", "s"), expect: "+stream\r\n"},
+		{name: "encoding", input: cmd("object", "encoding", "s"), expect: makeBulkString("stream")},
+		{name: "xadd_after_trim", input: cmd("xadd", "s", "4-0", "a", "1"), expect: "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{name: "set_str", input: cmd("set", "str", "v"), expect: "+OK\r\n"},
+		{name: "xlen_wrongtype", input: cmd("xlen", "str"), expect: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+}
+
+func TestStreamGroups(t *testing.T) {
+	conn := startTestServer(t, ServerOpt{port: "6411"})
+	a := dialTestServer(t, "6411")
+
+	cmd := func(args ...string) string { return makeArrayBulkString(args) }
+	arr := func(elems ...string) string { return makeArrayBulkString(elems) }
+	entry := func(id string, fields ...string) string { return "*2\r\n" + makeBulkString(id) + arr(fields...) }
+	read := func(key string, entries ...string) string {
+		return "*1\r\n*2\r\n" + makeBulkString(key) + fmt.Sprintf("*%d\r\n", len(entries)) + strings.Join(entries, "")
+	}
+	runCases(t, conn, []testCase{
+		{name: "xadd_1", input: cmd("xadd", "s", "1-0", "a", "1"), expect: makeBulkString("1-0")},
+		{name: "xadd_2", input: cmd("xadd", "s", "2-0", "b", "2"), expect: makeBulkString("2-0")},
+		{name: "create", input: cmd("xgroup", "create", "s", "g", "0"), expect: "+OK\r\n"},
+		{name: "create_busy", input: cmd("xgroup", "create", "s", "g", "$"), expect: "-BUSYGROUP Consumer Group name already exists\r\n"},
+		{name: "create_missing", input: cmd("xgroup", "create", "missing", "g", "$"), expect: "-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n"},
+		{name: "create_mkstream", input: cmd("xgroup", "create", "empty", "g", "$", "mkstream"), expect: "+OK\r\n"},
+		{name: "create_entriesread", input: cmd("xgroup", "create", "s", "h", "0", "entriesread", "-2"), expect: "-ERR value for ENTRIESREAD must be positive or -1\r\n"},
+		{name: "setid_nogroup", input: cmd("xgroup", "setid", "s", "nope", "0"), expect: "-NOGROUP No such consumer group 'nope' for key name 's'\r\n"},
+		{name: "xgroup_unknown", input: cmd("xgroup", "foo"), expect: "-ERR unknown subcommand 'foo'. Try XGROUP HELP.\r\n"},
+
+		{name: "readgroup_alice", input: cmd("xreadgroup", "group", "g", "alice", "count", "1", "streams", "s", ">"), expect: read("s", entry("1-0", "a", "1"))},
+		{name: "readgroup_bob", input: cmd("xreadgroup", "group", "g", "bob", "streams", "s", ">"), expect: read("s", entry("2-0", "b", "2"))},
+		{name: "readgroup_none", input: cmd("xreadgroup", "group", "g", "alice", "streams", "s", ">"), expect: "*-1\r\n"},
+		{name: "readgroup_history", input: cmd("xreadgroup", "group", "g", "alice", "streams", "s", "0"), expect: read("s", entry("1-0", "a", "1"))},
+		{name: "readgroup_dollar", input: cmd("xreadgroup", "group", "g", "alice", "streams", "s", "$"), expect: "-ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.\r\n"},
+		{name: "readgroup_nogroup", input: cmd("xreadgroup", "group", "nope", "alice", "streams", "s", ">"), expect: "-NOGROUP No such key 's' or consumer group 'nope' in XREADGROUP with GROUP option\r\n"},
+		{name: "xread", input: cmd("xread", "count", "1", "streams", "s", "0"), expect: read("s", entry("1-0", "a", "1"))},
+		{name: "xread_gt", input: cmd("xread", "streams", "s", ">"), expect: "-ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.\r\n"},
+
+		{name: "pending", input: cmd("xpending", "s", "g"), expect: "*4\r\n:2\r\n" + makeBulkString("1-0") + makeBulkString("2-0") +
+			"*2\r\n" + arr("alice", "1") + arr("bob", "1")},
+		{name: "pending_consumer", input: cmd("xpending", "s", "g", "-", "+", "10", "carol"), expect: "*0\r\n"},
+		{name: "pending_nogroup", input: cmd("xpending", "s", "nope"), expect: "-NOGROUP No such key 's' or consumer group 'nope'\r\n"},
+		{name: "xack", input: cmd("xack", "s", "g", "1-0", "9-0"), expect: ":1\r\n"},
+		{name: "xack_again", input: cmd("xack", "s", "g", "1-0"), expect: ":0\r\n"},
+		{name: "xclaim", input: cmd("xclaim", "s", "g", "alice", "0", "2-0", "justid"), expect: arr("2-0")},
+		{name: "xclaim_idle", input: cmd("xclaim", "s", "g", "bob", "3600000", "2-0"), expect: "*0\r\n"},
+		{name: "xclaim_option", input: cmd("xclaim", "s", "g", "bob", "0", "2-0", "foo"), expect: "-ERR Unrecognized XCLAIM option 'foo'\r\n"},
+		{name: "pending_empty", input: cmd("xpending", "empty", "g"), expect: "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n"},
+		{name: "groups", input: cmd("xinfo", "groups", "s"), expect: "*1\r\n*12\r\n" + makeBulkString("name") + makeBulkString("g") +
+			makeBulkString("consumers") + ":2\r\n" + makeBulkString("pending") + ":1\r\n" +
+			makeBulkString("last-delivered-id") + makeBulkString("2-0") + makeBulkString("entries-read") + ":2\r\n" + makeBulkString("lag") + ":0\r\n"},
+
+		{name: "autoclaim", input: cmd("xautoclaim", "s", "g", "bob", "0", "0-0"), expect: "*3\r\n" + makeBulkString("0-0") + "*1\r\n" + entry("2-0", "b", "2") + "*0\r\n"},
+		{name: "autoclaim_count", input: cmd("xautoclaim", "s", "g", "bob", "0", "0-0", "count", "0"), expect: "-ERR COUNT must be > 0\r\n"},
+		{name: "xdel", input: cmd("xdel", "s", "2-0"), expect: ":1\r\n"},
+		{name: "history_deleted", input: cmd("xreadgroup", "group", "g", "bob", "streams", "s", "0"), expect: read("s", "*2\r\n"+makeBulkString("2-0")+"*-1\r\n")},
+		{name: "autoclaim_deleted", input: cmd("xautoclaim", "s", "g", "alice", "0", "0-0", "justid"), expect: "*3\r\n" + makeBulkString("0-0") + "*0\r\n" + arr("2-0")},
+		{name: "createconsumer", input: cmd("xgroup", "createconsumer", "s", "g", "carol"), expect: ":1\r\n"},
+		{name: "createconsumer_again", input: cmd("xgroup", "createconsumer", "s", "g", "carol"), expect: ":0\r\n"},
+		{name: "delconsumer", input: cmd("xgroup", "delconsumer", "s", "g", "alice"), expect: ":0\r\n"},
+		{name: "consumers", input: cmd("xinfo", "groups", "s"), expect: "*1\r\n*12\r\n" + makeBulkString("name") + makeBulkString("g") +
+			makeBulkString("consumers") + ":2\r\n" + makeBulkString("pending") + ":0\r\n" +
+			makeBulkString("last-delivered-id") + makeBulkString("2-0") + makeBulkString("entries-read") + ":2\r\n" + makeBulkString("lag") + ":0\r\n"},
+		{name: "xinfo_stream", input: cmd("xinfo", "stream", "s"), expect: "*20\r\n" +
+			makeBulkString("length") + ":1\r\n" + makeBulkString("radix-tree-keys") + ":1\r\n" + makeBulkString("radix-tree-nodes") + ":1\r\n" +
+			makeBulkString("last-generated-id") + makeBulkString("2-0") + makeBulkString("max-deleted-entry-id") + makeBulkString("2-0") +
+			makeBulkString("entries-added") + ":2\r\n" + makeBulkString("recorded-first-entry-id") + makeBulkString("1-0") +
+			makeBulkString("groups") + ":1\r\n" + makeBulkString("first-entry") + entry("1-0", "a", "1") + makeBulkString("last-entry") + entry("1-0", "a", "1")},
+		{name: "xinfo_missing", input: cmd("xinfo", "stream", "missing"), expect: "-ERR no such key\r\n"},
+		{name: "destroy", input: cmd("xgroup", "destroy", "s", "g"), expect: ":1\r\n"},
+		{name: "destroy_again", input: cmd("xgroup", "destroy", "s", "g"), expect: ":0\r\n"},
+		{name: "getkeys", input: cmd("command", "getkeys", "xreadgroup", "group", "g", "c", "count", "1", "streams", "k1", "k2", ">", ">"), expect: arr("k1", "k2")},
+	})
+
+	send := func(conn net.Conn, args ...string) {
+		t.Helper()
+		if _, err := conn.Write([]byte(makeArrayBulkString(args))); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectReply := func(conn net.Conn, expect string) {
+		t.Helper()
+		if got := readN(t, conn, len(expect)); got != expect {
+			t.Fatalf("expected %q got %q", expect, got)
+		}
+	}
+
+	// "$" is the last ID when blocking, not when served
+	send(a, "xread", "block", "0", "streams", "s", "$")
+	runCases(t, conn, []testCase{
+		{name: "xadd_3", input: cmd("xadd", "s", "3-0", "c", "3"), expect: makeBulkString("3-0")},
+	})
+	expectReply(a, read("s", entry("3-0", "c", "3")))
+
+	send(a, "xreadgroup", "group", "g2", "alice", "block", "0", "streams", "s", ">")
+	expectReply(a, "-NOGROUP No such key 's' or consumer group 'g2' in XREADGROUP with GROUP option\r\n")
+	runCases(t, conn, []testCase{
+		{name: "create_g2", input: cmd("xgroup", "create", "s", "g2", "$"), expect: "+OK\r\n"},
+	})
+	send(a, "xreadgroup", "group", "g2", "alice", "block", "0", "streams", "s", ">")
+	runCases(t, conn, []testCase{
+		{name: "xadd_4", input: cmd("xadd", "s", "4-0", "d", "4"), expect: makeBulkString("4-0")},
+		{name: "pending_g2", input: cmd("xpending", "s", "g2"), expect: "*4\r\n:1\r\n" + makeBulkString("4-0") + makeBulkString("4-0") + "*1\r\n" + arr("alice", "1")},
+	})
+	expectReply(a, read("s", entry("4-0", "d", "4")))
+
+	// destroying the group wakes up its readers with an error
+	send(a, "xreadgroup", "group", "g2", "alice", "block", "0", "streams", "s", ">")
+	runCases(t, conn, []testCase{
+		{name: "destroy_g2", input: cmd("xgroup", "destroy", "s", "g2"), expect: ":1\r\n"},
+	})
+	expectReply(a, "-NOGROUP No such key 's' or consumer group 'g2' in XREADGROUP with GROUP option\r\n")
+
+	start := time.Now()
+	runCases(t, a, []testCase{
+		{name: "xread_timeout", input: cmd("xread", "block", "100", "streams", "s", "$"), expect: "*-1\r\n"},
+	})
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("expected xread to block for the timeout, returned after %v", elapsed)
+	}
+}
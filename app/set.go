@@ -0,0 +1,687 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSetMaxIntsetEntries   = 512
+	defaultSetMaxListpackEntries = 128
+	defaultSetMaxListpackValue   = 64
+)
+
+// A set made only of integers starts as an intset, other small sets as a
+// listpack of members. Past set-max-intset-entries members, or
+// set-max-listpack-entries and set-max-listpack-value for a listpack, it
+// is converted to a dict of members, and never converted back.
+
+// newSetObject returns an empty set with the encoding fitting member, its
+// first member.
+func (srv *Server) newSetObject(member string) *Object {
+	if _, ok := canonicalInt(member); ok {
+		return newObject(FieldTypeSet, EncodingIntset, newIntset())
+	}
+	if len(member) <= srv.configInt("set-max-listpack-value", defaultSetMaxListpackValue) {
+		return newObject(FieldTypeSet, EncodingListpack, newListpack())
+	}
+	return newObject(FieldTypeSet, EncodingHashtable, newDict[struct{}]())
+}
+
+// setConvert switches o to a listpack or a dict.
+func setConvert(o *Object, enc ObjEncoding) {
+	var val any
+	if enc == EncodingListpack {
+		lp := newListpack()
+		setIterate(o, func(member string) bool {
+			lp.Append(member)
+			return true
+		})
+		val = lp
+	} else {
+		d := newDict[struct{}]()
+		setIterate(o, func(member string) bool {
+			d.Set(member, struct{}{})
+			return true
+		})
+		val = d
+	}
+	o.Encoding = enc
+	o.Value = val
+}
+
+// setAdd adds member and reports whether it is new, converting o when it
+// outgrows its encoding.
+func (srv *Server) setAdd(o *Object, member string) bool {
+	maxEntries := srv.configInt("set-max-listpack-entries", defaultSetMaxListpackEntries)
+	maxValue := srv.configInt("set-max-listpack-value", defaultSetMaxListpackValue)
+
+	switch o.Encoding {
+	case EncodingIntset:
+		is := o.Value.(*intset)
+		if v, ok := canonicalInt(member); ok {
+			if !is.Add(v) {
+				return false
+			}
+			if is.Len() > srv.configInt("set-max-intset-entries", defaultSetMaxIntsetEntries) {
+				setConvert(o, EncodingHashtable)
+			}
+			return true
+		}
+		if is.Len()+1 <= maxEntries && len(member) <= maxValue {
+			setConvert(o, EncodingListpack)
+			o.Value.(*listpack).Append(member)
+			return true
+		}
+		setConvert(o, EncodingHashtable)
+	case EncodingListpack:
+		lp := o.Value.(*listpack)
+		if lp.Find(lp.First(), member, 0) != -1 {
+			return false
+		}
+		if lp.Len()+1 <= maxEntries && len(member) <= maxValue {
+			lp.Append(member)
+			return true
+		}
+		setConvert(o, EncodingHashtable)
+	}
+	return o.Value.(*dict[struct{}]).Set(member, struct{}{})
+}
+
+// setRemove removes member and reports whether it was in o.
+func setRemove(o *Object, member string) bool {
+	switch o.Encoding {
+	case EncodingIntset:
+		v, ok := canonicalInt(member)
+		return ok && o.Value.(*intset).Remove(v)
+	case EncodingListpack:
+		lp := o.Value.(*listpack)
+		p := lp.Find(lp.First(), member, 0)
+		if p == -1 {
+			return false
+		}
+		lp.Delete(p)
+		return true
+	}
+	_, ok := o.Value.(*dict[struct{}]).Delete(member)
+	return ok
+}
+
+func setIsMember(o *Object, member string) bool {
+	switch o.Encoding {
+	case EncodingIntset:
+		v, ok := canonicalInt(member)
+		return ok && o.Value.(*intset).Find(v)
+	case EncodingListpack:
+		lp := o.Value.(*listpack)
+		return lp.Find(lp.First(), member, 0) != -1
+	}
+	_, ok := o.Value.(*dict[struct{}]).Get(member)
+	return ok
+}
+
+func setLen(o *Object) int {
+	switch o.Encoding {
+	case EncodingIntset:
+		return o.Value.(*intset).Len()
+	case EncodingListpack:
+		return o.Value.(*listpack).Len()
+	}
+	return o.Value.(*dict[struct{}]).Len()
+}
+
+// setIterate calls fn for every member until it returns false. o must not
+// be modified meanwhile.
+func setIterate(o *Object, fn func(member string) bool) {
+	switch o.Encoding {
+	case EncodingIntset:
+		is := o.Value.(*intset)
+		for i := 0; i < is.Len(); i++ {
+			if !fn(strconv.FormatInt(is.Get(i), 10)) {
+				return
+			}
+		}
+	case EncodingListpack:
+		lp := o.Value.(*listpack)
+		for p := lp.First(); p != -1; p = lp.Next(p) {
+			if !fn(lp.Get(p)) {
+				return
+			}
+		}
+	default:
+		o.Value.(*dict[struct{}]).Iterate(func(member string, _ struct{}) bool {
+			return fn(member)
+		})
+	}
+}
+
+// setMembers returns every member of o.
+func setMembers(o *Object) []string {
+	res := make([]string, 0, setLen(o))
+	setIterate(o, func(member string) bool {
+		res = append(res, member)
+		return true
+	})
+	return res
+}
+
+// setRandom returns a random member of a non empty set.
+func setRandom(o *Object) string {
+	switch o.Encoding {
+	case EncodingIntset:
+		return strconv.FormatInt(o.Value.(*intset).Random(), 10)
+	case EncodingListpack:
+		lp := o.Value.(*listpack)
+		return lp.Get(lp.Seek(rand.Intn(lp.Len())))
+	}
+	member, _, _ := o.Value.(*dict[struct{}]).Random()
+	return member
+}
+
+// setDup returns a deep copy of the value of a set.
+func setDup(o *Object) any {
+	switch o.Encoding {
+	case EncodingIntset:
+		return &intset{b: append([]byte(nil), o.Value.(*intset).b...)}
+	case EncodingListpack:
+		return &listpack{b: append([]byte(nil), o.Value.(*listpack).b...)}
+	}
+	d := newDict[struct{}]()
+	o.Value.(*dict[struct{}]).Iterate(func(member string, _ struct{}) bool {
+		d.Set(member, struct{}{})
+		return true
+	})
+	return d
+}
+
+// setDeleteIfEmpty removes key once its set has no member left.
+func setDeleteIfEmpty(db *DB, key string, obj *Object) {
+	if setLen(obj) == 0 {
+		db.Delete(key)
+	}
+}
+
+// SADD key member [member ...]
+func (srv *Server) onSAdd(c *Client, args []string) error {
+	key := args[0]
+	obj, err := c.db.lookupType(key, FieldTypeSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		obj = srv.newSetObject(args[1])
+		c.db.Set(key, obj)
+	}
+
+	added := 0
+	for _, member := range args[1:] {
+		if srv.setAdd(obj, member) {
+			added++
+		}
+	}
+	c.writer.WriteInteger(int64(added))
+	return nil
+}
+
+// SREM key member [member ...]
+func (srv *Server) onSRem(c *Client, args []string) error {
+	key := args[0]
+	obj, err := c.db.lookupType(key, FieldTypeSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+
+	removed := 0
+	for _, member := range args[1:] {
+		if setRemove(obj, member) {
+			removed++
+		}
+	}
+	setDeleteIfEmpty(c.db, key, obj)
+	c.writer.WriteInteger(int64(removed))
+	return nil
+}
+
+// SMEMBERS key
+func (srv *Server) onSMembers(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteSetLen(0)
+		return nil
+	}
+
+	c.writer.WriteSetLen(setLen(obj))
+	setIterate(obj, func(member string) bool {
+		c.writer.WriteBulkString(member)
+		return true
+	})
+	return nil
+}
+
+// SISMEMBER key member
+func (srv *Server) onSIsMember(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeSet)
+	if err != nil {
+		return err
+	}
+	if obj != nil && setIsMember(obj, args[1]) {
+		c.writer.WriteInteger(1)
+	} else {
+		c.writer.WriteInteger(0)
+	}
+	return nil
+}
+
+// SMISMEMBER key member [member ...]
+func (srv *Server) onSMIsMember(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeSet)
+	if err != nil {
+		return err
+	}
+
+	c.writer.WriteArrayLen(len(args) - 1)
+	for _, member := range args[1:] {
+		if obj != nil && setIsMember(obj, member) {
+			c.writer.WriteInteger(1)
+		} else {
+			c.writer.WriteInteger(0)
+		}
+	}
+	return nil
+}
+
+// SCARD key
+func (srv *Server) onSCard(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+	c.writer.WriteInteger(int64(setLen(obj)))
+	return nil
+}
+
+// SPOP key [count]
+func (srv *Server) onSPop(c *Client, args []string) error {
+	if len(args) > 2 {
+		return ErrSyntax
+	}
+
+	count := -1
+	if len(args) == 2 {
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || n < 0 {
+			return errNotPositive
+		}
+		if n > math.MaxInt32 {
+			n = math.MaxInt32
+		}
+		count = int(n)
+	}
+
+	key := args[0]
+	obj, err := c.db.lookupType(key, FieldTypeSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		if count == -1 {
+			c.writer.WriteNull()
+		} else {
+			c.writer.WriteSetLen(0)
+		}
+		return nil
+	}
+
+	if count == -1 {
+		member := setRandom(obj)
+		setRemove(obj, member)
+		c.writer.WriteBulkString(member)
+		setDeleteIfEmpty(c.db, key, obj)
+		return nil
+	}
+
+	// taking the whole set is only a delete
+	if count >= setLen(obj) {
+		members := setMembers(obj)
+		c.db.Delete(key)
+		c.writer.WriteSetLen(len(members))
+		for _, member := range members {
+			c.writer.WriteBulkString(member)
+		}
+		return nil
+	}
+
+	c.writer.WriteSetLen(count)
+	for ; count > 0; count-- {
+		member := setRandom(obj)
+		setRemove(obj, member)
+		c.writer.WriteBulkString(member)
+	}
+	return nil
+}
+
+// SRANDMEMBER key [count]
+//
+// A positive count returns distinct members, at most the whole set, a
+// negative one returns -count members that may repeat.
+func (srv *Server) onSRandMember(c *Client, args []string) error {
+	if len(args) > 2 {
+		return ErrSyntax
+	}
+
+	count, withCount := 1, len(args) == 2
+	if withCount {
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return ErrNotInteger
+		}
+		if n < -math.MaxInt64/2 || n > math.MaxInt64/2 {
+			return errors.New("ERR value is out of range")
+		}
+		count = int(n)
+	}
+
+	obj, err := c.db.lookupType(args[0], FieldTypeSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		if withCount {
+			c.writer.WriteArrayLen(0)
+		} else {
+			c.writer.WriteNull()
+		}
+		return nil
+	}
+
+	if !withCount {
+		c.writer.WriteBulkString(setRandom(obj))
+		return nil
+	}
+
+	var res []string
+	if count < 0 {
+		for i := 0; i < -count; i++ {
+			res = append(res, setRandom(obj))
+		}
+	} else {
+		res = setMembers(obj)
+		rand.Shuffle(len(res), func(i, j int) {
+			res[i], res[j] = res[j], res[i]
+		})
+		if count < len(res) {
+			res = res[:count]
+		}
+	}
+	c.writer.WriteBulkStrings(res)
+	return nil
+}
+
+// SMOVE source destination member
+func (srv *Server) onSMove(c *Client, args []string) error {
+	src, dst, member := args[0], args[1], args[2]
+	srcObj, err := c.db.lookupType(src, FieldTypeSet)
+	if err != nil {
+		return err
+	}
+	dstObj, err := c.db.lookupType(dst, FieldTypeSet)
+	if err != nil {
+		return err
+	}
+
+	if srcObj == nil || !setIsMember(srcObj, member) {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+	if src == dst {
+		c.writer.WriteInteger(1)
+		return nil
+	}
+
+	setRemove(srcObj, member)
+	setDeleteIfEmpty(c.db, src, srcObj)
+	if dstObj == nil {
+		dstObj = srv.newSetObject(member)
+		c.db.Set(dst, dstObj)
+	}
+	srv.setAdd(dstObj, member)
+	c.writer.WriteInteger(1)
+	return nil
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+//
+// Intsets and listpacks are small enough to be returned in one call, with
+// cursor 0, like Redis does.
+func (srv *Server) onSScan(c *Client, args []string) error {
+	cursor, opt, err := parseScanArgs(args[1:], "sscan")
+	if err != nil {
+		return err
+	}
+
+	obj, err := c.db.lookupType(args[0], FieldTypeSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		writeScanReply(c.writer, 0, nil)
+		return nil
+	}
+
+	var res []string
+	visit := func(member string, _ struct{}) {
+		if opt.match == "" || stringMatch(opt.match, member, false) {
+			res = append(res, member)
+		}
+	}
+
+	if obj.Encoding == EncodingHashtable {
+		cursor = scanDict(obj.Value.(*dict[struct{}]), cursor, opt.count, visit)
+	} else {
+		setIterate(obj, func(member string) bool {
+			visit(member, struct{}{})
+			return true
+		})
+		cursor = 0
+	}
+
+	writeScanReply(c.writer, cursor, res)
+	return nil
+}
+
+// lookupSets returns the sets at keys, nil for the missing ones.
+func lookupSets(db *DB, keys []string) ([]*Object, error) {
+	sets := make([]*Object, len(keys))
+	for i, key := range keys {
+		obj, err := db.lookupType(key, FieldTypeSet)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = obj
+	}
+	return sets, nil
+}
+
+// setInter returns the members of every set in sets, a nil one being
+// empty, stopping at limit members when it is not 0.
+func setInter(sets []*Object, limit int) []string {
+	smallest := -1
+	for i, o := range sets {
+		if o == nil {
+			return nil
+		}
+		if smallest == -1 || setLen(o) < setLen(sets[smallest]) {
+			smallest = i
+		}
+	}
+
+	var res []string
+	setIterate(sets[smallest], func(member string) bool {
+		for i, o := range sets {
+			if i != smallest && !setIsMember(o, member) {
+				return true
+			}
+		}
+		res = append(res, member)
+		return limit == 0 || len(res) < limit
+	})
+	return res
+}
+
+// setUnion returns the members of any set in sets.
+func setUnion(sets []*Object) []string {
+	var res []string
+	seen := map[string]bool{}
+	for _, o := range sets {
+		if o == nil {
+			continue
+		}
+		setIterate(o, func(member string) bool {
+			if !seen[member] {
+				seen[member] = true
+				res = append(res, member)
+			}
+			return true
+		})
+	}
+	return res
+}
+
+// setDiff returns the members of the first set in none of the others.
+func setDiff(sets []*Object) []string {
+	if sets[0] == nil {
+		return nil
+	}
+
+	var res []string
+	setIterate(sets[0], func(member string) bool {
+		for _, o := range sets[1:] {
+			if o != nil && setIsMember(o, member) {
+				return true
+			}
+		}
+		res = append(res, member)
+		return true
+	})
+	return res
+}
+
+const (
+	setOpInter = iota
+	setOpUnion
+	setOpDiff
+)
+
+// setOpGeneric implements SINTER, SUNION and SDIFF, and their STORE
+// variants when dst is not empty: the result replaces dst and its size is
+// the reply.
+func (srv *Server) setOpGeneric(c *Client, keys []string, dst string, op int) error {
+	sets, err := lookupSets(c.db, keys)
+	if err != nil {
+		return err
+	}
+
+	var res []string
+	switch op {
+	case setOpInter:
+		res = setInter(sets, 0)
+	case setOpUnion:
+		res = setUnion(sets)
+	default:
+		res = setDiff(sets)
+	}
+
+	if dst == "" {
+		c.writer.WriteSetLen(len(res))
+		for _, member := range res {
+			c.writer.WriteBulkString(member)
+		}
+		return nil
+	}
+
+	if len(res) == 0 {
+		c.db.Delete(dst)
+	} else {
+		obj := srv.newSetObject(res[0])
+		for _, member := range res {
+			srv.setAdd(obj, member)
+		}
+		c.db.Set(dst, obj)
+	}
+	c.writer.WriteInteger(int64(len(res)))
+	return nil
+}
+
+// SINTER key [key ...]
+func (srv *Server) onSInter(c *Client, args []string) error {
+	return srv.setOpGeneric(c, args, "", setOpInter)
+}
+
+// SINTERSTORE destination key [key ...]
+func (srv *Server) onSInterStore(c *Client, args []string) error {
+	return srv.setOpGeneric(c, args[1:], args[0], setOpInter)
+}
+
+// SUNION key [key ...]
+func (srv *Server) onSUnion(c *Client, args []string) error {
+	return srv.setOpGeneric(c, args, "", setOpUnion)
+}
+
+// SUNIONSTORE destination key [key ...]
+func (srv *Server) onSUnionStore(c *Client, args []string) error {
+	return srv.setOpGeneric(c, args[1:], args[0], setOpUnion)
+}
+
+// SDIFF key [key ...]
+func (srv *Server) onSDiff(c *Client, args []string) error {
+	return srv.setOpGeneric(c, args, "", setOpDiff)
+}
+
+// SDIFFSTORE destination key [key ...]
+func (srv *Server) onSDiffStore(c *Client, args []string) error {
+	return srv.setOpGeneric(c, args[1:], args[0], setOpDiff)
+}
+
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+func (srv *Server) onSInterCard(c *Client, args []string) error {
+	keys, rest, err := parseNumKeys(args)
+	if err != nil {
+		return err
+	}
+
+	limit := 0
+	for i := 0; i < len(rest); i += 2 {
+		if strings.ToLower(rest[i]) != "limit" || i+1 >= len(rest) {
+			return ErrSyntax
+		}
+		n, err := strconv.Atoi(rest[i+1])
+		if err != nil {
+			return ErrNotInteger
+		}
+		if n < 0 {
+			return errors.New("ERR LIMIT can't be negative")
+		}
+		limit = n
+	}
+
+	sets, err := lookupSets(c.db, keys)
+	if err != nil {
+		return err
+	}
+	c.writer.WriteInteger(int64(len(setInter(sets, limit))))
+	return nil
+}
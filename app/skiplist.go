@@ -0,0 +1,276 @@
+package main
+
+import "math/rand"
+
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25
+)
+
+// zskiplist orders the members of a large sorted set by score, then by
+// member for equal scores. Every link also stores the number of nodes it
+// skips, its span, so the rank of a node is summed while looking it up and
+// rank operations are O(log n) like the others.
+//
+// The header is a sentinel without member, tail the last node, and every
+// node links back to the previous one on the lowest level for reverse
+// walks.
+type zskiplist struct {
+	header *zskiplistNode
+	tail   *zskiplistNode
+	length int
+	level  int
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int
+}
+
+// zrangeSpec is a range of a sorted set, by score or by member, see
+// zscoreRange and zlexRange.
+type zrangeSpec interface {
+	gteMin(score float64, member string) bool
+	lteMax(score float64, member string) bool
+}
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+// zslRandomLevel returns the level of a new node, each level being
+// zskiplistP times less likely than the one below.
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// before reports whether n sorts before the element score member.
+func (n *zskiplistNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// after reports whether n sorts after the element score member.
+func (n *zskiplistNode) after(score float64, member string) bool {
+	return n.score > score || (n.score == score && n.member > member)
+}
+
+// Len returns the number of nodes.
+func (zsl *zskiplist) Len() int {
+	return zsl.length
+}
+
+// First returns the node with the lowest score, nil when empty.
+func (zsl *zskiplist) First() *zskiplistNode {
+	return zsl.header.level[0].forward
+}
+
+// Last returns the node with the highest score, nil when empty.
+func (zsl *zskiplist) Last() *zskiplistNode {
+	return zsl.tail
+}
+
+// Next returns the node after n, nil at the end.
+func (n *zskiplistNode) Next() *zskiplistNode {
+	return n.level[0].forward
+}
+
+// Prev returns the node before n, nil at the start.
+func (n *zskiplistNode) Prev() *zskiplistNode {
+	return n.backward
+}
+
+// Insert adds member with score, member must not be in the skiplist yet.
+func (zsl *zskiplist) Insert(score float64, member string) *zskiplistNode {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+
+	// find the last node before the new one on every level, and its rank
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		// the new node splits the span of the link it is inserted in
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	// the links above the new node skip one more node
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+// deleteNode unlinks x, update holding the last node before it on every
+// level.
+func (zsl *zskiplist) deleteNode(x *zskiplistNode, update []*zskiplistNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+// findUpdate returns the last node before score member on every level.
+func (zsl *zskiplist) findUpdate(score float64, member string) []*zskiplistNode {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	return update
+}
+
+// Delete removes member with score and reports whether it was found.
+func (zsl *zskiplist) Delete(score float64, member string) bool {
+	update := zsl.findUpdate(score, member)
+	x := update[0].level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	zsl.deleteNode(x, update)
+	return true
+}
+
+// UpdateScore moves member from score cur to score, member must be in the
+// skiplist with score cur.
+func (zsl *zskiplist) UpdateScore(cur float64, member string, score float64) *zskiplistNode {
+	update := zsl.findUpdate(cur, member)
+	x := update[0].level[0].forward
+
+	// the node keeps its place when its neighbours still surround it
+	if (x.backward == nil || x.backward.before(score, member)) &&
+		(x.level[0].forward == nil || !x.level[0].forward.before(score, member)) {
+		x.score = score
+		return x
+	}
+
+	zsl.deleteNode(x, update)
+	return zsl.Insert(score, member)
+}
+
+// Rank returns the 1-based rank of member with score, 0 when it is not in
+// the skiplist.
+func (zsl *zskiplist) Rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !x.level[i].forward.after(score, member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// ByRank returns the node at the 1-based rank, nil when out of range.
+func (zsl *zskiplist) ByRank(rank int) *zskiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			if x == zsl.header {
+				return nil
+			}
+			return x
+		}
+	}
+	return nil
+}
+
+// FirstInRange returns the first node in r, nil when there is none.
+func (zsl *zskiplist) FirstInRange(r zrangeSpec) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward.score, x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if x == nil || !r.lteMax(x.score, x.member) {
+		return nil
+	}
+	return x
+}
+
+// LastInRange returns the last node in r, nil when there is none.
+func (zsl *zskiplist) LastInRange(r zrangeSpec) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward.score, x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.header || !r.gteMin(x.score, x.member) {
+		return nil
+	}
+	return x
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSkiplist(t *testing.T) {
+	zsl := newZskiplist()
+	var want []zsetEntry
+	for i := 0; i < 500; i++ {
+		e := zsetEntry{member: fmt.Sprint("m", i), score: float64(rand.Intn(50))}
+		want = append(want, e)
+		zsl.Insert(e.score, e.member)
+	}
+
+	// move some members, drop others
+	for i := 0; i < 100; i++ {
+		e := &want[rand.Intn(len(want))]
+		score := float64(rand.Intn(50))
+		zsl.UpdateScore(e.score, e.member, score)
+		e.score = score
+	}
+	for i := 0; i < 100; i++ {
+		j := rand.Intn(len(want))
+		if !zsl.Delete(want[j].score, want[j].member) {
+			t.Fatalf("Delete %v failed", want[j])
+		}
+		want = append(want[:j], want[j+1:]...)
+	}
+	if zsl.Delete(-1, "m0") {
+		t.Fatal("Delete of a missing member succeeded")
+	}
+
+	sort.Slice(want, func(i, j int) bool {
+		return want[i].score < want[j].score ||
+			(want[i].score == want[j].score && want[i].member < want[j].member)
+	})
+	if zsl.Len() != len(want) {
+		t.Fatalf("expected %d nodes got %d", len(want), zsl.Len())
+	}
+
+	i := 0
+	for x := zsl.First(); x != nil; x = x.Next() {
+		if x.member != want[i].member || x.score != want[i].score {
+			t.Fatalf("node %d: expected %v got %s %v", i, want[i], x.member, x.score)
+		}
+		if rank := zsl.Rank(x.score, x.member); rank != i+1 {
+			t.Fatalf("node %d: got rank %d", i, rank)
+		}
+		if zsl.ByRank(i+1) != x {
+			t.Fatalf("node %d: ByRank returned another node", i)
+		}
+		i++
+	}
+	for x, i := zsl.Last(), len(want)-1; x != nil; x, i = x.Prev(), i-1 {
+		if x.member != want[i].member {
+			t.Fatalf("backward node %d: expected %s got %s", i, want[i].member, x.member)
+		}
+	}
+	if zsl.ByRank(0) != nil || zsl.ByRank(len(want)+1) != nil {
+		t.Fatal("ByRank out of range returned a node")
+	}
+
+	r := zscoreRange{min: 10, max: 20, minex: true}
+	first, last := zsl.FirstInRange(r), zsl.LastInRange(r)
+	lo := sort.Search(len(want), func(i int) bool { return want[i].score > 10 })
+	hi := sort.Search(len(want), func(i int) bool { return want[i].score > 20 }) - 1
+	if first.member != want[lo].member || last.member != want[hi].member {
+		t.Fatalf("expected range %s..%s got %s..%s", want[lo].member, want[hi].member, first.member, last.member)
+	}
+	if zsl.FirstInRange(zscoreRange{min: 60, max: 70}) != nil || zsl.LastInRange(zscoreRange{min: 20, max: 10}) != nil {
+		t.Fatal("empty range returned a node")
+	}
+}
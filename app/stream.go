@@ -0,0 +1,1118 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStreamNodeMaxBytes   = 4096
+	defaultStreamNodeMaxEntries = 100
+)
+
+// stream is a log of entries, each a list of field value pairs identified
+// by an ID made of a unix ms timestamp and a sequence number, increasing
+// along the stream.
+//
+// Like Redis the entries are packed in listpack nodes of at most
+// stream-node-max-entries entries or stream-node-max-bytes bytes, each
+// keyed by the ID of its first entry, its master ID. Redis indexes the
+// nodes with a radix tree, here they are kept in a slice sorted by master
+// ID and searched in O(log n): nodes are only appended at the tail, and
+// removed from the head when trimmed or anywhere once all their entries
+// are deleted.
+//
+// A node starts with a master entry: the number of valid entries, the
+// number of deleted ones and the fields of the first entry, which the
+// following entries with the same fields don't repeat:
+//
+//	count deleted num-fields field_1 ... field_N 0
+//
+// Then come the entries: their flags, their ID as a difference to the
+// master ID, their fields and values, and the number of elements they are
+// made of, to walk them from the tail:
+//
+//	flags ms-diff seq-diff num-fields field_1 value_1 ... lp-count
+//	flags ms-diff seq-diff value_1 ... value_N lp-count  (same fields)
+//
+// Deleted entries are only flagged. The layout is the one of Redis so the
+// nodes are saved to and loaded from RDB files as is.
+type stream struct {
+	nodes        []*streamNode
+	length       int
+	lastID       streamID // the ID of the last entry ever added
+	firstID      streamID // the ID of the first entry, 0-0 when empty
+	maxDeletedID streamID // the greatest ID deleted by XDEL
+	entriesAdded uint64   // the number of entries ever added
+	groups       map[string]*streamCG
+}
+
+type streamNode struct {
+	master streamID
+	lp     *listpack
+}
+
+// flags of the entries of a stream node
+const (
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+// streamID identifies a stream entry.
+type streamID struct {
+	ms, seq uint64
+}
+
+var streamMaxID = streamID{math.MaxUint64, math.MaxUint64}
+
+// streamEntry is an entry and its field value pairs, as returned by range
+// queries.
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+func newStream() *stream {
+	return &stream{}
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+// incr returns the ID following id, false when id is the greatest one.
+func (id streamID) incr() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// decr returns the ID preceding id, false when id is 0-0.
+func (id streamID) decr() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// encode returns id as the 128 bit big endian key of a node in RDB files.
+func (id streamID) encode() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:], id.ms)
+	binary.BigEndian.PutUint64(b[8:], id.seq)
+	return string(b[:])
+}
+
+func decodeStreamID(s string) (streamID, bool) {
+	if len(s) != 16 {
+		return streamID{}, false
+	}
+	return streamID{binary.BigEndian.Uint64([]byte(s)), binary.BigEndian.Uint64([]byte(s[8:]))}, true
+}
+
+// int returns the integer at p, all the counters and IDs of a node being
+// stored as integers.
+func (n *streamNode) int(p int) int64 {
+	v, ok := n.lp.GetInt(p)
+	if !ok {
+		v, _ = strconv.ParseInt(n.lp.Get(p), 10, 64)
+	}
+	return v
+}
+
+// counts returns the number of valid and deleted entries of n.
+func (n *streamNode) counts() (count, deleted int64) {
+	p := n.lp.First()
+	return n.int(p), n.int(n.lp.Next(p))
+}
+
+// masterFields returns the fields of the master entry and the offset of
+// the first entry.
+func (n *streamNode) masterFields() ([]string, int) {
+	lp := n.lp
+	p := lp.Next(lp.Next(lp.First()))
+	fields := make([]string, n.int(p))
+	for i := range fields {
+		p = lp.Next(p)
+		fields[i] = lp.Get(p)
+	}
+	p = lp.Next(p) // the terminator of the master entry
+	return fields, lp.Next(p)
+}
+
+// entry reads the entry at p. It returns its ID, its flags, its field
+// value pairs and the offset of the next entry, -1 at the end.
+func (n *streamNode) entry(p int, master []string) (id streamID, flags int64, fields []string, next int) {
+	lp := n.lp
+	flags = n.int(p)
+	p = lp.Next(p)
+	id.ms = n.master.ms + uint64(n.int(p))
+	p = lp.Next(p)
+	id.seq = n.master.seq + uint64(n.int(p))
+	if flags&streamItemSameFields != 0 {
+		fields = make([]string, 0, 2*len(master))
+		for _, field := range master {
+			p = lp.Next(p)
+			fields = append(fields, field, lp.Get(p))
+		}
+	} else {
+		p = lp.Next(p)
+		num := 2 * n.int(p)
+		fields = make([]string, 0, num)
+		for i := int64(0); i < num; i++ {
+			p = lp.Next(p)
+			fields = append(fields, lp.Get(p))
+		}
+	}
+	p = lp.Next(p) // lp-count
+	return id, flags, fields, lp.Next(p)
+}
+
+// prevEntry returns the offset of the entry before the one at p, of the
+// last one when p is -1, and -1 when there is none.
+func (n *streamNode) prevEntry(p int) int {
+	lp := n.lp
+	if p == -1 {
+		p = lp.Last()
+	} else {
+		p = lp.Prev(p)
+	}
+	// p is the lp-count of the previous entry, or the 0 terminating the
+	// master entry
+	count := n.int(p)
+	if count == 0 {
+		return -1
+	}
+	for i := int64(0); i < count; i++ {
+		p = lp.Prev(p)
+	}
+	return p
+}
+
+// lastID returns the ID of the last entry of n, deleted or not.
+func (n *streamNode) lastID() streamID {
+	master, _ := n.masterFields()
+	id, _, _, _ := n.entry(n.prevEntry(-1), master)
+	return id
+}
+
+// seekNode returns the index of the node id would be in, -1 when id is
+// before the first node.
+func (s *stream) seekNode(id streamID) int {
+	return sort.Search(len(s.nodes), func(i int) bool {
+		return id.less(s.nodes[i].master)
+	}) - 1
+}
+
+// append adds an entry with id, which must be greater than lastID, and
+// its field value pairs. A new node is started once the tail one holds
+// maxEntries entries or maxBytes bytes, 0 meaning no limit.
+func (s *stream) append(id streamID, fields []string, maxBytes, maxEntries int) {
+	var n *streamNode
+	if len(s.nodes) > 0 {
+		n = s.nodes[len(s.nodes)-1]
+		size := n.lp.Bytes()
+		for _, f := range fields {
+			size += len(f)
+		}
+		count, deleted := n.counts()
+		if (maxBytes > 0 && size >= maxBytes) || (maxEntries > 0 && count+deleted >= int64(maxEntries)) {
+			n = nil
+		}
+	}
+	if n == nil {
+		n = &streamNode{master: id, lp: newListpack()}
+		n.lp.Append("0")
+		n.lp.Append("0")
+		n.lp.Append(strconv.Itoa(len(fields) / 2))
+		for i := 0; i < len(fields); i += 2 {
+			n.lp.Append(fields[i])
+		}
+		n.lp.Append("0")
+		s.nodes = append(s.nodes, n)
+	}
+
+	master, _ := n.masterFields()
+	sameFields := len(master) == len(fields)/2
+	for i := 0; sameFields && i < len(master); i++ {
+		sameFields = master[i] == fields[2*i]
+	}
+
+	lp := n.lp
+	lpCount := 3
+	if sameFields {
+		lp.Append(strconv.Itoa(streamItemSameFields))
+	} else {
+		lp.Append("0")
+	}
+	lp.Append(strconv.FormatInt(int64(id.ms-n.master.ms), 10))
+	lp.Append(strconv.FormatInt(int64(id.seq-n.master.seq), 10))
+	if sameFields {
+		for i := 1; i < len(fields); i += 2 {
+			lp.Append(fields[i])
+		}
+		lpCount += len(fields) / 2
+	} else {
+		lp.Append(strconv.Itoa(len(fields) / 2))
+		for _, f := range fields {
+			lp.Append(f)
+		}
+		lpCount += 1 + len(fields)
+	}
+	lp.Append(strconv.Itoa(lpCount))
+
+	count, _ := n.counts()
+	lp.Replace(lp.First(), strconv.FormatInt(count+1, 10))
+
+	if s.length == 0 {
+		s.firstID = id
+	}
+	s.length++
+	s.entriesAdded++
+	s.lastID = id
+}
+
+// markDeleted flags the entry at p of node i as deleted, freeing the node
+// when it was its last valid entry. The counters of the master entry may
+// change size, it returns how many bytes the following entries moved by.
+func (s *stream) markDeleted(i, p int, flags int64) int {
+	n := s.nodes[i]
+	s.length--
+	count, deleted := n.counts()
+	if count == 1 {
+		s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+		return 0
+	}
+
+	size := n.lp.Bytes()
+	n.lp.Replace(p, strconv.FormatInt(flags|streamItemDeleted, 10))
+	q := n.lp.First()
+	n.lp.Replace(q, strconv.FormatInt(count-1, 10))
+	n.lp.Replace(n.lp.Next(q), strconv.FormatInt(deleted+1, 10))
+	return n.lp.Bytes() - size
+}
+
+// delete removes the entry with id and reports whether it was found.
+func (s *stream) delete(id streamID) bool {
+	i := s.seekNode(id)
+	if i < 0 {
+		return false
+	}
+	n := s.nodes[i]
+	master, p := n.masterFields()
+	for p != -1 {
+		eid, flags, _, next := n.entry(p, master)
+		if eid == id {
+			if flags&streamItemDeleted != 0 {
+				return false
+			}
+			s.markDeleted(i, p, flags)
+			return true
+		}
+		if id.less(eid) {
+			break
+		}
+		p = next
+	}
+	return false
+}
+
+// updateFirstID sets firstID after the head of the stream was deleted.
+func (s *stream) updateFirstID() {
+	s.firstID = streamID{}
+	if e, ok := s.newIterator(streamID{}, streamMaxID, false).next(); ok {
+		s.firstID = e.id
+	}
+}
+
+// trim strategies of XADD and XTRIM
+const (
+	streamTrimMaxLen = iota + 1
+	streamTrimMinID
+)
+
+// streamTrimArgs tells how to trim a stream: down to maxLen entries or up
+// to minID. An approximate trim only frees whole nodes, and stops before
+// removing more than limit entries, 0 meaning no limit.
+type streamTrimArgs struct {
+	strategy int
+	maxLen   int64
+	minID    streamID
+	approx   bool
+	limit    int64
+}
+
+// trim removes the oldest entries following t and returns how many were
+// removed.
+func (s *stream) trim(t streamTrimArgs) int64 {
+	var removed int64
+	for len(s.nodes) > 0 {
+		if t.strategy == streamTrimMaxLen && int64(s.length) <= t.maxLen {
+			break
+		}
+		n := s.nodes[0]
+		count, _ := n.counts()
+		if t.limit > 0 && removed+count > t.limit {
+			break
+		}
+
+		var removeNode bool
+		if t.strategy == streamTrimMaxLen {
+			removeNode = int64(s.length)-count >= t.maxLen
+		} else {
+			removeNode = n.lastID().less(t.minID)
+		}
+		if removeNode {
+			s.nodes = s.nodes[1:]
+			s.length -= int(count)
+			removed += count
+			continue
+		}
+		if t.approx {
+			break
+		}
+
+		// some entries of the node are kept, the others are flagged
+		master, p := n.masterFields()
+		for p != -1 {
+			if t.strategy == streamTrimMaxLen && int64(s.length) <= t.maxLen {
+				break
+			}
+			id, flags, _, next := n.entry(p, master)
+			if t.strategy == streamTrimMinID && !id.less(t.minID) {
+				break
+			}
+			if flags&streamItemDeleted == 0 {
+				if shift := s.markDeleted(0, p, flags); next != -1 {
+					next += shift
+				}
+				removed++
+			}
+			p = next
+		}
+		break
+	}
+	s.updateFirstID()
+	return removed
+}
+
+// dup returns a deep copy of s.
+func (s *stream) dup() *stream {
+	res := *s
+	res.nodes = make([]*streamNode, len(s.nodes))
+	for i, n := range s.nodes {
+		res.nodes[i] = &streamNode{master: n.master, lp: &listpack{b: append([]byte(nil), n.lp.b...)}}
+	}
+	if s.groups != nil {
+		res.groups = make(map[string]*streamCG, len(s.groups))
+		for name, g := range s.groups {
+			res.groups[name] = g.dup()
+		}
+	}
+	return &res
+}
+
+// get returns the entry with id, false when it is missing or deleted.
+func (s *stream) get(id streamID) (streamEntry, bool) {
+	return s.newIterator(id, id, false).next()
+}
+
+// lastValidID returns the ID of the last entry not deleted, false when
+// there is none.
+func (s *stream) lastValidID() (streamID, bool) {
+	e, ok := s.newIterator(streamID{}, streamMaxID, true).next()
+	return e.id, ok
+}
+
+// streamIterator walks the valid entries of a stream from start to end,
+// both included, or backwards.
+type streamIterator struct {
+	s          *stream
+	start, end streamID
+	rev        bool
+	node       int
+	master     []string
+	p          int // the offset of the next entry of the node, -1 when done
+}
+
+func (s *stream) newIterator(start, end streamID, rev bool) *streamIterator {
+	it := &streamIterator{s: s, start: start, end: end, rev: rev}
+	if rev {
+		it.node = s.seekNode(end)
+	} else if it.node = s.seekNode(start); it.node < 0 {
+		it.node = 0
+	}
+	it.loadNode()
+	return it
+}
+
+func (it *streamIterator) loadNode() {
+	it.p = -1
+	if it.node < 0 || it.node >= len(it.s.nodes) {
+		return
+	}
+	n := it.s.nodes[it.node]
+	var first int
+	it.master, first = n.masterFields()
+	if it.rev {
+		it.p = n.prevEntry(-1)
+	} else {
+		it.p = first
+	}
+}
+
+// next returns the next entry, false once the range is exhausted.
+func (it *streamIterator) next() (streamEntry, bool) {
+	for it.node >= 0 && it.node < len(it.s.nodes) {
+		if it.p == -1 {
+			if it.rev {
+				it.node--
+			} else {
+				it.node++
+			}
+			it.loadNode()
+			continue
+		}
+
+		n := it.s.nodes[it.node]
+		id, flags, fields, next := n.entry(it.p, it.master)
+		if it.rev {
+			it.p = n.prevEntry(it.p)
+		} else {
+			it.p = next
+		}
+		// entries before the range are skipped, the first one after it
+		// ends the walk
+		before, after := id.less(it.start), it.end.less(id)
+		if it.rev {
+			before, after = after, before
+		}
+		if flags&streamItemDeleted != 0 || before {
+			continue
+		}
+		if after {
+			it.node = -1
+			break
+		}
+		return streamEntry{id: id, fields: fields}, true
+	}
+	return streamEntry{}, false
+}
+
+var (
+	errInvalidStreamID   = errors.New("ERR Invalid stream ID specified as stream command argument")
+	errStreamIDTooSmall  = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errStreamIDExhausted = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+)
+
+// parseStreamID parses an ID, the sequence number being missingSeq when
+// only the timestamp is given.
+func parseStreamID(s string, missingSeq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	return streamID{ms, seq}, nil
+}
+
+// parseStreamIntervalID parses a bound of XRANGE: an ID, "-" and "+" for
+// the smallest and greatest ones, and an ID after "(" to exclude it.
+func parseStreamIntervalID(s string, missingSeq uint64) (id streamID, exclude bool, err error) {
+	switch {
+	case s == "-":
+		return streamID{}, false, nil
+	case s == "+":
+		return streamMaxID, false, nil
+	case len(s) > 1 && s[0] == '(':
+		id, err = parseStreamID(s[1:], missingSeq)
+		return id, true, err
+	}
+	id, err = parseStreamID(s, missingSeq)
+	return id, false, err
+}
+
+// streamAddTrimArgs are the options of XADD and XTRIM.
+type streamAddTrimArgs struct {
+	trim       streamTrimArgs
+	noMkStream bool
+	id         streamID
+	idGiven    bool // false for "*"
+	seqGiven   bool // false for "<ms>-*"
+}
+
+// parseStreamAddOrTrimArgs parses the options of XADD or XTRIM, args
+// starting after the key. For XADD it also parses the ID and returns its
+// index.
+func (srv *Server) parseStreamAddOrTrimArgs(args []string, xadd bool) (streamAddTrimArgs, int, error) {
+	var res streamAddTrimArgs
+	limitGiven := false
+	i := 0
+loop:
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		more := i+1 < len(args)
+		switch {
+		case xadd && args[i] == "*":
+			break loop
+		case (opt == "maxlen" || opt == "minid") && more:
+			if res.trim.strategy != 0 {
+				return res, 0, errors.New("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")
+			}
+			i++
+			if (args[i] == "~" || args[i] == "=") && i+1 < len(args) {
+				res.trim.approx = args[i] == "~"
+				i++
+			}
+			if opt == "maxlen" {
+				n, err := strconv.ParseInt(args[i], 10, 64)
+				if err != nil {
+					return res, 0, ErrNotInteger
+				}
+				if n < 0 {
+					return res, 0, errors.New("ERR The MAXLEN argument must be >= 0.")
+				}
+				res.trim.strategy, res.trim.maxLen = streamTrimMaxLen, n
+			} else {
+				id, err := parseStreamID(args[i], 0)
+				if err != nil {
+					return res, 0, err
+				}
+				res.trim.strategy, res.trim.minID = streamTrimMinID, id
+			}
+		case opt == "limit" && more:
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return res, 0, ErrNotInteger
+			}
+			if n < 0 {
+				return res, 0, errors.New("ERR The LIMIT argument must be >= 0.")
+			}
+			res.trim.limit, limitGiven = n, true
+		case xadd && opt == "nomkstream":
+			res.noMkStream = true
+		case xadd:
+			// the ID
+			break loop
+		default:
+			return res, 0, ErrSyntax
+		}
+	}
+
+	if xadd {
+		if n := len(args) - i - 1; n < 2 || n%2 != 0 {
+			return res, 0, errWrongNumberOfArgs("xadd")
+		}
+		if args[i] != "*" {
+			res.idGiven, res.seqGiven = true, true
+			var err error
+			if strings.HasSuffix(args[i], "-*") {
+				res.seqGiven = false
+				res.id.ms, err = strconv.ParseUint(strings.TrimSuffix(args[i], "-*"), 10, 64)
+			} else {
+				res.id, err = parseStreamID(args[i], 0)
+			}
+			if err != nil {
+				return res, 0, errInvalidStreamID
+			}
+		}
+	} else if res.trim.strategy == 0 {
+		return res, 0, errors.New("ERR syntax error, XTRIM must be called with a trimming strategy")
+	}
+
+	if !res.trim.approx {
+		if limitGiven {
+			return res, 0, errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+	} else if !limitGiven {
+		res.trim.limit = 100 * int64(srv.configInt("stream-node-max-entries", defaultStreamNodeMaxEntries))
+		if res.trim.limit == 0 {
+			res.trim.limit = 10000
+		}
+	}
+	return res, i, nil
+}
+
+// nextID returns the ID of an entry added with the ID options of XADD a:
+// the current time for "*", the next sequence number of the timestamp for
+// "<ms>-*", or the given ID, which must be greater than the last one.
+func (s *stream) nextID(a streamAddTrimArgs, now int64) (streamID, error) {
+	last := s.lastID
+	switch {
+	case !a.idGiven:
+		if uint64(now) > last.ms {
+			return streamID{uint64(now), 0}, nil
+		}
+		id, _ := last.incr()
+		return id, nil
+	case !a.seqGiven:
+		if a.id.ms == last.ms {
+			if last.seq == math.MaxUint64 {
+				return streamID{}, errStreamIDTooSmall
+			}
+			return streamID{last.ms, last.seq + 1}, nil
+		}
+		if a.id.ms < last.ms {
+			return streamID{}, errStreamIDTooSmall
+		}
+		return streamID{a.id.ms, 0}, nil
+	case !last.less(a.id):
+		return streamID{}, errStreamIDTooSmall
+	}
+	return a.id, nil
+}
+
+// writeStreamEntry writes the ID and the field value pairs of e, a null
+// array for the entries of a PEL deleted from the stream.
+func writeStreamEntry(w *RESPWriter, e streamEntry) {
+	w.WriteArrayLen(2)
+	w.WriteBulkString(e.id.String())
+	if e.fields == nil {
+		w.WriteNullArray()
+		return
+	}
+	w.WriteBulkStrings(e.fields)
+}
+
+func writeStreamEntries(w *RESPWriter, entries []streamEntry) {
+	w.WriteArrayLen(len(entries))
+	for _, e := range entries {
+		writeStreamEntry(w, e)
+	}
+}
+
+// XADD key [NOMKSTREAM] [<MAXLEN | MINID> [= | ~] threshold [LIMIT count]] <* | id> field value [field value ...]
+func (srv *Server) onXAdd(c *Client, args []string) error {
+	key := args[0]
+	opts, i, err := srv.parseStreamAddOrTrimArgs(args[1:], true)
+	if err != nil {
+		return err
+	}
+	if opts.idGiven && opts.seqGiven && opts.id == (streamID{}) {
+		return errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	}
+
+	obj, err := c.db.lookupType(key, FieldTypeStream)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		if opts.noMkStream {
+			c.writer.WriteNull()
+			return nil
+		}
+		obj = newObject(FieldTypeStream, EncodingStream, newStream())
+		c.db.Set(key, obj)
+	}
+
+	s := obj.Value.(*stream)
+	if s.lastID == streamMaxID {
+		return errStreamIDExhausted
+	}
+	id, err := s.nextID(opts, mstime())
+	if err != nil {
+		return err
+	}
+	s.append(id, args[i+2:],
+		srv.configInt("stream-node-max-bytes", defaultStreamNodeMaxBytes),
+		srv.configInt("stream-node-max-entries", defaultStreamNodeMaxEntries))
+	if opts.trim.strategy != 0 {
+		s.trim(opts.trim)
+	}
+	c.db.signalKeyAsReady(key)
+	c.writer.WriteBulkString(id.String())
+	return nil
+}
+
+// XTRIM key <MAXLEN | MINID> [= | ~] threshold [LIMIT count]
+func (srv *Server) onXTrim(c *Client, args []string) error {
+	opts, _, err := srv.parseStreamAddOrTrimArgs(args[1:], false)
+	if err != nil {
+		return err
+	}
+	obj, err := c.db.lookupType(args[0], FieldTypeStream)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+	c.writer.WriteInteger(obj.Value.(*stream).trim(opts.trim))
+	return nil
+}
+
+// XDEL key id [id ...]
+func (srv *Server) onXDel(c *Client, args []string) error {
+	ids := make([]streamID, len(args)-1)
+	for i, arg := range args[1:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+
+	obj, err := c.db.lookupType(args[0], FieldTypeStream)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+
+	s := obj.Value.(*stream)
+	deleted := 0
+	for _, id := range ids {
+		if !s.delete(id) {
+			continue
+		}
+		deleted++
+		if s.maxDeletedID.less(id) {
+			s.maxDeletedID = id
+		}
+	}
+	if deleted > 0 {
+		s.updateFirstID()
+	}
+	c.writer.WriteInteger(int64(deleted))
+	return nil
+}
+
+// XLEN key
+func (srv *Server) onXLen(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeStream)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+	c.writer.WriteInteger(int64(obj.Value.(*stream).length))
+	return nil
+}
+
+// XRANGE key start end [COUNT count]
+func (srv *Server) onXRange(c *Client, args []string) error {
+	return srv.xrangeGeneric(c, args[0], args[1], args[2], args[3:], false)
+}
+
+// XREVRANGE key end start [COUNT count]
+func (srv *Server) onXRevRange(c *Client, args []string) error {
+	return srv.xrangeGeneric(c, args[0], args[2], args[1], args[3:], true)
+}
+
+// xrangeGeneric replies with the entries of the stream at key from
+// startArg to endArg, backwards when rev.
+func (srv *Server) xrangeGeneric(c *Client, key, startArg, endArg string, opts []string, rev bool) error {
+	start, startEx, err := parseStreamIntervalID(startArg, 0)
+	if err != nil {
+		return err
+	}
+	end, endEx, err := parseStreamIntervalID(endArg, math.MaxUint64)
+	if err != nil {
+		return err
+	}
+	var ok bool
+	if startEx {
+		if start, ok = start.incr(); !ok {
+			return errors.New("ERR invalid start ID for the interval")
+		}
+	}
+	if endEx {
+		if end, ok = end.decr(); !ok {
+			return errors.New("ERR invalid end ID for the interval")
+		}
+	}
+
+	count := int64(-1)
+	for i := 0; i < len(opts); i++ {
+		if strings.ToLower(opts[i]) != "count" || i+1 == len(opts) {
+			return ErrSyntax
+		}
+		i++
+		n, err := strconv.ParseInt(opts[i], 10, 64)
+		if err != nil {
+			return ErrNotInteger
+		}
+		if n < 0 {
+			n = 0
+		}
+		count = n
+	}
+
+	obj, err := c.db.lookupType(key, FieldTypeStream)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteArrayLen(0)
+		return nil
+	}
+	if count == 0 {
+		c.writer.WriteNullArray()
+		return nil
+	}
+
+	var entries []streamEntry
+	it := obj.Value.(*stream).newIterator(start, end, rev)
+	for count < 0 || int64(len(entries)) < count {
+		e, ok := it.next()
+		if !ok {
+			break
+		}
+		entries = append(entries, e)
+	}
+	writeStreamEntries(c.writer, entries)
+	return nil
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func (srv *Server) onXRead(c *Client, args []string) error {
+	return srv.xreadGeneric(c, "xread", args)
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func (srv *Server) onXReadGroup(c *Client, args []string) error {
+	return srv.xreadGeneric(c, "xreadgroup", args)
+}
+
+// xreadGeneric replies with the entries of every stream after the ID
+// given for it, "$" standing for its last ID.
+//
+// With a group the ID ">" reads the entries never delivered to the group
+// yet, which are added to the PELs of the group and the consumer unless
+// NOACK. Any other ID reads the PEL of the consumer instead.
+//
+// When there is nothing to read and BLOCK is given the client blocks until
+// new entries are added.
+func (srv *Server) xreadGeneric(c *Client, cmd string, args []string) error {
+	xreadgroup := cmd == "xreadgroup"
+	var (
+		count               int64
+		timeout             time.Duration
+		block, noack        bool
+		group, consumerName string
+		hasGroup            bool
+	)
+	streamsIdx := -1
+	for i := 0; i < len(args) && streamsIdx < 0; i++ {
+		more := len(args) - i - 1
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "block" && more > 0:
+			i++
+			t, err := parseTimeoutMs(args[i])
+			if err != nil {
+				return err
+			}
+			timeout, block = t, true
+		case opt == "count" && more > 0:
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return ErrNotInteger
+			}
+			if n < 0 {
+				n = 0
+			}
+			count = n
+		case opt == "streams" && more > 0:
+			if more%2 != 0 {
+				symbol := "$"
+				if xreadgroup {
+					symbol = ">"
+				}
+				return fmt.Errorf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '%s' must be specified.", cmd, symbol)
+			}
+			streamsIdx = i + 1
+		case opt == "group" && more >= 2:
+			if !xreadgroup {
+				return errors.New("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			group, consumerName, hasGroup = args[i+1], args[i+2], true
+			i += 2
+		case opt == "noack":
+			if !xreadgroup {
+				return errors.New("ERR The NOACK option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			noack = true
+		default:
+			return ErrSyntax
+		}
+	}
+	if streamsIdx < 0 {
+		return ErrSyntax
+	}
+	if xreadgroup && !hasGroup {
+		return errors.New("ERR Missing GROUP option for XREADGROUP")
+	}
+
+	n := (len(args) - streamsIdx) / 2
+	keys, idArgs := args[streamsIdx:streamsIdx+n], args[streamsIdx+n:]
+	ids := make([]streamID, n)
+	streams := make([]*stream, n)
+	groups := make([]*streamCG, n)
+	for i, key := range keys {
+		obj, err := c.db.lookupType(key, FieldTypeStream)
+		if err != nil {
+			return err
+		}
+		if obj != nil {
+			streams[i] = obj.Value.(*stream)
+		}
+		if hasGroup {
+			if obj != nil {
+				groups[i] = streams[i].groups[group]
+			}
+			if groups[i] == nil {
+				return fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, group)
+			}
+		}
+
+		switch idArgs[i] {
+		case "$":
+			if xreadgroup {
+				return errors.New("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+			}
+			if streams[i] != nil {
+				ids[i] = streams[i].lastID
+			}
+		case ">":
+			if !xreadgroup {
+				return errors.New("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+			}
+			ids[i] = streamMaxID
+		default:
+			id, err := parseStreamID(idArgs[i], 0)
+			if err != nil {
+				return err
+			}
+			ids[i] = id
+		}
+	}
+
+	type result struct {
+		key     string
+		entries []streamEntry
+	}
+	var results []result
+	now := mstime()
+	for i, s := range streams {
+		if s == nil {
+			continue
+		}
+		after := ids[i]
+		serve, history := false, false
+		var consumer *streamConsumer
+		if hasGroup {
+			if after != streamMaxID {
+				serve, history = true, true
+			} else if last, ok := s.lastValidID(); ok && groups[i].lastID.less(last) {
+				serve, after = true, groups[i].lastID
+			}
+			consumer = groups[i].consumer(consumerName, now)
+			consumer.seenTime = now
+		} else if last, ok := s.lastValidID(); ok && after.less(last) {
+			serve = true
+		}
+		if !serve {
+			continue
+		}
+
+		start, _ := after.incr()
+		var entries []streamEntry
+		switch {
+		case history:
+			entries = s.consumerHistory(consumer, start, count, now)
+		case hasGroup:
+			entries = s.deliver(groups[i], consumer, start, count, noack, now)
+		default:
+			it := s.newIterator(start, streamMaxID, false)
+			for count == 0 || int64(len(entries)) < count {
+				e, ok := it.next()
+				if !ok {
+					break
+				}
+				entries = append(entries, e)
+			}
+		}
+		results = append(results, result{key: keys[i], entries: entries})
+	}
+
+	if len(results) > 0 {
+		// a map of the keys to their entries, or pairs in an array for
+		// RESP2
+		if c.writer.proto == 3 {
+			c.writer.WriteMapLen(len(results))
+		} else {
+			c.writer.WriteArrayLen(len(results))
+		}
+		for _, r := range results {
+			if c.writer.proto != 3 {
+				c.writer.WriteArrayLen(2)
+			}
+			c.writer.WriteBulkString(r.key)
+			writeStreamEntries(c.writer, r.entries)
+		}
+		return nil
+	}
+	if !block {
+		c.writer.WriteNullArray()
+		return nil
+	}
+
+	// the client waits for the entries after the last ID of now, not of
+	// when it is served
+	blockArgs := append([]string(nil), args...)
+	for i := range keys {
+		if idArgs[i] == "$" {
+			blockArgs[streamsIdx+n+i] = ids[i].String()
+		}
+	}
+	if err := srv.blockForKeys(c, keys, FieldTypeStream, timeout, Message{cmd: cmd, args: blockArgs}, c.writer.WriteNullArray); err != nil || c.blocked == nil {
+		return err
+	}
+	c.blocked.ready = func(key string, obj *Object) bool {
+		s := obj.Value.(*stream)
+		for i, k := range keys {
+			if k != key {
+				continue
+			}
+			if hasGroup {
+				// the group destroyed meanwhile is reported by the command
+				g := s.groups[group]
+				return g == nil || g.lastID.less(s.lastID)
+			}
+			return ids[i].less(s.lastID)
+		}
+		return false
+	}
+	return nil
+}
@@ -0,0 +1,1092 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A consumer group delivers the entries of a stream to its consumers, each
+// entry to a single consumer, from the last ID delivered to the group on.
+// Delivered entries are pending in the PEL, pending entries list, of the
+// group and of their consumer until acknowledged with XACK. Other
+// consumers take over the entries pending for too long with XCLAIM or
+// XAUTOCLAIM, so every entry is processed at least once.
+
+// streamInvalidEntriesRead is the entriesRead of a group whose position
+// in the stream is unknown.
+const streamInvalidEntriesRead = -1
+
+type streamCG struct {
+	lastID      streamID // the ID of the last entry delivered
+	entriesRead int64    // the number of entries added up to lastID
+	pel         *streamPEL
+	consumers   map[string]*streamConsumer
+}
+
+type streamConsumer struct {
+	name       string
+	seenTime   int64 // unix ms of its last read or claim
+	activeTime int64 // unix ms of its last delivery, -1 for never
+	pel        *streamPEL
+}
+
+// streamNACK is an entry delivered to a consumer and not acknowledged yet,
+// it is shared by the PELs of the group and of the consumer.
+type streamNACK struct {
+	deliveryTime  int64 // unix ms
+	deliveryCount int64
+	consumer      *streamConsumer
+}
+
+// streamPEL is a pending entries list, the NACKs by entry ID. The IDs
+// are also kept in ID order in a skiplist, encoded big endian as members
+// all scored 0.
+type streamPEL struct {
+	nacks map[streamID]*streamNACK
+	ids   *zskiplist
+}
+
+func newStreamPEL() *streamPEL {
+	return &streamPEL{nacks: map[streamID]*streamNACK{}, ids: newZskiplist()}
+}
+
+func (p *streamPEL) Len() int {
+	return len(p.nacks)
+}
+
+func (p *streamPEL) get(id streamID) *streamNACK {
+	return p.nacks[id]
+}
+
+func (p *streamPEL) insert(id streamID, nack *streamNACK) {
+	if _, ok := p.nacks[id]; !ok {
+		p.ids.Insert(0, id.encode())
+	}
+	p.nacks[id] = nack
+}
+
+func (p *streamPEL) remove(id streamID) bool {
+	if _, ok := p.nacks[id]; !ok {
+		return false
+	}
+	delete(p.nacks, id)
+	p.ids.Delete(0, id.encode())
+	return true
+}
+
+// first and last return the smallest and greatest IDs, the PEL must not
+// be empty.
+func (p *streamPEL) first() streamID {
+	id, _ := decodeStreamID(p.ids.First().member)
+	return id
+}
+
+func (p *streamPEL) last() streamID {
+	id, _ := decodeStreamID(p.ids.Last().member)
+	return id
+}
+
+// pelFrom is the range of the encoded IDs from an encoded ID on.
+type pelFrom string
+
+func (r pelFrom) gteMin(_ float64, member string) bool { return member >= string(r) }
+func (r pelFrom) lteMax(float64, string) bool          { return true }
+
+// iterate calls fn for the NACKs from start on, in ID order, until it
+// returns false. fn may remove the NACK it is called for.
+func (p *streamPEL) iterate(start streamID, fn func(id streamID, nack *streamNACK) bool) {
+	for x := p.ids.FirstInRange(pelFrom(start.encode())); x != nil; {
+		next := x.Next()
+		id, _ := decodeStreamID(x.member)
+		if !fn(id, p.nacks[id]) {
+			return
+		}
+		x = next
+	}
+}
+
+// createGroup adds the group name delivering the entries after id, nil
+// when it already exists.
+func (s *stream) createGroup(name string, id streamID, entriesRead int64) *streamCG {
+	if s.groups == nil {
+		s.groups = map[string]*streamCG{}
+	}
+	if _, ok := s.groups[name]; ok {
+		return nil
+	}
+	g := &streamCG{lastID: id, entriesRead: entriesRead, pel: newStreamPEL(), consumers: map[string]*streamConsumer{}}
+	s.groups[name] = g
+	return g
+}
+
+// groupNames returns the names of the groups, sorted like Redis lists them.
+func (s *stream) groupNames() []string {
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (g *streamCG) consumerNames() []string {
+	names := make([]string, 0, len(g.consumers))
+	for name := range g.consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// createConsumer adds the consumer name, nil when it already exists.
+func (g *streamCG) createConsumer(name string, now int64) *streamConsumer {
+	if _, ok := g.consumers[name]; ok {
+		return nil
+	}
+	c := &streamConsumer{name: name, seenTime: now, activeTime: -1, pel: newStreamPEL()}
+	g.consumers[name] = c
+	return c
+}
+
+// consumer returns the consumer name, created when missing.
+func (g *streamCG) consumer(name string, now int64) *streamConsumer {
+	if c, ok := g.consumers[name]; ok {
+		return c
+	}
+	return g.createConsumer(name, now)
+}
+
+// deleteConsumer removes c and its pending entries from the group.
+func (g *streamCG) deleteConsumer(c *streamConsumer) {
+	c.pel.iterate(streamID{}, func(id streamID, _ *streamNACK) bool {
+		g.pel.remove(id)
+		return true
+	})
+	delete(g.consumers, c.name)
+}
+
+// dup returns a deep copy of g, the NACKs being shared by the copies of
+// the PELs.
+func (g *streamCG) dup() *streamCG {
+	res := &streamCG{lastID: g.lastID, entriesRead: g.entriesRead, pel: newStreamPEL(), consumers: map[string]*streamConsumer{}}
+	consumers := make(map[*streamConsumer]*streamConsumer, len(g.consumers))
+	for name, c := range g.consumers {
+		cp := &streamConsumer{name: name, seenTime: c.seenTime, activeTime: c.activeTime, pel: newStreamPEL()}
+		res.consumers[name] = cp
+		consumers[c] = cp
+	}
+	g.pel.iterate(streamID{}, func(id streamID, nack *streamNACK) bool {
+		cp := &streamNACK{deliveryTime: nack.deliveryTime, deliveryCount: nack.deliveryCount, consumer: consumers[nack.consumer]}
+		res.pel.insert(id, cp)
+		cp.consumer.pel.insert(id, cp)
+		return true
+	})
+	return res
+}
+
+// rangeHasTombstones reports whether entries from start on may have been
+// deleted, which makes counting the entries read from the IDs unreliable.
+func (s *stream) rangeHasTombstones(start streamID) bool {
+	if s.length == 0 || s.maxDeletedID == (streamID{}) {
+		return false
+	}
+	return !s.maxDeletedID.less(start)
+}
+
+// estimateEntriesRead returns the number of entries added up to id when it
+// can be told from the counters of s, streamInvalidEntriesRead otherwise.
+func (s *stream) estimateEntriesRead(id streamID) int64 {
+	added := int64(s.entriesAdded)
+	switch {
+	case added == 0:
+		return 0
+	case s.length == 0 && !s.lastID.less(id):
+		return added
+	case id == s.lastID:
+		return added
+	case s.lastID.less(id):
+		return streamInvalidEntriesRead
+	}
+	if s.maxDeletedID == (streamID{}) || s.maxDeletedID.less(s.firstID) {
+		// no entry was deleted past the first one
+		if id.less(s.firstID) {
+			return added - int64(s.length)
+		}
+		if id == s.firstID {
+			return added - int64(s.length) + 1
+		}
+	}
+	return streamInvalidEntriesRead
+}
+
+// groupLag returns the number of entries not delivered to g yet, false
+// when it can not be told.
+func (s *stream) groupLag(g *streamCG) (int64, bool) {
+	if s.entriesAdded == 0 {
+		return 0, true
+	}
+	if g.entriesRead != streamInvalidEntriesRead && !s.rangeHasTombstones(g.lastID) {
+		return int64(s.entriesAdded) - g.entriesRead, true
+	}
+	if read := s.estimateEntriesRead(g.lastID); read != streamInvalidEntriesRead {
+		g.entriesRead = read
+		return int64(s.entriesAdded) - read, true
+	}
+	return 0, false
+}
+
+// deliver returns up to count entries from start on, all when count is 0,
+// delivered to consumer of g. They are added to the PELs of g and of the
+// consumer unless noack.
+func (s *stream) deliver(g *streamCG, consumer *streamConsumer, start streamID, count int64, noack bool, now int64) []streamEntry {
+	var res []streamEntry
+	it := s.newIterator(start, streamMaxID, false)
+	for count == 0 || int64(len(res)) < count {
+		e, ok := it.next()
+		if !ok {
+			break
+		}
+		if g.lastID.less(e.id) {
+			if g.entriesRead != streamInvalidEntriesRead && !s.rangeHasTombstones(e.id) {
+				g.entriesRead++
+			} else if s.entriesAdded != 0 {
+				g.entriesRead = s.estimateEntriesRead(e.id)
+			}
+			g.lastID = e.id
+		}
+
+		if !noack {
+			// an entry delivered again after XGROUP SETID moves to the
+			// consumer
+			nack := g.pel.get(e.id)
+			if nack != nil {
+				nack.consumer.pel.remove(e.id)
+				nack.consumer, nack.deliveryTime, nack.deliveryCount = consumer, now, 1
+			} else {
+				nack = &streamNACK{deliveryTime: now, deliveryCount: 1, consumer: consumer}
+				g.pel.insert(e.id, nack)
+			}
+			consumer.pel.insert(e.id, nack)
+			consumer.activeTime = now
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// consumerHistory returns up to count entries of the PEL of consumer from
+// start on, all when count is 0, which count as delivered again. Entries
+// deleted from the stream since have no fields.
+func (s *stream) consumerHistory(consumer *streamConsumer, start streamID, count int64, now int64) []streamEntry {
+	var res []streamEntry
+	consumer.pel.iterate(start, func(id streamID, nack *streamNACK) bool {
+		if count > 0 && int64(len(res)) == count {
+			return false
+		}
+		e, ok := s.get(id)
+		if ok {
+			nack.deliveryTime = now
+			nack.deliveryCount++
+		} else {
+			e = streamEntry{id: id}
+		}
+		res = append(res, e)
+		return true
+	})
+	return res
+}
+
+// claim moves the NACK of id to consumer, removing it from the PEL of its
+// previous consumer.
+func (g *streamCG) claim(id streamID, nack *streamNACK, consumer *streamConsumer) {
+	if nack.consumer == consumer {
+		return
+	}
+	if nack.consumer != nil {
+		nack.consumer.pel.remove(id)
+	}
+	consumer.pel.insert(id, nack)
+	nack.consumer = consumer
+}
+
+// forget removes the NACK of id from the PELs.
+func (g *streamCG) forget(id streamID, nack *streamNACK) {
+	g.pel.remove(id)
+	if nack.consumer != nil {
+		nack.consumer.pel.remove(id)
+	}
+}
+
+// lookupStreamGroup returns the group name of the stream at key, failing
+// with errNoGroup when the key or the group is missing.
+func (c *Client) lookupStreamGroup(key, name string) (*stream, *streamCG, error) {
+	obj, err := c.db.lookupType(key, FieldTypeStream)
+	if err != nil {
+		return nil, nil, err
+	}
+	if obj != nil {
+		s := obj.Value.(*stream)
+		if g := s.groups[name]; g != nil {
+			return s, g, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, name)
+}
+
+func errNoGroup(key, name string) error {
+	return fmt.Errorf("NOGROUP No such consumer group '%s' for key name '%s'", name, key)
+}
+
+// the arity of the XGROUP subcommands, negative for a minimum
+var xgroupArity = map[string]int{
+	"create":         -4,
+	"setid":          -4,
+	"destroy":        3,
+	"createconsumer": 4,
+	"delconsumer":    4,
+}
+
+// XGROUP CREATE key group <id | $> [MKSTREAM] [ENTRIESREAD entries-read]
+// XGROUP SETID key group <id | $> [ENTRIESREAD entries-read]
+// XGROUP DESTROY key group
+// XGROUP CREATECONSUMER key group consumer
+// XGROUP DELCONSUMER key group consumer
+func (srv *Server) onXGroup(c *Client, args []string) error {
+	sub := strings.ToLower(args[0])
+	arity, ok := xgroupArity[sub]
+	if !ok {
+		return errUnknownSubcommand("xgroup", sub)
+	}
+	if arity > 0 && len(args) != arity || arity < 0 && len(args) < -arity {
+		return errWrongNumberOfArgs("xgroup|" + sub)
+	}
+
+	mkstream := false
+	entriesRead := int64(streamInvalidEntriesRead)
+	for i := 4; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); {
+		case sub == "create" && opt == "mkstream":
+			mkstream = true
+		case opt == "entriesread" && i+1 < len(args):
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return ErrNotInteger
+			}
+			if n < 0 && n != streamInvalidEntriesRead {
+				return errors.New("ERR value for ENTRIESREAD must be positive or -1")
+			}
+			entriesRead = n
+		default:
+			return ErrSyntax
+		}
+	}
+
+	key, name := args[1], args[2]
+	obj, err := c.db.lookupType(key, FieldTypeStream)
+	if err != nil {
+		return err
+	}
+	var s *stream
+	var g *streamCG
+	if obj != nil {
+		s = obj.Value.(*stream)
+		g = s.groups[name]
+	}
+	if !mkstream {
+		if s == nil {
+			return errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+		}
+		if g == nil && sub != "create" && sub != "destroy" {
+			return errNoGroup(key, name)
+		}
+	}
+
+	now := mstime()
+	switch sub {
+	case "create", "setid":
+		var id streamID
+		if args[3] == "$" {
+			if s != nil {
+				id = s.lastID
+			}
+		} else if id, err = parseStreamID(args[3], 0); err != nil {
+			return err
+		}
+
+		if sub == "setid" {
+			g.lastID, g.entriesRead = id, entriesRead
+			c.writer.WriteSimpleString("OK")
+			return nil
+		}
+		if g != nil {
+			return errors.New("BUSYGROUP Consumer Group name already exists")
+		}
+		if s == nil {
+			s = newStream()
+			c.db.Set(key, newObject(FieldTypeStream, EncodingStream, s))
+		}
+		s.createGroup(name, id, entriesRead)
+		c.writer.WriteSimpleString("OK")
+	case "destroy":
+		if g == nil {
+			c.writer.WriteInteger(0)
+			return nil
+		}
+		delete(s.groups, name)
+		// the clients blocked on the group get an error
+		c.db.signalKeyAsReady(key)
+		c.writer.WriteInteger(1)
+	case "createconsumer":
+		if g.createConsumer(args[3], now) == nil {
+			c.writer.WriteInteger(0)
+		} else {
+			c.writer.WriteInteger(1)
+		}
+	case "delconsumer":
+		consumer := g.consumers[args[3]]
+		if consumer == nil {
+			c.writer.WriteInteger(0)
+			return nil
+		}
+		pending := consumer.pel.Len()
+		g.deleteConsumer(consumer)
+		c.writer.WriteInteger(int64(pending))
+	}
+	return nil
+}
+
+// XACK key group id [id ...]
+func (srv *Server) onXAck(c *Client, args []string) error {
+	ids := make([]streamID, len(args)-2)
+	for i, arg := range args[2:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+
+	obj, err := c.db.lookupType(args[0], FieldTypeStream)
+	if err != nil {
+		return err
+	}
+	var g *streamCG
+	if obj != nil {
+		g = obj.Value.(*stream).groups[args[1]]
+	}
+	if g == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+
+	acked := 0
+	for _, id := range ids {
+		if nack := g.pel.get(id); nack != nil {
+			g.forget(id, nack)
+			acked++
+		}
+	}
+	c.writer.WriteInteger(int64(acked))
+	return nil
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func (srv *Server) onXPending(c *Client, args []string) error {
+	key, name := args[0], args[1]
+	extended := len(args) > 2
+	if extended && (len(args) < 5 || len(args) > 8) {
+		return ErrSyntax
+	}
+
+	var minIdle, count int64
+	var start, end streamID
+	consumerName := ""
+	if extended {
+		i := 2
+		if strings.ToLower(args[i]) == "idle" {
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return ErrNotInteger
+			}
+			if len(args) < 7 {
+				return ErrSyntax
+			}
+			minIdle = n
+			i += 2
+		}
+		if i+3 < len(args)-1 {
+			return ErrSyntax
+		}
+
+		n, err := strconv.ParseInt(args[i+2], 10, 64)
+		if err != nil {
+			return ErrNotInteger
+		}
+		if n > 0 {
+			count = n
+		}
+		start, end, err = parseStreamInterval(args[i], args[i+1])
+		if err != nil {
+			return err
+		}
+		if i+3 < len(args) {
+			consumerName = args[i+3]
+		}
+	}
+
+	_, g, err := c.lookupStreamGroup(key, name)
+	if err != nil {
+		return err
+	}
+
+	if !extended {
+		c.writer.WriteArrayLen(4)
+		c.writer.WriteInteger(int64(g.pel.Len()))
+		if g.pel.Len() == 0 {
+			c.writer.WriteNull()
+			c.writer.WriteNull()
+			c.writer.WriteNullArray()
+			return nil
+		}
+		c.writer.WriteBulkString(g.pel.first().String())
+		c.writer.WriteBulkString(g.pel.last().String())
+
+		// the consumers with pending entries and their number
+		var pending []*streamConsumer
+		for _, consumerName := range g.consumerNames() {
+			if consumer := g.consumers[consumerName]; consumer.pel.Len() > 0 {
+				pending = append(pending, consumer)
+			}
+		}
+		c.writer.WriteArrayLen(len(pending))
+		for _, consumer := range pending {
+			c.writer.WriteArrayLen(2)
+			c.writer.WriteBulkString(consumer.name)
+			c.writer.WriteBulkString(strconv.Itoa(consumer.pel.Len()))
+		}
+		return nil
+	}
+
+	pel := g.pel
+	if consumerName != "" {
+		consumer := g.consumers[consumerName]
+		if consumer == nil {
+			c.writer.WriteArrayLen(0)
+			return nil
+		}
+		pel = consumer.pel
+	}
+
+	type pendingEntry struct {
+		id   streamID
+		nack *streamNACK
+	}
+	var res []pendingEntry
+	now := mstime()
+	pel.iterate(start, func(id streamID, nack *streamNACK) bool {
+		if end.less(id) || int64(len(res)) >= count {
+			return false
+		}
+		if minIdle == 0 || now-nack.deliveryTime >= minIdle {
+			res = append(res, pendingEntry{id: id, nack: nack})
+		}
+		return true
+	})
+	c.writer.WriteArrayLen(len(res))
+	for _, e := range res {
+		c.writer.WriteArrayLen(4)
+		c.writer.WriteBulkString(e.id.String())
+		c.writer.WriteBulkString(e.nack.consumer.name)
+		c.writer.WriteInteger(now - e.nack.deliveryTime)
+		c.writer.WriteInteger(e.nack.deliveryCount)
+	}
+	return nil
+}
+
+// parseStreamInterval parses the bounds of a range of IDs like XRANGE
+// does, excluded bounds being moved to the next ID inside.
+func parseStreamInterval(startArg, endArg string) (start, end streamID, err error) {
+	start, startEx, err := parseStreamIntervalID(startArg, 0)
+	if err != nil {
+		return start, end, err
+	}
+	end, endEx, err := parseStreamIntervalID(endArg, math.MaxUint64)
+	if err != nil {
+		return start, end, err
+	}
+	var ok bool
+	if startEx {
+		if start, ok = start.incr(); !ok {
+			return start, end, errors.New("ERR invalid start ID for the interval")
+		}
+	}
+	if endEx {
+		if end, ok = end.decr(); !ok {
+			return start, end, errors.New("ERR invalid end ID for the interval")
+		}
+	}
+	return start, end, nil
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func (srv *Server) onXClaim(c *Client, args []string) error {
+	s, g, err := c.lookupStreamGroup(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return errors.New("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+
+	// the IDs end at the first argument that is not one, the options
+	// follow
+	var ids []streamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	now := mstime()
+	deliveryTime, retryCount := int64(-1), int64(-1)
+	force, justID := false, false
+	var lastID streamID
+	for ; i < len(args); i++ {
+		more := i+1 < len(args)
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "force":
+			force = true
+		case opt == "justid":
+			justID = true
+		case opt == "idle" && more:
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return errors.New("ERR Invalid IDLE option argument for XCLAIM")
+			}
+			deliveryTime = now - n
+		case opt == "time" && more:
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return errors.New("ERR Invalid TIME option argument for XCLAIM")
+			}
+			deliveryTime = n
+		case opt == "retrycount" && more:
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return errors.New("ERR Invalid RETRYCOUNT option argument for XCLAIM")
+			}
+			retryCount = n
+		case opt == "lastid" && more:
+			i++
+			if lastID, err = parseStreamID(args[i], 0); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("ERR Unrecognized XCLAIM option '%s'", args[i])
+		}
+	}
+	// a bogus delivery time, maybe from a client clock ahead, is now
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+	if g.lastID.less(lastID) {
+		g.lastID = lastID
+	}
+
+	var consumer *streamConsumer
+	var claimed []streamEntry
+	for _, id := range ids {
+		nack := g.pel.get(id)
+		e, ok := s.get(id)
+		if !ok {
+			// the entry was deleted, it can not be processed anymore
+			if nack != nil {
+				g.forget(id, nack)
+			}
+			continue
+		}
+
+		// FORCE adds the entries missing from the PEL, whatever the idle
+		// time
+		if nack == nil {
+			if !force {
+				continue
+			}
+			nack = &streamNACK{}
+			g.pel.insert(id, nack)
+		} else if minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+
+		if consumer == nil {
+			consumer = g.consumer(args[2], now)
+		}
+		g.claim(id, nack, consumer)
+		nack.deliveryTime = deliveryTime
+		if retryCount >= 0 {
+			nack.deliveryCount = retryCount
+		} else if !justID {
+			nack.deliveryCount++
+		}
+		consumer.activeTime = now
+		claimed = append(claimed, e)
+	}
+	if consumer != nil {
+		consumer.seenTime = now
+	}
+
+	c.writer.WriteArrayLen(len(claimed))
+	for _, e := range claimed {
+		if justID {
+			c.writer.WriteBulkString(e.id.String())
+		} else {
+			writeStreamEntry(c.writer, e)
+		}
+	}
+	return nil
+}
+
+// xautoclaimAttemptsFactor bounds the PEL entries XAUTOCLAIM looks at to
+// this many times COUNT.
+const xautoclaimAttemptsFactor = 10
+
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func (srv *Server) onXAutoClaim(c *Client, args []string) error {
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return errors.New("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, startEx, err := parseStreamIntervalID(args[4], 0)
+	if err != nil {
+		return err
+	}
+	if startEx {
+		var ok bool
+		if start, ok = start.incr(); !ok {
+			return errors.New("ERR invalid start ID for the interval")
+		}
+	}
+
+	count := int64(100)
+	justID := false
+	for i := 5; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "count" && i+1 < len(args):
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || n < 1 || n > math.MaxInt64/xautoclaimAttemptsFactor {
+				return errors.New("ERR COUNT must be > 0")
+			}
+			count = n
+		case opt == "justid":
+			justID = true
+		default:
+			return ErrSyntax
+		}
+	}
+
+	s, g, err := c.lookupStreamGroup(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	now := mstime()
+	consumer := g.consumer(args[2], now)
+	consumer.seenTime = now
+	attempts := count * xautoclaimAttemptsFactor
+	var claimed []streamEntry
+	var deleted []streamID
+	var next streamID // where the next call starts, 0-0 once the PEL is walked
+	g.pel.iterate(start, func(id streamID, nack *streamNACK) bool {
+		if attempts == 0 || count == 0 {
+			next = id
+			return false
+		}
+		attempts--
+
+		e, ok := s.get(id)
+		if !ok {
+			g.forget(id, nack)
+			deleted = append(deleted, id)
+			count--
+			return true
+		}
+		if minIdle > 0 && now-nack.deliveryTime < minIdle {
+			return true
+		}
+
+		g.claim(id, nack, consumer)
+		nack.deliveryTime = now
+		if !justID {
+			nack.deliveryCount++
+		}
+		consumer.activeTime = now
+		claimed = append(claimed, e)
+		count--
+		return true
+	})
+
+	c.writer.WriteArrayLen(3)
+	c.writer.WriteBulkString(next.String())
+	c.writer.WriteArrayLen(len(claimed))
+	for _, e := range claimed {
+		if justID {
+			c.writer.WriteBulkString(e.id.String())
+		} else {
+			writeStreamEntry(c.writer, e)
+		}
+	}
+	c.writer.WriteArrayLen(len(deleted))
+	for _, id := range deleted {
+		c.writer.WriteBulkString(id.String())
+	}
+	return nil
+}
+
+// the arity of the XINFO subcommands, negative for a minimum
+var xinfoArity = map[string]int{
+	"stream":    -2,
+	"groups":    2,
+	"consumers": 3,
+}
+
+// XINFO STREAM key [FULL [COUNT count]]
+// XINFO GROUPS key
+// XINFO CONSUMERS key group
+func (srv *Server) onXInfo(c *Client, args []string) error {
+	sub := strings.ToLower(args[0])
+	arity, ok := xinfoArity[sub]
+	if !ok {
+		return errUnknownSubcommand("xinfo", sub)
+	}
+	if arity > 0 && len(args) != arity || arity < 0 && len(args) < -arity {
+		return errWrongNumberOfArgs("xinfo|" + sub)
+	}
+
+	key := args[1]
+	obj, err := c.db.lookupType(key, FieldTypeStream)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return errors.New("ERR no such key")
+	}
+	s := obj.Value.(*stream)
+	now := mstime()
+
+	switch sub {
+	case "stream":
+		return srv.xinfoStream(c, s, args[2:])
+	case "groups":
+		c.writer.WriteArrayLen(len(s.groups))
+		for _, name := range s.groupNames() {
+			g := s.groups[name]
+			c.writer.WriteMapLen(6)
+			c.writer.WriteBulkString("name")
+			c.writer.WriteBulkString(name)
+			c.writer.WriteBulkString("consumers")
+			c.writer.WriteInteger(int64(len(g.consumers)))
+			c.writer.WriteBulkString("pending")
+			c.writer.WriteInteger(int64(g.pel.Len()))
+			writeStreamGroupPosition(c.writer, s, g)
+		}
+	case "consumers":
+		g := s.groups[args[2]]
+		if g == nil {
+			return errNoGroup(key, args[2])
+		}
+		c.writer.WriteArrayLen(len(g.consumers))
+		for _, name := range g.consumerNames() {
+			consumer := g.consumers[name]
+			inactive := int64(-1)
+			if consumer.activeTime != -1 {
+				inactive = now - consumer.activeTime
+			}
+			c.writer.WriteMapLen(4)
+			c.writer.WriteBulkString("name")
+			c.writer.WriteBulkString(name)
+			c.writer.WriteBulkString("pending")
+			c.writer.WriteInteger(int64(consumer.pel.Len()))
+			c.writer.WriteBulkString("idle")
+			c.writer.WriteInteger(now - consumer.seenTime)
+			c.writer.WriteBulkString("inactive")
+			c.writer.WriteInteger(inactive)
+		}
+	}
+	return nil
+}
+
+// writeStreamGroupPosition writes the last delivered ID of g, the entries
+// read up to it and its lag, both null when unknown.
+func writeStreamGroupPosition(w *RESPWriter, s *stream, g *streamCG) {
+	w.WriteBulkString("last-delivered-id")
+	w.WriteBulkString(g.lastID.String())
+	w.WriteBulkString("entries-read")
+	lag, lagOK := s.groupLag(g)
+	if g.entriesRead != streamInvalidEntriesRead {
+		w.WriteInteger(g.entriesRead)
+	} else {
+		w.WriteNull()
+	}
+	w.WriteBulkString("lag")
+	if lagOK {
+		w.WriteInteger(lag)
+	} else {
+		w.WriteNull()
+	}
+}
+
+// xinfoStream replies with the metadata of s, and with FULL with its
+// entries and groups, the first COUNT of them, 10 by default and all with
+// 0.
+func (srv *Server) xinfoStream(c *Client, s *stream, opts []string) error {
+	full := false
+	count := int64(10)
+	if len(opts) > 0 {
+		if strings.ToLower(opts[0]) != "full" {
+			return ErrSyntax
+		}
+		full = true
+		if len(opts) > 1 {
+			if len(opts) != 3 || strings.ToLower(opts[1]) != "count" {
+				return ErrSyntax
+			}
+			n, err := strconv.ParseInt(opts[2], 10, 64)
+			if err != nil {
+				return ErrNotInteger
+			}
+			if n >= 0 {
+				count = n
+			}
+		}
+	}
+
+	w := c.writer
+	if full {
+		w.WriteMapLen(9)
+	} else {
+		w.WriteMapLen(10)
+	}
+	// the nodes stand for the keys and the nodes of the radix tree of Redis
+	for _, kv := range []struct {
+		name  string
+		value int64
+	}{
+		{"length", int64(s.length)},
+		{"radix-tree-keys", int64(len(s.nodes))},
+		{"radix-tree-nodes", int64(len(s.nodes))},
+	} {
+		w.WriteBulkString(kv.name)
+		w.WriteInteger(kv.value)
+	}
+	w.WriteBulkString("last-generated-id")
+	w.WriteBulkString(s.lastID.String())
+	w.WriteBulkString("max-deleted-entry-id")
+	w.WriteBulkString(s.maxDeletedID.String())
+	w.WriteBulkString("entries-added")
+	w.WriteInteger(int64(s.entriesAdded))
+	w.WriteBulkString("recorded-first-entry-id")
+	w.WriteBulkString(s.firstID.String())
+
+	if !full {
+		w.WriteBulkString("groups")
+		w.WriteInteger(int64(len(s.groups)))
+		for _, rev := range []bool{false, true} {
+			if rev {
+				w.WriteBulkString("last-entry")
+			} else {
+				w.WriteBulkString("first-entry")
+			}
+			if e, ok := s.newIterator(streamID{}, streamMaxID, rev).next(); ok {
+				writeStreamEntry(w, e)
+			} else {
+				w.WriteNull()
+			}
+		}
+		return nil
+	}
+
+	var entries []streamEntry
+	it := s.newIterator(streamID{}, streamMaxID, false)
+	for count == 0 || int64(len(entries)) < count {
+		e, ok := it.next()
+		if !ok {
+			break
+		}
+		entries = append(entries, e)
+	}
+	w.WriteBulkString("entries")
+	writeStreamEntries(w, entries)
+
+	// the first count entries of a PEL
+	pending := func(pel *streamPEL, fn func(id streamID, nack *streamNACK)) {
+		n := pel.Len()
+		if count > 0 && int64(n) > count {
+			n = int(count)
+		}
+		w.WriteArrayLen(n)
+		pel.iterate(streamID{}, func(id streamID, nack *streamNACK) bool {
+			if n == 0 {
+				return false
+			}
+			n--
+			fn(id, nack)
+			return true
+		})
+	}
+
+	w.WriteBulkString("groups")
+	w.WriteArrayLen(len(s.groups))
+	for _, name := range s.groupNames() {
+		g := s.groups[name]
+		w.WriteMapLen(7)
+		w.WriteBulkString("name")
+		w.WriteBulkString(name)
+		writeStreamGroupPosition(w, s, g)
+		w.WriteBulkString("pel-count")
+		w.WriteInteger(int64(g.pel.Len()))
+		w.WriteBulkString("pending")
+		pending(g.pel, func(id streamID, nack *streamNACK) {
+			w.WriteArrayLen(4)
+			w.WriteBulkString(id.String())
+			w.WriteBulkString(nack.consumer.name)
+			w.WriteInteger(nack.deliveryTime)
+			w.WriteInteger(nack.deliveryCount)
+		})
+
+		w.WriteBulkString("consumers")
+		w.WriteArrayLen(len(g.consumers))
+		for _, consumerName := range g.consumerNames() {
+			consumer := g.consumers[consumerName]
+			w.WriteMapLen(5)
+			w.WriteBulkString("name")
+			w.WriteBulkString(consumerName)
+			w.WriteBulkString("seen-time")
+			w.WriteInteger(consumer.seenTime)
+			w.WriteBulkString("active-time")
+			w.WriteInteger(consumer.activeTime)
+			w.WriteBulkString("pel-count")
+			w.WriteInteger(int64(consumer.pel.Len()))
+			w.WriteBulkString("pending")
+			pending(consumer.pel, func(id streamID, nack *streamNACK) {
+				w.WriteArrayLen(3)
+				w.WriteBulkString(id.String())
+				w.WriteInteger(nack.deliveryTime)
+				w.WriteInteger(nack.deliveryCount)
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	s := newStream()
+	var want []streamEntry
+	for i := 0; i < 250; i++ {
+		id := streamID{uint64(i / 3), uint64(i % 3)}
+		fields := []string{"a", fmt.Sprint(i), "b", "x"}
+		if i%7 == 0 {
+			fields = []string{"c", fmt.Sprint(-i)}
+		}
+		s.append(id, fields, 0, 10)
+		want = append(want, streamEntry{id: id, fields: fields})
+	}
+	if len(s.nodes) != 25 || s.length != 250 || s.lastID != want[249].id {
+		t.Fatalf("unexpected stream of %d nodes, %d entries and last ID %s", len(s.nodes), s.length, s.lastID)
+	}
+
+	// drop every fifth entry, and all the entries of the third node
+	for i := len(want) - 1; i >= 0; i-- {
+		if i%5 == 0 || i >= 20 && i < 30 {
+			if !s.delete(want[i].id) {
+				t.Fatalf("delete %s failed", want[i].id)
+			}
+			want = append(want[:i], want[i+1:]...)
+		}
+	}
+	if s.delete(streamID{0, 0}) || s.delete(streamID{1000, 0}) {
+		t.Fatal("delete of a missing ID succeeded")
+	}
+	s.updateFirstID()
+	if len(s.nodes) != 24 || s.length != len(want) || s.firstID != want[0].id {
+		t.Fatalf("unexpected stream of %d nodes, %d entries and first ID %s", len(s.nodes), s.length, s.firstID)
+	}
+
+	check := func(start, end streamID, rev bool) {
+		t.Helper()
+		var expect []streamEntry
+		for _, e := range want {
+			if !e.id.less(start) && !end.less(e.id) {
+				expect = append(expect, e)
+			}
+		}
+		if rev {
+			for i, j := 0, len(expect)-1; i < j; i, j = i+1, j-1 {
+				expect[i], expect[j] = expect[j], expect[i]
+			}
+		}
+		it := s.newIterator(start, end, rev)
+		for i := 0; ; i++ {
+			e, ok := it.next()
+			if !ok {
+				if i != len(expect) {
+					t.Fatalf("range %s %s rev %v: %d entries want %d", start, end, rev, i, len(expect))
+				}
+				return
+			}
+			if i >= len(expect) || e.id != expect[i].id || fmt.Sprint(e.fields) != fmt.Sprint(expect[i].fields) {
+				t.Fatalf("range %s %s rev %v: entry %d is %v", start, end, rev, i, e)
+			}
+		}
+	}
+	for _, rev := range []bool{false, true} {
+		check(streamID{}, streamMaxID, rev)
+		check(streamID{5, 1}, streamID{40, 0}, rev)
+		check(streamID{7, 0}, streamID{9, 2}, rev)
+		check(streamID{20, 0}, streamID{10, 0}, rev)
+	}
+
+	cp := s.dup()
+	if removed := s.trim(streamTrimArgs{strategy: streamTrimMaxLen, maxLen: 100, approx: true}); s.length < 100 || s.length-100 >= 10 || int(removed) != len(want)-s.length {
+		t.Fatalf("approximate trim removed %d entries down to %d", removed, s.length)
+	}
+	want = want[len(want)-s.length:]
+	if removed := s.trim(streamTrimArgs{strategy: streamTrimMaxLen, maxLen: 50}); removed != int64(len(want)-50) || s.length != 50 {
+		t.Fatalf("trim removed %d entries down to %d", removed, s.length)
+	}
+	want = want[len(want)-50:]
+	check(streamID{}, streamMaxID, false)
+	if s.firstID != want[0].id {
+		t.Fatalf("first ID %s want %s", s.firstID, want[0].id)
+	}
+
+	minID := streamID{75, 0}
+	s.trim(streamTrimArgs{strategy: streamTrimMinID, minID: minID})
+	for len(want) > 0 && want[0].id.less(minID) {
+		want = want[1:]
+	}
+	check(streamID{}, streamMaxID, true)
+
+	if cp.length == s.length {
+		t.Fatal("trimming a stream changed its copy")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// setOptions are the options of SET, see parseSetOptions.
+type setOptions struct {
+	nx, xx  bool
+	get     bool
+	keepTTL bool
+	expire  bool
+	when    int64 // unix ms timestamp the key expires at when expire is set
+}
+
+// parseSetOptions parses the options following SET key value.
+// [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds |
+// PXAT unix-time-milliseconds | KEEPTTL]
+func parseSetOptions(args []string) (setOptions, error) {
+	var opt setOptions
+	var expireFlag string
+	for i := 0; i < len(args); i++ {
+		flag := strings.ToLower(args[i])
+		switch flag {
+		case "nx":
+			if opt.xx {
+				return opt, ErrSyntax
+			}
+			opt.nx = true
+		case "xx":
+			if opt.nx {
+				return opt, ErrSyntax
+			}
+			opt.xx = true
+		case "get":
+			opt.get = true
+		case "keepttl":
+			if expireFlag != "" {
+				return opt, ErrSyntax
+			}
+			expireFlag = flag
+			opt.keepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if expireFlag != "" || i+1 >= len(args) {
+				return opt, ErrSyntax
+			}
+			expireFlag = flag
+			i++
+
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return opt, ErrNotInteger
+			}
+			if n <= 0 {
+				return opt, errInvalidExpireTime("set")
+			}
+
+			switch flag {
+			case "ex", "exat":
+				if n > math.MaxInt64/1000 {
+					return opt, errInvalidExpireTime("set")
+				}
+				n *= 1000
+			}
+			switch flag {
+			case "ex", "px":
+				if n > math.MaxInt64-mstime() {
+					return opt, errInvalidExpireTime("set")
+				}
+				n += mstime()
+			}
+
+			opt.expire = true
+			opt.when = n
+		default:
+			return opt, ErrSyntax
+		}
+	}
+	return opt, nil
+}
+
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+func (srv *Server) onSet(c *Client, args []string) error {
+	key, val := args[0], args[1]
+	opt, err := parseSetOptions(args[2:])
+	if err != nil {
+		return err
+	}
+
+	old := c.db.lookup(key)
+	exists := old != nil
+	if opt.get {
+		if exists && old.Type != FieldTypeString {
+			return ErrWrongType
+		}
+		if exists {
+			c.writer.WriteBulkString(old.str())
+		} else {
+			c.writer.WriteNull()
+		}
+	}
+
+	if (opt.nx && exists) || (opt.xx && !exists) {
+		if !opt.get {
+			c.writer.WriteNull()
+		}
+		return nil
+	}
+
+	if opt.keepTTL {
+		c.db.SetKeepTTL(key, newStringObject(val))
+	} else {
+		c.db.Set(key, newStringObject(val))
+	}
+
+	if opt.expire {
+		if opt.when <= mstime() {
+			c.db.Delete(key)
+		} else {
+			c.db.SetExpire(key, opt.when)
+		}
+	}
+
+	if !opt.get {
+		c.writer.WriteSimpleString("OK")
+	}
+	return nil
+}
+
+func (srv *Server) onGet(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeString)
+	if err != nil {
+		return err
+	}
+
+	if obj == nil {
+		c.writer.WriteNull()
+		return nil
+	}
+
+	c.writer.WriteBulkString(obj.str())
+	return nil
+}
+
+// incrDecr adds incr to the integer at key, 0 when missing, keeping its
+// TTL.
+func (srv *Server) incrDecr(c *Client, key string, incr int64) error {
+	obj, err := c.db.lookupType(key, FieldTypeString)
+	if err != nil {
+		return err
+	}
+
+	var n int64
+	if obj != nil {
+		var ok bool
+		if obj.Encoding == EncodingInt {
+			n, ok = obj.Value.(int64), true
+		} else {
+			n, ok = canonicalInt(obj.str())
+		}
+		if !ok {
+			return ErrNotInteger
+		}
+	}
+	if (incr < 0 && n < math.MinInt64-incr) || (incr > 0 && n > math.MaxInt64-incr) {
+		return errIncrOverflow
+	}
+
+	n += incr
+	if obj != nil {
+		obj.Encoding, obj.Value = EncodingInt, n
+	} else {
+		c.db.Set(key, newObject(FieldTypeString, EncodingInt, n))
+	}
+	c.writer.WriteInteger(n)
+	return nil
+}
+
+// INCR key
+func (srv *Server) onIncr(c *Client, args []string) error {
+	return srv.incrDecr(c, args[0], 1)
+}
+
+// DECR key
+func (srv *Server) onDecr(c *Client, args []string) error {
+	return srv.incrDecr(c, args[0], -1)
+}
+
+// INCRBY key increment
+func (srv *Server) onIncrBy(c *Client, args []string) error {
+	incr, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return ErrNotInteger
+	}
+	return srv.incrDecr(c, args[0], incr)
+}
+
+// DECRBY key decrement
+func (srv *Server) onDecrBy(c *Client, args []string) error {
+	decr, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return ErrNotInteger
+	}
+	if decr == math.MinInt64 {
+		return errDecrOverflow
+	}
+	return srv.incrDecr(c, args[0], -decr)
+}
+
+// INCRBYFLOAT key increment
+//
+// The result is stored formatted like it is returned, so it is read back
+// as a plain string, or an integer when it has no fractional part.
+func (srv *Server) onIncrByFloat(c *Client, args []string) error {
+	key := args[0]
+	incr, ok := parseLongDouble(args[1])
+	if !ok {
+		return errNotFloat
+	}
+	obj, err := c.db.lookupType(key, FieldTypeString)
+	if err != nil {
+		return err
+	}
+
+	f := new(big.Float)
+	if obj != nil {
+		if f, ok = parseLongDouble(obj.str()); !ok {
+			return errNotFloat
+		}
+	}
+	value, ok := addLongDouble(f, incr)
+	if !ok {
+		return errIncrNaNOrInfinity
+	}
+
+	if obj != nil {
+		c.db.SetKeepTTL(key, newStringObject(value))
+	} else {
+		c.db.Set(key, newStringObject(value))
+	}
+	c.writer.WriteBulkString(value)
+	return nil
+}
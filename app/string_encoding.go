@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"strconv"
 )
 
@@ -16,7 +17,10 @@ func DecodeString(r *bufio.Reader) (string, error) {
 
 	if length&0b11000000 != 0b11000000 {
 		// length-prefixed
-		return decodeLengthPrefixed(r, length)
+		if err := r.UnreadByte(); err != nil {
+			return "", err
+		}
+		return decodeLengthPrefixed(r)
 	}
 
 	remainingSixBits := length & 0b00111111
@@ -48,28 +52,50 @@ func DecodeString(r *bufio.Reader) (string, error) {
 		return strconv.Itoa(i), nil
 	case 3:
 		// LZF compressed string
-		return "", errors.New("unimplemented")
+		return decodeLZF(r)
 	default:
-		return decodeLengthPrefixed(r, length)
+		return "", fmt.Errorf("unknown string encoding %d", remainingSixBits)
 	}
 }
 
-func decodeLengthPrefixed(r *bufio.Reader, length byte) (string, error) {
-	b := make([]byte, length)
-	n, err := r.Read(b)
+func decodeLengthPrefixed(r *bufio.Reader) (string, error) {
+	length, err := DecodeLength(r)
 	if err != nil {
 		return "", err
 	}
 
-	// fmt.Println(string(b), n, length)
-
-	if n != int(length) {
-		return "", fmt.Errorf("consumed byte is not equal length")
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
 	}
 
 	return string(b), nil
 }
 
+// decodeLZF reads the compressed and uncompressed lengths followed by the
+// compressed data.
+func decodeLZF(r *bufio.Reader) (string, error) {
+	clen, err := DecodeLength(r)
+	if err != nil {
+		return "", err
+	}
+	ulen, err := DecodeLength(r)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, clen)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+
+	res, err := lzfDecompress(b, ulen)
+	if err != nil {
+		return "", err
+	}
+	return string(res), nil
+}
+
 func decodeInt(r *bufio.Reader, bitSize int) (int, error) {
 	switch bitSize {
 	case 8, 16, 32:
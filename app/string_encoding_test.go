@@ -24,3 +24,33 @@ func Test_decodeInt(t *testing.T) {
 	got, err = decodeInt(r, 32)
 	fmt.Println(got, err)
 }
+
+func TestDecodeString(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"6 bit length", "\x03foo", "foo"},
+		{"14 bit length", "\x40\x64" + long, long},
+		{"32 bit length", "\x80\x00\x00\x00\x64" + long, long},
+		{"int8", "\xc0\x7b", "123"},
+		// "abc" literal then a back reference of 3 bytes at offset 3
+		{"lzf", "\xc3\x06\x06\x02abc\x20\x02", "abcabc"},
+		// overlapping back reference
+		{"lzf overlap", "\xc3\x05\x0a\x00a\xe0\x00\x00", "aaaaaaaaaa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeString(bufio.NewReader(strings.NewReader(tt.in)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
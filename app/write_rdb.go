@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const rdbVersion = "0012"
+
+// crc64Jones is the table of the CRC-64 variant Redis checksums RDB files
+// with, the Jones polynomial in reversed form.
+var crc64Jones = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// crc64Update is crc64.Update without the inversion of the crc before and
+// after, which the Redis variant does not do.
+func crc64Update(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crc64Jones, p)
+}
+
+// RDBWriter serializes the keyspace in the RDB format ParseRDB reads, the
+// checksum of everything written is appended by WriteEOF.
+type RDBWriter struct {
+	w   *bufio.Writer
+	crc uint64
+}
+
+func NewRDBWriter(w io.Writer) *RDBWriter {
+	return &RDBWriter{w: bufio.NewWriter(w)}
+}
+
+func (w *RDBWriter) Write(p []byte) (int, error) {
+	w.crc = crc64Update(w.crc, p)
+	return w.w.Write(p)
+}
+
+func (w *RDBWriter) writeByte(b byte) {
+	w.Write([]byte{b})
+}
+
+// writeLength writes n with the length encoding DecodeLength reads. n is
+// taken as unsigned, for the 64 bit stream IDs.
+func (w *RDBWriter) writeLength(n int) {
+	var b []byte
+	switch u := uint64(n); {
+	case u < 1<<6:
+		b = []byte{byte(u)}
+	case u < 1<<14:
+		b = []byte{0x40 | byte(u>>8), byte(u)}
+	case u <= 0xFFFFFFFF:
+		b = []byte{0x80, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(u))
+	default:
+		b = []byte{0x81, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], u)
+	}
+	w.Write(b)
+}
+
+func (w *RDBWriter) writeString(s string) {
+	w.writeLength(len(s))
+	io.WriteString(w, s)
+}
+
+func (w *RDBWriter) WriteHeader() {
+	io.WriteString(w, "REDIS"+rdbVersion)
+	for _, kv := range [][2]string{
+		{AuxFieldRedisVer, redisVersion},
+		{AuxFieldRedisBits, "64"},
+		{AuxFieldCtime, strconv.FormatInt(time.Now().Unix(), 10)},
+		{"aof-base", "0"},
+	} {
+		w.writeByte(OPCodeAUX)
+		w.writeString(kv[0])
+		w.writeString(kv[1])
+	}
+}
+
+// WriteDB writes the keys of db that are not expired, nothing when it is
+// empty.
+func (w *RDBWriter) WriteDB(db *DB) error {
+	// the size hints count only the keys written
+	now := mstime()
+	keys, expires := 0, 0
+	db.data.Iterate(func(key string, obj *Object) bool {
+		if obj.ExpireAt == 0 {
+			keys++
+		} else if obj.ExpireAt > now {
+			keys++
+			expires++
+		}
+		return true
+	})
+	if keys == 0 {
+		return nil
+	}
+
+	w.writeByte(OPCodeSELECTDB)
+	w.writeLength(db.id)
+	w.writeByte(OPCodeRESIZEDB)
+	w.writeLength(keys)
+	w.writeLength(expires)
+
+	var err error
+	db.data.Iterate(func(key string, obj *Object) bool {
+		if obj.ExpireAt != 0 && obj.ExpireAt <= now {
+			return true
+		}
+		err = w.writeKey(key, obj)
+		return err == nil
+	})
+	return err
+}
+
+func (w *RDBWriter) writeKey(key string, obj *Object) error {
+	if obj.ExpireAt != 0 {
+		w.writeByte(OPCodeEXPIRETIMEMS)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(obj.ExpireAt))
+		w.Write(b[:])
+	}
+
+	switch obj.Type {
+	case FieldTypeString:
+		w.writeByte(byte(FieldTypeString))
+		w.writeString(key)
+		w.writeString(obj.str())
+	case FieldTypeList:
+		w.writeByte(byte(FieldTypeListQuicklist2))
+		w.writeString(key)
+		nodes := obj.Value.(*quicklist).Nodes()
+		w.writeLength(len(nodes))
+		for _, lp := range nodes {
+			w.writeLength(quicklistNodePacked)
+			w.writeString(string(lp.b))
+		}
+	case FieldTypeSet:
+		switch obj.Encoding {
+		case EncodingIntset:
+			w.writeByte(byte(FieldTypeSetIntset))
+			w.writeString(key)
+			w.writeString(string(obj.Value.(*intset).b))
+		case EncodingListpack:
+			w.writeByte(byte(FieldTypeSetListpack))
+			w.writeString(key)
+			w.writeString(string(obj.Value.(*listpack).b))
+		default:
+			w.writeByte(byte(FieldTypeSet))
+			w.writeString(key)
+			w.writeLength(setLen(obj))
+			setIterate(obj, func(member string) bool {
+				w.writeString(member)
+				return true
+			})
+		}
+	case FieldTypeZSet:
+		if obj.Encoding == EncodingListpack {
+			w.writeByte(byte(FieldTypeZSetListpack))
+			w.writeString(key)
+			w.writeString(string(obj.Value.(*listpack).b))
+			break
+		}
+		w.writeByte(byte(FieldTypeZSet2))
+		w.writeString(key)
+		w.writeLength(zsetLen(obj))
+		for x := obj.Value.(*zset).zsl.First(); x != nil; x = x.Next() {
+			w.writeString(x.member)
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(x.score))
+			w.Write(b[:])
+		}
+	case FieldTypeHash:
+		w.writeHash(key, obj)
+	case FieldTypeStream:
+		w.writeStream(key, obj.Value.(*stream))
+	default:
+		return fmt.Errorf("can not save key %q of type %s", key, obj.Type)
+	}
+	return nil
+}
+
+// writeHash saves a listpack hash as is and a hashTable field by field.
+// Both start with the earliest field TTL when some field has one, the
+// TTLs of a hashTable are then saved relative to it.
+func (w *RDBWriter) writeHash(key string, obj *Object) {
+	minExpire := hashMinExpire(obj)
+	writeMinExpire := func() {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(minExpire))
+		w.Write(b[:])
+	}
+
+	switch {
+	case obj.Encoding == EncodingListpack:
+		w.writeByte(byte(FieldTypeHashListpack))
+		w.writeString(key)
+	case obj.Encoding == EncodingListpackEx:
+		w.writeByte(byte(FieldTypeHashListpackEx))
+		w.writeString(key)
+		writeMinExpire()
+	case minExpire != 0:
+		w.writeByte(byte(FieldTypeHashMetadata))
+		w.writeString(key)
+		writeMinExpire()
+	default:
+		w.writeByte(byte(FieldTypeHash))
+		w.writeString(key)
+	}
+
+	if obj.Encoding != EncodingHashtable {
+		w.writeString(string(obj.Value.(*listpack).b))
+		return
+	}
+	w.writeLength(hashLen(obj))
+	hashIterateWithTTL(obj, func(field, value string, at int64) bool {
+		if minExpire != 0 {
+			if at == 0 {
+				w.writeLength(0)
+			} else {
+				w.writeLength(int(at - minExpire + 1))
+			}
+		}
+		w.writeString(field)
+		w.writeString(value)
+		return true
+	})
+}
+
+// WriteEOF ends the file with the checksum and flushes.
+func (w *RDBWriter) WriteEOF() error {
+	w.writeByte(OPCodeEOF)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], w.crc)
+	w.w.Write(b[:])
+	return w.w.Flush()
+}
+
+// saveRDB writes a snapshot of every database to the configured dir and
+// dbfilename. It goes through a temporary file renamed at the end so a
+// failure never leaves a truncated snapshot behind.
+func (srv *Server) saveRDB() error {
+	dir := srv.config["dir"]
+	path := filepath.Join(dir, srv.config["dbfilename"])
+	tmp := filepath.Join(dir, fmt.Sprintf("temp-%d.rdb", os.Getpid()))
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := NewRDBWriter(f)
+	w.WriteHeader()
+	for _, db := range srv.dbs {
+		if err := w.WriteDB(db); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.WriteEOF(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// SAVE
+func (srv *Server) onSave(c *Client, args []string) error {
+	if srv.config["dbfilename"] == "" {
+		return errors.New("ERR no dbfilename configured")
+	}
+
+	if err := srv.saveRDB(); err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	c.writer.WriteSimpleString("OK")
+	return nil
+}
+
+// writeStream saves the nodes of s as is, each after its master ID, then
+// the metadata of s.
+func (w *RDBWriter) writeStream(key string, s *stream) {
+	w.writeByte(byte(FieldTypeStreamListpacks3))
+	w.writeString(key)
+	w.writeLength(len(s.nodes))
+	for _, n := range s.nodes {
+		w.writeString(n.master.encode())
+		w.writeString(string(n.lp.b))
+	}
+	w.writeLength(s.length)
+	for _, id := range []streamID{s.lastID, s.firstID, s.maxDeletedID} {
+		w.writeLength(int(id.ms))
+		w.writeLength(int(id.seq))
+	}
+	w.writeLength(int(s.entriesAdded))
+
+	// the consumer groups, the PEL of each consumer listing only IDs of
+	// NACKs in the PEL of the group
+	w.writeLength(len(s.groups))
+	for _, name := range s.groupNames() {
+		g := s.groups[name]
+		w.writeString(name)
+		w.writeLength(int(g.lastID.ms))
+		w.writeLength(int(g.lastID.seq))
+		w.writeLength(int(g.entriesRead))
+		w.writeLength(g.pel.Len())
+		g.pel.iterate(streamID{}, func(id streamID, nack *streamNACK) bool {
+			w.Write([]byte(id.encode()))
+			w.writeMillis(nack.deliveryTime)
+			w.writeLength(int(nack.deliveryCount))
+			return true
+		})
+		w.writeLength(len(g.consumers))
+		for _, consumerName := range g.consumerNames() {
+			consumer := g.consumers[consumerName]
+			w.writeString(consumerName)
+			w.writeMillis(consumer.seenTime)
+			w.writeMillis(consumer.activeTime)
+			w.writeLength(consumer.pel.Len())
+			consumer.pel.iterate(streamID{}, func(id streamID, _ *streamNACK) bool {
+				w.Write([]byte(id.encode()))
+				return true
+			})
+		}
+	}
+}
+
+// writeMillis writes a unix time in milliseconds as 8 bytes little endian.
+func (w *RDBWriter) writeMillis(ms int64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(ms))
+	w.Write(b[:])
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCRC64(t *testing.T) {
+	if got := crc64Update(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("unexpected crc %x", got)
+	}
+}
+
+func TestSaveRDB(t *testing.T) {
+	dir := t.TempDir()
+	srv := &Server{config: map[string]string{
+		"dir":        dir,
+		"dbfilename": "dump.rdb",
+		"databases":  "2",
+	}}
+	srv.setupDatabases()
+
+	at := mstime() + 100000
+	srv.dbs[0].Set("str", newStringObject("bar"))
+	srv.dbs[0].SetExpire("str", at)
+	list := srv.newListObject()
+	for i := 0; i < 2000; i++ {
+		listPush(list, strconv.Itoa(i), listTail)
+	}
+	srv.dbs[1].Set("list", list)
+	small, big := srv.newHashObject(), srv.newHashObject()
+	srv.hashSet(small, "f", "v")
+	for i := 0; i < 200; i++ {
+		srv.hashSet(big, strconv.Itoa(i), strconv.Itoa(-i))
+	}
+	srv.hashSet(small, "g", "w")
+	hashSetExpire(small, "g", at)
+	hashSetExpire(big, "7", at)
+	srv.dbs[1].Set("small", small)
+	srv.dbs[1].Set("big", big)
+	srv.dbs[1].Set("expired", newStringObject("v"))
+	srv.dbs[1].SetExpire("expired", mstime()-1)
+	for _, members := range [][]string{{"1", "-70000"}, {"a", "1"}, {strings.Repeat("x", 65), "1"}} {
+		set := srv.newSetObject(members[0])
+		for _, member := range members {
+			srv.setAdd(set, member)
+		}
+		srv.dbs[0].Set("set:"+set.Encoding.String(), set)
+	}
+	for _, size := range []int{3, 300} {
+		zs := srv.newZsetObject(size, 1)
+		for i := 0; i < size; i++ {
+			srv.zsetInsert(zs, float64(i)/2, strconv.Itoa(i))
+		}
+		srv.dbs[0].Set("zset:"+zs.Encoding.String(), zs)
+	}
+	st := newStream()
+	for i := 1; i <= 150; i++ {
+		st.append(streamID{uint64(i), math.MaxUint64 - 1}, []string{"f", strconv.Itoa(i)}, 0, 100)
+	}
+	st.delete(streamID{1, math.MaxUint64 - 1})
+	st.updateFirstID()
+	g := st.createGroup("g", streamID{}, 0)
+	st.deliver(g, g.consumer("alice", 1000), streamID{}, 3, false, 2000)
+	g.createConsumer("bob", 3000)
+	srv.dbs[0].Set("stream", newObject(FieldTypeStream, EncodingStream, st))
+
+	if err := srv.saveRDB(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dump.rdb")); err != nil {
+		t.Fatal(err)
+	}
+
+	rdb := ParseRDB(filepath.Join(dir, "dump.rdb"))
+	if len(rdb.Databases) != 2 {
+		t.Fatalf("expected 2 databases got %d", len(rdb.Databases))
+	}
+	str := rdb.Databases[0].Fields["str"]
+	if str.Value != "bar" || int64(str.ExpiredTime) != at {
+		t.Fatalf("unexpected string field %+v", str)
+	}
+
+	// the expired key is neither written nor counted
+	if resize := rdb.Databases[1].ResizeDB; resize.HashTableSize != 3 || resize.ExpireHashTable != 0 || len(rdb.Databases[1].Fields) != 3 {
+		t.Fatalf("unexpected database 1 with sizes %+v and %d keys", resize, len(rdb.Databases[1].Fields))
+	}
+
+	f := rdb.Databases[1].Fields["list"]
+	elems := f.Value.([]string)
+	if f.Type != FieldTypeListQuicklist2 || len(elems) != 2000 || elems[1999] != "1999" {
+		t.Fatalf("unexpected list field type %d with %d elements", f.Type, len(elems))
+	}
+
+	for key, enc := range map[string]ObjEncoding{"small": EncodingListpackEx, "big": EncodingHashtable} {
+		obj, err := srv.newObjectFromField(rdb.Databases[1].Fields[key])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.Encoding != enc || hashLen(obj) != hashLen(srv.dbs[1].lookupNoTouch(key)) {
+			t.Fatalf("unexpected hash %q loaded with encoding %s and %d fields", key, obj.Encoding, hashLen(obj))
+		}
+		hashIterateWithTTL(srv.dbs[1].lookupNoTouch(key), func(field, value string, at int64) bool {
+			if v, _ := hashGet(obj, field); v != value {
+				t.Fatalf("hash %q loaded field %q with value %q want %q", key, field, v, value)
+			}
+			if got, _ := hashGetExpire(obj, field); got != at {
+				t.Fatalf("hash %q loaded field %q with TTL %d want %d", key, field, got, at)
+			}
+			return true
+		})
+	}
+
+	for _, enc := range []ObjEncoding{EncodingIntset, EncodingListpack, EncodingHashtable} {
+		key := "set:" + enc.String()
+		obj, err := srv.newObjectFromField(rdb.Databases[0].Fields[key])
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := setMembers(srv.dbs[0].lookupNoTouch(key))
+		if obj.Encoding != enc || setLen(obj) != len(want) {
+			t.Fatalf("set %q loaded with encoding %s and %d members", key, obj.Encoding, setLen(obj))
+		}
+		for _, member := range want {
+			if !setIsMember(obj, member) {
+				t.Fatalf("set %q loaded without %q", key, member)
+			}
+		}
+	}
+
+	for _, enc := range []ObjEncoding{EncodingListpack, EncodingSkiplist} {
+		key := "zset:" + enc.String()
+		obj, err := srv.newObjectFromField(rdb.Databases[0].Fields[key])
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := srv.dbs[0].lookupNoTouch(key)
+		if obj.Encoding != enc || zsetLen(obj) != zsetLen(want) {
+			t.Fatalf("sorted set %q loaded with encoding %s and %d members", key, obj.Encoding, zsetLen(obj))
+		}
+		for it := zsetIterAtRank(want, 0, false); it.valid(); it.next() {
+			if score, _ := zsetScore(obj, it.member()); score != it.score() {
+				t.Fatalf("sorted set %q loaded %q with score %v want %v", key, it.member(), score, it.score())
+			}
+		}
+	}
+
+	obj, err := srv.newObjectFromField(rdb.Databases[0].Fields["stream"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := obj.Value.(*stream)
+	if loaded.length != 149 || loaded.firstID != st.firstID || loaded.lastID != st.lastID || loaded.entriesAdded != 150 {
+		t.Fatalf("unexpected stream loaded with %d entries from %s to %s", loaded.length, loaded.firstID, loaded.lastID)
+	}
+	it := st.newIterator(streamID{}, streamMaxID, false)
+	for lit := loaded.newIterator(streamID{}, streamMaxID, false); ; {
+		e, ok := it.next()
+		le, lok := lit.next()
+		if ok != lok || e.id != le.id || strings.Join(e.fields, " ") != strings.Join(le.fields, " ") {
+			t.Fatalf("stream loaded entry %v want %v", le, e)
+		}
+		if !ok {
+			break
+		}
+	}
+
+	lg := loaded.groups["g"]
+	if len(loaded.groups) != 1 || lg == nil || lg.lastID != g.lastID || lg.entriesRead != 3 || len(lg.consumers) != 2 {
+		t.Fatalf("unexpected stream groups loaded %v", loaded.groups)
+	}
+	alice := lg.consumers["alice"]
+	if alice == nil || alice.seenTime != 1000 || alice.activeTime != 2000 || alice.pel.Len() != 3 || lg.pel.Len() != 3 {
+		t.Fatalf("unexpected consumer loaded %+v", alice)
+	}
+	if nack := lg.pel.get(lg.pel.first()); nack.consumer != alice || nack.deliveryTime != 2000 || nack.deliveryCount != 1 {
+		t.Fatalf("unexpected pending entry loaded %+v", nack)
+	}
+	if bob := lg.consumers["bob"]; bob.seenTime != 3000 || bob.activeTime != -1 || bob.pel.Len() != 0 {
+		t.Fatalf("unexpected consumer loaded %+v", bob)
+	}
+}
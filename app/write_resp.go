@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RESPWriter encodes replies. Every value is length-prefixed or checked for
+// CRLF so replies stay binary-safe. Write errors are sticky in the
+// underlying bufio.Writer and surface on Flush.
+//
+// proto is the protocol negotiated with HELLO. With RESP3 the native types
+// are emitted, with RESP2 they fall back to the closest RESP2 encoding.
+type RESPWriter struct {
+	w     *bufio.Writer
+	proto int
+}
+
+func NewRESPWriter(w io.Writer) *RESPWriter {
+	return &RESPWriter{w: bufio.NewWriter(w), proto: 2}
+}
+
+func (rw *RESPWriter) Flush() error {
+	return rw.w.Flush()
+}
+
+func (rw *RESPWriter) writeHeader(prefix byte, n int64) {
+	rw.w.WriteByte(prefix)
+	rw.w.WriteString(strconv.FormatInt(n, 10))
+	rw.w.WriteString("\r\n")
+}
+
+// writeLine writes a single line reply, CR and LF would break the framing
+// so they are replaced by spaces like Redis does.
+func (rw *RESPWriter) writeLine(prefix byte, s string) {
+	if strings.ContainsAny(s, "\r\n") {
+		s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	}
+	rw.w.WriteByte(prefix)
+	rw.w.WriteString(s)
+	rw.w.WriteString("\r\n")
+}
+
+func (rw *RESPWriter) WriteSimpleString(s string) {
+	rw.writeLine('+', s)
+}
+
+// WriteError writes an error reply, msg should start with an error code
+// such as ERR or WRONGTYPE.
+func (rw *RESPWriter) WriteError(msg string) {
+	rw.writeLine('-', msg)
+}
+
+func (rw *RESPWriter) WriteInteger(n int64) {
+	rw.writeHeader(':', n)
+}
+
+func (rw *RESPWriter) WriteBulkString(s string) {
+	rw.writeHeader('$', int64(len(s)))
+	rw.w.WriteString(s)
+	rw.w.WriteString("\r\n")
+}
+
+// WriteNull writes the null bulk string.
+func (rw *RESPWriter) WriteNull() {
+	if rw.proto == 3 {
+		rw.w.WriteString("_\r\n")
+		return
+	}
+	rw.w.WriteString("$-1\r\n")
+}
+
+// WriteNullArray writes the null array used by commands such as BLPOP on
+// timeout.
+func (rw *RESPWriter) WriteNullArray() {
+	if rw.proto == 3 {
+		rw.w.WriteString("_\r\n")
+		return
+	}
+	rw.w.WriteString("*-1\r\n")
+}
+
+// WriteArrayLen writes the header of an array, the caller writes the n
+// elements next.
+func (rw *RESPWriter) WriteArrayLen(n int) {
+	rw.writeHeader('*', int64(n))
+}
+
+// WriteMapLen writes the header of a map of n key/value pairs. RESP2 has
+// no map so it becomes a flat array of 2*n elements.
+func (rw *RESPWriter) WriteMapLen(n int) {
+	if rw.proto == 3 {
+		rw.writeHeader('%', int64(n))
+		return
+	}
+	rw.writeHeader('*', int64(n*2))
+}
+
+// WriteSetLen writes the header of a set of n elements.
+func (rw *RESPWriter) WriteSetLen(n int) {
+	if rw.proto == 3 {
+		rw.writeHeader('~', int64(n))
+		return
+	}
+	rw.writeHeader('*', int64(n))
+}
+
+// WritePushLen writes the header of an out of band push message of n
+// elements, such as a pub/sub message.
+func (rw *RESPWriter) WritePushLen(n int) {
+	if rw.proto == 3 {
+		rw.writeHeader('>', int64(n))
+		return
+	}
+	rw.writeHeader('*', int64(n))
+}
+
+// WriteDouble writes a floating point reply, RESP2 clients get it as a
+// bulk string.
+func (rw *RESPWriter) WriteDouble(f float64) {
+	if rw.proto == 3 {
+		rw.writeLine(',', formatDouble(f))
+		return
+	}
+	rw.WriteBulkString(formatDouble(f))
+}
+
+// WriteBool writes a boolean reply, RESP2 clients get 1 or 0.
+func (rw *RESPWriter) WriteBool(b bool) {
+	if rw.proto == 3 {
+		if b {
+			rw.w.WriteString("#t\r\n")
+		} else {
+			rw.w.WriteString("#f\r\n")
+		}
+		return
+	}
+	if b {
+		rw.WriteInteger(1)
+	} else {
+		rw.WriteInteger(0)
+	}
+}
+
+// WriteBigNumber writes an integer that does not fit in 64 bits, RESP2
+// clients get it as a bulk string.
+func (rw *RESPWriter) WriteBigNumber(n string) {
+	if rw.proto == 3 {
+		rw.writeLine('(', n)
+		return
+	}
+	rw.WriteBulkString(n)
+}
+
+// WriteVerbatim writes a text reply tagged with a three letters format
+// such as "txt" or "mkd", RESP2 clients get it as a bulk string.
+func (rw *RESPWriter) WriteVerbatim(format, s string) {
+	if rw.proto == 3 {
+		rw.writeHeader('=', int64(len(format)+1+len(s)))
+		rw.w.WriteString(format)
+		rw.w.WriteByte(':')
+		rw.w.WriteString(s)
+		rw.w.WriteString("\r\n")
+		return
+	}
+	rw.WriteBulkString(s)
+}
+
+func (rw *RESPWriter) WriteBulkStrings(s []string) {
+	rw.WriteArrayLen(len(s))
+	for _, v := range s {
+		rw.WriteBulkString(v)
+	}
+}
+
+// WriteRDBPayload writes a snapshot after +FULLRESYNC, framed like a bulk
+// string without the trailing CRLF.
+func (rw *RESPWriter) WriteRDBPayload(data []byte) {
+	rw.writeHeader('$', int64(len(data)))
+	rw.w.Write(data)
+}
+
+// formatDouble formats f with the fewest digits that read back as f, with
+// an exponent only below 1e-4 or from 1e17 on, like the %.17g of Redis.
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-4 || abs >= 1e17) {
+		return strconv.FormatFloat(f, 'e', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func makeArrayBulkString(s []string) string {
+	var sb strings.Builder
+	rw := NewRESPWriter(&sb)
+	rw.WriteBulkStrings(s)
+	rw.Flush()
+	return sb.String()
+}
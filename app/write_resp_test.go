@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRESPWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		write  func(rw *RESPWriter)
+		expect string
+	}{
+		{
+			name:   "simple_string",
+			write:  func(rw *RESPWriter) { rw.WriteSimpleString("OK") },
+			expect: "+OK\r\n",
+		},
+		{
+			name:   "error_strips_crlf",
+			write:  func(rw *RESPWriter) { rw.WriteError("ERR bad\r\nthing") },
+			expect: "-ERR bad  thing\r\n",
+		},
+		{
+			name:   "integer",
+			write:  func(rw *RESPWriter) { rw.WriteInteger(-7) },
+			expect: ":-7\r\n",
+		},
+		{
+			name:   "binary_bulk_string",
+			write:  func(rw *RESPWriter) { rw.WriteBulkString("a\r\n\x00b") },
+			expect: "$5\r\na\r\n\x00b\r\n",
+		},
+		{
+			name:   "null",
+			write:  func(rw *RESPWriter) { rw.WriteNull() },
+			expect: "$-1\r\n",
+		},
+		{
+			name:   "double",
+			write:  func(rw *RESPWriter) { rw.WriteDouble(1234567) },
+			expect: "$7\r\n1234567\r\n",
+		},
+		{
+			name:   "double_exponent",
+			write:  func(rw *RESPWriter) { rw.WriteDouble(-1e21) },
+			expect: "$6\r\n-1e+21\r\n",
+		},
+		{
+			name: "map",
+			write: func(rw *RESPWriter) {
+				rw.WriteMapLen(1)
+				rw.WriteBulkString("dir")
+				rw.WriteBulkString("/tmp")
+			},
+			expect: "*2\r\n$3\r\ndir\r\n$4\r\n/tmp\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sb strings.Builder
+			rw := NewRESPWriter(&sb)
+			tt.write(rw)
+			if err := rw.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if sb.String() != tt.expect {
+				t.Fatalf("expected %q got %q", tt.expect, sb.String())
+			}
+		})
+	}
+}
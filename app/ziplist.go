@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+var errZiplistCorrupt = errors.New("corrupt ziplist")
+
+// ziplistEntries decodes a ziplist, the compact encoding listpacks
+// replaced. Only RDB files of Redis before 7.0 still hold them, they are
+// converted on load so there is no need to modify them in place.
+//
+//	<zlbytes uint32> <zltail uint32> <zllen uint16> <entry> ... <0xFF>
+//
+// Each entry is the length of the previous one, an encoding and the
+// integer or the string data.
+func ziplistEntries(b []byte) ([]string, error) {
+	if len(b) < 11 || int(binary.LittleEndian.Uint32(b)) != len(b) || b[len(b)-1] != 0xFF {
+		return nil, errZiplistCorrupt
+	}
+
+	var res []string
+	p := 10
+	for b[p] != 0xFF {
+		// previous entry length, 1 or 5 bytes
+		if b[p] < 0xFE {
+			p++
+		} else {
+			p += 5
+		}
+		if p >= len(b)-1 {
+			return nil, errZiplistCorrupt
+		}
+
+		enc := b[p]
+		var strlen, intlen int
+		switch {
+		case enc>>6 == 0:
+			strlen, p = int(enc&0x3F), p+1
+		case enc>>6 == 1:
+			strlen, p = int(enc&0x3F)<<8|int(b[p+1]), p+2
+		case enc == 0x80:
+			if p+5 > len(b) {
+				return nil, errZiplistCorrupt
+			}
+			strlen, p = int(binary.BigEndian.Uint32(b[p+1:])), p+5
+		case enc == 0xC0:
+			intlen = 2
+		case enc == 0xD0:
+			intlen = 4
+		case enc == 0xE0:
+			intlen = 8
+		case enc == 0xF0:
+			intlen = 3
+		case enc == 0xFE:
+			intlen = 1
+		case enc >= 0xF1 && enc <= 0xFD:
+			// 4 bit immediate integer, 0 to 12
+			res = append(res, strconv.Itoa(int(enc&0x0F)-1))
+			p++
+			continue
+		default:
+			return nil, errZiplistCorrupt
+		}
+
+		if intlen > 0 {
+			p++
+			if p+intlen >= len(b) {
+				return nil, errZiplistCorrupt
+			}
+			var v int64
+			switch intlen {
+			case 1:
+				v = int64(int8(b[p]))
+			case 2:
+				v = int64(int16(binary.LittleEndian.Uint16(b[p:])))
+			case 3:
+				v = int64(b[p]) | int64(b[p+1])<<8 | int64(int8(b[p+2]))<<16
+			case 4:
+				v = int64(int32(binary.LittleEndian.Uint32(b[p:])))
+			case 8:
+				v = int64(binary.LittleEndian.Uint64(b[p:]))
+			}
+			res = append(res, strconv.FormatInt(v, 10))
+			p += intlen
+			continue
+		}
+
+		if p+strlen >= len(b) {
+			return nil, errZiplistCorrupt
+		}
+		res = append(res, string(b[p:p+strlen]))
+		p += strlen
+	}
+	return res, nil
+}
@@ -0,0 +1,1533 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultZsetMaxListpackEntries = 128
+	defaultZsetMaxListpackValue   = 64
+)
+
+// A sorted set starts as a listpack of member score pairs, sorted by score
+// then member like the skiplist, compact and fast enough while small. It
+// is converted to a zset once it holds more than zset-max-listpack-entries
+// members or a member longer than zset-max-listpack-value bytes, and never
+// converted back.
+
+// zset is the skiplist encoding of a sorted set: dict gives the score of a
+// member in O(1) and zsl keeps the members sorted.
+type zset struct {
+	dict *dict[float64]
+	zsl  *zskiplist
+}
+
+func newZset() *zset {
+	return &zset{dict: newDict[float64](), zsl: newZskiplist()}
+}
+
+// zsetEntry is a member and its score, as returned by range queries.
+type zsetEntry struct {
+	member string
+	score  float64
+}
+
+// newZsetObject returns an empty sorted set with the encoding fitting size
+// members, the longest being maxLen bytes.
+func (srv *Server) newZsetObject(size, maxLen int) *Object {
+	if size <= srv.configInt("zset-max-listpack-entries", defaultZsetMaxListpackEntries) &&
+		maxLen <= srv.configInt("zset-max-listpack-value", defaultZsetMaxListpackValue) {
+		return newObject(FieldTypeZSet, EncodingListpack, newListpack())
+	}
+	return newObject(FieldTypeZSet, EncodingSkiplist, newZset())
+}
+
+// zsetConvert switches o from a listpack to a zset.
+func zsetConvert(o *Object) {
+	zs := newZset()
+	for it := zsetIterAtRank(o, 0, false); it.valid(); it.next() {
+		member, score := it.member(), it.score()
+		zs.dict.Set(member, score)
+		zs.zsl.Insert(score, member)
+	}
+	o.Encoding = EncodingSkiplist
+	o.Value = zs
+}
+
+// zzlScore returns the score at p in a listpack sorted set.
+func zzlScore(lp *listpack, p int) float64 {
+	f, _ := strconv.ParseFloat(lp.Get(p), 64)
+	return f
+}
+
+// zzlFind returns the offset of member in a listpack sorted set, -1 if
+// missing.
+func zzlFind(lp *listpack, member string) int {
+	return lp.Find(lp.First(), member, 1)
+}
+
+// zzlNext returns the offset of the member after the one at p, -1 at the
+// end.
+func zzlNext(lp *listpack, p int) int {
+	p = lp.Next(p)
+	if p == -1 {
+		return -1
+	}
+	return lp.Next(p)
+}
+
+// zzlPrev returns the offset of the member before the one at p, -1 at the
+// start.
+func zzlPrev(lp *listpack, p int) int {
+	p = lp.Prev(p)
+	if p == -1 {
+		return -1
+	}
+	return lp.Prev(p)
+}
+
+// zzlInsert adds member with score at its place in a listpack sorted set.
+func zzlInsert(lp *listpack, score float64, member string) {
+	for p := lp.First(); p != -1; p = zzlNext(lp, p) {
+		s := zzlScore(lp, lp.Next(p))
+		if s > score || (s == score && lp.Get(p) > member) {
+			p = lp.Insert(p, member)
+			lp.Insert(lp.Next(p), formatDouble(score))
+			return
+		}
+	}
+	lp.Append(member)
+	lp.Append(formatDouble(score))
+}
+
+func zsetLen(o *Object) int {
+	if o.Encoding == EncodingListpack {
+		return o.Value.(*listpack).Len() / 2
+	}
+	return o.Value.(*zset).zsl.Len()
+}
+
+func zsetScore(o *Object, member string) (float64, bool) {
+	if o.Encoding == EncodingListpack {
+		lp := o.Value.(*listpack)
+		p := zzlFind(lp, member)
+		if p == -1 {
+			return 0, false
+		}
+		return zzlScore(lp, lp.Next(p)), true
+	}
+	return o.Value.(*zset).dict.Get(member)
+}
+
+// zsetInsert adds member, which must not be in o yet, converting o when it
+// would outgrow its listpack.
+func (srv *Server) zsetInsert(o *Object, score float64, member string) {
+	if o.Encoding == EncodingListpack {
+		if zsetLen(o)+1 > srv.configInt("zset-max-listpack-entries", defaultZsetMaxListpackEntries) ||
+			len(member) > srv.configInt("zset-max-listpack-value", defaultZsetMaxListpackValue) {
+			zsetConvert(o)
+		} else {
+			zzlInsert(o.Value.(*listpack), score, member)
+			return
+		}
+	}
+	zs := o.Value.(*zset)
+	zs.dict.Set(member, score)
+	zs.zsl.Insert(score, member)
+}
+
+// zsetUpdateScore moves member from score cur to score.
+func zsetUpdateScore(o *Object, member string, cur, score float64) {
+	if o.Encoding == EncodingListpack {
+		lp := o.Value.(*listpack)
+		lp.Delete(lp.Delete(zzlFind(lp, member)))
+		zzlInsert(lp, score, member)
+		return
+	}
+	zs := o.Value.(*zset)
+	zs.zsl.UpdateScore(cur, member, score)
+	zs.dict.Set(member, score)
+}
+
+// zsetRemove removes member and reports whether it was in o.
+func zsetRemove(o *Object, member string) bool {
+	if o.Encoding == EncodingListpack {
+		lp := o.Value.(*listpack)
+		p := zzlFind(lp, member)
+		if p == -1 {
+			return false
+		}
+		lp.Delete(lp.Delete(p))
+		return true
+	}
+	zs := o.Value.(*zset)
+	score, ok := zs.dict.Delete(member)
+	if ok {
+		zs.zsl.Delete(score, member)
+	}
+	return ok
+}
+
+// The flags of zsetAdd: the ZADD options it follows and what it did.
+const (
+	zaddInIncr = 1 << iota
+	zaddInNX
+	zaddInXX
+	zaddInGT
+	zaddInLT
+	zaddInCH
+)
+
+const (
+	zaddOutAdded = 1 << iota
+	zaddOutUpdated
+	zaddOutNop
+	zaddOutNaN
+)
+
+// zsetAdd adds member with score or updates its score, following the
+// zaddIn flags. It returns the resulting score, which is the current one
+// incremented by score with zaddInIncr, and zaddOut flags telling what it
+// did.
+func (srv *Server) zsetAdd(o *Object, score float64, member string, in int) (float64, int) {
+	cur, ok := zsetScore(o, member)
+	if !ok {
+		if in&zaddInXX != 0 {
+			return 0, zaddOutNop
+		}
+		srv.zsetInsert(o, score, member)
+		return score, zaddOutAdded
+	}
+
+	if in&zaddInNX != 0 {
+		return cur, zaddOutNop
+	}
+	if in&zaddInIncr != 0 {
+		score += cur
+		if math.IsNaN(score) {
+			return 0, zaddOutNaN
+		}
+	}
+	if (in&zaddInLT != 0 && score >= cur) || (in&zaddInGT != 0 && score <= cur) {
+		return cur, zaddOutNop
+	}
+	if score == cur {
+		return score, 0
+	}
+	zsetUpdateScore(o, member, cur, score)
+	return score, zaddOutUpdated
+}
+
+// zsetRank returns the 0-based rank of member, from the highest score when
+// rev, and its score.
+func zsetRank(o *Object, member string, rev bool) (int, float64, bool) {
+	var rank int
+	var score float64
+	if o.Encoding == EncodingListpack {
+		it := zsetIterAtRank(o, 0, false)
+		for ; it.valid() && it.member() != member; it.next() {
+			rank++
+		}
+		if !it.valid() {
+			return 0, 0, false
+		}
+		score = it.score()
+	} else {
+		zs := o.Value.(*zset)
+		var ok bool
+		if score, ok = zs.dict.Get(member); !ok {
+			return 0, 0, false
+		}
+		rank = zs.zsl.Rank(score, member) - 1
+	}
+
+	if rev {
+		rank = zsetLen(o) - 1 - rank
+	}
+	return rank, score, true
+}
+
+// zsetCount returns the number of members in r, in O(log n) with the ranks
+// of the first and last ones of a zset.
+func zsetCount(o *Object, r zrangeSpec) int {
+	if o.Encoding == EncodingListpack {
+		n := 0
+		for it := zsetIterInRange(o, r, false); it.valid(); it.next() {
+			n++
+		}
+		return n
+	}
+
+	zsl := o.Value.(*zset).zsl
+	first := zsl.FirstInRange(r)
+	if first == nil {
+		return 0
+	}
+	last := zsl.LastInRange(r)
+	return zsl.Rank(last.score, last.member) - zsl.Rank(first.score, first.member) + 1
+}
+
+// zsetDup returns a deep copy of the value of a sorted set.
+func zsetDup(o *Object) any {
+	if o.Encoding == EncodingListpack {
+		b := o.Value.(*listpack).b
+		return &listpack{b: append([]byte(nil), b...)}
+	}
+
+	res := newZset()
+	for x := o.Value.(*zset).zsl.First(); x != nil; x = x.Next() {
+		res.dict.Set(x.member, x.score)
+		res.zsl.Insert(x.score, x.member)
+	}
+	return res
+}
+
+// zsetDeleteIfEmpty removes key once its sorted set has no member left.
+func zsetDeleteIfEmpty(db *DB, key string, obj *Object) {
+	if zsetLen(obj) == 0 {
+		db.Delete(key)
+	}
+}
+
+// zsetIter walks a sorted set in ascending order, or descending when rev,
+// up to the end of r when set. It is valid until the set is modified.
+type zsetIter struct {
+	lp   *listpack
+	p    int // offset of the member in lp, -1 past the end
+	node *zskiplistNode
+	rev  bool
+	r    zrangeSpec
+}
+
+// zsetIterAtRank returns an iterator at the 0-based rank, from the highest
+// score when rev.
+func zsetIterAtRank(o *Object, rank int, rev bool) *zsetIter {
+	it := &zsetIter{rev: rev}
+	if rev {
+		rank = zsetLen(o) - 1 - rank
+	}
+	if o.Encoding == EncodingListpack {
+		it.lp = o.Value.(*listpack)
+		it.p = it.lp.Seek(2 * rank)
+	} else {
+		it.node = o.Value.(*zset).zsl.ByRank(rank + 1)
+	}
+	return it
+}
+
+// zsetIterInRange returns an iterator over the members in r, from the last
+// one when rev.
+func zsetIterInRange(o *Object, r zrangeSpec, rev bool) *zsetIter {
+	it := &zsetIter{rev: rev, r: r}
+	if o.Encoding != EncodingListpack {
+		zsl := o.Value.(*zset).zsl
+		if rev {
+			it.node = zsl.LastInRange(r)
+		} else {
+			it.node = zsl.FirstInRange(r)
+		}
+		return it
+	}
+
+	it.lp = o.Value.(*listpack)
+	if rev {
+		it.p = it.lp.Last()
+		if it.p != -1 {
+			it.p = it.lp.Prev(it.p)
+		}
+	} else {
+		it.p = it.lp.First()
+	}
+	// skip the members before the start of the range
+	for ; it.p != -1; it.next() {
+		if (!rev && r.gteMin(it.score(), it.member())) || (rev && r.lteMax(it.score(), it.member())) {
+			break
+		}
+	}
+	return it
+}
+
+func (it *zsetIter) valid() bool {
+	if it.lp != nil && it.p == -1 || it.lp == nil && it.node == nil {
+		return false
+	}
+	if it.r == nil {
+		return true
+	}
+	if it.rev {
+		return it.r.gteMin(it.score(), it.member())
+	}
+	return it.r.lteMax(it.score(), it.member())
+}
+
+func (it *zsetIter) member() string {
+	if it.lp != nil {
+		return it.lp.Get(it.p)
+	}
+	return it.node.member
+}
+
+func (it *zsetIter) score() float64 {
+	if it.lp != nil {
+		return zzlScore(it.lp, it.lp.Next(it.p))
+	}
+	return it.node.score
+}
+
+func (it *zsetIter) next() {
+	switch {
+	case it.lp != nil && it.rev:
+		it.p = zzlPrev(it.lp, it.p)
+	case it.lp != nil:
+		it.p = zzlNext(it.lp, it.p)
+	case it.rev:
+		it.node = it.node.Prev()
+	default:
+		it.node = it.node.Next()
+	}
+}
+
+// zscoreRange is a range of scores, min and max excluded when minex and
+// maxex.
+type zscoreRange struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func (r zscoreRange) gteMin(score float64, _ string) bool {
+	if r.minex {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+func (r zscoreRange) lteMax(score float64, _ string) bool {
+	if r.maxex {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+// zlexBound is a bound of a zlexRange: member, excluded when ex, or the
+// lowest or the highest possible member when inf is -1 or 1.
+type zlexBound struct {
+	member string
+	ex     bool
+	inf    int
+}
+
+// zlexRange is a range of members, meaningful only when all the members
+// have the same score.
+type zlexRange struct {
+	min, max zlexBound
+}
+
+func (r zlexRange) gteMin(_ float64, member string) bool {
+	switch {
+	case r.min.inf != 0:
+		return r.min.inf < 0
+	case r.min.ex:
+		return member > r.min.member
+	}
+	return member >= r.min.member
+}
+
+func (r zlexRange) lteMax(_ float64, member string) bool {
+	switch {
+	case r.max.inf != 0:
+		return r.max.inf > 0
+	case r.max.ex:
+		return member < r.max.member
+	}
+	return member <= r.max.member
+}
+
+var (
+	errMinMaxNotFloat = errors.New("ERR min or max is not a float")
+	errMinMaxNotLex   = errors.New("ERR min or max not valid string range item")
+)
+
+// parseScoreBound parses a score bound, excluded when prefixed with "(".
+func parseScoreBound(s string) (float64, bool, error) {
+	ex := strings.HasPrefix(s, "(")
+	if ex {
+		s = s[1:]
+	}
+	f, ok := parseFloat(s)
+	if !ok {
+		return 0, false, errMinMaxNotFloat
+	}
+	return f, ex, nil
+}
+
+func parseScoreRange(min, max string) (zscoreRange, error) {
+	var r zscoreRange
+	var err error
+	if r.min, r.minex, err = parseScoreBound(min); err != nil {
+		return r, err
+	}
+	if r.max, r.maxex, err = parseScoreBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// parseLexBound parses a member prefixed with "[" to include it or "(" to
+// exclude it, or "-" and "+" for the lowest and highest members.
+func parseLexBound(s string) (zlexBound, error) {
+	switch {
+	case s == "-":
+		return zlexBound{inf: -1}, nil
+	case s == "+":
+		return zlexBound{inf: 1}, nil
+	case strings.HasPrefix(s, "("):
+		return zlexBound{member: s[1:], ex: true}, nil
+	case strings.HasPrefix(s, "["):
+		return zlexBound{member: s[1:]}, nil
+	}
+	return zlexBound{}, errMinMaxNotLex
+}
+
+func parseLexRange(min, max string) (zlexRange, error) {
+	var r zlexRange
+	var err error
+	if r.min, err = parseLexBound(min); err != nil {
+		return r, err
+	}
+	if r.max, err = parseLexBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// writeZsetEntries writes the members alone or, withScores, each member
+// followed by its score. RESP3 nests every pair in an array of its own.
+func writeZsetEntries(w *RESPWriter, entries []zsetEntry, withScores bool) {
+	if !withScores {
+		w.WriteArrayLen(len(entries))
+		for _, e := range entries {
+			w.WriteBulkString(e.member)
+		}
+		return
+	}
+	writeZsetPairs(w, entries, w.proto == 3)
+}
+
+// writeZsetPairs writes each member followed by its score, in an array of
+// their own when nested.
+func writeZsetPairs(w *RESPWriter, entries []zsetEntry, nested bool) {
+	if nested {
+		w.WriteArrayLen(len(entries))
+	} else {
+		w.WriteArrayLen(2 * len(entries))
+	}
+	for _, e := range entries {
+		if nested {
+			w.WriteArrayLen(2)
+		}
+		w.WriteBulkString(e.member)
+		w.WriteDouble(e.score)
+	}
+}
+
+var zaddFlags = map[string]int{
+	"nx":   zaddInNX,
+	"xx":   zaddInXX,
+	"gt":   zaddInGT,
+	"lt":   zaddInLT,
+	"ch":   zaddInCH,
+	"incr": zaddInIncr,
+}
+
+// ZADD key [NX | XX] [GT | LT] [CH] [INCR] score member [score member ...]
+func (srv *Server) onZAdd(c *Client, args []string) error {
+	in, i := 0, 1
+	for ; i < len(args); i++ {
+		flag, ok := zaddFlags[strings.ToLower(args[i])]
+		if !ok {
+			break
+		}
+		in |= flag
+	}
+	return srv.zaddGeneric(c, args[0], args[i:], in)
+}
+
+// ZINCRBY key increment member
+func (srv *Server) onZIncrBy(c *Client, args []string) error {
+	return srv.zaddGeneric(c, args[0], args[1:], zaddInIncr)
+}
+
+// zaddGeneric adds the score member pairs of elems to the sorted set at
+// key following the zaddIn flags.
+func (srv *Server) zaddGeneric(c *Client, key string, elems []string, in int) error {
+	incr := in&zaddInIncr != 0
+	switch {
+	case len(elems) == 0 || len(elems)%2 != 0:
+		return ErrSyntax
+	case in&zaddInNX != 0 && in&zaddInXX != 0:
+		return errors.New("ERR XX and NX options at the same time are not compatible")
+	case in&zaddInGT != 0 && in&(zaddInLT|zaddInNX) != 0, in&zaddInLT != 0 && in&zaddInNX != 0:
+		return errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")
+	case incr && len(elems) > 2:
+		return errors.New("ERR INCR option supports a single increment-element pair")
+	}
+
+	scores := make([]float64, len(elems)/2)
+	maxLen := 0
+	for i := range scores {
+		f, ok := parseFloat(elems[2*i])
+		if !ok {
+			return errNotFloat
+		}
+		scores[i] = f
+		if len(elems[2*i+1]) > maxLen {
+			maxLen = len(elems[2*i+1])
+		}
+	}
+
+	obj, err := c.db.lookupType(key, FieldTypeZSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		if in&zaddInXX != 0 {
+			if incr {
+				c.writer.WriteNull()
+			} else {
+				c.writer.WriteInteger(0)
+			}
+			return nil
+		}
+		obj = srv.newZsetObject(len(scores), maxLen)
+		c.db.Set(key, obj)
+	}
+
+	added, updated, processed := 0, 0, 0
+	var score float64
+	for i, s := range scores {
+		var out int
+		score, out = srv.zsetAdd(obj, s, elems[2*i+1], in)
+		if out&zaddOutNaN != 0 {
+			zsetDeleteIfEmpty(c.db, key, obj)
+			return errors.New("ERR resulting score is not a number (NaN)")
+		}
+		if out&zaddOutAdded != 0 {
+			added++
+		}
+		if out&zaddOutUpdated != 0 {
+			updated++
+		}
+		if out&zaddOutNop == 0 {
+			processed++
+		}
+	}
+
+	switch {
+	case incr && processed == 0:
+		c.writer.WriteNull()
+	case incr:
+		c.writer.WriteDouble(score)
+	case in&zaddInCH != 0:
+		c.writer.WriteInteger(int64(added + updated))
+	default:
+		c.writer.WriteInteger(int64(added))
+	}
+	return nil
+}
+
+// ZREM key member [member ...]
+func (srv *Server) onZRem(c *Client, args []string) error {
+	key := args[0]
+	obj, err := c.db.lookupType(key, FieldTypeZSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+
+	removed := 0
+	for _, member := range args[1:] {
+		if zsetRemove(obj, member) {
+			removed++
+		}
+	}
+	zsetDeleteIfEmpty(c.db, key, obj)
+	c.writer.WriteInteger(int64(removed))
+	return nil
+}
+
+// ZCARD key
+func (srv *Server) onZCard(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeZSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+	c.writer.WriteInteger(int64(zsetLen(obj)))
+	return nil
+}
+
+// ZSCORE key member
+func (srv *Server) onZScore(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeZSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteNull()
+		return nil
+	}
+
+	score, ok := zsetScore(obj, args[1])
+	if !ok {
+		c.writer.WriteNull()
+		return nil
+	}
+	c.writer.WriteDouble(score)
+	return nil
+}
+
+// ZMSCORE key member [member ...]
+func (srv *Server) onZMScore(c *Client, args []string) error {
+	obj, err := c.db.lookupType(args[0], FieldTypeZSet)
+	if err != nil {
+		return err
+	}
+
+	c.writer.WriteArrayLen(len(args) - 1)
+	for _, member := range args[1:] {
+		var score float64
+		ok := false
+		if obj != nil {
+			score, ok = zsetScore(obj, member)
+		}
+		if ok {
+			c.writer.WriteDouble(score)
+		} else {
+			c.writer.WriteNull()
+		}
+	}
+	return nil
+}
+
+// zrankGeneric implements ZRANK and ZREVRANK key member [WITHSCORE].
+func (srv *Server) zrankGeneric(c *Client, args []string, rev bool) error {
+	if len(args) > 3 || (len(args) == 3 && strings.ToLower(args[2]) != "withscore") {
+		return ErrSyntax
+	}
+	withScore := len(args) == 3
+
+	obj, err := c.db.lookupType(args[0], FieldTypeZSet)
+	if err != nil {
+		return err
+	}
+
+	var rank int
+	var score float64
+	ok := false
+	if obj != nil {
+		rank, score, ok = zsetRank(obj, args[1], rev)
+	}
+	switch {
+	case !ok && withScore:
+		c.writer.WriteNullArray()
+	case !ok:
+		c.writer.WriteNull()
+	case withScore:
+		c.writer.WriteArrayLen(2)
+		c.writer.WriteInteger(int64(rank))
+		c.writer.WriteDouble(score)
+	default:
+		c.writer.WriteInteger(int64(rank))
+	}
+	return nil
+}
+
+// ZRANK key member [WITHSCORE]
+func (srv *Server) onZRank(c *Client, args []string) error {
+	return srv.zrankGeneric(c, args, false)
+}
+
+// ZREVRANK key member [WITHSCORE]
+func (srv *Server) onZRevRank(c *Client, args []string) error {
+	return srv.zrankGeneric(c, args, true)
+}
+
+// zcountGeneric implements ZCOUNT and ZLEXCOUNT, r being the parsed range.
+func (srv *Server) zcountGeneric(c *Client, key string, r zrangeSpec) error {
+	obj, err := c.db.lookupType(key, FieldTypeZSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteInteger(0)
+		return nil
+	}
+	c.writer.WriteInteger(int64(zsetCount(obj, r)))
+	return nil
+}
+
+// ZCOUNT key min max
+func (srv *Server) onZCount(c *Client, args []string) error {
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return err
+	}
+	return srv.zcountGeneric(c, args[0], r)
+}
+
+// ZLEXCOUNT key min max
+func (srv *Server) onZLexCount(c *Client, args []string) error {
+	r, err := parseLexRange(args[1], args[2])
+	if err != nil {
+		return err
+	}
+	return srv.zcountGeneric(c, args[0], r)
+}
+
+// The kinds of range of zrangeGeneric.
+const (
+	zrangeAuto = iota // ZRANGE, by rank unless BYSCORE or BYLEX
+	zrangeRank
+	zrangeScore
+	zrangeLex
+)
+
+// zrangeGeneric implements ZRANGE, ZRANGESTORE and the older range
+// commands, args being key min max and the options, preceded by the
+// destination key when store. rangeType and rev are fixed by the older
+// commands, ZRANGE and ZRANGESTORE choose them with BYSCORE, BYLEX and
+// REV.
+func (srv *Server) zrangeGeneric(c *Client, args []string, store bool, rangeType int, rev bool) error {
+	var dst string
+	if store {
+		dst, args = args[0], args[1:]
+	}
+	key, min, max := args[0], args[1], args[2]
+
+	auto := rangeType == zrangeAuto
+	withScores, withLimit := false, false
+	offset, limit := 0, -1
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "withscores" && !store:
+			withScores = true
+		case opt == "limit" && i+2 < len(args):
+			var err1, err2 error
+			offset, err1 = strconv.Atoi(args[i+1])
+			limit, err2 = strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil {
+				return ErrNotInteger
+			}
+			withLimit = true
+			i += 2
+		case opt == "byscore" && auto:
+			rangeType = zrangeScore
+		case opt == "bylex" && auto:
+			rangeType = zrangeLex
+		case opt == "rev" && auto:
+			rev = true
+		default:
+			return ErrSyntax
+		}
+	}
+	if rangeType == zrangeAuto {
+		rangeType = zrangeRank
+	}
+
+	if withLimit && rangeType == zrangeRank {
+		return errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if withScores && rangeType == zrangeLex {
+		return errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	// reversed score and member ranges are given from max to min
+	if rev && rangeType != zrangeRank {
+		min, max = max, min
+	}
+
+	var r zrangeSpec
+	var start, stop int
+	var err error
+	switch rangeType {
+	case zrangeRank:
+		var err1, err2 error
+		start, err1 = strconv.Atoi(min)
+		stop, err2 = strconv.Atoi(max)
+		if err1 != nil || err2 != nil {
+			return ErrNotInteger
+		}
+	case zrangeScore:
+		r, err = parseScoreRange(min, max)
+	case zrangeLex:
+		r, err = parseLexRange(min, max)
+	}
+	if err != nil {
+		return err
+	}
+
+	obj, err := c.db.lookupType(key, FieldTypeZSet)
+	if err != nil {
+		return err
+	}
+
+	var res []zsetEntry
+	if obj != nil {
+		var it *zsetIter
+		if rangeType == zrangeRank {
+			if start, stop, ok := listRange(start, stop, zsetLen(obj)); ok {
+				it, limit = zsetIterAtRank(obj, start, rev), stop-start+1
+			}
+		} else if offset >= 0 {
+			it = zsetIterInRange(obj, r, rev)
+		}
+		for ; it != nil && it.valid() && offset > 0; it.next() {
+			offset--
+		}
+		for ; it != nil && it.valid() && limit != 0; it.next() {
+			res = append(res, zsetEntry{it.member(), it.score()})
+			limit--
+		}
+	}
+
+	if !store {
+		writeZsetEntries(c.writer, res, withScores)
+		return nil
+	}
+	if len(res) == 0 {
+		c.db.Delete(dst)
+	} else {
+		c.db.Set(dst, srv.zsetFromEntries(res))
+	}
+	c.writer.WriteInteger(int64(len(res)))
+	return nil
+}
+
+// zsetFromEntries returns a new sorted set made of entries, which have
+// distinct members.
+func (srv *Server) zsetFromEntries(entries []zsetEntry) *Object {
+	maxLen := 0
+	for _, e := range entries {
+		if len(e.member) > maxLen {
+			maxLen = len(e.member)
+		}
+	}
+	obj := srv.newZsetObject(len(entries), maxLen)
+	for _, e := range entries {
+		srv.zsetInsert(obj, e.score, e.member)
+	}
+	return obj
+}
+
+// ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count]
+// [WITHSCORES]
+func (srv *Server) onZRange(c *Client, args []string) error {
+	return srv.zrangeGeneric(c, args, false, zrangeAuto, false)
+}
+
+// ZRANGESTORE dst src min max [BYSCORE | BYLEX] [REV] [LIMIT offset count]
+func (srv *Server) onZRangeStore(c *Client, args []string) error {
+	return srv.zrangeGeneric(c, args, true, zrangeAuto, false)
+}
+
+// ZREVRANGE key start stop [WITHSCORES]
+func (srv *Server) onZRevRange(c *Client, args []string) error {
+	return srv.zrangeGeneric(c, args, false, zrangeRank, true)
+}
+
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func (srv *Server) onZRangeByScore(c *Client, args []string) error {
+	return srv.zrangeGeneric(c, args, false, zrangeScore, false)
+}
+
+// ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func (srv *Server) onZRevRangeByScore(c *Client, args []string) error {
+	return srv.zrangeGeneric(c, args, false, zrangeScore, true)
+}
+
+// ZRANGEBYLEX key min max [LIMIT offset count]
+func (srv *Server) onZRangeByLex(c *Client, args []string) error {
+	return srv.zrangeGeneric(c, args, false, zrangeLex, false)
+}
+
+// ZREVRANGEBYLEX key max min [LIMIT offset count]
+func (srv *Server) onZRevRangeByLex(c *Client, args []string) error {
+	return srv.zrangeGeneric(c, args, false, zrangeLex, true)
+}
+
+// zsetRandom returns a random member and its score of a non empty sorted
+// set.
+func zsetRandom(o *Object) zsetEntry {
+	if o.Encoding == EncodingListpack {
+		lp := o.Value.(*listpack)
+		p := lp.Seek(2 * rand.Intn(zsetLen(o)))
+		return zsetEntry{lp.Get(p), zzlScore(lp, lp.Next(p))}
+	}
+	member, score, _ := o.Value.(*zset).dict.Random()
+	return zsetEntry{member, score}
+}
+
+// zsetPop removes up to count members with the lowest scores, or the
+// highest when max, and deletes key once no member is left.
+func zsetPop(db *DB, key string, obj *Object, max bool, count int) []zsetEntry {
+	var res []zsetEntry
+	for ; count > 0 && zsetLen(obj) > 0; count-- {
+		it := zsetIterAtRank(obj, 0, max)
+		e := zsetEntry{it.member(), it.score()}
+		zsetRemove(obj, e.member)
+		res = append(res, e)
+	}
+	zsetDeleteIfEmpty(db, key, obj)
+	return res
+}
+
+// zpopGeneric implements ZPOPMIN and ZPOPMAX key [count]. Without count the
+// reply is the member and its score, with it RESP3 nests every pair.
+func (srv *Server) zpopGeneric(c *Client, args []string, max bool) error {
+	if len(args) > 2 {
+		return ErrSyntax
+	}
+	count := -1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return ErrNotInteger
+		}
+		if n < 0 {
+			return errNotPositive
+		}
+		count = n
+	}
+
+	obj, err := c.db.lookupType(args[0], FieldTypeZSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		c.writer.WriteArrayLen(0)
+		return nil
+	}
+
+	if count == -1 {
+		writeZsetPairs(c.writer, zsetPop(c.db, args[0], obj, max, 1), false)
+		return nil
+	}
+	writeZsetPairs(c.writer, zsetPop(c.db, args[0], obj, max, count), c.writer.proto == 3)
+	return nil
+}
+
+// ZPOPMIN key [count]
+func (srv *Server) onZPopMin(c *Client, args []string) error {
+	return srv.zpopGeneric(c, args, false)
+}
+
+// ZPOPMAX key [count]
+func (srv *Server) onZPopMax(c *Client, args []string) error {
+	return srv.zpopGeneric(c, args, true)
+}
+
+// bzpopGeneric implements BZPOPMIN and BZPOPMAX, the reply is the key, the
+// member popped from the first non empty sorted set and its score.
+func (srv *Server) bzpopGeneric(c *Client, cmd string, args []string, max bool) error {
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return err
+	}
+
+	keys := args[:len(args)-1]
+	for _, key := range keys {
+		obj, err := c.db.lookupType(key, FieldTypeZSet)
+		if err != nil {
+			return err
+		}
+		if obj == nil {
+			continue
+		}
+
+		e := zsetPop(c.db, key, obj, max, 1)[0]
+		c.writer.WriteArrayLen(3)
+		c.writer.WriteBulkString(key)
+		c.writer.WriteBulkString(e.member)
+		c.writer.WriteDouble(e.score)
+		return nil
+	}
+
+	return srv.blockForKeys(c, keys, FieldTypeZSet, timeout, Message{cmd: cmd, args: args}, c.writer.WriteNullArray)
+}
+
+// BZPOPMIN key [key ...] timeout
+func (srv *Server) onBZPopMin(c *Client, args []string) error {
+	return srv.bzpopGeneric(c, "bzpopmin", args, false)
+}
+
+// BZPOPMAX key [key ...] timeout
+func (srv *Server) onBZPopMax(c *Client, args []string) error {
+	return srv.bzpopGeneric(c, "bzpopmax", args, true)
+}
+
+// parseZMPopArgs parses the arguments of ZMPOP and BZMPOP that follow the
+// keys: MIN | MAX [COUNT count].
+func parseZMPopArgs(args []string) (max bool, count int, err error) {
+	if len(args) == 0 {
+		return false, 0, ErrSyntax
+	}
+	switch strings.ToLower(args[0]) {
+	case "min":
+	case "max":
+		max = true
+	default:
+		return false, 0, ErrSyntax
+	}
+
+	if count, err = parseMPopCount(args[1:]); err != nil {
+		return false, 0, err
+	}
+	return max, count, nil
+}
+
+// zmpopGeneric pops up to count members from the first non empty sorted
+// set of keys, replying with the key and the members with their scores.
+// It reports whether it found one.
+func (srv *Server) zmpopGeneric(c *Client, keys []string, max bool, count int) (bool, error) {
+	for _, key := range keys {
+		obj, err := c.db.lookupType(key, FieldTypeZSet)
+		if err != nil {
+			return false, err
+		}
+		if obj == nil {
+			continue
+		}
+
+		c.writer.WriteArrayLen(2)
+		c.writer.WriteBulkString(key)
+		writeZsetPairs(c.writer, zsetPop(c.db, key, obj, max, count), true)
+		return true, nil
+	}
+	return false, nil
+}
+
+// ZMPOP numkeys key [key ...] MIN | MAX [COUNT count]
+func (srv *Server) onZMPop(c *Client, args []string) error {
+	keys, rest, err := parseNumKeys(args)
+	if err != nil {
+		return err
+	}
+	max, count, err := parseZMPopArgs(rest)
+	if err != nil {
+		return err
+	}
+
+	ok, err := srv.zmpopGeneric(c, keys, max, count)
+	if err == nil && !ok {
+		c.writer.WriteNullArray()
+	}
+	return err
+}
+
+// BZMPOP timeout numkeys key [key ...] MIN | MAX [COUNT count]
+func (srv *Server) onBZMPop(c *Client, args []string) error {
+	timeout, err := parseTimeout(args[0])
+	if err != nil {
+		return err
+	}
+	keys, rest, err := parseNumKeys(args[1:])
+	if err != nil {
+		return err
+	}
+	max, count, err := parseZMPopArgs(rest)
+	if err != nil {
+		return err
+	}
+
+	ok, err := srv.zmpopGeneric(c, keys, max, count)
+	if err != nil || ok {
+		return err
+	}
+	return srv.blockForKeys(c, keys, FieldTypeZSet, timeout, Message{cmd: "bzmpop", args: args}, c.writer.WriteNullArray)
+}
+
+// ZRANDMEMBER key [count [WITHSCORES]]
+//
+// A positive count returns distinct members, at most the whole set, a
+// negative one returns -count members that may repeat.
+func (srv *Server) onZRandMember(c *Client, args []string) error {
+	if len(args) > 3 || (len(args) == 3 && strings.ToLower(args[2]) != "withscores") {
+		return ErrSyntax
+	}
+
+	count, withCount := 1, len(args) > 1
+	if withCount {
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return ErrNotInteger
+		}
+		if n < -math.MaxInt64/2 || n > math.MaxInt64/2 {
+			return errors.New("ERR value is out of range")
+		}
+		count = int(n)
+	}
+	withScores := len(args) == 3
+
+	obj, err := c.db.lookupType(args[0], FieldTypeZSet)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		if withCount {
+			c.writer.WriteArrayLen(0)
+		} else {
+			c.writer.WriteNull()
+		}
+		return nil
+	}
+
+	if !withCount {
+		c.writer.WriteBulkString(zsetRandom(obj).member)
+		return nil
+	}
+
+	var res []zsetEntry
+	if count < 0 {
+		for i := 0; i < -count; i++ {
+			res = append(res, zsetRandom(obj))
+		}
+	} else {
+		for it := zsetIterAtRank(obj, 0, false); it.valid(); it.next() {
+			res = append(res, zsetEntry{it.member(), it.score()})
+		}
+		rand.Shuffle(len(res), func(i, j int) {
+			res[i], res[j] = res[j], res[i]
+		})
+		if count < len(res) {
+			res = res[:count]
+		}
+	}
+	writeZsetEntries(c.writer, res, withScores)
+	return nil
+}
+
+// The AGGREGATE functions combining the scores of a member found in
+// several inputs of ZUNION and ZINTER.
+const (
+	zaggSum = iota
+	zaggMin
+	zaggMax
+)
+
+func zsetAggregate(agg int, a, b float64) float64 {
+	switch agg {
+	case zaggMin:
+		return math.Min(a, b)
+	case zaggMax:
+		return math.Max(a, b)
+	}
+	// inf + -inf counts as 0, like Redis
+	if sum := a + b; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// zsetWeight applies weight to score, inf * 0 counting as 0.
+func zsetWeight(score, weight float64) float64 {
+	if res := score * weight; !math.IsNaN(res) {
+		return res
+	}
+	return 0
+}
+
+// The inputs of ZUNION, ZINTER and ZDIFF are sorted sets or sets, whose
+// members all score 1.
+
+func zsetInputLen(o *Object) int {
+	if o.Type == FieldTypeSet {
+		return setLen(o)
+	}
+	return zsetLen(o)
+}
+
+func zsetInputScore(o *Object, member string) (float64, bool) {
+	if o.Type == FieldTypeSet {
+		return 1, setIsMember(o, member)
+	}
+	return zsetScore(o, member)
+}
+
+// zsetInputIterate calls fn for every member until it returns false.
+func zsetInputIterate(o *Object, fn func(member string, score float64) bool) {
+	if o.Type == FieldTypeSet {
+		setIterate(o, func(member string) bool {
+			return fn(member, 1)
+		})
+		return
+	}
+	for it := zsetIterAtRank(o, 0, false); it.valid(); it.next() {
+		if !fn(it.member(), it.score()) {
+			return
+		}
+	}
+}
+
+// lookupZsetInputs returns the sorted sets or sets at keys, nil for the
+// missing ones.
+func lookupZsetInputs(db *DB, keys []string) ([]*Object, error) {
+	inputs := make([]*Object, len(keys))
+	for i, key := range keys {
+		obj := db.lookup(key)
+		if obj != nil && obj.Type != FieldTypeZSet && obj.Type != FieldTypeSet {
+			return nil, ErrWrongType
+		}
+		inputs[i] = obj
+	}
+	return inputs, nil
+}
+
+// zsetInter returns the members of every input with their weighted and
+// aggregated scores, stopping at limit members when it is not 0.
+func zsetInter(inputs []*Object, weights []float64, agg, limit int) []zsetEntry {
+	order := make([]int, len(inputs))
+	for i, o := range inputs {
+		if o == nil {
+			return nil
+		}
+		order[i] = i
+	}
+	// walk the smallest input, checking the others for each of its members
+	sort.Slice(order, func(i, j int) bool {
+		return zsetInputLen(inputs[order[i]]) < zsetInputLen(inputs[order[j]])
+	})
+
+	var res []zsetEntry
+	first := order[0]
+	zsetInputIterate(inputs[first], func(member string, score float64) bool {
+		total := zsetWeight(score, weights[first])
+		for _, i := range order[1:] {
+			score, ok := zsetInputScore(inputs[i], member)
+			if !ok {
+				return true
+			}
+			total = zsetAggregate(agg, total, zsetWeight(score, weights[i]))
+		}
+		res = append(res, zsetEntry{member, total})
+		return limit == 0 || len(res) < limit
+	})
+	return res
+}
+
+// zsetUnion returns the members of any input with their weighted and
+// aggregated scores.
+func zsetUnion(inputs []*Object, weights []float64, agg int) []zsetEntry {
+	scores := map[string]float64{}
+	for i, o := range inputs {
+		if o == nil {
+			continue
+		}
+		zsetInputIterate(o, func(member string, score float64) bool {
+			score = zsetWeight(score, weights[i])
+			if cur, ok := scores[member]; ok {
+				score = zsetAggregate(agg, cur, score)
+			}
+			scores[member] = score
+			return true
+		})
+	}
+
+	res := make([]zsetEntry, 0, len(scores))
+	for member, score := range scores {
+		res = append(res, zsetEntry{member, score})
+	}
+	return res
+}
+
+// zsetDiff returns the members of the first input that are in none of the
+// others, with their score.
+func zsetDiff(inputs []*Object) []zsetEntry {
+	if inputs[0] == nil {
+		return nil
+	}
+
+	var res []zsetEntry
+	zsetInputIterate(inputs[0], func(member string, score float64) bool {
+		for _, o := range inputs[1:] {
+			if o == nil {
+				continue
+			}
+			if _, ok := zsetInputScore(o, member); ok {
+				return true
+			}
+		}
+		res = append(res, zsetEntry{member, score})
+		return true
+	})
+	return res
+}
+
+// parseZsetNumKeys parses the number of keys of ZUNION and the like
+// followed by the keys, and returns the keys and the arguments after them.
+func parseZsetNumKeys(cmd string, args []string) ([]string, []string, error) {
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, ErrNotInteger
+	}
+	if n < 1 {
+		return nil, nil, fmt.Errorf("ERR at least 1 input key is needed for '%s' command", cmd)
+	}
+	if n > len(args)-1 {
+		return nil, nil, ErrSyntax
+	}
+	return args[1 : 1+n], args[1+n:], nil
+}
+
+// zsetOpGeneric implements ZUNION, ZINTER and ZDIFF, args starting with
+// numkeys, and their STORE variants when store, args starting with the
+// destination key.
+func (srv *Server) zsetOpGeneric(c *Client, cmd string, args []string, store bool, op int) error {
+	var dst string
+	if store {
+		dst, args = args[0], args[1:]
+	}
+	keys, rest, err := parseZsetNumKeys(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	weights := make([]float64, len(keys))
+	for i := range weights {
+		weights[i] = 1
+	}
+	agg, withScores := zaggSum, false
+	for i := 0; i < len(rest); i++ {
+		switch opt := strings.ToLower(rest[i]); {
+		case opt == "weights" && op != setOpDiff && i+len(keys) < len(rest):
+			for j := range weights {
+				w, ok := parseFloat(rest[i+1+j])
+				if !ok {
+					return errors.New("ERR weight value is not a float")
+				}
+				weights[j] = w
+			}
+			i += len(keys)
+		case opt == "aggregate" && op != setOpDiff && i+1 < len(rest):
+			switch strings.ToLower(rest[i+1]) {
+			case "sum":
+				agg = zaggSum
+			case "min":
+				agg = zaggMin
+			case "max":
+				agg = zaggMax
+			default:
+				return ErrSyntax
+			}
+			i++
+		case opt == "withscores" && !store:
+			withScores = true
+		default:
+			return ErrSyntax
+		}
+	}
+
+	inputs, err := lookupZsetInputs(c.db, keys)
+	if err != nil {
+		return err
+	}
+
+	var res []zsetEntry
+	switch op {
+	case setOpInter:
+		res = zsetInter(inputs, weights, agg, 0)
+	case setOpUnion:
+		res = zsetUnion(inputs, weights, agg)
+	default:
+		res = zsetDiff(inputs)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].score < res[j].score || (res[i].score == res[j].score && res[i].member < res[j].member)
+	})
+
+	if !store {
+		writeZsetEntries(c.writer, res, withScores)
+		return nil
+	}
+	if len(res) == 0 {
+		c.db.Delete(dst)
+	} else {
+		c.db.Set(dst, srv.zsetFromEntries(res))
+	}
+	c.writer.WriteInteger(int64(len(res)))
+	return nil
+}
+
+// ZUNION numkeys key [key ...] [WEIGHTS weight [weight ...]]
+// [AGGREGATE SUM | MIN | MAX] [WITHSCORES]
+func (srv *Server) onZUnion(c *Client, args []string) error {
+	return srv.zsetOpGeneric(c, "zunion", args, false, setOpUnion)
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight
+// [weight ...]] [AGGREGATE SUM | MIN | MAX]
+func (srv *Server) onZUnionStore(c *Client, args []string) error {
+	return srv.zsetOpGeneric(c, "zunionstore", args, true, setOpUnion)
+}
+
+// ZINTER numkeys key [key ...] [WEIGHTS weight [weight ...]]
+// [AGGREGATE SUM | MIN | MAX] [WITHSCORES]
+func (srv *Server) onZInter(c *Client, args []string) error {
+	return srv.zsetOpGeneric(c, "zinter", args, false, setOpInter)
+}
+
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight
+// [weight ...]] [AGGREGATE SUM | MIN | MAX]
+func (srv *Server) onZInterStore(c *Client, args []string) error {
+	return srv.zsetOpGeneric(c, "zinterstore", args, true, setOpInter)
+}
+
+// ZDIFF numkeys key [key ...] [WITHSCORES]
+func (srv *Server) onZDiff(c *Client, args []string) error {
+	return srv.zsetOpGeneric(c, "zdiff", args, false, setOpDiff)
+}
+
+// ZDIFFSTORE destination numkeys key [key ...]
+func (srv *Server) onZDiffStore(c *Client, args []string) error {
+	return srv.zsetOpGeneric(c, "zdiffstore", args, true, setOpDiff)
+}
+
+// ZINTERCARD numkeys key [key ...] [LIMIT limit]
+func (srv *Server) onZInterCard(c *Client, args []string) error {
+	keys, rest, err := parseZsetNumKeys("zintercard", args)
+	if err != nil {
+		return err
+	}
+
+	limit := 0
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToLower(rest[0]) == "limit":
+		if limit, err = strconv.Atoi(rest[1]); err != nil {
+			return ErrNotInteger
+		}
+		if limit < 0 {
+			return errors.New("ERR LIMIT can't be negative")
+		}
+	default:
+		return ErrSyntax
+	}
+
+	inputs, err := lookupZsetInputs(c.db, keys)
+	if err != nil {
+		return err
+	}
+	weights := make([]float64, len(keys))
+	c.writer.WriteInteger(int64(len(zsetInter(inputs, weights, zaggSum, limit))))
+	return nil
+}